@@ -0,0 +1,372 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+)
+
+// downloadSuffix is appended to the name of a file while it is being downloaded
+const downloadSuffix = ".ccmm-download"
+
+// errPartialUploadMismatch is returned when the manager refuses to resume an
+// upload because its partial file doesn't match the start of the local file
+var errPartialUploadMismatch = errors.New("partial upload doesn't match the local file")
+
+// managerClient is used to talk to the manager API
+type managerClient struct {
+	address    string
+	token      string
+	httpClient *http.Client
+}
+
+func newManagerClient(config model.ClientConfig) *managerClient {
+	return &managerClient{
+		address:    strings.TrimSuffix(config.ManagerAddress, "/"),
+		token:      config.ManagerToken,
+		httpClient: &http.Client{},
+	}
+}
+
+// postSyncRequest sends the list of files the client has to the manager and
+// returns the manager's sync plan
+func (mc *managerClient) postSyncRequest(syncRequest model.SyncRequest) (model.SyncRequest, error) {
+	var plan model.SyncRequest
+
+	body, err := json.Marshal(syncRequest)
+	if err != nil {
+		return plan, err
+	}
+
+	response, err := mc.do("POST", "/api/v1/sync_request", bytes.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return plan, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return plan, err
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&plan); err != nil {
+		return plan, fmt.Errorf("failed to read sync plan: %w", err)
+	}
+
+	if plan.SyncType != model.SyncTypePlan {
+		return plan, fmt.Errorf("manager returned sync type '%s', expected '%s'", plan.SyncType, model.SyncTypePlan)
+	}
+
+	return plan, nil
+}
+
+// listServices returns every service date stored on the manager
+func (mc *managerClient) listServices() ([]string, error) {
+	response, err := mc.do("GET", "/api/v1/services", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var services []string
+	err = json.NewDecoder(response.Body).Decode(&services)
+
+	return services, err
+}
+
+// download fetches the file from the manager to localPath. A partial download
+// left behind by a previous attempt is resumed. progressCallback is called
+// with the number of new bytes received
+func (mc *managerClient) download(file model.SyncFile, localPath string, progressCallback func(bytes int64)) error {
+	tempPath := localPath + downloadSuffix
+
+	offset := int64(0)
+	stat, err := os.Stat(tempPath)
+	if err == nil {
+		offset = stat.Size()
+	}
+
+	// the partial file has the modification time of the copy it was started
+	// from, so the manager only resumes it if the file hasn't changed since
+	headers := map[string]string{}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+		headers["If-Range"] = stat.ModTime().UTC().Format(http.TimeFormat)
+	}
+
+	response, err := mc.do("GET", fileEndpoint("files", file), nil, headers)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		progressCallback(offset)
+	case http.StatusOK:
+		// the manager sent the whole file, so start over
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is no good, start over on the next attempt
+		os.Remove(tempPath)
+		return fmt.Errorf("partial download of '%s' is invalid", file.FilePath)
+	default:
+		return checkResponse(response, http.StatusOK)
+	}
+
+	tempFile, err := os.OpenFile(tempPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tempFile, &progressReader{reader: response.Body, progressCallback: progressCallback})
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	tempFile.Close()
+
+	if lastModified, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(tempPath, time.Time{}, lastModified)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := verifyFile(tempPath, file); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	if modTime, err := time.Parse(time.RFC3339Nano, response.Header.Get(model.ModTimeHeader)); err == nil {
+		os.Chtimes(tempPath, time.Time{}, modTime)
+	}
+
+	return os.Rename(tempPath, localPath)
+}
+
+// upload sends the file at localPath to the manager. If the manager already
+// has part of the file from a previous attempt, only the remainder is sent,
+// provided that the part the manager has matches the start of the local file.
+// progressCallback is called with the number of new bytes sent
+func (mc *managerClient) upload(file model.SyncFile, localPath string, progressCallback func(bytes int64)) error {
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	offset, err := mc.uploadOffset(file)
+	if err != nil {
+		return err
+	}
+
+	// the manager can have the whole file if the response to the last chunk was
+	// lost, but a chunk can't be empty, so the file is sent again
+	if offset >= stat.Size() {
+		offset = 0
+	}
+
+	if offset == 0 {
+		return mc.uploadFrom(file, localPath, stat, 0, progressCallback)
+	}
+
+	// the transport can still be reading the body of a refused resume, so its
+	// progress is discarded once the resume is refused
+	var progressMutex sync.Mutex
+	resumeBytes := int64(0)
+	resumeRefused := false
+
+	err = mc.uploadFrom(file, localPath, stat, offset, func(bytes int64) {
+		progressMutex.Lock()
+		defer progressMutex.Unlock()
+
+		if !resumeRefused {
+			resumeBytes += bytes
+			progressCallback(bytes)
+		}
+	})
+
+	if !errors.Is(err, errPartialUploadMismatch) {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Partial upload of '%s' doesn't match the local file, starting over", file.FilePath))
+
+	progressMutex.Lock()
+	resumeRefused = true
+	progressCallback(-resumeBytes)
+	progressMutex.Unlock()
+
+	return mc.uploadFrom(file, localPath, stat, 0, progressCallback)
+}
+
+//
+// private functions
+//
+
+// uploadFrom sends the local file to the manager, starting at offset. If the
+// manager refuses to resume its partial file, errPartialUploadMismatch is returned
+func (mc *managerClient) uploadFrom(file model.SyncFile, localPath string, stat os.FileInfo, offset int64, progressCallback func(bytes int64)) error {
+	headers := map[string]string{
+		"Content-Type":      "application/octet-stream",
+		model.ModTimeHeader: stat.ModTime().Format(time.RFC3339Nano),
+	}
+
+	if file.Hash != "" {
+		headers[model.HashHeader] = file.Hash
+	}
+
+	// an empty file can't be described with a Content-Range
+	if stat.Size() > 0 {
+		headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", offset, stat.Size()-1, stat.Size())
+	}
+
+	if offset > 0 {
+		partialHash, err := util.HashFilePrefix(localPath, offset)
+		if err != nil {
+			return err
+		}
+		headers[model.PartialHashHeader] = partialHash
+	}
+
+	// the transport closes the body, and so the file, once it is done with it
+	sourceFile, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := sourceFile.Seek(offset, io.SeekStart); err != nil {
+		sourceFile.Close()
+		return err
+	}
+	progressCallback(offset)
+
+	body := struct {
+		io.Reader
+		io.Closer
+	}{&progressReader{reader: sourceFile, progressCallback: progressCallback}, sourceFile}
+
+	response, err := mc.do("PUT", fileEndpoint("files", file), body, headers)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusPreconditionFailed && offset > 0 {
+		return errPartialUploadMismatch
+	}
+
+	return checkResponse(response, http.StatusCreated)
+}
+
+func (mc *managerClient) uploadOffset(file model.SyncFile) (int64, error) {
+	response, err := mc.do("GET", fileEndpoint("uploads", file), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return 0, err
+	}
+
+	var status model.UploadStatus
+	err = json.NewDecoder(response.Body).Decode(&status)
+
+	return status.Offset, err
+}
+
+func (mc *managerClient) do(method string, endpoint string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	request, err := http.NewRequest(method, mc.address+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if mc.token != "" {
+		request.Header.Set("Authorization", "Bearer "+mc.token)
+	}
+
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	return mc.httpClient.Do(request)
+}
+
+// fileEndpoint returns the manager endpoint for the provided file. FilePath
+// starts with the media type directory, which is part of the endpoint instead
+func fileEndpoint(resource string, file model.SyncFile) string {
+	relativePath := strings.TrimPrefix(strings.TrimPrefix(file.FilePath, "/"), file.MediaType+"/")
+
+	segments := []string{url.PathEscape(file.Service), url.PathEscape(file.MediaType)}
+	for _, segment := range strings.Split(relativePath, "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+
+	return fmt.Sprintf("/api/v1/%s/%s", resource, strings.Join(segments, "/"))
+}
+
+func checkResponse(response *http.Response, expectedStatusCode int) error {
+	if response.StatusCode == expectedStatusCode {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+
+	return fmt.Errorf("manager returned '%s': %s", response.Status, strings.TrimSpace(string(body)))
+}
+
+// progressReader wraps an io.Reader and reports the number of bytes read by
+// each call to Read
+type progressReader struct {
+	reader           io.Reader
+	progressCallback func(bytes int64)
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	pr.progressCallback(int64(n))
+
+	return n, err
+}
@@ -0,0 +1,200 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ccmm/model"
+)
+
+func TestResolveLocalPath(t *testing.T) {
+	serviceDir := filepath.Join(t.TempDir(), "2024 Q4", "2024-11-03")
+
+	valid := map[string]string{
+		"/Audio/Tr1.WAV":        filepath.Join(serviceDir, "Audio", "Tr1.WAV"),
+		"Audio/Tr1.WAV":         filepath.Join(serviceDir, "Audio", "Tr1.WAV"),
+		"Video/cam/../A001.MXF": filepath.Join(serviceDir, "Video", "A001.MXF"),
+	}
+
+	for relativePath, want := range valid {
+		got, err := resolveLocalPath(serviceDir, relativePath)
+		if err != nil || got != want {
+			t.Errorf("resolveLocalPath('%s') = '%s', %v, want '%s'", relativePath, got, err, want)
+		}
+	}
+
+	for _, relativePath := range []string{"", "/", ".", "..", "../../x", "/../x", "Audio/../../x", "//etc/passwd"} {
+		if got, err := resolveLocalPath(serviceDir, relativePath); err == nil {
+			t.Errorf("resolveLocalPath('%s') = '%s', expected an error", relativePath, got)
+		}
+	}
+}
+
+// fakeUploadManager records the uploads it receives. The partial upload it
+// reports having is offset bytes long, and is only resumed if it matches
+// partialHash
+type fakeUploadManager struct {
+	mutex       sync.Mutex
+	offset      int64
+	partialHash string
+	ranges      []string
+	received    string
+}
+
+func (m *fakeUploadManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/api/v1/uploads/") {
+		json.NewEncoder(w).Encode(model.UploadStatus{Offset: m.offset})
+		return
+	}
+
+	m.ranges = append(m.ranges, r.Header.Get("Content-Range"))
+
+	if !strings.HasPrefix(r.Header.Get("Content-Range"), "bytes 0-") && r.Header.Get(model.PartialHashHeader) != m.partialHash {
+		m.offset = 0
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	m.received = string(body)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func uploadTestFile(t *testing.T, manager *fakeUploadManager, content string) int64 {
+	t.Helper()
+
+	server := httptest.NewServer(manager)
+	defer server.Close()
+
+	localPath := filepath.Join(t.TempDir(), "Tr1.WAV")
+	if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newManagerClient(model.ClientConfig{ManagerAddress: server.URL})
+	file := model.SyncFile{FilePath: "Audio/Tr1.WAV", MediaType: "Audio", Service: "2024-11-03", Size: int64(len(content))}
+
+	progress := int64(0)
+	if err := client.upload(file, localPath, func(bytes int64) { progress += bytes }); err != nil {
+		t.Fatal(err)
+	}
+
+	return progress
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadResumesFromOffset(t *testing.T) {
+	manager := &fakeUploadManager{offset: 4, partialHash: sha256Hex("0123")}
+
+	progress := uploadTestFile(t, manager, "0123456789")
+
+	if len(manager.ranges) != 1 || manager.ranges[0] != "bytes 4-9/10" || manager.received != "456789" {
+		t.Errorf("expected only the remainder to be sent, got %v '%s'", manager.ranges, manager.received)
+	}
+	if progress != 10 {
+		t.Errorf("expected progress of 10 bytes, got %d", progress)
+	}
+}
+
+func TestUploadStartsOverWhenPartialDoesNotMatch(t *testing.T) {
+	manager := &fakeUploadManager{offset: 4, partialHash: sha256Hex("abcd")}
+
+	progress := uploadTestFile(t, manager, "0123456789")
+
+	if len(manager.ranges) != 2 || manager.ranges[1] != "bytes 0-9/10" || manager.received != "0123456789" {
+		t.Errorf("expected the whole file to be sent again, got %v '%s'", manager.ranges, manager.received)
+	}
+	if progress != 10 {
+		t.Errorf("expected progress of 10 bytes, got %d", progress)
+	}
+}
+
+func TestUploadResendsFileTheManagerAlreadyHas(t *testing.T) {
+	manager := &fakeUploadManager{offset: 10}
+
+	uploadTestFile(t, manager, "0123456789")
+
+	if len(manager.ranges) != 1 || manager.ranges[0] != "bytes 0-9/10" {
+		t.Errorf("expected the whole file to be sent, got %v", manager.ranges)
+	}
+}
+
+func TestDownloadResumesOnlyUnchangedFile(t *testing.T) {
+	content := "0123456789"
+	modTime := time.Date(2024, 11, 3, 10, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(model.ModTimeHeader, modTime.Format(time.RFC3339Nano))
+		http.ServeContent(w, r, "Tr1.WAV", modTime, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	client := newManagerClient(model.ClientConfig{ManagerAddress: server.URL})
+	file := model.SyncFile{FilePath: "Audio/Tr1.WAV", MediaType: "Audio", Service: "2024-11-03", Size: int64(len(content)), Hash: sha256Hex(content)}
+
+	tests := []struct {
+		name        string
+		partial     string
+		partialTime time.Time
+	}{
+		{"unchanged", "0123", modTime},
+		{"changed since", "abcd", modTime.Add(-time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			localPath := filepath.Join(t.TempDir(), "Tr1.WAV")
+			os.WriteFile(localPath+downloadSuffix, []byte(test.partial), 0644)
+			os.Chtimes(localPath+downloadSuffix, time.Time{}, test.partialTime)
+
+			if err := client.download(file, localPath, func(bytes int64) {}); err != nil {
+				t.Fatal(err)
+			}
+
+			if downloaded, _ := os.ReadFile(localPath); string(downloaded) != content {
+				t.Errorf("unexpected downloaded file '%s'", downloaded)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ccmm/util"
+)
+
+const (
+	progressBarWidth   = 30
+	progressBarRefresh = 250 * time.Millisecond
+)
+
+// progressBar renders the overall progress of a sync to the terminal. If the
+// output is not a terminal, nothing is rendered
+type progressBar struct {
+	output         io.Writer
+	enabled        bool
+	mutex          sync.Mutex
+	totalFiles     int
+	completedFiles int
+	totalBytes     int64
+	bytes          int64
+	startedAt      time.Time
+	done           chan struct{}
+	stopped        chan struct{}
+}
+
+func newProgressBar() *progressBar {
+	enabled := false
+	if stat, err := os.Stderr.Stat(); err == nil {
+		enabled = stat.Mode()&os.ModeCharDevice != 0
+	}
+
+	return &progressBar{output: os.Stderr, enabled: enabled}
+}
+
+func (pb *progressBar) start(totalFiles int, totalBytes int64) {
+	pb.mutex.Lock()
+	pb.totalFiles = totalFiles
+	pb.totalBytes = totalBytes
+	pb.startedAt = time.Now()
+	pb.mutex.Unlock()
+
+	if !pb.enabled || totalFiles == 0 {
+		return
+	}
+
+	pb.done = make(chan struct{})
+	pb.stopped = make(chan struct{})
+
+	go func() {
+		defer close(pb.stopped)
+
+		ticker := time.NewTicker(progressBarRefresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-pb.done:
+				pb.render()
+				fmt.Fprintln(pb.output)
+				return
+			case <-ticker.C:
+				pb.render()
+			}
+		}
+	}()
+}
+
+func (pb *progressBar) stop() {
+	if pb.done == nil {
+		return
+	}
+
+	close(pb.done)
+	<-pb.stopped
+	pb.done = nil
+}
+
+func (pb *progressBar) addBytes(bytes int64) {
+	pb.mutex.Lock()
+	pb.bytes += bytes
+	pb.mutex.Unlock()
+}
+
+func (pb *progressBar) fileDone() {
+	pb.mutex.Lock()
+	pb.completedFiles++
+	pb.mutex.Unlock()
+}
+
+//
+// private functions
+//
+
+func (pb *progressBar) render() {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+
+	fraction := 1.0
+	if pb.totalBytes > 0 {
+		fraction = min(float64(pb.bytes)/float64(pb.totalBytes), 1)
+	}
+
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	rate := 0.0
+	if elapsed := time.Since(pb.startedAt).Seconds(); elapsed > 0 {
+		rate = float64(pb.bytes) / elapsed
+	}
+
+	fmt.Fprintf(pb.output, "\r[%s] %3.0f%%  %s / %s  %d/%d files  %s/s   ",
+		bar, fraction*100, util.FormatBytes(pb.bytes), util.FormatBytes(pb.totalBytes), pb.completedFiles, pb.totalFiles, util.FormatBytes(int64(rate)))
+}
@@ -26,27 +26,43 @@ package action
 
 import (
 	"ccmm/model"
+	"ccmm/util"
 	"ccmm/util/sync"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
 )
 
+// DoSync synchronizes the requested services with the manager. The list of
+// local files is sent to the manager, which replies with a plan describing
+// what each side needs to do. The client then carries out its side of the plan.
+// If syncConfig.DryRun is set, the plan is printed instead
 func DoSync(config model.ClientConfig, syncConfig model.SyncConfig) error {
-	// TODO: add validation to ensure requested service date exists either locally or remotely
+	manager := newManagerClient(config)
 
-	if len(syncConfig.Services) == 0 {
+	services, err := resolveServices(config, syncConfig, manager)
+	if err != nil {
+		return err
+	}
+
+	if len(services) == 0 {
 		return fmt.Errorf("no service dates provided, nothing to do")
 	}
 
 	syncRequest := model.SyncRequest{
 		ClientName:   config.ClientName,
-		SyncType:     "request",
-		Services:     syncConfig.Services,
+		SyncType:     model.SyncTypeRequest,
+		Services:     services,
 		MediaTypes:   syncConfig.MediaTypes,
 		ServiceFiles: make(map[string][]model.SyncFile),
 	}
 
-	for _, service := range syncConfig.Services {
+	for _, service := range services {
 		if len(service) < 10 {
 			return fmt.Errorf("provided service doesn't appread to be a date: '%s'", service)
 		}
@@ -60,7 +76,110 @@ func DoSync(config model.ClientConfig, syncConfig model.SyncConfig) error {
 	if syncConfig.Dump {
 		j, _ := json.MarshalIndent(syncRequest, "", "  ")
 		fmt.Println(string(j))
+		return nil
+	}
+
+	slog.Info(fmt.Sprintf("Requesting sync plan for %d service(s) from '%s'", len(services), config.ManagerAddress))
+
+	plan, err := manager.postSyncRequest(syncRequest)
+	if err != nil {
+		return fmt.Errorf("failed to get sync plan: %w", err)
+	}
+
+	if syncConfig.DryRun {
+		printPlan(plan)
+		return nil
+	}
+
+	executor := &syncExecutor{
+		config:   config,
+		manager:  manager,
+		progress: newProgressBar(),
+	}
+
+	if err := executor.execute(plan); err != nil {
+		return fmt.Errorf("one or more files failed to sync: %w", err)
 	}
 
+	slog.Info("Sync completed successfully")
+
 	return nil
 }
+
+//
+// private functions
+//
+
+// resolveServices returns the explicitly requested services along with every
+// service, either local or on the manager, that is in one of the requested
+// quarters or on or after the requested since date
+func resolveServices(config model.ClientConfig, syncConfig model.SyncConfig, manager *managerClient) ([]string, error) {
+	services := make([]string, 0, len(syncConfig.Services))
+
+	for _, service := range syncConfig.Services {
+		if len(service) < 10 {
+			return nil, fmt.Errorf("provided service doesn't appread to be a date: '%s'", service)
+		}
+
+		if !slices.Contains(services, service[:10]) {
+			services = append(services, service[:10])
+		}
+	}
+
+	if len(syncConfig.Quarters) == 0 && syncConfig.Since.IsZero() {
+		return services, nil
+	}
+
+	knownServices, err := sync.ListServices(config.DataDirs.Services)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to list local services: %s", err.Error()))
+	}
+
+	managerServices, err := manager.listServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services on manager: %w", err)
+	}
+	knownServices = append(knownServices, managerServices...)
+
+	for _, service := range knownServices {
+		date, err := time.Parse("2006-01-02", service)
+		if err != nil || slices.Contains(services, service) {
+			continue
+		}
+
+		if slices.Contains(syncConfig.Quarters, util.GetServiceQuarter(date)) ||
+			(!syncConfig.Since.IsZero() && !date.Before(syncConfig.Since)) {
+			services = append(services, service)
+		}
+	}
+
+	sort.Strings(services)
+
+	return services, nil
+}
+
+// printPlan writes the plan to stdout as a table, skipping files that need no action
+func printPlan(plan model.SyncRequest) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERVICE\tCLIENT\tMANAGER\tSIZE\tPATH")
+
+	inSync := 0
+	for _, service := range plan.Services {
+		for _, file := range plan.ServiceFiles[service] {
+			if file.ClientAction == model.SyncActionNone && file.ServerAction == model.SyncActionNone {
+				inSync++
+				continue
+			}
+
+			filePath := file.FilePath
+			if file.PreviousPath != "" {
+				filePath = fmt.Sprintf("%s -> %s", file.PreviousPath, file.FilePath)
+			}
+
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", service, file.ClientAction, file.ServerAction, util.FormatBytes(file.Size), filePath)
+		}
+	}
+
+	writer.Flush()
+	fmt.Printf("\n%d file(s) already in sync\n", inSync)
+}
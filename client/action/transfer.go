@@ -0,0 +1,265 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+	ccmmsync "ccmm/util/sync"
+)
+
+// retryWaitSeconds is multiplied by the attempt number to get the time to wait
+// before retrying a failed transfer
+const retryWaitSeconds = 2
+
+// syncTask is a single file that needs work done on the client side
+type syncTask struct {
+	file      model.SyncFile
+	localPath string
+}
+
+// syncExecutor applies a sync plan to the client
+type syncExecutor struct {
+	config   model.ClientConfig
+	manager  *managerClient
+	progress *progressBar
+}
+
+// execute performs every client action in the plan. Moves and deletes are done
+// first, then all transfers are run in parallel. Each transfer is retried up to
+// the configured number of times. A joined error describing every file that
+// could not be synced is returned
+func (se *syncExecutor) execute(plan model.SyncRequest) error {
+	var syncErrors []error
+	transfers := make([]syncTask, 0)
+	totalBytes := int64(0)
+
+	for _, service := range plan.Services {
+		serviceDir, err := ccmmsync.GetServiceDirectory(service, se.config.DataDirs.Services)
+		if err != nil {
+			syncErrors = append(syncErrors, err)
+			continue
+		}
+
+		for _, file := range plan.ServiceFiles[service] {
+			if file.Service == "" {
+				file.Service = service
+			}
+
+			localPath, err := resolveLocalPath(serviceDir, file.FilePath)
+			if err != nil {
+				syncErrors = append(syncErrors, err)
+				continue
+			}
+
+			task := syncTask{
+				file:      file,
+				localPath: localPath,
+			}
+
+			switch file.ClientAction {
+			case model.SyncActionMoved:
+				previousPath, err := resolveLocalPath(serviceDir, file.PreviousPath)
+				if err != nil {
+					syncErrors = append(syncErrors, err)
+					continue
+				}
+
+				if err := moveLocalFile(previousPath, task.localPath); err != nil {
+					syncErrors = append(syncErrors, fmt.Errorf("%s: %w", file.PreviousPath, err))
+				}
+
+			case model.SyncActionDelete:
+				slog.Info(fmt.Sprintf("Deleting duplicate file '%s'", task.localPath))
+				if err := os.Remove(task.localPath); err != nil {
+					syncErrors = append(syncErrors, fmt.Errorf("%s: %w", file.FilePath, err))
+				}
+
+			case model.SyncActionAdd, model.SyncActionUpdate, model.SyncActionSend:
+				transfers = append(transfers, task)
+				totalBytes += file.Size
+			}
+		}
+	}
+
+	se.progress.start(len(transfers), totalBytes)
+	syncErrors = append(syncErrors, se.runTransfers(transfers)...)
+	se.progress.stop()
+
+	return errors.Join(syncErrors...)
+}
+
+//
+// private functions
+//
+
+func (se *syncExecutor) runTransfers(transfers []syncTask) []error {
+	var (
+		transferErrors []error
+		errorMutex     sync.Mutex
+		waitGroup      sync.WaitGroup
+	)
+
+	workers := max(se.config.SyncWorkers, 1)
+	taskChan := make(chan syncTask)
+
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			for task := range taskChan {
+				if err := se.transferWithRetry(task); err != nil {
+					errorMutex.Lock()
+					transferErrors = append(transferErrors, fmt.Errorf("%s: %w", task.file.FilePath, err))
+					errorMutex.Unlock()
+				}
+				se.progress.fileDone()
+			}
+		}()
+	}
+
+	for _, task := range transfers {
+		taskChan <- task
+	}
+	close(taskChan)
+
+	waitGroup.Wait()
+
+	return transferErrors
+}
+
+func (se *syncExecutor) transferWithRetry(task syncTask) error {
+	var err error
+
+	attempts := max(se.config.SyncRetries, 0) + 1
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		transferred := int64(0)
+		progressCallback := func(bytes int64) {
+			atomic.AddInt64(&transferred, bytes)
+			se.progress.addBytes(bytes)
+		}
+
+		if task.file.ClientAction == model.SyncActionSend {
+			slog.Debug(fmt.Sprintf("Uploading '%s' [attempt %d/%d]", task.localPath, attempt, attempts))
+			err = se.manager.upload(task.file, task.localPath, progressCallback)
+		} else {
+			slog.Debug(fmt.Sprintf("Downloading '%s' [attempt %d/%d]", task.localPath, attempt, attempts))
+			if err = os.MkdirAll(filepath.Dir(task.localPath), 0755); err == nil {
+				err = se.manager.download(task.file, task.localPath, progressCallback)
+			}
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		// the next attempt reports its own progress, including anything resumed
+		se.progress.addBytes(-atomic.LoadInt64(&transferred))
+
+		if attempt < attempts {
+			slog.Warn(fmt.Sprintf("Failed to transfer '%s', waiting %d seconds and trying again [attempt %d/%d]: %s",
+				task.file.FilePath, attempt*retryWaitSeconds, attempt, attempts, err.Error()))
+			time.Sleep(time.Duration(attempt*retryWaitSeconds) * time.Second)
+		}
+	}
+
+	return err
+}
+
+// resolveLocalPath returns the local path of a file from the sync plan. Paths
+// in the plan are rooted at the service directory (ex: /Audio/Tr1.WAV). The
+// plan comes from the manager, so a path that would end up outside of the
+// service directory is rejected
+func resolveLocalPath(serviceDir string, planPath string) (string, error) {
+	relativePath := strings.TrimPrefix(planPath, "/")
+
+	if relativePath == "" || path.IsAbs(relativePath) || filepath.IsAbs(relativePath) || filepath.VolumeName(relativePath) != "" {
+		return "", fmt.Errorf("%s: invalid path in sync plan", planPath)
+	}
+
+	localPath := filepath.Join(serviceDir, filepath.FromSlash(relativePath))
+
+	if rel, err := filepath.Rel(serviceDir, localPath); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: path in sync plan is outside of the service directory", planPath)
+	}
+
+	return localPath, nil
+}
+
+func moveLocalFile(previousPath string, newPath string) error {
+	slog.Info(fmt.Sprintf("Moving '%s' to '%s'", previousPath, newPath))
+
+	if util.FileExists(newPath) {
+		return fmt.Errorf("cannot move, '%s' already exists", newPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(previousPath, newPath)
+}
+
+// verifyFile ensures that a downloaded file matches the size and, if known,
+// the hash from the sync plan
+func verifyFile(filePath string, file model.SyncFile) error {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() != file.Size {
+		return fmt.Errorf("expected %d bytes but received %d", file.Size, stat.Size())
+	}
+
+	if file.Hash == "" {
+		return nil
+	}
+
+	hash, err := util.HashFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	if hash != file.Hash {
+		return fmt.Errorf("%w: expected %s but got %s", util.ErrChecksumMismatch, file.Hash, hash)
+	}
+
+	return nil
+}
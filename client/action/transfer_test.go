@@ -0,0 +1,186 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ccmm/model"
+	"ccmm/util"
+	ccmmsync "ccmm/util/sync"
+)
+
+const testService = "2024-11-03"
+
+// fakeSyncManager serves the files of a service directory the way the
+// manager's file endpoints do
+type fakeSyncManager struct {
+	serviceDir string
+}
+
+func (m *fakeSyncManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/uploads/") {
+		w.Write([]byte(`{"offset":0}`))
+		return
+	}
+
+	relativePath := strings.TrimPrefix(r.URL.Path, "/api/v1/files/"+testService+"/")
+	localPath := filepath.Join(m.serviceDir, filepath.FromSlash(relativePath))
+
+	switch r.Method {
+	case http.MethodGet:
+		http.ServeFile(w, r, localPath)
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		os.MkdirAll(filepath.Dir(localPath), 0755)
+		os.WriteFile(localPath, data, 0644)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// writeServiceFiles writes the files to the service directory below root,
+// recording their hashes in the manifest the way the importer does
+func writeServiceFiles(t *testing.T, root string, files map[string]string) string {
+	t.Helper()
+
+	serviceDir, err := ccmmsync.GetServiceDirectory(testService, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make(map[string]model.ManifestEntry)
+	for relativePath, content := range files {
+		fullPath := filepath.Join(serviceDir, filepath.FromSlash(relativePath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		entries[relativePath] = model.ManifestEntry{Size: int64(len(content)), HashAlgorithm: util.HashAlgorithm, Hash: sha256Hex(content)}
+	}
+
+	if err := util.UpdateManifest(serviceDir, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	return serviceDir
+}
+
+func TestSyncExecutorAppliesPlan(t *testing.T) {
+	clientRoot := t.TempDir()
+	managerRoot := t.TempDir()
+
+	clientServiceDir := writeServiceFiles(t, clientRoot, map[string]string{
+		"Audio/old/Tr1.WAV":  "moved recording",
+		"Audio/copy/Tr1.WAV": "moved recording",
+		"Audio/client.WAV":   "client only",
+	})
+	managerServiceDir := writeServiceFiles(t, managerRoot, map[string]string{
+		"Audio/new/Tr1.WAV":  "moved recording",
+		"Audio/manager.WAV":  "manager only",
+		"Video/cam/A001.MXF": "video",
+	})
+
+	request := model.SyncRequest{
+		Services:     []string{testService},
+		ServiceFiles: map[string][]model.SyncFile{testService: ccmmsync.ScanService(testService, nil, clientRoot)},
+	}
+	plan := ccmmsync.PlanSync(request, func(serviceDateStr string) []model.SyncFile {
+		return ccmmsync.ScanService(serviceDateStr, nil, managerRoot)
+	})
+
+	server := httptest.NewServer(&fakeSyncManager{serviceDir: managerServiceDir})
+	defer server.Close()
+
+	config := model.ClientConfig{ManagerAddress: server.URL, SyncWorkers: 2}
+	config.DataDirs.Services = clientRoot
+
+	executor := &syncExecutor{config: config, manager: newManagerClient(config), progress: &progressBar{output: io.Discard}}
+	if err := executor.execute(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client ends up with the manager's layout, and the manager with the
+	// file only the client had
+	want := map[string]string{
+		filepath.Join(clientServiceDir, "Audio", "new", "Tr1.WAV"):  "moved recording",
+		filepath.Join(clientServiceDir, "Audio", "manager.WAV"):     "manager only",
+		filepath.Join(clientServiceDir, "Audio", "client.WAV"):      "client only",
+		filepath.Join(clientServiceDir, "Video", "cam", "A001.MXF"): "video",
+		filepath.Join(managerServiceDir, "Audio", "client.WAV"):     "client only",
+	}
+	for fullPath, content := range want {
+		if data, err := os.ReadFile(fullPath); err != nil || string(data) != content {
+			t.Errorf("'%s': expected '%s', got '%s' %v", fullPath, content, data, err)
+		}
+	}
+
+	for _, relativePath := range []string{"Audio/old/Tr1.WAV", "Audio/copy/Tr1.WAV"} {
+		if _, err := os.Stat(filepath.Join(clientServiceDir, filepath.FromSlash(relativePath))); err == nil {
+			t.Errorf("expected '%s' to be moved or deleted", relativePath)
+		}
+	}
+}
+
+func TestSyncExecutorRejectsEscapingPaths(t *testing.T) {
+	clientRoot := t.TempDir()
+	serviceDir := writeServiceFiles(t, clientRoot, map[string]string{"Audio/Tr1.WAV": "recording"})
+
+	outside := filepath.Join(clientRoot, "outside.txt")
+	if err := os.WriteFile(outside, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := model.SyncRequest{
+		Services: []string{testService},
+		ServiceFiles: map[string][]model.SyncFile{testService: {
+			{FilePath: "/../../outside.txt", MediaType: "Audio", ClientAction: model.SyncActionDelete},
+			{FilePath: "/Audio/Tr2.WAV", PreviousPath: "/../../outside.txt", MediaType: "Audio", ClientAction: model.SyncActionMoved},
+		}},
+	}
+
+	config := model.ClientConfig{}
+	config.DataDirs.Services = clientRoot
+
+	executor := &syncExecutor{config: config, progress: &progressBar{output: io.Discard}}
+	if err := executor.execute(plan); err == nil {
+		t.Error("expected the plan to be refused")
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside of the service directory was touched: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(serviceDir, "Audio", "Tr2.WAV")); err == nil {
+		t.Error("file outside of the service directory was moved in")
+	}
+}
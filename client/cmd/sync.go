@@ -28,6 +28,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"ccmm/client/action"
 	"ccmm/model"
@@ -36,9 +37,13 @@ import (
 )
 
 var (
-	syncArgDryRun bool
-	syncArgServer string
-	syncArgDump   bool
+	syncArgDryRun     bool
+	syncArgServer     string
+	syncArgDump       bool
+	syncArgServices   []string
+	syncArgMediaTypes []string
+	syncArgQuarters   []string
+	syncArgSince      string
 
 	syncCmd = &cobra.Command{
 		Use:   "sync",
@@ -48,14 +53,24 @@ var (
 		Run: func(cmd *cobra.Command, args []string) {
 			config := cmd.Context().Value(model.ClientConfigContext).(model.ClientConfig)
 
+			if syncArgServer != "" {
+				config.ManagerAddress = syncArgServer
+			}
+
 			var syncConfig model.SyncConfig
 			syncConfig.DryRun = syncArgDryRun
 			syncConfig.Dump = syncArgDump
-			syncConfig.Services = []string{
-				"2024-11-03",
-			}
-			syncConfig.MediaTypes = []string{
-				"Video",
+			syncConfig.Services = syncArgServices
+			syncConfig.MediaTypes = syncArgMediaTypes
+			syncConfig.Quarters = syncArgQuarters
+
+			if syncArgSince != "" {
+				since, err := time.Parse("2006-01-02", syncArgSince)
+				if err != nil {
+					slog.Error(fmt.Sprintf("Invalid --since date '%s', expected YYYY-MM-DD", syncArgSince))
+					os.Exit(1)
+				}
+				syncConfig.Since = since
 			}
 
 			slog.Debug(fmt.Sprintf("%+v", syncConfig))
@@ -71,10 +86,13 @@ var (
 )
 
 func init() {
-	// TODO: add args for data types, services, etc
-	syncCmd.Flags().BoolVarP(&syncArgDryRun, "dry_run", "n", false, "Perform a dry-run import (don't copy anything)")
+	syncCmd.Flags().BoolVarP(&syncArgDryRun, "dry_run", "n", false, "Print the sync plan without transferring anything")
 	syncCmd.Flags().BoolVarP(&syncArgDump, "dump", "d", false, "If set, dump the list of scanned files to json and exit (for debugging only)")
-	syncCmd.Flags().StringVarP(&syncArgServer, "server", "s", "localhost:7273", "<host>:<port> -- If specified, connect to the specified server instance to queue an import")
+	syncCmd.Flags().StringVarP(&syncArgServer, "server", "s", "", "http://<host>:<port> -- If specified, overrides the manager_address from the config file")
+	syncCmd.Flags().StringSliceVar(&syncArgServices, "service", []string{}, "Service date to sync (ex: 2024-11-03), can be repeated")
+	syncCmd.Flags().StringSliceVar(&syncArgMediaTypes, "media-type", []string{}, "Media type to sync (ex: Video), can be repeated. Defaults to all media types")
+	syncCmd.Flags().StringSliceVar(&syncArgQuarters, "quarter", []string{}, "Sync every service in the quarter (ex: \"2024 Q4\"), can be repeated")
+	syncCmd.Flags().StringVar(&syncArgSince, "since", "", "Sync every service on or after the provided date (ex: 2024-10-01)")
 
 	rootCmd.AddCommand(syncCmd)
 }
@@ -11,6 +11,7 @@ require (
 	github.com/prometheus-community/pro-bing v0.4.1
 	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/spf13/cobra v1.8.1
+	go.etcd.io/bbolt v1.3.11
 	gopkg.in/yaml.v2 v2.4.0
 )
 
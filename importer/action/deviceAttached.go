@@ -29,6 +29,7 @@ import (
 	"log/slog"
 	"time"
 
+	"ccmm/importer/statuslight"
 	"ccmm/model"
 	"ccmm/util"
 )
@@ -42,6 +43,7 @@ var (
 // will automaticlly mount the device, call an import, then unmount and power off the device
 func DeviceAttached(config model.ImporterConfig, params model.DeviceAttached) {
 	slog.Info(fmt.Sprintf("Handle device attachment for '%s'", params.DevicePath))
+	statuslight.SetDeviceState(params.DevicePath, statuslight.Detecting)
 
 	if !params.AlreadyMounted {
 		for i := 1; i <= mountRetries; i++ {
@@ -58,6 +60,7 @@ func DeviceAttached(config model.ImporterConfig, params model.DeviceAttached) {
 
 	if params.MountPath == "" {
 		slog.Error(fmt.Sprintf("Failed to mount device %s", params.DevicePath))
+		statuslight.SetDeviceState(params.DevicePath, statuslight.Error)
 		return
 	}
 
@@ -66,12 +69,15 @@ func DeviceAttached(config model.ImporterConfig, params model.DeviceAttached) {
 		VolumePath: params.MountPath,
 	}
 
-	Import(config, importConfig, func(_ *ImportQueueItem) {
-		// TODO: do empty, if enabled
+	statuslight.SetDeviceState(params.DevicePath, statuslight.Importing)
 
+	jobID := Import(config, importConfig, func(queueItem *ImportQueueItem) {
+		PostImport(config, queueItem)
+
+		unmounted := false
 		for i := 1; i <= mountRetries; i++ {
-			success := util.UnmountVolume(params.DevicePath)
-			if success {
+			unmounted = util.UnmountVolume(params.DevicePath)
+			if unmounted {
 				break
 			}
 
@@ -79,8 +85,30 @@ func DeviceAttached(config model.ImporterConfig, params model.DeviceAttached) {
 				params.DevicePath, mountRetryWaitSeconds, i, mountRetries))
 			time.Sleep(time.Duration(mountRetryWaitSeconds) * time.Second)
 		}
+
+		importMutex.Lock()
+		status := queueItem.Status
+		importMutex.Unlock()
+
+		switch {
+		case !unmounted || status == Failed || status == Cancelled:
+			statuslight.SetDeviceState(params.DevicePath, statuslight.Error)
+		default:
+			statuslight.SetDeviceState(params.DevicePath, statuslight.Done)
+		}
+
+		// the card reader disappears when it is powered off, so the device is
+		// forgotten first to keep showing the result until the next card is inserted
+		if unmounted {
+			statuslight.ForgetDevice(params.DevicePath)
+		}
+
 		util.PowerOffDevice(params.DevicePath)
 
 		slog.Info(fmt.Sprintf("Finished device attachment for '%s'", params.DevicePath))
 	})
+
+	if jobID < 0 {
+		statuslight.SetDeviceState(params.DevicePath, statuslight.Error)
+	}
 }
@@ -0,0 +1,241 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"ccmm/importer/jobstore"
+	"ccmm/model"
+	"ccmm/util"
+)
+
+var (
+	jobStore *jobstore.Store
+
+	// persistMutex makes sure that job snapshots are written to the store in
+	// the order they were taken. It must be acquired before importMutex
+	persistMutex sync.Mutex
+)
+
+// ErrHistoryUnavailable is returned when the job history is requested but the
+// job store has not been opened
+var ErrHistoryUnavailable = errors.New("job history is not available")
+
+// OpenJobStore opens the job database configured by config.JobDatabase. Once
+// opened, every import job is persisted as it moves through the queue and new
+// jobs are numbered after the last stored job
+func OpenJobStore(config model.ImporterConfig) error {
+	store, err := jobstore.Open(config.JobDatabase)
+	if err != nil {
+		return err
+	}
+
+	lastID, err := store.LastJobID()
+	if err != nil {
+		store.Close()
+		return err
+	}
+
+	importMutex.Lock()
+	jobStore = store
+	queueIndex = max(queueIndex, lastID)
+	importMutex.Unlock()
+
+	slog.Info(fmt.Sprintf("Opened job database '%s', last job ID: %d", config.JobDatabase, lastID))
+
+	return nil
+}
+
+// CloseJobStore closes the job database, if open
+func CloseJobStore() {
+	persistMutex.Lock()
+	defer persistMutex.Unlock()
+
+	if jobStore != nil {
+		jobStore.Close()
+		jobStore = nil
+	}
+}
+
+// ResumeInterruptedJobs finds the jobs that were still queued or running when
+// the importer last stopped and queues them again. Files that were already
+// imported are found at their destination and skipped, so a resumed job only
+// copies what is missing. If the volume of a job is no longer mounted where
+// it was, the job is marked as interrupted instead.
+//
+// The post-import action and device power off are not performed for resumed
+// jobs, as the device that was attached may no longer be the same one
+func ResumeInterruptedJobs(config model.ImporterConfig) {
+	if jobStore == nil {
+		return
+	}
+
+	jobs, err := jobStore.GetUnfinishedJobs([]string{Completed.String(), Failed.String(), Cancelled.String(), Interrupted.String()})
+	if err != nil {
+		slog.Error("Failed to read unfinished jobs from job database: " + err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		queueItem := &ImportQueueItem{
+			ID: job.ID,
+			Params: model.ImportVolume{
+				VolumePath: job.VolumePath,
+				DryRun:     job.DryRun || config.ForceDryRun,
+			},
+			VolumeLabel: job.VolumeLabel,
+			QueuedAt:    job.QueuedAt,
+			PostImport:  job.PostImport,
+			Transitions: job.Transitions,
+			Resumed:     job.Resumed,
+			FinishedCallback: func(queueItem *ImportQueueItem) {
+				slog.Info(fmt.Sprintf("Resumed import #%d finished, the post-import action is not run for resumed jobs", queueItem.ID))
+			},
+		}
+
+		reason := ""
+		if !util.DirectoryExists(job.VolumePath) {
+			reason = "volume is no longer mounted"
+		} else if label := util.GetVolumeName(job.VolumePath); label != job.VolumeLabel {
+			reason = fmt.Sprintf("a different volume ('%s') is now mounted at the volume path", label)
+		}
+
+		if reason != "" {
+			slog.Warn(fmt.Sprintf("Import #%d for volume '%s' was interrupted and cannot be resumed: %s", job.ID, job.VolumePath, reason))
+			queueItem.setStatus(Interrupted, "importer stopped while the job was "+job.Status+", "+reason)
+			continue
+		}
+
+		slog.Info(fmt.Sprintf("Resuming interrupted import #%d for volume '%s'", job.ID, job.VolumePath))
+
+		queueItem.Resumed++
+		queueItem.transition(Interrupted, "importer stopped while the job was "+job.Status+", resuming")
+		queueImport(config, queueItem)
+	}
+}
+
+// GetImportHistory returns the stored import jobs that match the provided
+// filter, newest first. Jobs that are still in memory are reported with their
+// current state. File lists are not included
+func GetImportHistory(filter model.ImportHistoryFilter) ([]model.ImportJobStatus, error) {
+	persistMutex.Lock()
+	if jobStore == nil {
+		persistMutex.Unlock()
+		return nil, ErrHistoryUnavailable
+	}
+
+	jobs, err := jobStore.GetJobs(filter)
+	persistMutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	importMutex.Lock()
+	defer importMutex.Unlock()
+
+	for i, job := range jobs {
+		if queueItem := findQueueItem(job.ID); queueItem != nil {
+			jobs[i] = queueItem.toJobStatus(false)
+		}
+	}
+
+	return jobs, nil
+}
+
+//
+// private functions
+//
+
+// findQueueItem must only be called while holding importMutex
+func findQueueItem(id int) *ImportQueueItem {
+	if queueItem, ok := importQueue[id]; ok {
+		return queueItem
+	}
+
+	for _, finishedJob := range finishedJobs {
+		if finishedJob.ID == id {
+			return finishedJob
+		}
+	}
+
+	return nil
+}
+
+func getStoredJob(id int) *model.ImportJobStatus {
+	persistMutex.Lock()
+	defer persistMutex.Unlock()
+
+	if jobStore == nil {
+		return nil
+	}
+
+	job, err := jobStore.GetJob(id)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to read job #%d from job database: %s", id, err.Error()))
+		return nil
+	}
+
+	return job
+}
+
+// persist writes the current state of the queue item to the job store.
+// Must not be called while holding importMutex
+func (queueItem *ImportQueueItem) persist() {
+	queueItem.save(nil)
+}
+
+// recordResult is used as the processor.ImportResultCallback for a queue item
+func (queueItem *ImportQueueItem) recordResult(result model.ImportFileResult) {
+	queueItem.save(&result)
+}
+
+func (queueItem *ImportQueueItem) save(result *model.ImportFileResult) {
+	persistMutex.Lock()
+	defer persistMutex.Unlock()
+
+	if jobStore == nil {
+		return
+	}
+
+	importMutex.Lock()
+	jobStatus := queueItem.toJobStatus(false)
+	importMutex.Unlock()
+
+	var err error
+	if result != nil {
+		err = jobStore.SaveResult(jobStatus, *result)
+	} else {
+		err = jobStore.SaveJob(jobStatus)
+	}
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to save job #%d to job database: %s", queueItem.ID, err.Error()))
+	}
+}
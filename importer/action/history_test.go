@@ -0,0 +1,123 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ccmm/importer/jobstore"
+	"ccmm/model"
+	"ccmm/util"
+)
+
+func TestResumeInterruptedJobs(t *testing.T) {
+	config := newBlockingImportConfig()
+	config.JobDatabase = filepath.Join(t.TempDir(), "jobs.db")
+
+	importMutex.Lock()
+	firstID := queueIndex + 1
+	importMutex.Unlock()
+
+	volumePath := t.TempDir()
+	resumedID, unmountedID, completedID := firstID, firstID+1, firstID+2
+	queuedAt := time.Now().Add(-time.Hour)
+
+	// the jobs are written to the database as a previous run of the importer
+	// would have left them
+	store, err := jobstore.Open(config.JobDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []model.ImportJobStatus{
+		{ID: resumedID, VolumePath: volumePath, VolumeLabel: util.GetVolumeName(volumePath), Status: Importing.String(), QueuedAt: queuedAt},
+		{ID: unmountedID, VolumePath: filepath.Join(volumePath, "gone"), VolumeLabel: "H6_SD", Status: Scanning.String(), QueuedAt: queuedAt},
+		{ID: completedID, VolumePath: volumePath, Status: Completed.String(), QueuedAt: queuedAt},
+	} {
+		if err := store.SaveJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	if err := OpenJobStore(config); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseJobStore()
+
+	importMutex.Lock()
+	if queueIndex != completedID {
+		t.Errorf("expected new jobs to be numbered after %d, got %d", completedID, queueIndex)
+	}
+	importMutex.Unlock()
+
+	StartScheduler(config)
+	defer StopScheduler(time.Second)
+
+	ResumeInterruptedJobs(config)
+
+	select {
+	case <-scanningChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resumed job never started scanning")
+	}
+
+	importMutex.Lock()
+	resumed := importQueue[resumedID]
+	var resumedCount int
+	var transitions []model.ImportJobTransition
+	if resumed != nil {
+		resumedCount = resumed.Resumed
+		transitions = append(transitions, resumed.Transitions...)
+	}
+	_, unmountedQueued := importQueue[unmountedID]
+	_, completedQueued := importQueue[completedID]
+	importMutex.Unlock()
+
+	if resumed == nil {
+		t.Fatalf("expected job #%d to be queued again", resumedID)
+	}
+	if resumedCount != 1 {
+		t.Errorf("expected resumed count %v, got %v", 1, resumedCount)
+	}
+	if len(transitions) == 0 || transitions[0].Status != Interrupted.String() {
+		t.Errorf("expected the job to record that it was interrupted, got %+v", transitions)
+	}
+	if unmountedQueued || completedQueued {
+		t.Errorf("expected only job #%d to be queued, unmounted %t, completed %t", resumedID, unmountedQueued, completedQueued)
+	}
+
+	if job := getStoredJob(unmountedID); job == nil || job.Status != Interrupted.String() {
+		t.Errorf("expected job #%d to be marked interrupted, got %+v", unmountedID, job)
+	}
+	if job := getStoredJob(completedID); job == nil || job.Status != Completed.String() || job.Resumed != 0 {
+		t.Errorf("expected job #%d to be left alone, got %+v", completedID, job)
+	}
+
+	if err := CancelImport(resumedID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -25,8 +25,11 @@
 package action
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -34,6 +37,7 @@ import (
 	"ccmm/importer/processor"
 	"ccmm/model"
 	"ccmm/util"
+	"ccmm/util/notify"
 )
 
 // ImportStatus Enum to describe the potential state of enums
@@ -54,121 +58,394 @@ const (
 
 	// Failed One or more errors occurred during the import process
 	Failed
+
+	// Cancelled The import was cancelled by request before it finished
+	Cancelled
+
+	// Interrupted The importer stopped while the job was running and the job
+	// could not be resumed when the importer started again
+	Interrupted
 )
 
+// maxFinishedJobs is the number of finished jobs that are kept in memory so that
+// their final status can be queried from the status API
+const maxFinishedJobs = 50
+
+// String returns the name of the import status
+func (s ImportStatus) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Scanning:
+		return "scanning"
+	case Importing:
+		return "importing"
+	case Completed:
+		return "completed"
+	case Failed:
+		return "failed"
+	case Cancelled:
+		return "cancelled"
+	case Interrupted:
+		return "interrupted"
+	}
+
+	return "unknown"
+}
+
 // ImportQueueItem Structure that defines an import job
 type ImportQueueItem struct {
+	ID               int
 	Params           model.ImportVolume
+	VolumeLabel      string
+	Volume           *model.Volume
 	Processors       []processor.Processor
+	Diagnostics      []model.ProcessorDiagnostic
 	Files            []model.SourceFile
 	Status           ImportStatus
+	Results          []model.ImportFileResult
+	Progress         model.ImportProgress
+	QueuedAt         time.Time
+	PostImport       *model.PostImportReport
+	Transitions      []model.ImportJobTransition
+	Resumed          int
 	FinishedCallback func(queueItem *ImportQueueItem)
-	processCallback  func(queueItem *ImportQueueItem)
+	processCallback  func(ctx context.Context, queueItem *ImportQueueItem)
+	transferredBytes int64
+
+	// device identifies the physical device the volume lives on, jobs on the
+	// same device are never run at the same time
+	device string
+
+	// cancel is set by the scheduler while the job is running
+	cancel context.CancelCauseFunc
 }
 
 var (
-	queueIndex  int
-	importQueue = map[int]*ImportQueueItem{}
-	importMutex sync.Mutex
+	queueIndex   int
+	importQueue  = map[int]*ImportQueueItem{}
+	finishedJobs = make([]*ImportQueueItem, 0)
+	importMutex  sync.Mutex
 )
 
-func getFirstQueueIndex(importQueue *map[int]*ImportQueueItem) int {
-	keys := make([]int, 0)
-	for k := range *importQueue {
-		keys = append(keys, k)
-	}
+// GetImportStatus returns a snapshot of every queued, running and recently
+// finished import job, ordered by job ID. File lists are not included
+func GetImportStatus() []model.ImportJobStatus {
+	importMutex.Lock()
+	defer importMutex.Unlock()
 
-	if len(keys) == 0 {
-		return -1
+	jobs := make([]model.ImportJobStatus, 0, len(finishedJobs)+len(importQueue))
+
+	for _, queueItem := range finishedJobs {
+		jobs = append(jobs, queueItem.toJobStatus(false))
 	}
 
-	sort.Ints(keys)
-	for _, k := range keys {
-		return k
+	for _, queueItem := range importQueue {
+		jobs = append(jobs, queueItem.toJobStatus(false))
 	}
 
-	return -1
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+
+	return jobs
 }
 
-// ImportWorker Routine that runs and processes the import queue sequentially
-func ImportWorker() {
-	// TODO: add cancel channel
-	for {
-		importMutex.Lock()
-		firstQueueIndex := getFirstQueueIndex(&importQueue)
+// GetDeviceStatus returns the running and queued import jobs of each physical
+// source device that currently has jobs, ordered by device
+func GetDeviceStatus() []model.ImportDeviceStatus {
+	importMutex.Lock()
+	defer importMutex.Unlock()
 
-		// nothing pending, so we wait a bit and check again.
-		if firstQueueIndex < 0 {
-			importMutex.Unlock()
-			time.Sleep(500 * time.Millisecond)
-			continue
+	devices := make(map[string]*model.ImportDeviceStatus)
+
+	for _, queueItem := range importQueue {
+		device, ok := devices[queueItem.device]
+		if !ok {
+			device = &model.ImportDeviceStatus{
+				Device:     queueItem.device,
+				QueuedJobs: make([]int, 0),
+			}
+			devices[queueItem.device] = device
 		}
-		queueItem := importQueue[firstQueueIndex]
-		importMutex.Unlock()
 
-		// there seems to be some sort of race condition here, so this is a workaround for it
-		if queueItem == nil || queueItem.processCallback == nil {
-			slog.Debug(fmt.Sprintf("Process callback not set for queue item #%d, trying again..", firstQueueIndex))
-			time.Sleep(500 * time.Millisecond)
-			continue
+		if queueItem.cancel != nil {
+			device.RunningJob = queueItem.ID
+			device.BytesPerSecond = queueItem.toJobStatus(false).Progress.BytesPerSecond
+		} else {
+			device.QueuedJobs = append(device.QueuedJobs, queueItem.ID)
 		}
+	}
 
-		slog.Info(fmt.Sprintf("Processing import queue item '%d', volume path: '%s'", firstQueueIndex, queueItem.Params.VolumePath))
+	deviceStatus := make([]model.ImportDeviceStatus, 0, len(devices))
+	for _, device := range devices {
+		sort.Ints(device.QueuedJobs)
+		deviceStatus = append(deviceStatus, *device)
+	}
 
-		queueItem.processCallback(queueItem)
+	sort.Slice(deviceStatus, func(i, j int) bool { return deviceStatus[i].Device < deviceStatus[j].Device })
 
-		importMutex.Lock()
-		slog.Info(fmt.Sprintf("Finished processing import queue item '%d', volume path: '%s'", firstQueueIndex, queueItem.Params.VolumePath))
-		delete(importQueue, firstQueueIndex)
+	return deviceStatus
+}
+
+// GetImportJob returns a snapshot of the import job with the provided ID,
+// including the list of enumerated files. Jobs that are no longer held in
+// memory are read from the job store. If no job with the provided ID is
+// known, nil is returned
+func GetImportJob(id int) *model.ImportJobStatus {
+	importMutex.Lock()
+	queueItem := findQueueItem(id)
+
+	if queueItem == nil {
 		importMutex.Unlock()
+		return getStoredJob(id)
 	}
+
+	jobStatus := queueItem.toJobStatus(true)
+	importMutex.Unlock()
+
+	return &jobStatus
 }
 
 // Import Add a new import job to the queue by providing the params
 // that describe the import job. Additionally, a finishedCallback should
 // be provided and will be executed upon completion of the import job
 // (regardless of a successful or failed import)
-func Import(config model.ImporterConfig, params model.ImportVolume, finishedCallback func(queueItem *ImportQueueItem)) {
+//
+// The ID of the newly queued job is returned, or -1 if the job could not be queued
+func Import(config model.ImporterConfig, params model.ImportVolume, finishedCallback func(queueItem *ImportQueueItem)) int {
 	if !util.DirectoryExists(params.VolumePath) {
 		slog.Error(fmt.Sprintf("Cannot import because directory not found: %s", params.VolumePath))
-		return
+		return -1
 	}
 
+	importMutex.Lock()
 	queueIndex++
-	slog.Info(fmt.Sprintf("Queueing import #%d for volume '%s'", queueIndex, params.VolumePath))
+	jobID := queueIndex
+	importMutex.Unlock()
 
-	importMutex.Lock()
-	importQueue[queueIndex] = &ImportQueueItem{
+	slog.Info(fmt.Sprintf("Queueing import #%d for volume '%s'", jobID, params.VolumePath))
+
+	queueImport(config, &ImportQueueItem{
+		ID:               jobID,
 		Params:           params,
-		Status:           Scanning,
-		Processors:       make([]processor.Processor, 0),
-		Files:            make([]model.SourceFile, 0),
+		VolumeLabel:      util.GetVolumeName(params.VolumePath),
+		QueuedAt:         time.Now(),
 		FinishedCallback: finishedCallback,
+	})
+
+	return jobID
+}
+
+//
+// private functions
+//
+
+// queueImport adds the provided queue item to the import queue and wakes the
+// scheduler. The item is only added once it is completely set up, processor
+// detection happens when the job is started
+func queueImport(config model.ImporterConfig, queueItem *ImportQueueItem) {
+	params := queueItem.Params
+
+	queueItem.Processors = make([]processor.Processor, 0)
+	queueItem.Files = make([]model.SourceFile, 0)
+	queueItem.device = util.GetPhysicalDevice(params.VolumePath)
+	if queueItem.device == "" {
+		queueItem.device = params.VolumePath
 	}
-	importMutex.Unlock()
 
-	processors := processor.FindProcessors(config, params.VolumePath)
+	queueItem.processCallback = func(ctx context.Context, queueItem *ImportQueueItem) {
+		queueItem.setStatus(Scanning, "")
+
+		volume := util.OpenDirectoryVolume(params.VolumePath)
+		processors, diagnostics := processor.FindProcessors(ctx, config, volume)
 
-	importMutex.Lock()
-	importQueue[queueIndex].Status = Pending
-	importQueue[queueIndex].Processors = processors
-	importQueue[queueIndex].processCallback = func(queueItem *ImportQueueItem) {
 		importMutex.Lock()
-		queueItem.Status = Scanning
+		queueItem.Volume = &volume
+		queueItem.Processors = processors
+		queueItem.Diagnostics = slices.Clone(diagnostics)
 		importMutex.Unlock()
 
-		files := processor.EnumerateSources(processors, params.Dump)
+		// scanning can take a while, so don't bother if the job was already cancelled
+		files := make([]model.SourceFile, 0)
+		if ctx.Err() == nil {
+			var err error
+			files, err = processor.EnumerateSources(ctx, config, processors, volume, diagnostics, params.Dump)
+
+			importMutex.Lock()
+			queueItem.Diagnostics = diagnostics
+			importMutex.Unlock()
+
+			// importing only some of the files could lead to the post-import
+			// action removing files that were never imported
+			if err != nil && ctx.Err() == nil {
+				queueItem.setStatus(Failed, err.Error())
+				queueItem.notify(notify.EventJobFailed, err.Error())
+
+				slog.Info(fmt.Sprintf("Finished import for volume '%s'", params.VolumePath))
+				queueItem.FinishedCallback(queueItem)
+				return
+			}
+		}
 
 		importMutex.Lock()
 		queueItem.Files = files
-		queueItem.Status = Importing
+		queueItem.Progress = model.ImportProgress{TotalFiles: len(files)}
+		for _, file := range files {
+			queueItem.Progress.TotalBytes += file.Size
+		}
+		queueItem.Progress.StartedAt = time.Now()
 		importMutex.Unlock()
 
-		// TODO: add some sort of status callback here
-		processor.ImportFiles(config, files, params.DryRun)
+		queueItem.setStatus(Importing, "")
+
+		results, err := processor.ImportFiles(ctx, config, files, params.DryRun, queueItem.ID, queueItem.updateProgress, queueItem.recordResult)
+
+		importMutex.Lock()
+		queueItem.Results = results
+		queueItem.Progress.FinishedAt = time.Now()
+		importMutex.Unlock()
+
+		// the job can be stopped while scanning, in which case there may be no
+		// files left for ImportFiles to fail on
+		switch cause := context.Cause(ctx); {
+		case ctx.Err() != nil && errors.Is(cause, errShutdown):
+			// leave the job as it is so that it is resumed at next startup
+			slog.Info(fmt.Sprintf("Import #%d for volume '%s' stopped because the importer is shutting down", queueItem.ID, params.VolumePath))
+			queueItem.persist()
+			return
+
+		case ctx.Err() != nil && errors.Is(cause, ErrCancelled):
+			queueItem.setStatus(Cancelled, cause.Error())
+			queueItem.notify(notify.EventJobCancelled, "")
+
+		case ctx.Err() != nil:
+			queueItem.setStatus(Failed, cause.Error())
+			queueItem.notify(notify.EventJobFailed, cause.Error())
+
+		case err != nil:
+			queueItem.setStatus(Failed, err.Error())
+			queueItem.notify(notify.EventJobFailed, err.Error())
+
+		default:
+			queueItem.setStatus(Completed, "")
+			queueItem.notify(notify.EventJobCompleted, "")
+		}
 
 		slog.Info(fmt.Sprintf("Finished import for volume '%s'", params.VolumePath))
 		queueItem.FinishedCallback(queueItem)
 	}
+
+	importMutex.Lock()
+	queueItem.transition(Pending, "")
+	importQueue[queueItem.ID] = queueItem
 	importMutex.Unlock()
+
+	queueItem.persist()
+	queueItem.notify(notify.EventJobQueued, "")
+	wakeScheduler()
+}
+
+// setStatus moves the queue item to the provided status and persists the job.
+// Must not be called while holding importMutex
+func (queueItem *ImportQueueItem) setStatus(status ImportStatus, message string) {
+	importMutex.Lock()
+	queueItem.transition(status, message)
+	importMutex.Unlock()
+
+	queueItem.persist()
+}
+
+// transition moves the queue item to the provided status and records when
+// it happened. Must only be called while holding importMutex
+func (queueItem *ImportQueueItem) transition(status ImportStatus, message string) {
+	queueItem.Status = status
+	queueItem.Transitions = append(queueItem.Transitions, model.ImportJobTransition{
+		Status:  status.String(),
+		At:      time.Now(),
+		Message: message,
+	})
+}
+
+// toJobStatus must only be called while holding importMutex
+func (queueItem *ImportQueueItem) toJobStatus(includeFiles bool) model.ImportJobStatus {
+	jobStatus := model.ImportJobStatus{
+		ID:          queueItem.ID,
+		VolumePath:  queueItem.Params.VolumePath,
+		VolumeLabel: queueItem.VolumeLabel,
+		Device:      queueItem.device,
+		DryRun:      queueItem.Params.DryRun,
+		Status:      queueItem.Status.String(),
+		Volume:      queueItem.Volume,
+		Processors:  make([]string, 0, len(queueItem.Processors)),
+		Diagnostics: append([]model.ProcessorDiagnostic{}, queueItem.Diagnostics...),
+		Progress:    queueItem.Progress,
+		QueuedAt:    queueItem.QueuedAt,
+		PostImport:  queueItem.PostImport,
+		Transitions: append([]model.ImportJobTransition{}, queueItem.Transitions...),
+		Resumed:     queueItem.Resumed,
+	}
+
+	for _, processor := range queueItem.Processors {
+		jobStatus.Processors = append(jobStatus.Processors, processorName(processor))
+	}
+
+	if includeFiles {
+		jobStatus.Files = append([]model.SourceFile{}, queueItem.Files...)
+		jobStatus.Results = append([]model.ImportFileResult{}, queueItem.Results...)
+	}
+
+	// throughput is based only on the bytes that were actually transferred, so
+	// that skipped files don't inflate the number
+	if !queueItem.Progress.StartedAt.IsZero() {
+		endTime := queueItem.Progress.FinishedAt
+		if endTime.IsZero() {
+			endTime = time.Now()
+		}
+
+		if elapsed := endTime.Sub(queueItem.Progress.StartedAt).Seconds(); elapsed > 0 {
+			jobStatus.Progress.BytesPerSecond = float64(queueItem.transferredBytes) / elapsed
+		}
+	}
+
+	return jobStatus
+}
+
+// updateProgress is used as the processor.ImportProgressCallback for a queue item
+func (queueItem *ImportQueueItem) updateProgress(sourceFile model.SourceFile, fileBytesCopied int64, fileDone bool) {
+	importMutex.Lock()
+	defer importMutex.Unlock()
+
+	progress := &queueItem.Progress
+
+	if progress.CurrentFile != sourceFile.SourcePath {
+		progress.CurrentFile = sourceFile.SourcePath
+		progress.CurrentFileBytes = 0
+	}
+
+	delta := fileBytesCopied - progress.CurrentFileBytes
+	progress.CurrentFileBytes = fileBytesCopied
+	progress.BytesCopied += delta
+
+	if !queueItem.Params.DryRun {
+		queueItem.transferredBytes += delta
+	}
+
+	if fileDone {
+		// skipped and failed files don't report the bytes they didn't copy,
+		// which still count towards the progress but not the transfer rate
+		if fileBytesCopied < sourceFile.Size {
+			progress.BytesCopied += sourceFile.Size - fileBytesCopied
+		}
+
+		progress.CompletedFiles++
+		progress.CurrentFile = ""
+		progress.CurrentFileBytes = 0
+	}
+}
+
+func processorName(p processor.Processor) string {
+	if p == nil {
+		return ""
+	}
+
+	return processor.GetProcessorName(p)
 }
@@ -0,0 +1,219 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"ccmm/importer/processor"
+	"ccmm/model"
+)
+
+// blockingProcessor detects every volume, then blocks while listing files
+// until the job is stopped
+type blockingProcessor struct {
+	scanning chan struct{}
+}
+
+func (p *blockingProcessor) Name() string { return "blockingTest" }
+
+func (p *blockingProcessor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	return model.Detection{Confidence: model.HighConfidence}, nil
+}
+
+func (p *blockingProcessor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	p.scanning <- struct{}{}
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+var (
+	scanningChan         = make(chan struct{}, 1)
+	registerBlockingOnce sync.Once
+)
+
+// queueBlockingImport starts the scheduler and queues an import that blocks
+// while scanning. The finished job is sent on the returned channel
+func queueBlockingImport(t *testing.T, config model.ImporterConfig) (*ImportQueueItem, chan *ImportQueueItem) {
+	t.Helper()
+
+	finished := make(chan *ImportQueueItem, 1)
+
+	importMutex.Lock()
+	queueIndex++
+	queueItem := &ImportQueueItem{
+		ID:               queueIndex,
+		Params:           model.ImportVolume{VolumePath: t.TempDir()},
+		QueuedAt:         time.Now(),
+		FinishedCallback: func(queueItem *ImportQueueItem) { finished <- queueItem },
+	}
+	importMutex.Unlock()
+
+	StartScheduler(config)
+	queueImport(config, queueItem)
+
+	select {
+	case <-scanningChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job never started scanning")
+	}
+
+	return queueItem, finished
+}
+
+// newBlockingImportConfig registers the blocking processor and returns a
+// config in which it is the only enabled processor
+func newBlockingImportConfig() model.ImporterConfig {
+	registerBlockingOnce.Do(func() {
+		processor.RegisterProcessor("blockingTest", func() processor.Processor {
+			return &blockingProcessor{scanning: scanningChan}
+		})
+	})
+
+	config := model.DefaultImporterConfig
+	config.EnabledProcessors = []string{"blockingTest"}
+	config.Notifications = nil
+
+	return config
+}
+
+func TestCancelImportWhileScanning(t *testing.T) {
+	config := newBlockingImportConfig()
+	queueItem, finished := queueBlockingImport(t, config)
+	defer StopScheduler(time.Second)
+
+	if err := CancelImport(queueItem.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled job never finished")
+	}
+
+	importMutex.Lock()
+	status := queueItem.Status
+	importMutex.Unlock()
+
+	if status != Cancelled {
+		t.Errorf("expected the job to be cancelled, got %s", status)
+	}
+}
+
+func TestShutdownWhileScanningLeavesJobToResume(t *testing.T) {
+	config := newBlockingImportConfig()
+	config.JobDatabase = filepath.Join(t.TempDir(), "jobs.db")
+
+	if err := OpenJobStore(config); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseJobStore()
+
+	queueItem, finished := queueBlockingImport(t, config)
+
+	StopScheduler(10 * time.Millisecond)
+
+	select {
+	case <-finished:
+		t.Fatal("the finished callback ran for a job stopped by the shutdown")
+	default:
+	}
+
+	jobs, err := jobStore.GetUnfinishedJobs([]string{Completed.String(), Failed.String(), Cancelled.String(), Interrupted.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 || jobs[0].ID != queueItem.ID {
+		t.Errorf("expected job #%d to be left to resume, got %+v", queueItem.ID, jobs)
+	}
+}
+
+func TestProgressOnlyCountsCopiedBytesAsTransferred(t *testing.T) {
+	config := model.DefaultImporterConfig
+	config.LiveDataDir = t.TempDir()
+	config.Notifications = nil
+
+	sourceDir := t.TempDir()
+	captureTime := time.Date(2024, 11, 3, 10, 0, 0, 0, time.Local)
+	newSourceFile := func(fileName string, size int) model.SourceFile {
+		sourcePath := filepath.Join(sourceDir, fileName)
+		if err := os.WriteFile(sourcePath, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return model.SourceFile{
+			FileName:      fileName,
+			SourcePath:    sourcePath,
+			Size:          int64(size),
+			MediaType:     "Audio",
+			SourceName:    "Test",
+			ProcessorName: "test",
+			CaptureTime:   captureTime,
+			CaptureDate:   time.Date(2024, 11, 3, 0, 0, 0, 0, time.Local),
+			FileModTime:   captureTime,
+		}
+	}
+
+	existing := []model.SourceFile{newSourceFile("a.wav", 1000), newSourceFile("b.wav", 2000)}
+	if _, err := processor.ImportFiles(context.Background(), config, existing, false, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first two files are already at their destination and are skipped
+	files := append(existing, newSourceFile("c.wav", 500))
+	queueItem := &ImportQueueItem{ID: 2}
+
+	results, err := processor.ImportFiles(context.Background(), config, files, false, queueItem.ID, queueItem.updateProgress, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := make([]string, 0, len(results))
+	for _, result := range results {
+		actions = append(actions, result.Action)
+	}
+	if !slices.Equal(actions, []string{"skipped", "skipped", "copied"}) {
+		t.Fatalf("expected two skipped files and one copied file, got %v", actions)
+	}
+
+	if queueItem.transferredBytes != 500 {
+		t.Errorf("expected transferred bytes %v, got %v", 500, queueItem.transferredBytes)
+	}
+	if queueItem.Progress.BytesCopied != 3500 {
+		t.Errorf("expected bytes copied %v, got %v", 3500, queueItem.Progress.BytesCopied)
+	}
+	if queueItem.Progress.CompletedFiles != 3 {
+		t.Errorf("expected completed files %v, got %v", 3, queueItem.Progress.CompletedFiles)
+	}
+}
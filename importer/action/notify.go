@@ -0,0 +1,123 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ccmm/util/notify"
+)
+
+//
+// private functions
+//
+
+// notify sends a notification describing the current state of the job.
+// Must not be called while holding importMutex
+func (queueItem *ImportQueueItem) notify(eventType string, reason string) {
+	importMutex.Lock()
+	jobStatus := queueItem.toJobStatus(false)
+
+	actions := make(map[string]int)
+	for _, result := range queueItem.Results {
+		actions[result.Action]++
+	}
+	importMutex.Unlock()
+
+	volume := jobStatus.VolumePath
+	if jobStatus.VolumeLabel != "" {
+		volume = fmt.Sprintf("%s (%s)", jobStatus.VolumeLabel, jobStatus.VolumePath)
+	}
+
+	event := notify.Event{
+		Type:  eventType,
+		JobID: jobStatus.ID,
+		Data: map[string]any{
+			"volume_path":  jobStatus.VolumePath,
+			"volume_label": jobStatus.VolumeLabel,
+			"device":       jobStatus.Device,
+			"dry_run":      jobStatus.DryRun,
+			"processors":   jobStatus.Processors,
+			"total_files":  jobStatus.Progress.TotalFiles,
+			"total_bytes":  jobStatus.Progress.TotalBytes,
+			"files":        actions,
+		},
+	}
+
+	dryRun := ""
+	if jobStatus.DryRun {
+		dryRun = " (dry run)"
+	}
+
+	switch eventType {
+	case notify.EventJobQueued:
+		event.Title = fmt.Sprintf("Import #%d queued%s", jobStatus.ID, dryRun)
+		event.Message = fmt.Sprintf("Volume %s was queued for import", volume)
+
+	case notify.EventJobStarted:
+		event.Title = fmt.Sprintf("Import #%d started%s", jobStatus.ID, dryRun)
+		event.Message = fmt.Sprintf("Started importing volume %s", volume)
+
+	case notify.EventJobCompleted:
+		event.Title = fmt.Sprintf("Import #%d completed%s", jobStatus.ID, dryRun)
+		event.Message = fmt.Sprintf("Finished importing %d file(s) from volume %s in %s: %s",
+			jobStatus.Progress.TotalFiles, volume, jobDuration(jobStatus.Progress.StartedAt, jobStatus.Progress.FinishedAt), describeActions(actions))
+
+	case notify.EventJobFailed:
+		event.Title = fmt.Sprintf("Import #%d failed%s", jobStatus.ID, dryRun)
+		event.Message = fmt.Sprintf("Import of volume %s failed (%s): %s", volume, describeActions(actions), reason)
+
+	case notify.EventJobCancelled:
+		event.Title = fmt.Sprintf("Import #%d cancelled%s", jobStatus.ID, dryRun)
+		event.Message = fmt.Sprintf("Import of volume %s was cancelled (%s)", volume, describeActions(actions))
+	}
+
+	notify.Notify(event)
+}
+
+func describeActions(actions map[string]int) string {
+	if len(actions) == 0 {
+		return "no files handled"
+	}
+
+	parts := make([]string, 0, len(actions))
+	for _, action := range []string{"copied", "skipped", "dry_run", "failed"} {
+		if actions[action] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", actions[action], action))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func jobDuration(startedAt time.Time, finishedAt time.Time) string {
+	if startedAt.IsZero() || finishedAt.IsZero() {
+		return "0s"
+	}
+
+	return finishedAt.Sub(startedAt).Round(time.Second).String()
+}
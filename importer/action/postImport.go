@@ -0,0 +1,258 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"ccmm/importer/processor"
+	"ccmm/model"
+)
+
+// PostImport runs the configured post-import action against the volume of a
+// finished import job. The action only runs if the import completed without
+// error and every file in the job was verified against its destination by
+// checksum. Only the files that were imported (and so verified) are deleted,
+// files the processors didn't enumerate are left alone. Control files needed by
+// the device are never removed and the filesystem itself (including the volume
+// label) is left untouched, reformatting the volume is not supported.
+//
+// For a dry run job, nothing is removed and the report describes what would
+// have been deleted. If no post-import action is configured for any of the
+// processors used by the job, nil is returned
+func PostImport(config model.ImporterConfig, queueItem *ImportQueueItem) *model.PostImportReport {
+	importMutex.Lock()
+	params := queueItem.Params
+	status := queueItem.Status
+	processors := append([]processor.Processor{}, queueItem.Processors...)
+	results := append([]model.ImportFileResult{}, queueItem.Results...)
+	files := append([]model.SourceFile{}, queueItem.Files...)
+	importMutex.Unlock()
+
+	deleteProcessors := make([]string, 0)
+	controlFiles := make([]string, 0)
+
+	for _, p := range processors {
+		name := processor.GetProcessorName(p)
+		processorControlFiles := processor.GetControlFiles(config, p)
+		controlFiles = append(controlFiles, processorControlFiles...)
+
+		if getPostImportAction(config, name) == model.PostImportDelete {
+			deleteProcessors = append(deleteProcessors, name)
+		}
+	}
+
+	if len(deleteProcessors) == 0 {
+		return nil
+	}
+
+	report := &model.PostImportReport{
+		Action:    model.PostImportDelete,
+		DryRun:    params.DryRun,
+		Deleted:   make([]string, 0),
+		Protected: make([]string, 0),
+	}
+
+	defer func() {
+		importMutex.Lock()
+		queueItem.PostImport = report
+		importMutex.Unlock()
+	}()
+
+	controlRegexes, err := compileControlFiles(controlFiles)
+	if err != nil {
+		report.SkippedReason = err.Error()
+		slog.Error(fmt.Sprintf("Not running post-import action for '%s': %s", params.VolumePath, report.SkippedReason))
+		return report
+	}
+
+	if reason := checkPostImportSafety(status, params.DryRun, files, results); reason != "" {
+		report.SkippedReason = reason
+		slog.Warn(fmt.Sprintf("Not running post-import action for '%s': %s", params.VolumePath, reason))
+		return report
+	}
+
+	// files that weren't enumerated by the import were never verified, so
+	// only the imported files are deleted
+	var candidates []string
+	for _, file := range files {
+		if slices.Contains(deleteProcessors, file.ProcessorName) {
+			candidates = append(candidates, file.SourcePath)
+		}
+	}
+
+	for _, filePath := range candidates {
+		relativePath, err := filepath.Rel(params.VolumePath, filePath)
+		if err != nil || strings.HasPrefix(relativePath, "..") {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: file is not on the volume", filePath))
+			continue
+		}
+
+		if isControlFile(controlRegexes, filepath.ToSlash(relativePath)) {
+			report.Protected = append(report.Protected, filePath)
+			continue
+		}
+
+		if params.DryRun {
+			slog.Info(fmt.Sprintf("[Dry run] Would delete '%s'", filePath))
+			report.Deleted = append(report.Deleted, filePath)
+			continue
+		}
+
+		slog.Debug(fmt.Sprintf("Deleting '%s'", filePath))
+		if err := os.Remove(filePath); err != nil {
+			slog.Error(fmt.Sprintf("Failed to delete '%s': %s", filePath, err.Error()))
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", filePath, err.Error()))
+			continue
+		}
+
+		report.Deleted = append(report.Deleted, filePath)
+	}
+
+	if !params.DryRun {
+		removeEmptyDirectories(params.VolumePath, controlRegexes)
+	}
+
+	slog.Info(fmt.Sprintf("Post-import action '%s' finished for '%s', %d file(s) deleted, %d control file(s) kept, %d error(s)",
+		report.Action, params.VolumePath, len(report.Deleted), len(report.Protected), len(report.Errors)))
+
+	return report
+}
+
+//
+// private functions
+//
+
+// getPostImportAction returns the post-import action for the provided processor,
+// falling back to the global action if the processor doesn't override it
+func getPostImportAction(config model.ImporterConfig, processorName string) string {
+	if processorConfig, ok := config.Processors[processorName]; ok && processorConfig.PostImportAction != "" {
+		return processorConfig.PostImportAction
+	}
+
+	if config.PostImportAction == "" {
+		return model.PostImportNone
+	}
+
+	return config.PostImportAction
+}
+
+// checkPostImportSafety returns the reason that the post-import action must
+// not run, or an empty string if it is safe to run
+func checkPostImportSafety(status ImportStatus, dryRun bool, files []model.SourceFile, results []model.ImportFileResult) string {
+	if status != Completed {
+		return fmt.Sprintf("import job finished with status '%s'", status.String())
+	}
+
+	if len(files) == 0 {
+		return "no files were imported"
+	}
+
+	if len(results) != len(files) {
+		return fmt.Sprintf("only %d of %d files have an import result", len(results), len(files))
+	}
+
+	for _, result := range results {
+		if result.Action == "failed" {
+			return fmt.Sprintf("'%s' failed to import", result.SourcePath)
+		}
+
+		// nothing is copied during a dry run, so there is nothing to verify
+		if !dryRun && !result.Verified {
+			return fmt.Sprintf("'%s' was not verified by checksum", result.SourcePath)
+		}
+	}
+
+	return ""
+}
+
+func compileControlFiles(patterns []string) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid control file pattern '%s': %w", pattern, err)
+		}
+		regexes = append(regexes, regex)
+	}
+
+	return regexes, nil
+}
+
+// isControlFile returns true if the relative path, or any of its parent
+// directories, matches one of the control file patterns
+func isControlFile(controlRegexes []*regexp.Regexp, relativePath string) bool {
+	for checkPath := relativePath; checkPath != "." && checkPath != "/"; checkPath = filepath.ToSlash(filepath.Dir(checkPath)) {
+		for _, regex := range controlRegexes {
+			if regex.MatchString(checkPath) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// removeEmptyDirectories removes any directory below the top level of the
+// volume that is empty and isn't a control file. Top level directories are
+// always kept because devices commonly expect them to exist (ex: DCIM)
+func removeEmptyDirectories(volumePath string, controlRegexes []*regexp.Regexp) {
+	dirs := make([]string, 0)
+
+	filepath.WalkDir(volumePath, func(dirPath string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			dirs = append(dirs, dirPath)
+		}
+		return nil
+	})
+
+	// deepest first, so that parents that become empty are also removed
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+
+	for _, dirPath := range dirs {
+		relativePath, err := filepath.Rel(volumePath, dirPath)
+		if err != nil || !strings.Contains(filepath.ToSlash(relativePath), "/") {
+			continue
+		}
+
+		if isControlFile(controlRegexes, filepath.ToSlash(relativePath)) {
+			continue
+		}
+
+		if entries, err := os.ReadDir(dirPath); err == nil && len(entries) == 0 {
+			slog.Debug(fmt.Sprintf("Removing empty directory '%s'", dirPath))
+			os.Remove(dirPath)
+		}
+	}
+}
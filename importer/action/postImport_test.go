@@ -0,0 +1,118 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"ccmm/importer/processor"
+	"ccmm/importer/processor/behringerX32"
+	"ccmm/importer/processor/zoomH6"
+	"ccmm/model"
+)
+
+func writeVolumeFiles(t *testing.T, volumePath string, relativePaths ...string) {
+	t.Helper()
+
+	for _, relativePath := range relativePaths {
+		fullPath := filepath.Join(volumePath, relativePath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newPostImportItem(volumePath string, p processor.Processor, relativePaths ...string) *ImportQueueItem {
+	queueItem := &ImportQueueItem{
+		Params:     model.ImportVolume{VolumePath: volumePath},
+		Processors: []processor.Processor{p},
+		Status:     Completed,
+	}
+
+	for _, relativePath := range relativePaths {
+		sourcePath := filepath.Join(volumePath, relativePath)
+		queueItem.Files = append(queueItem.Files, model.SourceFile{SourcePath: sourcePath, ProcessorName: processor.GetProcessorName(p)})
+		queueItem.Results = append(queueItem.Results, model.ImportFileResult{SourcePath: sourcePath, Action: "copied", Verified: true})
+	}
+
+	return queueItem
+}
+
+func TestPostImportDeleteOnlyDeletesImportedFiles(t *testing.T) {
+	volumePath := t.TempDir()
+	imported := "FOLDER01/ZOOM0001/ZOOM0001_Tr1.WAV"
+	control := "FOLDER01/ZOOM0001/240101-120000.hprj"
+	unknown := "FOLDER02/NOTES.TXT"
+	writeVolumeFiles(t, volumePath, imported, control, unknown)
+
+	config := model.DefaultImporterConfig
+	config.PostImportAction = model.PostImportDelete
+
+	queueItem := newPostImportItem(volumePath, zoomH6.New(), imported, control)
+	report := PostImport(config, queueItem)
+
+	if report == nil || report.SkippedReason != "" {
+		t.Fatalf("expected the action to run, got %+v", report)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0] != filepath.Join(volumePath, imported) {
+		t.Errorf("expected only the imported file to be deleted, got %v", report.Deleted)
+	}
+	if len(report.Protected) != 1 || report.Protected[0] != filepath.Join(volumePath, control) {
+		t.Errorf("expected the control file to be protected, got %v", report.Protected)
+	}
+
+	for relativePath, wantExists := range map[string]bool{imported: false, control: true, unknown: true} {
+		_, err := os.Stat(filepath.Join(volumePath, relativePath))
+		if exists := err == nil; exists != wantExists {
+			t.Errorf("'%s': exists %t, want %t", relativePath, exists, wantExists)
+		}
+	}
+}
+
+func TestPostImportRejectsEmpty(t *testing.T) {
+	if slices.Contains(model.PostImportActions, "empty") {
+		t.Errorf("expected 'empty' not to be a valid post-import action, got %v", model.PostImportActions)
+	}
+
+	volumePath := t.TempDir()
+	imported := "X32/R_20240101-120000.wav"
+	writeVolumeFiles(t, volumePath, imported)
+
+	config := model.DefaultImporterConfig
+	config.PostImportAction = "empty"
+
+	if report := PostImport(config, newPostImportItem(volumePath, behringerX32.New(), imported)); report != nil {
+		t.Errorf("expected no post-import action, got %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(volumePath, imported)); err != nil {
+		t.Errorf("imported file was deleted: %s", err.Error())
+	}
+}
@@ -0,0 +1,260 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util/notify"
+)
+
+var (
+	// ErrCancelled is the reason recorded for import jobs that were cancelled by request
+	ErrCancelled = errors.New("import cancelled by request")
+
+	// ErrJobNotFound is returned by CancelImport when no job with the provided ID is known
+	ErrJobNotFound = errors.New("import job not found")
+
+	// ErrJobFinished is returned by CancelImport when the job has already finished
+	ErrJobFinished = errors.New("import job has already finished")
+
+	// errShutdown is used to stop running jobs when the importer is shutting
+	// down. Jobs stopped this way are left as they are, so that they are
+	// resumed the next time the importer starts
+	errShutdown = errors.New("importer is shutting down")
+
+	// wakeChan is used to tell the scheduler that it should look for jobs to
+	// start, because one was queued or finished
+	wakeChan = make(chan struct{}, 1)
+
+	jobScheduler *scheduler
+)
+
+// scheduler runs queued import jobs in the order they were queued. Up to
+// maxJobs jobs run at the same time, but never more than one per physical
+// device. The job state is guarded by importMutex
+type scheduler struct {
+	maxJobs        int
+	runningJobs    int
+	runningDevices map[string]bool
+	running        sync.WaitGroup
+	stop           context.CancelFunc
+	done           chan struct{}
+}
+
+// StartScheduler starts the routine that runs queued import jobs
+func StartScheduler(config model.ImporterConfig) {
+	ctx, stop := context.WithCancel(context.Background())
+
+	importMutex.Lock()
+	jobScheduler = &scheduler{
+		maxJobs:        max(config.MaxConcurrentImports, 1),
+		runningDevices: make(map[string]bool),
+		stop:           stop,
+		done:           make(chan struct{}),
+	}
+	s := jobScheduler
+	importMutex.Unlock()
+
+	go s.run(ctx)
+	wakeScheduler()
+}
+
+// StopScheduler stops the scheduler from starting any more jobs and waits up
+// to drainTimeout for the running jobs to finish. Jobs still running after
+// that are stopped and left to be resumed the next time the importer starts,
+// as are jobs that never started
+func StopScheduler(drainTimeout time.Duration) {
+	importMutex.Lock()
+	s := jobScheduler
+	jobScheduler = nil
+	importMutex.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	s.stop()
+	<-s.done
+
+	drained := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return
+
+	case <-time.After(drainTimeout):
+	}
+
+	importMutex.Lock()
+	for _, queueItem := range importQueue {
+		if queueItem.cancel != nil {
+			slog.Warn(fmt.Sprintf("Stopping import #%d, it will be resumed when the importer starts again", queueItem.ID))
+			queueItem.cancel(errShutdown)
+		}
+	}
+	importMutex.Unlock()
+
+	<-drained
+}
+
+// CancelImport cancels the import job with the provided ID. A job that hasn't
+// started yet is removed from the queue, a running job is stopped after the
+// file currently being copied is aborted. In both cases, the finished callback
+// of the job is still called
+func CancelImport(id int) error {
+	importMutex.Lock()
+	queueItem, ok := importQueue[id]
+
+	if !ok {
+		finished := findQueueItem(id) != nil
+		importMutex.Unlock()
+
+		if finished || getStoredJob(id) != nil {
+			return ErrJobFinished
+		}
+
+		return ErrJobNotFound
+	}
+
+	if queueItem.cancel != nil {
+		slog.Info(fmt.Sprintf("Cancelling running import #%d", id))
+		queueItem.cancel(ErrCancelled)
+		importMutex.Unlock()
+		return nil
+	}
+
+	slog.Info(fmt.Sprintf("Cancelling queued import #%d", id))
+	delete(importQueue, id)
+	queueItem.transition(Cancelled, ErrCancelled.Error())
+	addFinishedJob(queueItem)
+	importMutex.Unlock()
+
+	queueItem.persist()
+	queueItem.notify(notify.EventJobCancelled, "")
+	go queueItem.FinishedCallback(queueItem)
+
+	return nil
+}
+
+//
+// private functions
+//
+
+func wakeScheduler() {
+	select {
+	case wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-wakeChan:
+			importMutex.Lock()
+			s.startReadyJobs()
+			importMutex.Unlock()
+		}
+	}
+}
+
+// startReadyJobs must only be called while holding importMutex
+func (s *scheduler) startReadyJobs() {
+	ids := make([]int, 0, len(importQueue))
+	for id, queueItem := range importQueue {
+		if queueItem.cancel == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if s.runningJobs >= s.maxJobs {
+			return
+		}
+
+		queueItem := importQueue[id]
+		if s.runningDevices[queueItem.device] {
+			continue
+		}
+
+		ctx, cancel := context.WithCancelCause(context.Background())
+		queueItem.cancel = cancel
+		s.runningDevices[queueItem.device] = true
+		s.runningJobs++
+		s.running.Add(1)
+
+		go s.runJob(ctx, queueItem)
+	}
+}
+
+func (s *scheduler) runJob(ctx context.Context, queueItem *ImportQueueItem) {
+	defer s.running.Done()
+
+	slog.Info(fmt.Sprintf("Processing import queue item '%d', volume path: '%s'", queueItem.ID, queueItem.Params.VolumePath))
+	queueItem.notify(notify.EventJobStarted, "")
+
+	queueItem.processCallback(ctx, queueItem)
+	queueItem.cancel(nil)
+
+	// the finished callback may have changed the job (ex: post-import report)
+	queueItem.persist()
+
+	importMutex.Lock()
+	slog.Info(fmt.Sprintf("Finished processing import queue item '%d', volume path: '%s'", queueItem.ID, queueItem.Params.VolumePath))
+	delete(importQueue, queueItem.ID)
+	delete(s.runningDevices, queueItem.device)
+	s.runningJobs--
+	addFinishedJob(queueItem)
+	importMutex.Unlock()
+
+	wakeScheduler()
+}
+
+// addFinishedJob must only be called while holding importMutex
+func addFinishedJob(queueItem *ImportQueueItem) {
+	finishedJobs = append(finishedJobs, queueItem)
+	if len(finishedJobs) > maxFinishedJobs {
+		finishedJobs = finishedJobs[len(finishedJobs)-maxFinishedJobs:]
+	}
+}
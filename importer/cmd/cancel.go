@@ -0,0 +1,72 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"ccmm/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	cancelArgServer string
+
+	cancelCmd = &cobra.Command{
+		Use:   "cancel [flags] job_id",
+		Short: "Cancel a queued or running import",
+		Long:  `Cancel an import job on the running server. A running job stops after aborting the file currently being copied`,
+		Args:  cobra.ExactArgs(1),
+
+		Run: func(cmd *cobra.Command, args []string) {
+			jobID, err := strconv.Atoi(args[0])
+			if err != nil {
+				slog.Error(fmt.Sprintf("Invalid job ID '%s'", args[0]))
+				os.Exit(1)
+			}
+
+			uri := fmt.Sprintf("http://%s/api/v1/jobs/%d/cancel", cancelArgServer, jobID)
+			body, statusCode := util.CallServer(uri, nil)
+
+			if statusCode != 202 {
+				slog.Error(fmt.Sprintf("Failed to cancel import #%d: %s", jobID, strings.TrimSpace(string(body))))
+				os.Exit(1)
+			}
+
+			fmt.Printf("Cancelled import #%d\n", jobID)
+		},
+	}
+)
+
+func init() {
+	cancelCmd.Flags().StringVarP(&cancelArgServer, "server", "s", "localhost:7273", "<host>:<port> -- Server instance running the import")
+
+	rootCmd.AddCommand(cancelCmd)
+}
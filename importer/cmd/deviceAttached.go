@@ -46,7 +46,7 @@ var (
 	deviceAttachedCmd = &cobra.Command{
 		Use:   "device_attached [flags] device_path",
 		Short: "Process a device that was attached to the system.",
-		Long:  `This is the fully automatic import process that can be triggered by udev to mount, import, unmount and power down an attached device.`,
+		Long:  `This is the fully automatic import process that can be triggered by an external integration to mount, import, unmount and power down an attached device.`,
 		Args:  cobra.MinimumNArgs(1),
 
 		Run: func(cmd *cobra.Command, args []string) {
@@ -0,0 +1,128 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyArgServer    string
+	historyArgSince     string
+	historyArgUntil     string
+	historyArgLabel     string
+	historyArgProcessor string
+	historyArgLimit     int
+	historyArgJson      bool
+
+	historyCmd = &cobra.Command{
+		Use:   "history [flags]",
+		Short: "List past imports",
+		Long:  `List the import jobs recorded by the running server, newest first`,
+
+		Run: func(cmd *cobra.Command, _ []string) {
+			query := url.Values{}
+			query.Set("since", historyArgSince)
+			query.Set("until", historyArgUntil)
+			query.Set("label", historyArgLabel)
+			query.Set("processor", historyArgProcessor)
+			query.Set("limit", fmt.Sprint(historyArgLimit))
+
+			uri := fmt.Sprintf("http://%s/api/v1/history?%s", historyArgServer, query.Encode())
+			body, statusCode := util.GetFromServer(uri)
+
+			if statusCode != 200 {
+				slog.Error(fmt.Sprintf("Failed to read import history: %s", strings.TrimSpace(string(body))))
+				os.Exit(1)
+			}
+
+			if historyArgJson {
+				fmt.Println(string(body))
+				return
+			}
+
+			var jobs []model.ImportJobStatus
+			if err := json.Unmarshal(body, &jobs); err != nil {
+				slog.Error("Failed to decode import history: " + err.Error())
+				os.Exit(1)
+			}
+
+			printHistory(jobs)
+		},
+	}
+)
+
+func init() {
+	historyCmd.Flags().StringVarP(&historyArgServer, "server", "s", "localhost:7273", "<host>:<port> -- Server instance to read the history from")
+	historyCmd.Flags().StringVar(&historyArgSince, "since", "", "Only list imports queued on or after this date (YYYY-MM-DD)")
+	historyCmd.Flags().StringVar(&historyArgUntil, "until", "", "Only list imports queued on or before this date (YYYY-MM-DD)")
+	historyCmd.Flags().StringVarP(&historyArgLabel, "label", "l", "", "Only list imports of volumes with this label")
+	historyCmd.Flags().StringVarP(&historyArgProcessor, "processor", "p", "", "Only list imports handled by this processor")
+	historyCmd.Flags().IntVarP(&historyArgLimit, "limit", "n", 50, "Maximum number of imports to list (0 for all)")
+	historyCmd.Flags().BoolVar(&historyArgJson, "json", false, "Print the raw JSON returned by the server")
+
+	rootCmd.AddCommand(historyCmd)
+}
+
+func printHistory(jobs []model.ImportJobStatus) {
+	if len(jobs) == 0 {
+		fmt.Println("No imports found")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tQUEUED\tSTATUS\tLABEL\tPROCESSORS\tFILES\tVOLUME")
+
+	for _, job := range jobs {
+		status := job.Status
+		if job.DryRun {
+			status += " (dry run)"
+		}
+
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%d/%d\t%s\n",
+			job.ID,
+			job.QueuedAt.Local().Format(time.DateTime),
+			status,
+			job.VolumeLabel,
+			strings.Join(job.Processors, ","),
+			job.Progress.CompletedFiles,
+			job.Progress.TotalFiles,
+			job.VolumePath)
+	}
+
+	writer.Flush()
+}
@@ -59,7 +59,20 @@ var (
 			slog.Debug(fmt.Sprintf("%+v", importConfig))
 
 			if importArgIndividual {
-				action.Import(config, importConfig, func(_ *action.ImportQueueItem) {})
+				finished := make(chan struct{})
+				jobID := action.Import(config, importConfig, func(_ *action.ImportQueueItem) { close(finished) })
+
+				if jobID < 0 {
+					os.Exit(1)
+				}
+
+				select {
+				case <-finished:
+				case <-cmd.Context().Done():
+					slog.Warn(fmt.Sprintf("Interrupted, stopping import #%d", jobID))
+					action.CancelImport(jobID)
+					<-finished
+				}
 			} else {
 				// queue the import with the server intance
 				uri := fmt.Sprintf("http://%s/trigger_import", importArgServer)
@@ -0,0 +1,135 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	localsendPendingArgServer   string
+	localsendPendingArgApprove  string
+	localsendPendingArgDeny     string
+	localsendPendingArgRemember bool
+
+	localsendPendingCmd = &cobra.Command{
+		Use:   "localsend_pending [flags]",
+		Short: "List, approve or deny localsend transfers waiting for approval",
+		Long: `When localsend.unknown_senders is set to approve, transfers from senders
+    without a rule wait until an operator approves or denies them. Without flags,
+    the waiting transfers are listed`,
+
+		Run: func(cmd *cobra.Command, _ []string) {
+			if localsendPendingArgApprove != "" && localsendPendingArgDeny != "" {
+				slog.Error("Only one of --approve and --deny can be given")
+				os.Exit(1)
+			}
+
+			if localsendPendingArgApprove != "" {
+				uri := fmt.Sprintf("http://%s/api/v1/localsend/pending/%s/approve?remember=%t",
+					localsendPendingArgServer, localsendPendingArgApprove, localsendPendingArgRemember)
+				decideLocalsendPending(uri, localsendPendingArgApprove, "Approved")
+				return
+			}
+
+			if localsendPendingArgDeny != "" {
+				uri := fmt.Sprintf("http://%s/api/v1/localsend/pending/%s/deny", localsendPendingArgServer, localsendPendingArgDeny)
+				decideLocalsendPending(uri, localsendPendingArgDeny, "Denied")
+				return
+			}
+
+			uri := fmt.Sprintf("http://%s/api/v1/localsend/pending", localsendPendingArgServer)
+			body, statusCode := util.GetFromServer(uri)
+
+			if statusCode != 200 {
+				slog.Error(fmt.Sprintf("Failed to read pending localsend transfers: %s", strings.TrimSpace(string(body))))
+				os.Exit(1)
+			}
+
+			var pending []model.LocalSendPendingSender
+			if err := json.Unmarshal(body, &pending); err != nil {
+				slog.Error("Failed to decode pending localsend transfers: " + err.Error())
+				os.Exit(1)
+			}
+
+			printLocalsendPending(pending)
+		},
+	}
+)
+
+func init() {
+	localsendPendingCmd.Flags().StringVarP(&localsendPendingArgServer, "server", "s", "localhost:7273", "<host>:<port> -- Server instance receiving the transfers")
+	localsendPendingCmd.Flags().StringVar(&localsendPendingArgApprove, "approve", "", "ID of the transfer to approve")
+	localsendPendingCmd.Flags().StringVar(&localsendPendingArgDeny, "deny", "", "ID of the transfer to deny")
+	localsendPendingCmd.Flags().BoolVar(&localsendPendingArgRemember, "remember", false, "With --approve, accept later transfers from the same sender until the server restarts")
+
+	rootCmd.AddCommand(localsendPendingCmd)
+}
+
+func decideLocalsendPending(uri string, id string, verb string) {
+	body, statusCode := util.CallServer(uri, nil)
+
+	if statusCode != 204 {
+		slog.Error(fmt.Sprintf("Failed to update transfer %s: %s", id, strings.TrimSpace(string(body))))
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s transfer %s\n", verb, id)
+}
+
+func printLocalsendPending(pending []model.LocalSendPendingSender) {
+	if len(pending) == 0 {
+		fmt.Println("No transfers waiting for approval")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tREQUESTED\tEXPIRES\tALIAS\tADDRESS\tFILES\tSIZE\tFINGERPRINT")
+
+	for _, sender := range pending {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			sender.ID,
+			sender.RequestedAt.Local().Format(time.DateTime),
+			sender.ExpiresAt.Local().Format(time.TimeOnly),
+			sender.Alias,
+			sender.IPAddress,
+			sender.Files,
+			util.FormatBytes(sender.TotalBytes),
+			sender.Fingerprint)
+	}
+
+	writer.Flush()
+}
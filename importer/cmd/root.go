@@ -26,12 +26,20 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"syscall"
+	"time"
 
 	"ccmm/importer/action"
+	"ccmm/importer/processor"
 	"ccmm/model"
 	"ccmm/util"
+	"ccmm/util/notify"
 
 	"github.com/spf13/cobra"
 )
@@ -65,19 +73,28 @@ func Execute() {
 
 	slog.Info("Configured data directory: " + config.LiveDataDir)
 
-	// This starts the thread that actually processes the import queue
-	// TODO: need to add a shutdown channel for clean termination
-	// var (
-	// shutdownChan    chan struct{}
-	// )
-	// shutdownChan = make(chan struct{})
-	go action.ImportWorker()
+	notifier, err := notify.New("importer", config.Notifications)
+	if err != nil {
+		slog.Error("Invalid notification config: " + err.Error())
+		os.Exit(1)
+	}
+	notify.SetDefault(notifier)
+
+	// This starts the routine that actually processes the import queue
+	action.StartScheduler(config)
 
 	// TODO: add config entries to enable/disable importer server
 	// TODO: add config entries to enable/disable localsend server
 
-	ctx := context.WithValue(context.TODO(), model.ImportConfigContext, config)
-	err := rootCmd.ExecuteContext(ctx)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx = context.WithValue(ctx, model.ImportConfigContext, config)
+	err = rootCmd.ExecuteContext(ctx)
+	stop()
+
+	// running imports get a chance to finish, anything left is resumed by the
+	// server the next time it starts
+	action.StopScheduler(time.Duration(config.ShutdownTimeout) * time.Second)
+	notify.Close()
 
 	if err != nil {
 		os.Exit(1)
@@ -98,5 +115,138 @@ func loadConfig() model.ImporterConfig {
 
 	util.ReadConfig(&config, true, false, "importer.yml")
 
+	// allowed_aliases predates the senders rules, each alias listed becomes a
+	// rule and unknown senders are denied unless __ALL__ is listed
+	allowAll := len(config.LocalSend.AllowedAliases) == 0 || slices.Contains(config.LocalSend.AllowedAliases, "__ALL__")
+	for _, alias := range config.LocalSend.AllowedAliases {
+		if alias != "__ALL__" {
+			config.LocalSend.Senders = append(config.LocalSend.Senders, model.LocalSendSender{Alias: alias})
+		}
+	}
+	if config.LocalSend.UnknownSenders == "" {
+		config.LocalSend.UnknownSenders = model.LocalSendDeny
+		if allowAll {
+			config.LocalSend.UnknownSenders = model.LocalSendAllow
+		}
+	}
+
+	if err := util.ValidateDestinationTemplates(config); err != nil {
+		slog.Error("Invalid destination template in config: " + err.Error())
+		os.Exit(1)
+	}
+
+	if config.ProcessorDefinitionsDir != "" {
+		if err := processor.LoadProcessorDefinitions(config.ProcessorDefinitionsDir); err != nil {
+			slog.Error("Failed to load processor definitions: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if !slices.Contains(model.ConflictPolicies, config.ConflictPolicy) {
+		slog.Error(fmt.Sprintf("Invalid conflict_policy '%s', must be one of: %v", config.ConflictPolicy, model.ConflictPolicies))
+		os.Exit(1)
+	}
+
+	for name, processorConfig := range config.Processors {
+		if processorConfig.ConflictPolicy != "" && !slices.Contains(model.ConflictPolicies, processorConfig.ConflictPolicy) {
+			slog.Error(fmt.Sprintf("Invalid conflict_policy '%s' for processor '%s', must be one of: %v", processorConfig.ConflictPolicy, name, model.ConflictPolicies))
+			os.Exit(1)
+		}
+	}
+
+	if !slices.Contains(model.PostImportActions, config.PostImportAction) {
+		slog.Error(fmt.Sprintf("Invalid post_import_action '%s', must be one of: %v", config.PostImportAction, model.PostImportActions))
+		os.Exit(1)
+	}
+
+	for name, processorConfig := range config.Processors {
+		if processorConfig.PostImportAction != "" && !slices.Contains(model.PostImportActions, processorConfig.PostImportAction) {
+			slog.Error(fmt.Sprintf("Invalid post_import_action '%s' for processor '%s', must be one of: %v", processorConfig.PostImportAction, name, model.PostImportActions))
+			os.Exit(1)
+		}
+
+		for _, pattern := range processorConfig.ControlFiles {
+			if _, err := regexp.Compile(pattern); err != nil {
+				slog.Error(fmt.Sprintf("Invalid control_files pattern '%s' for processor '%s': %s", pattern, name, err.Error()))
+				os.Exit(1)
+			}
+		}
+	}
+
+	if config.SiteTimezone != "" {
+		if _, err := time.LoadLocation(config.SiteTimezone); err != nil {
+			slog.Error(fmt.Sprintf("Invalid site_timezone '%s': %s", config.SiteTimezone, err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if config.MaxConcurrentImports < 1 {
+		slog.Error(fmt.Sprintf("Invalid max_concurrent_imports '%d', must be at least 1", config.MaxConcurrentImports))
+		os.Exit(1)
+	}
+
+	if !slices.Contains(model.LocalSendSenderPolicies, config.LocalSend.UnknownSenders) {
+		slog.Error(fmt.Sprintf("Invalid localsend unknown_senders '%s', must be one of: %v", config.LocalSend.UnknownSenders, model.LocalSendSenderPolicies))
+		os.Exit(1)
+	}
+
+	for i, sender := range config.LocalSend.Senders {
+		if sender.Alias == "" && sender.Fingerprint == "" {
+			slog.Error(fmt.Sprintf("Invalid localsend sender %d, an alias or fingerprint is required", i+1))
+			os.Exit(1)
+		}
+	}
+
+	if config.LocalSend.PinAttempts < 1 {
+		slog.Error(fmt.Sprintf("Invalid localsend pin_attempts '%d', must be at least 1", config.LocalSend.PinAttempts))
+		os.Exit(1)
+	}
+
+	if config.FreeSpaceReserveMB < 0 || config.LocalSend.FreeSpaceReserveMB < 0 {
+		slog.Error("Invalid free_space_reserve_mb, must not be negative")
+		os.Exit(1)
+	}
+
+	if config.DestinationIOLimit < 1 {
+		slog.Error(fmt.Sprintf("Invalid destination_io_limit '%d', must be at least 1", config.DestinationIOLimit))
+		os.Exit(1)
+	}
+
+	watcherPatterns := append([]string{}, config.DeviceWatcher.AllowedLabels...)
+	for _, pattern := range append(watcherPatterns, config.DeviceWatcher.IgnoredLabels...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			slog.Error(fmt.Sprintf("Invalid device_watcher label pattern '%s': %s", pattern, err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if !slices.Contains(model.StatusLightBackends, config.StatusLight.Backend) {
+		slog.Error(fmt.Sprintf("Invalid status_light backend '%s', must be one of: %v", config.StatusLight.Backend, model.StatusLightBackends))
+		os.Exit(1)
+	}
+
+	slotIDs := make(map[int]bool)
+	slotPorts := make(map[string]bool)
+	for _, slot := range config.StatusLight.Slots {
+		if slot.UsbPort == "" || slotIDs[slot.ID] || slotPorts[slot.UsbPort] {
+			slog.Error(fmt.Sprintf("Invalid status_light slot %d, every slot needs a unique id and usb_port", slot.ID))
+			os.Exit(1)
+		}
+		slotIDs[slot.ID] = true
+		slotPorts[slot.UsbPort] = true
+	}
+
+	knownProcessors := processor.GetProcessorNames()
+	for _, name := range config.EnabledProcessors {
+		if !slices.Contains(knownProcessors, name) {
+			slog.Warn(fmt.Sprintf("Unknown processor '%s' listed in enabled_processors", name))
+		}
+	}
+	for name := range config.Processors {
+		if !slices.Contains(knownProcessors, name) {
+			slog.Warn(fmt.Sprintf("Unknown processor '%s' listed in processors", name))
+		}
+	}
+
 	return config
 }
@@ -32,7 +32,6 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"runtime"
 
 	"github.com/spf13/cobra"
 )
@@ -45,8 +44,9 @@ var (
 		Use:   "server",
 		Short: "Start the importer in daemon mode (start REST server)",
 		Long: `The importer consists of two main components. The server (this) which 
-    waits for media inserted notifications and clients which are to be invoked by 
-    udev or other integrations to notify the server when media has been inserted.`,
+    watches for media being inserted (kernel uevents on linux, diskutil on mac) and
+    clients which can be invoked by other integrations to notify the server when
+    media has been inserted.`,
 
 		Run: func(cmd *cobra.Command, _ []string) {
 			config := cmd.Context().Value(model.ImportConfigContext).(model.ImporterConfig)
@@ -69,29 +69,7 @@ var (
 				}
 			})
 
-			// special handling for mac systems that will spin up a diskutil watcher to
-			// trigger imports
-			if runtime.GOOS == "darwin" {
-				go util.WatchForDeviceAttached(func(devicePath, volumePath string) {
-					deviceAttachedConfig := model.DeviceAttached{
-						AlreadyMounted: true,
-						DryRun:         config.ForceDryRun,
-						DevicePath:     devicePath,
-						MountPath:      volumePath,
-					}
-
-					// queue the import with the server intance
-					uri := fmt.Sprintf("http://%s/device_attached", deviceAttachedServer)
-					_, statusCode := util.CallServer(uri, deviceAttachedConfig)
-
-					if statusCode != 201 {
-						slog.Error("Unknown error occurred sending request")
-						os.Exit(1)
-					}
-				})
-			}
-
-			server.StartServer(config, serverListenAddress, serverListenPort)
+			server.StartServer(cmd.Context(), config, serverListenAddress, serverListenPort)
 		},
 	}
 )
@@ -0,0 +1,95 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ccmm/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotArgOutput string
+
+	snapshotCmd = &cobra.Command{
+		Use:   "snapshot volumePath [flags]",
+		Short: "Capture a snapshot of a volume for checking processor detection without the card",
+		Long: `Write a small .tar.gz of the volume holding its directory tree, the size and
+    modification time of every file, its label and format, and the start of the
+    sidecar files processors read (.CTG, .hprj, INDEX.MIF, SE_LOG.BIN and .XML).
+    Media files are left empty. The snapshot can be passed to test_processor in
+    place of the volume`,
+		Args: cobra.ExactArgs(1),
+
+		Run: func(cmd *cobra.Command, args []string) {
+			volume, err := util.OpenVolume(args[0])
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to open volume '%s': %s", args[0], err.Error()))
+				os.Exit(1)
+			}
+
+			outputPath := snapshotArgOutput
+			if outputPath == "" {
+				name := volume.Label
+				if name == "" {
+					name = filepath.Base(volume.Path)
+				}
+
+				outputPath = fmt.Sprintf("%s-%s.tar.gz", name, time.Now().Format("20060102-150405"))
+			}
+
+			output, err := os.Create(outputPath)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to create snapshot '%s': %s", outputPath, err.Error()))
+				os.Exit(1)
+			}
+
+			err = util.WriteVolumeSnapshot(volume, output)
+			if closeErr := output.Close(); err == nil {
+				err = closeErr
+			}
+
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to write snapshot '%s': %s", outputPath, err.Error()))
+				os.Remove(outputPath)
+				os.Exit(1)
+			}
+
+			slog.Info(fmt.Sprintf("Wrote snapshot of volume '%s' (label '%s', format '%s') to '%s'", volume.Path, volume.Label, volume.FsType, outputPath))
+		},
+	}
+)
+
+func init() {
+	snapshotCmd.Flags().StringVarP(&snapshotArgOutput, "output", "o", "", "Path of the snapshot to write (default: <volume label>-<date>-<time>.tar.gz)")
+
+	rootCmd.AddCommand(snapshotCmd)
+}
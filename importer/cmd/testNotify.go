@@ -0,0 +1,74 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"ccmm/model"
+	"ccmm/util/notify"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	testNotifyArgEvent string
+
+	testNotifyCmd = &cobra.Command{
+		Use:   "test_notify",
+		Short: "Send a test notification",
+		Long:  `Send a test notification to every configured destination that accepts the event type, and report any that failed`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			config := cmd.Context().Value(model.ImportConfigContext).(model.ImporterConfig)
+
+			if len(config.Notifications) == 0 {
+				slog.Error("No notifications are configured")
+				os.Exit(1)
+			}
+
+			err := notify.SendNow(notify.Event{
+				Type:    testNotifyArgEvent,
+				Title:   "Test notification",
+				Message: "This is a test notification from the ccmm importer",
+			})
+
+			if err != nil {
+				slog.Error("Failed to send test notification: " + err.Error())
+				os.Exit(1)
+			}
+
+			fmt.Println("Test notification sent")
+		},
+	}
+)
+
+func init() {
+	testNotifyCmd.Flags().StringVarP(&testNotifyArgEvent, "event", "e", notify.EventTest, "Event type to send, only destinations accepting this event receive it")
+
+	rootCmd.AddCommand(testNotifyCmd)
+}
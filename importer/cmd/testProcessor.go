@@ -25,8 +25,13 @@
 package cmd
 
 import (
-	"ccmm/importer/processor"
+	"fmt"
 	"log/slog"
+	"os"
+
+	"ccmm/importer/processor"
+	"ccmm/model"
+	"ccmm/util"
 
 	"github.com/spf13/cobra"
 )
@@ -35,22 +40,46 @@ var (
 	testProcessorCmd = &cobra.Command{
 		Use:   "test_processor processor volumePath",
 		Short: "Test the specified processor to see if it is valid for the provided voluemPath",
-		Args:  cobra.MinimumNArgs(2),
+		Long: `Test the specified processor to see if it is valid for the provided volumePath,
+which may be a mounted volume, a directory, a snapshot written by the snapshot
+command or a .tar, .tar.gz or .zip archive of a volume. The label and format of a directory or archive can be provided by a
+.ccmm-volume.yml file at its root, ex:
+
+  label: H6_SD
+  fs_type: FAT32`,
+		Args: cobra.MinimumNArgs(2),
 
 		Run: func(cmd *cobra.Command, args []string) {
+			config := cmd.Context().Value(model.ImportConfigContext).(model.ImporterConfig)
 			requestedProcessor := args[0]
+			config.EnabledProcessors = []string{requestedProcessor}
+
+			volume, err := util.OpenVolume(args[1])
+			if err != nil {
+				slog.Error(fmt.Sprintf("Failed to open volume '%s': %s", args[1], err.Error()))
+				os.Exit(1)
+			}
 
-			processors := processor.InitProcessors([]string{requestedProcessor}, args[1])
-			var foundProcessors []processor.Processor
+			slog.Info(fmt.Sprintf("Volume '%s': label '%s', format '%s', UUID '%s', %s", volume.Path, volume.Label, volume.FsType, volume.UUID, util.FormatBytes(volume.Size)))
 
-			for _, processor := range processors {
-				if processor.CheckSource() {
-					slog.Info("Processor compatible: " + requestedProcessor)
-					foundProcessors = append(foundProcessors, processor)
-				}
+			foundProcessors, diagnostics := processor.FindProcessors(cmd.Context(), config, volume)
+			if len(diagnostics) == 0 {
+				slog.Error(fmt.Sprintf("Unknown processor '%s'", requestedProcessor))
+				os.Exit(1)
 			}
 
-			processor.EnumerateSources(foundProcessors, true)
+			for _, diagnostic := range diagnostics {
+				slog.Info(fmt.Sprintf("Processor '%s': confidence %d, %s", diagnostic.Processor, diagnostic.Confidence, diagnostic.Reason))
+			}
+
+			if len(foundProcessors) > 0 {
+				slog.Info("Processor compatible: " + requestedProcessor)
+			}
+
+			if _, err := processor.EnumerateSources(cmd.Context(), config, foundProcessors, volume, diagnostics, true); err != nil {
+				slog.Error("Failed to list the files on the volume: " + err.Error())
+				os.Exit(1)
+			}
 		},
 	}
 )
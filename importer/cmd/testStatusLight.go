@@ -0,0 +1,123 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"ccmm/importer/statuslight"
+	"ccmm/model"
+	"ccmm/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	testStatusLightArgDelay    int
+	testStatusLightArgListOnly bool
+
+	testStatusLightCmd = &cobra.Command{
+		Use:   "test_statuslight",
+		Short: "List card reader USB ports and test the status lights",
+		Long: `List the USB port of every attached disk along with the status light slot it is mapped to, then
+cycle every configured slot through each state. Insert a card in each reader to find the usb_port to configure`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			config := cmd.Context().Value(model.ImportConfigContext).(model.ImporterConfig)
+
+			listUsbPorts(config.StatusLight)
+
+			if testStatusLightArgListOnly {
+				return
+			}
+
+			controller, err := statuslight.New(config.StatusLight)
+			if err != nil {
+				slog.Error("Failed to start status lights: " + err.Error())
+				os.Exit(1)
+			}
+			if controller == nil {
+				slog.Error("Status lights are disabled, set status_light.backend to test them")
+				os.Exit(1)
+			}
+			defer controller.Close()
+
+			delay := time.Duration(testStatusLightArgDelay) * time.Second
+			for _, slot := range controller.Slots() {
+				for _, state := range statuslight.States {
+					fmt.Printf("Slot %d: %s\n", slot, state)
+					controller.SetSlotState(slot, state)
+					time.Sleep(delay)
+				}
+				controller.SetSlotState(slot, statuslight.Idle)
+			}
+		},
+	}
+)
+
+func init() {
+	testStatusLightCmd.Flags().IntVarP(&testStatusLightArgDelay, "delay", "d", 2, "Number of seconds to show each state")
+	testStatusLightCmd.Flags().BoolVarP(&testStatusLightArgListOnly, "list", "l", false, "Only list the USB ports, don't cycle the lights")
+
+	rootCmd.AddCommand(testStatusLightCmd)
+}
+
+//
+// private functions
+//
+
+func listUsbPorts(config model.StatusLightConfig) {
+	slots := make(map[string]int)
+	for _, slot := range config.Slots {
+		slots[slot.UsbPort] = slot.ID
+	}
+
+	devices, _ := filepath.Glob("/sys/class/block/*")
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "DEVICE\tUSB PORT\tSLOT")
+
+	for _, device := range devices {
+		devicePath := "/dev/" + filepath.Base(device)
+		port := util.GetUsbPort(devicePath)
+		if port == "" {
+			continue
+		}
+
+		slot := "-"
+		if id, ok := slots[port]; ok {
+			slot = fmt.Sprintf("%d", id)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", devicePath, port, slot)
+	}
+
+	writer.Flush()
+}
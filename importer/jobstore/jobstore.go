@@ -0,0 +1,287 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package jobstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"ccmm/model"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket    = []byte("jobs")
+	resultsBucket = []byte("results")
+)
+
+// Store persists import jobs and the outcome of each file they handled so
+// that the job history survives a restart of the importer
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens (creating if needed) the job database at the provided path. Only
+// one process can have the database open at a time, so this will fail if
+// another importer instance is already using it
+func Open(dbPath string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job database directory: %w", err)
+	}
+
+	db, err := bolt.Open(dbPath, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job database '%s': %w", dbPath, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{jobsBucket, resultsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job database: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// LastJobID returns the highest job ID that has been stored, or 0 if the
+// store is empty
+func (s *Store) LastJobID() (int, error) {
+	lastID := 0
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key, _ := tx.Bucket(jobsBucket).Cursor().Last()
+		if key != nil {
+			lastID = int(binary.BigEndian.Uint64(key))
+		}
+
+		return nil
+	})
+
+	return lastID, err
+}
+
+// SaveJob stores the provided job. Files and results are not stored with the
+// job, results are stored individually by SaveResult
+func (s *Store) SaveJob(job model.ImportJobStatus) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJob(tx, job)
+	})
+}
+
+// SaveResult stores the outcome of a single file along with the current state
+// of the job it belongs to. Results are keyed by source path, so saving the
+// result of a file again replaces the previous one
+func (s *Store) SaveResult(job model.ImportJobStatus, result model.ImportFileResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putJob(tx, job); err != nil {
+			return err
+		}
+
+		bucket, err := tx.Bucket(resultsBucket).CreateBucketIfNotExists(jobKey(job.ID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(result.SourcePath), value)
+	})
+}
+
+// GetJob returns the job with the provided ID, including the stored file
+// results, or nil if the job isn't known
+func (s *Store) GetJob(id int) (*model.ImportJobStatus, error) {
+	var job *model.ImportJobStatus
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(jobsBucket).Get(jobKey(id))
+		if value == nil {
+			return nil
+		}
+
+		job = &model.ImportJobStatus{}
+		if err := json.Unmarshal(value, job); err != nil {
+			return fmt.Errorf("failed to decode job %d: %w", id, err)
+		}
+
+		results, err := getResults(tx, id)
+		job.Results = results
+
+		return err
+	})
+
+	return job, err
+}
+
+// GetJobs returns the jobs that match the provided filter, newest first.
+// File results are not included
+func (s *Store) GetJobs(filter model.ImportHistoryFilter) ([]model.ImportJobStatus, error) {
+	jobs := make([]model.ImportJobStatus, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(jobsBucket).Cursor()
+
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var job model.ImportJobStatus
+			if err := json.Unmarshal(value, &job); err != nil {
+				return fmt.Errorf("failed to decode job %d: %w", binary.BigEndian.Uint64(key), err)
+			}
+
+			if !matchesFilter(job, filter) {
+				continue
+			}
+
+			jobs = append(jobs, job)
+			if filter.Limit > 0 && len(jobs) >= filter.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return jobs, err
+}
+
+// GetUnfinishedJobs returns the jobs that were still queued or running when
+// the importer last stopped, oldest first
+func (s *Store) GetUnfinishedJobs(finishedStatuses []string) ([]model.ImportJobStatus, error) {
+	jobs := make([]model.ImportJobStatus, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(key []byte, value []byte) error {
+			var job model.ImportJobStatus
+			if err := json.Unmarshal(value, &job); err != nil {
+				return fmt.Errorf("failed to decode job %d: %w", binary.BigEndian.Uint64(key), err)
+			}
+
+			if slices.Contains(finishedStatuses, job.Status) {
+				return nil
+			}
+
+			results, err := getResults(tx, job.ID)
+			if err != nil {
+				return err
+			}
+
+			job.Results = results
+			jobs = append(jobs, job)
+
+			return nil
+		})
+	})
+
+	return jobs, err
+}
+
+//
+// private functions
+//
+
+func jobKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+
+	return key
+}
+
+func putJob(tx *bolt.Tx, job model.ImportJobStatus) error {
+	job.Files = nil
+	job.Results = nil
+
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(jobsBucket).Put(jobKey(job.ID), value)
+}
+
+func getResults(tx *bolt.Tx, id int) ([]model.ImportFileResult, error) {
+	results := make([]model.ImportFileResult, 0)
+
+	bucket := tx.Bucket(resultsBucket).Bucket(jobKey(id))
+	if bucket == nil {
+		return results, nil
+	}
+
+	err := bucket.ForEach(func(_ []byte, value []byte) error {
+		var result model.ImportFileResult
+		if err := json.Unmarshal(value, &result); err != nil {
+			return fmt.Errorf("failed to decode result for job %d: %w", id, err)
+		}
+
+		results = append(results, result)
+		return nil
+	})
+
+	return results, err
+}
+
+func matchesFilter(job model.ImportJobStatus, filter model.ImportHistoryFilter) bool {
+	if !filter.Since.IsZero() && job.QueuedAt.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && !job.QueuedAt.Before(filter.Until) {
+		return false
+	}
+
+	if filter.VolumeLabel != "" && !strings.EqualFold(job.VolumeLabel, filter.VolumeLabel) {
+		return false
+	}
+
+	if filter.Processor != "" && !slices.ContainsFunc(job.Processors, func(name string) bool {
+		return strings.EqualFold(name, filter.Processor)
+	}) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,209 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package jobstore
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"ccmm/model"
+)
+
+func openTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "jobs", "jobs.db")
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store, dbPath
+}
+
+func jobIDs(jobs []model.ImportJobStatus) []int {
+	ids := make([]int, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+
+	return ids
+}
+
+func TestLastJobID(t *testing.T) {
+	store, dbPath := openTestStore(t)
+
+	if lastID, err := store.LastJobID(); err != nil || lastID != 0 {
+		t.Errorf("expected 0 for an empty store, got %d %v", lastID, err)
+	}
+
+	// keys are ordered as bytes, so 256 has to sort after 12
+	for _, id := range []int{3, 256, 12} {
+		if err := store.SaveJob(model.ImportJobStatus{ID: id, Status: "completed"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lastID, err := store.LastJobID(); err != nil || lastID != 256 {
+		t.Errorf("expected 256, got %d %v", lastID, err)
+	}
+
+	// the jobs survive reopening the database
+	store.Close()
+	store, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if lastID, err := store.LastJobID(); err != nil || lastID != 256 {
+		t.Errorf("expected 256 after reopening, got %d %v", lastID, err)
+	}
+}
+
+func TestSaveResultReplacesResult(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	job := model.ImportJobStatus{
+		ID:     1,
+		Status: "importing",
+		Files:  []model.SourceFile{{SourcePath: "/media/CARD/A.WAV"}},
+	}
+
+	if err := store.SaveResult(job, model.ImportFileResult{SourcePath: "/media/CARD/A.WAV", Action: "failed", Error: "read error"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveResult(job, model.ImportFileResult{SourcePath: "/media/CARD/B.WAV", Action: "copied"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is imported again when the job is resumed
+	job.Status = "completed"
+	if err := store.SaveResult(job, model.ImportFileResult{SourcePath: "/media/CARD/A.WAV", Action: "copied", Verified: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.GetJob(1)
+	if err != nil || stored == nil {
+		t.Fatalf("expected the job to be stored, got %v %v", stored, err)
+	}
+
+	if stored.Status != "completed" {
+		t.Errorf("expected status %v, got %v", "completed", stored.Status)
+	}
+	if stored.Files != nil {
+		t.Errorf("expected the files not to be stored, got %+v", stored.Files)
+	}
+	if len(stored.Results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", stored.Results)
+	}
+	for _, result := range stored.Results {
+		if result.Action != "copied" || result.Error != "" {
+			t.Errorf("'%s': expected the latest result, got %+v", result.SourcePath, result)
+		}
+	}
+
+	if missing, err := store.GetJob(2); err != nil || missing != nil {
+		t.Errorf("expected no job, got %+v %v", missing, err)
+	}
+}
+
+func TestGetUnfinishedJobs(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	statuses := map[int]string{1: "completed", 2: "importing", 3: "failed", 4: "pending", 5: "cancelled", 6: "interrupted", 7: "scanning"}
+	for id, status := range statuses {
+		if err := store.SaveJob(model.ImportJobStatus{ID: id, Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveResult(model.ImportJobStatus{ID: 2, Status: "importing"}, model.ImportFileResult{SourcePath: "/media/CARD/A.WAV", Action: "copied"}); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := store.GetUnfinishedJobs([]string{"completed", "failed", "cancelled", "interrupted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := jobIDs(jobs); !slices.Equal(ids, []int{2, 4, 7}) {
+		t.Fatalf("expected jobs [2 4 7], oldest first, got %v", ids)
+	}
+	if len(jobs[0].Results) != 1 || len(jobs[1].Results) != 0 {
+		t.Errorf("expected the stored results with each job, got %+v", jobs)
+	}
+}
+
+func TestGetJobsFilter(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	start := time.Date(2024, 11, 3, 9, 0, 0, 0, time.UTC)
+	for id := 1; id <= 5; id++ {
+		job := model.ImportJobStatus{
+			ID:          id,
+			Status:      "completed",
+			VolumeLabel: "H6_SD",
+			Processors:  []string{"zoomH6"},
+			QueuedAt:    start.Add(time.Duration(id-1) * time.Hour),
+		}
+		if id%2 == 0 {
+			job.VolumeLabel = "EOS_DIGITAL"
+			job.Processors = []string{"canonEOS"}
+		}
+
+		if err := store.SaveJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter model.ImportHistoryFilter
+		want   []int
+	}{
+		{"everything, newest first", model.ImportHistoryFilter{}, []int{5, 4, 3, 2, 1}},
+		{"since is inclusive", model.ImportHistoryFilter{Since: start.Add(2 * time.Hour)}, []int{5, 4, 3}},
+		{"until is exclusive", model.ImportHistoryFilter{Until: start.Add(2 * time.Hour)}, []int{2, 1}},
+		{"since and until", model.ImportHistoryFilter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, []int{3, 2}},
+		{"limit", model.ImportHistoryFilter{Limit: 2}, []int{5, 4}},
+		{"limit applies after filtering", model.ImportHistoryFilter{VolumeLabel: "h6_sd", Limit: 2}, []int{5, 3}},
+		{"processor", model.ImportHistoryFilter{Processor: "CANONEOS"}, []int{4, 2}},
+		{"nothing matches", model.ImportHistoryFilter{Since: start.Add(24 * time.Hour)}, []int{}},
+	}
+
+	for _, test := range tests {
+		jobs, err := store.GetJobs(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := jobIDs(jobs); !slices.Equal(ids, test.want) {
+			t.Errorf("%s: expected jobs %v, got %v", test.name, test.want, ids)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package localsend
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	certificateFile = "cert.pem"
+	keyFile         = "key.pem"
+
+	// certificateValidity is how long a generated certificate is valid for.
+	// LocalSend clients pin the fingerprint rather than checking the dates, so
+	// this only needs to be long enough to never be a concern
+	certificateValidity = 10 * 365 * 24 * time.Hour
+)
+
+// loadOrCreateCertificate loads the TLS certificate and key stored in dir,
+// generating and storing a new self-signed certificate if there isn't one yet.
+// The certificate is returned along with its fingerprint, the hex encoded
+// SHA-256 of the certificate, which is what LocalSend clients identify the
+// server by
+func loadOrCreateCertificate(dir string, alias string) (tls.Certificate, string, error) {
+	certPath := filepath.Join(dir, certificateFile)
+	keyPath := filepath.Join(dir, keyFile)
+
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info(fmt.Sprintf("Generating a new localsend TLS certificate in '%s'", dir))
+		certificate, err = createCertificate(certPath, keyPath, alias)
+	}
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	fingerprint := sha256.Sum256(certificate.Certificate[0])
+
+	return certificate, hex.EncodeToString(fingerprint[:]), nil
+}
+
+//
+// private functions
+//
+
+func createCertificate(certPath string, keyPath string, alias string) (tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: alias, Organization: []string{"ccmm"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to store key: %w", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to store certificate: %w", err)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -152,13 +152,24 @@ func StartBroadcastHTTP(config model.LocalSendConfig, message model.BroadcastMes
 // registerWithHttp Sending HTTP Requests
 func registerWithHttp(config model.LocalSendConfig, ctx context.Context, ip string, data []byte) {
 	url := fmt.Sprintf("https://%s:%d/api/localsend/v2/register", ip, config.ListenPort)
+
+	if _, err := postRegister(ctx, url, data); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to register with %s: %s", ip, err.Error()))
+	}
+}
+
+// postRegister sends our device info to the register endpoint at url and
+// returns the device info that the other device answered with
+func postRegister(ctx context.Context, url string, data []byte) (model.BroadcastMessage, error) {
+	var response model.BroadcastMessage
+
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
 	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to create HTTP request: %s", err.Error()))
-		return
+		return response, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	// other devices use self-signed certificates
 	client := &http.Client{
 		Timeout: 2 * time.Second,
 		Transport: &http.Transport{
@@ -170,19 +181,22 @@ func registerWithHttp(config model.LocalSendConfig, ctx context.Context, ip stri
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return
+		return response, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to read HTTP response body: %s", err.Error()))
-		return
+		return response, fmt.Errorf("failed to read HTTP response body: %w", err)
 	}
-	var response model.BroadcastMessage
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to parse HTTP response from %s: %v", ip, err.Error()))
-		return
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return response, fmt.Errorf("failed to parse HTTP response: %w", err)
 	}
+
+	return response, nil
 }
@@ -22,13 +22,18 @@ package discovery
 
 import (
 	"ccmm/model"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net"
+	"strconv"
 	"time"
 )
 
+// maxAnnouncementSize is large enough for any announcement a LocalSend device sends
+const maxAnnouncementSize = 64 * 1024
+
 // StartBroadcastUDP Sending a Broadcast Message
 func StartBroadcastUDP(config model.LocalSendConfig, message model.BroadcastMessage) {
 	// Set the multicast address and port
@@ -64,3 +69,85 @@ func StartBroadcastUDP(config model.LocalSendConfig, message model.BroadcastMess
 		time.Sleep(5 * time.Second) // Send a broadcast message every 5 seconds
 	}
 }
+
+// ListenForAnnouncements Listens for multicast announcements from other
+// devices and answers them, so that this server shows up on a sender as soon
+// as it opens LocalSend instead of at our next broadcast
+func ListenForAnnouncements(config model.LocalSendConfig, message model.BroadcastMessage) {
+	multicastAddr := &net.UDPAddr{
+		IP:   net.ParseIP(config.UdpBroadcastAddress),
+		Port: config.UdpBroadcastPort,
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, multicastAddr)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error listening for multicast announcements: %s", err.Error()))
+		return
+	}
+	defer conn.Close()
+
+	buffer := make([]byte, maxAnnouncementSize)
+	for {
+		n, sender, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Stopped listening for multicast announcements: %s", err.Error()))
+			return
+		}
+
+		var announcement model.BroadcastMessage
+		if err := json.Unmarshal(buffer[:n], &announcement); err != nil {
+			slog.Debug(fmt.Sprintf("Ignoring invalid multicast message from %s: %s", sender.IP, err.Error()))
+			continue
+		}
+
+		// our own announcements are received too, and answers to other
+		// devices' announcements don't need an answer
+		if announcement.Fingerprint == message.Fingerprint || !announcement.Announce {
+			continue
+		}
+
+		slog.Debug(fmt.Sprintf("Received announcement from '%s' at %s", announcement.Alias, sender.IP))
+		go respondToAnnouncement(multicastAddr, message, announcement, sender.IP)
+	}
+}
+
+// respondToAnnouncement Answers an announcement by registering with the
+// device that sent it, falling back to a multicast answer if that fails
+func respondToAnnouncement(multicastAddr *net.UDPAddr, message model.BroadcastMessage, announcement model.BroadcastMessage, ip net.IP) {
+	message.Announce = false
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		slog.Error(fmt.Sprintf("json convert failed: %s", err.Error()))
+		return
+	}
+
+	protocol := announcement.Protocol
+	if protocol == "" {
+		protocol = "https"
+	}
+
+	port := announcement.Port
+	if port == 0 {
+		port = multicastAddr.Port
+	}
+
+	url := fmt.Sprintf("%s://%s/api/localsend/v2/register", protocol, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	_, err = postRegister(context.Background(), url, data)
+	if err == nil {
+		return
+	}
+
+	slog.Debug(fmt.Sprintf("Failed to register with '%s' (%s), answering by multicast instead", announcement.Alias, err.Error()))
+
+	conn, err := net.DialUDP("udp4", nil, multicastAddr)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to answer announcement from '%s': %s", announcement.Alias, err.Error()))
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(data); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to answer announcement from '%s': %s", announcement.Alias, err.Error()))
+	}
+}
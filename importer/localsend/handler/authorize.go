@@ -0,0 +1,367 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util/notify"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned when deciding whether a sender may transfer files
+var (
+	ErrPendingSenderNotFound = errors.New("pending sender not found")
+
+	errSenderDenied     = errors.New("sender is not allowed")
+	errApprovalTimedOut = errors.New("sender was not approved in time")
+	errPinRequired      = errors.New("PIN required")
+	errInvalidPin       = errors.New("invalid PIN")
+	errPinLockedOut     = errors.New("too many invalid PINs")
+	errInvalidRequest   = errors.New("invalid request")
+)
+
+// sender describes who is asking to transfer files. Fingerprint is empty
+// unless the sender proved it with a certificate
+type sender struct {
+	Alias       string
+	Fingerprint string
+	DeviceModel string
+	IPAddress   string
+}
+
+// pinLimiter counts invalid PINs by IP address, so that PINs can't be guessed
+type pinLimiter struct {
+	mutex    sync.Mutex
+	failures map[string]*pinFailures
+}
+
+type pinFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// approvalManager holds the transfers from unknown senders that are waiting
+// for an operator, along with the senders that were approved for good
+type approvalManager struct {
+	mutex    sync.Mutex
+	pending  map[string]*pendingSender
+	approved []sender
+}
+
+type pendingSender struct {
+	model.LocalSendPendingSender
+	decision chan bool
+}
+
+var (
+	pins      = &pinLimiter{failures: make(map[string]*pinFailures)}
+	approvals = &approvalManager{pending: make(map[string]*pendingSender)}
+)
+
+// GetPendingSenders returns the transfers waiting for an operator to approve
+// or deny them, oldest first
+func GetPendingSenders() []model.LocalSendPendingSender {
+	approvals.mutex.Lock()
+	defer approvals.mutex.Unlock()
+
+	pending := make([]model.LocalSendPendingSender, 0, len(approvals.pending))
+	for _, request := range approvals.pending {
+		pending = append(pending, request.LocalSendPendingSender)
+	}
+
+	slices.SortFunc(pending, func(a, b model.LocalSendPendingSender) int {
+		return a.RequestedAt.Compare(b.RequestedAt)
+	})
+
+	return pending
+}
+
+// ApproveSender lets the pending transfer go ahead. If remember is set, later
+// transfers from the same sender are accepted without asking again, until the
+// importer is restarted
+func ApproveSender(id string, remember bool) error {
+	approvals.mutex.Lock()
+	defer approvals.mutex.Unlock()
+
+	request, ok := approvals.pending[id]
+	if !ok {
+		return ErrPendingSenderNotFound
+	}
+
+	if remember {
+		approvals.approved = append(approvals.approved, sender{Alias: request.Alias, Fingerprint: request.Fingerprint})
+	}
+
+	slog.Info(fmt.Sprintf("Localsend transfer from '%s' approved", request.Alias), slog.Bool("Remember", remember))
+	delete(approvals.pending, id)
+	request.decision <- true
+
+	return nil
+}
+
+// DenySender refuses the pending transfer
+func DenySender(id string) error {
+	approvals.mutex.Lock()
+	defer approvals.mutex.Unlock()
+
+	request, ok := approvals.pending[id]
+	if !ok {
+		return ErrPendingSenderNotFound
+	}
+
+	slog.Info(fmt.Sprintf("Localsend transfer from '%s' denied", request.Alias))
+	delete(approvals.pending, id)
+	request.decision <- false
+
+	return nil
+}
+
+//
+// private functions
+//
+
+// getSender describes the sender of the request. The fingerprint is only
+// known when the sender presented a certificate over https. The one a sender
+// claims is ignored, as fingerprints are broadcast for anyone to copy
+func getSender(r *http.Request, info model.Info) sender {
+	s := sender{
+		Alias:       info.Alias,
+		DeviceModel: info.DeviceModel,
+		IPAddress:   getSenderIP(r),
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		fingerprint := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		s.Fingerprint = hex.EncodeToString(fingerprint[:])
+	}
+
+	return s
+}
+
+// matches checks the sender against a rule. Empty fields of the rule match anything
+func (s sender) matches(alias string, fingerprint string) bool {
+	if alias == "" && fingerprint == "" {
+		return false
+	}
+
+	return (alias == "" || strings.EqualFold(alias, s.Alias)) &&
+		(fingerprint == "" || strings.EqualFold(fingerprint, s.Fingerprint))
+}
+
+// findSenderRule returns the first rule matching the sender
+func findSenderRule(config model.LocalSendConfig, s sender) (model.LocalSendSender, bool) {
+	for _, rule := range config.Senders {
+		if s.matches(rule.Alias, rule.Fingerprint) {
+			return rule, true
+		}
+	}
+
+	return model.LocalSendSender{}, false
+}
+
+// authorizeSender decides whether the sender may transfer files, waiting for
+// an operator if needed. The matching rule is returned, or an empty rule for
+// an unknown sender that was allowed
+func authorizeSender(ctx context.Context, config model.LocalSendConfig, s sender, pin string, files map[string]model.FileInfo) (model.LocalSendSender, error) {
+	if err := pins.check(s.IPAddress); err != nil {
+		return model.LocalSendSender{}, err
+	}
+
+	rule, known := findSenderRule(config, s)
+	if rule.Deny || (!known && config.UnknownSenders == model.LocalSendDeny) {
+		return rule, errSenderDenied
+	}
+
+	requiredPin := config.RequirePassword
+	if rule.Pin != "" {
+		requiredPin = rule.Pin
+	}
+
+	if requiredPin != "" {
+		// senders ask without a PIN first to find out whether one is needed
+		if pin == "" {
+			return rule, errPinRequired
+		}
+
+		if subtle.ConstantTimeCompare([]byte(pin), []byte(requiredPin)) != 1 {
+			pins.failed(config, s.IPAddress)
+			return rule, errInvalidPin
+		}
+		pins.succeeded(s.IPAddress)
+	}
+
+	if !known && config.UnknownSenders == model.LocalSendApprove {
+		return rule, approvals.wait(ctx, config, s, files)
+	}
+
+	return rule, nil
+}
+
+// statusForAuthError returns the HTTP status the LocalSend protocol expects
+// when a sender isn't authorized
+func statusForAuthError(err error) int {
+	switch {
+	case errors.Is(err, errPinRequired), errors.Is(err, errInvalidPin):
+		return http.StatusUnauthorized
+	case errors.Is(err, errPinLockedOut):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errInvalidRequest):
+		return http.StatusBadRequest
+	}
+
+	return http.StatusForbidden
+}
+
+func (pl *pinLimiter) check(ip string) error {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	if failures, ok := pl.failures[ip]; ok && time.Now().Before(failures.lockedUntil) {
+		return errPinLockedOut
+	}
+
+	return nil
+}
+
+func (pl *pinLimiter) failed(config model.LocalSendConfig, ip string) {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	failures, ok := pl.failures[ip]
+	if !ok || (!failures.lockedUntil.IsZero() && time.Now().After(failures.lockedUntil)) {
+		failures = &pinFailures{}
+		pl.failures[ip] = failures
+	}
+
+	failures.count++
+	if failures.count >= config.PinAttempts {
+		failures.lockedUntil = time.Now().Add(time.Duration(config.PinLockout) * time.Second)
+		slog.Warn(fmt.Sprintf("Too many invalid PINs from %s, refusing transfers from it for %d seconds", ip, config.PinLockout))
+	}
+}
+
+func (pl *pinLimiter) succeeded(ip string) {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	delete(pl.failures, ip)
+}
+
+// wait holds the transfer until an operator approves or denies it, the
+// approval times out or the sender gives up
+func (am *approvalManager) wait(ctx context.Context, config model.LocalSendConfig, s sender, files map[string]model.FileInfo) error {
+	timeout := time.Duration(config.ApprovalTimeout) * time.Second
+
+	am.mutex.Lock()
+	for _, approved := range am.approved {
+		if s.matches(approved.Alias, approved.Fingerprint) {
+			am.mutex.Unlock()
+			return nil
+		}
+	}
+
+	request := &pendingSender{
+		LocalSendPendingSender: model.LocalSendPendingSender{
+			ID:          uuid.New().String(),
+			Alias:       s.Alias,
+			Fingerprint: s.Fingerprint,
+			DeviceModel: s.DeviceModel,
+			IPAddress:   s.IPAddress,
+			Files:       len(files),
+			RequestedAt: time.Now(),
+			ExpiresAt:   time.Now().Add(timeout),
+		},
+		decision: make(chan bool, 1),
+	}
+	for _, fileInfo := range files {
+		request.TotalBytes += fileInfo.Size
+	}
+
+	am.pending[request.ID] = request
+	am.mutex.Unlock()
+
+	slog.Info(fmt.Sprintf("Localsend transfer from unknown sender '%s' (%s) is waiting for approval", s.Alias, s.IPAddress),
+		slog.String("PendingID", request.ID))
+
+	notify.Notify(notify.Event{
+		Type:    notify.EventLocalSendApproval,
+		Title:   "LocalSend transfer waiting for approval",
+		Message: fmt.Sprintf("'%s' (%s) wants to send %d file(s), approve or deny it within %s", s.Alias, s.IPAddress, request.Files, timeout),
+		Data: map[string]any{
+			"id":          request.ID,
+			"alias":       s.Alias,
+			"fingerprint": s.Fingerprint,
+			"ip_address":  s.IPAddress,
+			"files":       request.Files,
+			"total_bytes": request.TotalBytes,
+		},
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case approved := <-request.decision:
+		if !approved {
+			return errSenderDenied
+		}
+		return nil
+
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	// the decision may have been made while the lock was being acquired
+	select {
+	case approved := <-request.decision:
+		if approved {
+			return nil
+		}
+		return errSenderDenied
+	default:
+	}
+
+	delete(am.pending, request.ID)
+	slog.Info(fmt.Sprintf("Localsend transfer from '%s' was not approved in time", s.Alias), slog.String("PendingID", request.ID))
+
+	return errApprovalTimedOut
+}
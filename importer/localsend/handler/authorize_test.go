@@ -0,0 +1,123 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"ccmm/model"
+)
+
+func newTestPinConfig() model.LocalSendConfig {
+	return model.LocalSendConfig{
+		UnknownSenders: model.LocalSendAllow,
+		PinAttempts:    3,
+		PinLockout:     300,
+		Senders: []model.LocalSendSender{
+			{Alias: "camera", Pin: "1234"},
+			{Alias: "phone"},
+		},
+	}
+}
+
+func TestAuthorizeSenderPin(t *testing.T) {
+	tests := []struct {
+		name  string
+		alias string
+		pin   string
+		want  error
+	}{
+		{"no PIN needed", "phone", "", nil},
+		{"PIN asked for", "camera", "", errPinRequired},
+		{"wrong PIN", "camera", "4321", errInvalidPin},
+		{"shorter PIN", "camera", "123", errInvalidPin},
+		{"correct PIN", "camera", "1234", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pins = &pinLimiter{failures: make(map[string]*pinFailures)}
+
+			s := sender{Alias: test.alias, IPAddress: "192.0.2.1"}
+			if _, err := authorizeSender(context.Background(), newTestPinConfig(), s, test.pin, nil); !errors.Is(err, test.want) {
+				t.Errorf("expected %v, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSenderPinLockout(t *testing.T) {
+	pins = &pinLimiter{failures: make(map[string]*pinFailures)}
+	config := newTestPinConfig()
+	ctx := context.Background()
+
+	camera := sender{Alias: "camera", IPAddress: "192.0.2.1"}
+	phone := sender{Alias: "phone", IPAddress: "192.0.2.1"}
+
+	for i := 0; i < config.PinAttempts-1; i++ {
+		if _, err := authorizeSender(ctx, config, camera, "0000", nil); !errors.Is(err, errInvalidPin) {
+			t.Fatalf("attempt %d: expected an invalid PIN, got %v", i+1, err)
+		}
+	}
+
+	// a sender that needs no PIN, from the same address, doesn't clear the
+	// invalid PINs
+	if _, err := authorizeSender(ctx, config, phone, "", nil); err != nil {
+		t.Fatalf("expected the phone to be allowed, got %v", err)
+	}
+
+	if _, err := authorizeSender(ctx, config, camera, "0000", nil); !errors.Is(err, errInvalidPin) {
+		t.Fatalf("expected an invalid PIN, got %v", err)
+	}
+	if _, err := authorizeSender(ctx, config, camera, "1234", nil); !errors.Is(err, errPinLockedOut) {
+		t.Errorf("expected the address to be locked out, got %v", err)
+	}
+	if _, err := authorizeSender(ctx, config, phone, "", nil); !errors.Is(err, errPinLockedOut) {
+		t.Errorf("expected the address to be locked out, got %v", err)
+	}
+}
+
+func TestAuthorizeSenderCorrectPinClearsFailures(t *testing.T) {
+	pins = &pinLimiter{failures: make(map[string]*pinFailures)}
+	config := newTestPinConfig()
+	ctx := context.Background()
+
+	camera := sender{Alias: "camera", IPAddress: "192.0.2.1"}
+
+	for i := 0; i < config.PinAttempts-1; i++ {
+		authorizeSender(ctx, config, camera, "0000", nil)
+	}
+	if _, err := authorizeSender(ctx, config, camera, "1234", nil); err != nil {
+		t.Fatalf("expected the correct PIN to be accepted, got %v", err)
+	}
+
+	for i := 0; i < config.PinAttempts-1; i++ {
+		if _, err := authorizeSender(ctx, config, camera, "0000", nil); !errors.Is(err, errInvalidPin) {
+			t.Fatalf("attempt %d: expected an invalid PIN, got %v", i+1, err)
+		}
+	}
+}
@@ -23,35 +23,74 @@ package handler
 import (
 	"ccmm/model"
 	"ccmm/util"
+	"ccmm/util/notify"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-var (
-	sessions = make(map[string]*model.ReceiveSession)
-)
+// uploadSuffix is appended to the name of a file while it is being received
+const uploadSuffix = ".ccmm-upload"
 
-func getOutputFilePath(config model.LocalSendConfig, alias string, fileName string) (string, string, error) {
+// getOutputFilePath returns the directory that files are stored in for the
+// provided storage subdirectory, and the path of fileName within it. Paths
+// that would end up outside of the storage path are refused
+func getOutputFilePath(config model.LocalSendConfig, subdirectory string, fileName string) (string, string, error) {
 	// Generate file paths, preserving file extensions
-	outputDirectory, err := filepath.Abs(config.StoragePath)
+	storagePath, err := filepath.Abs(config.StoragePath)
 	if err != nil {
 		return "", "", err
 	}
 
+	outputDirectory := filepath.Join(storagePath, subdirectory)
+	filePath := filepath.Join(outputDirectory, fileName)
+
+	if !isWithin(storagePath, outputDirectory) || !isWithin(outputDirectory, filePath) {
+		return "", "", fmt.Errorf("%w: '%s' is outside of the storage path", errInvalidRequest, filepath.Join(subdirectory, fileName))
+	}
+
+	return outputDirectory, filePath, nil
+}
+
+// getStorageSubdirectory returns the subdirectory of the storage path that
+// files from the sender are placed in
+func getStorageSubdirectory(config model.LocalSendConfig, rule model.LocalSendSender, alias string) string {
+	if rule.Subdirectory != "" {
+		return rule.Subdirectory
+	}
+
 	if config.AppendSenderAlias {
-		outputDirectory = path.Join(outputDirectory, alias)
+		return alias
 	}
 
-	return outputDirectory, path.Join(outputDirectory, fileName), nil
+	return ""
+}
+
+func isWithin(parent string, path string) bool {
+	relativePath, err := filepath.Rel(parent, path)
+	return err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+// getSenderIP returns the IP address that the request came from
+func getSenderIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
 }
 
 func PrepareReceive(config model.LocalSendConfig, message model.BroadcastMessage, w http.ResponseWriter, r *http.Request) {
@@ -69,36 +108,29 @@ func PrepareReceive(config model.LocalSendConfig, message model.BroadcastMessage
 	requestLogger.Info("Received upload request")
 	requestLogger.Debug(fmt.Sprintf("Request details: %v", req))
 
-	if config.RequirePassword != "" && pin != config.RequirePassword {
-		w.WriteHeader(http.StatusUnauthorized)
-
-		if pin != "" {
-			requestLogger.Warn("Invalid PIN provided, access denied")
-		}
-
+	rule, err := authorizeSender(r.Context(), config, getSender(r, req.Info), pin, req.Files)
+	if err != nil {
+		requestLogger.Warn("Refusing upload request: " + err.Error())
+		http.Error(w, err.Error(), statusForAuthError(err))
 		return
 	}
 
-	sessionID := uuid.New().String()
-
-	sessions[sessionID] = &model.ReceiveSession{
-		Alias:          req.Info.Alias,
-		TotalFiles:     0,
-		CompletedFiles: 0,
-		Files:          make(map[string]model.FileInfo),
-	}
+	session := newSession(req.Info.Alias, getSenderIP(r))
+	session.Subdirectory = getStorageSubdirectory(config, rule, req.Info.Alias)
 
 	files := make(map[string]string)
+	var requiredBytes int64
 	for fileID, fileInfo := range req.Files {
 		// Get output file path to see if the file already exists and is the same size
-		_, filePath, err := getOutputFilePath(config, req.Info.Alias, fileInfo.FileName)
+		_, filePath, err := getOutputFilePath(config, session.Subdirectory, fileInfo.FileName)
 		if err != nil {
-			slog.Error("Failed to get absolute output directory: " + err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			requestLogger.Warn("Refusing upload request: " + err.Error())
+			http.Error(w, err.Error(), statusForAuthError(err))
+			return
 		}
 
 		// GetFileSize returns -1 if the file doesn't exist
-		if util.GetFileSize(filePath) == fileInfo.Size {
+		if util.GetFileSize(filePath) == fileInfo.Size && existingFileMatches(filePath, fileInfo) {
 			slog.Debug("File already exists and is same size, telling the sender to skip it",
 				slog.String("FileName", fileInfo.FileName),
 				slog.Int64("FileSize", fileInfo.Size),
@@ -106,22 +138,52 @@ func PrepareReceive(config model.LocalSendConfig, message model.BroadcastMessage
 			continue
 		}
 
-		token := fmt.Sprintf("token-%s", fileID)
+		token := uuid.New().String()
 		files[fileID] = token
 
 		// Save file name
-		sessions[sessionID].Files[fileID] = fileInfo
-		sessions[sessionID].TotalFiles += 1
+		session.Files[fileID] = fileInfo
+		session.Tokens[fileID] = token
+		session.TotalFiles += 1
+		requiredBytes += fileInfo.Size
+	}
+
+	if session.TotalFiles == 0 {
+		requestLogger.Info("Every file has already been received, nothing to transfer")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// refuse the whole transfer up front rather than running out of space
+	// part way through it
+	outputDirectory, _, err := getOutputFilePath(config, session.Subdirectory, "")
+	if err != nil {
+		slog.Error("Failed to get absolute output directory: " + err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := util.CheckFreeSpace(outputDirectory, requiredBytes, config.FreeSpaceReserveMB*1024*1024); err != nil {
+		requestLogger.Warn("Refusing upload request: " + err.Error())
+		http.Error(w, "Not enough free space to receive the files", http.StatusInsufficientStorage)
+		return
+	}
+
+	session.ID = uuid.New().String()
+	if err := sessions.start(config, session); err != nil {
+		requestLogger.Warn("Refusing upload request: " + err.Error())
+		http.Error(w, "Blocked by another session", statusForError(err))
+		return
 	}
 
 	resp := model.PrepareReceiveResponse{
-		SessionID: sessionID,
+		SessionID: session.ID,
 		Files:     files,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 
-	requestLogger.Info("Done processing upload request, ready to receive files", slog.String("SessionID", sessionID))
+	requestLogger.Info("Done processing upload request, ready to receive files", slog.String("SessionID", session.ID))
 }
 
 func ReceiveHandler(config model.LocalSendConfig, message model.BroadcastMessage, w http.ResponseWriter, r *http.Request, sessionCompleteCallback func(string)) {
@@ -142,48 +204,29 @@ func ReceiveHandler(config model.LocalSendConfig, message model.BroadcastMessage
 		return
 	}
 
-	session, ok := sessions[sessionID]
-	if !ok {
-		receiveLogger.Warn("Invalid session ID")
-		http.Error(w, "Couldn't find sessionID "+sessionID, http.StatusBadRequest)
+	session, fileEntry, err := sessions.beginUpload(config, sessionID, fileID, token, getSenderIP(r))
+	if err != nil {
+		receiveLogger.Warn("Refusing upload: " + err.Error())
+		http.Error(w, err.Error(), statusForError(err))
+		return
 	}
 
-	sessionLogger := receiveLogger.With(slog.String("Alias", session.Alias))
-
-	// verify access is allowed
-	// this obviously isn't a very robust access system, but its mainly meant to
-	// prevent accidental uploads
-	if config.AllowedAliases[0] != "__ALL__" {
-		allowed := false
-
-		for _, allowedAlias := range config.AllowedAliases {
-			if strings.EqualFold(session.Alias, allowedAlias) {
-				allowed = true
-				break
-			}
-		}
-
-		if !allowed {
-			http.Error(w, "Access Denied", http.StatusForbidden)
-			sessionLogger.Warn("Not on the allowed list, access denied")
-			return
+	success := false
+	defer func() {
+		if sessions.finishUpload(session, fileID, success) {
+			sessionComplete(config, session, sessionCompleteCallback)
 		}
-	}
+	}()
 
-	// Use fileID to get the file name
-	fileEntry, ok := session.Files[fileID]
-	if !ok {
-		sessionLogger.Warn("Invalid file ID")
-		http.Error(w, "Invalid file ID", http.StatusBadRequest)
-		return
-	}
+	sessionLogger := receiveLogger.With(slog.String("Alias", session.Alias))
 	fileName := fileEntry.FileName
 
 	// Get output directory and file path
-	outputDirectory, filePath, err := getOutputFilePath(config, session.Alias, fileName)
+	outputDirectory, filePath, err := getOutputFilePath(config, session.Subdirectory, fileName)
 	if err != nil {
 		slog.Error("Failed to get absolute output directory: " + err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
 	transferLogger := sessionLogger.With(
@@ -201,19 +244,63 @@ func ReceiveHandler(config model.LocalSendConfig, message model.BroadcastMessage
 		return
 	}
 
-	// Create a file
-	file, err := os.Create(filePath)
+	// the file is received next to its final path and only moved into place
+	// once it has been verified, so that an interrupted or corrupted upload
+	// never leaves a file that looks complete
+	tempPath := filePath + uploadSuffix
+
+	file, err := os.Create(tempPath)
 	if err != nil {
 		http.Error(w, "Failed to create file", http.StatusInternalServerError)
 		transferLogger.Warn("Error creating file: " + err.Error())
 		return
 	}
-	defer file.Close()
+	defer func() {
+		file.Close()
+
+		if !success {
+			os.Remove(tempPath)
+		}
+	}()
+
+	hasher := sha256.New()
+	writer := io.MultiWriter(file, hasher)
+	var received int64
+
+	// a sender that stops sending is cut off once nothing has been received
+	// for session_timeout seconds, so that it doesn't hold the session forever.
+	// Cancelling the session interrupts a read that is waiting on the sender
+	inactivityTimeout := time.Duration(config.SessionTimeout) * time.Second
+	responseController := http.NewResponseController(w)
+	stopInterrupt := context.AfterFunc(session.ctx, func() {
+		responseController.SetReadDeadline(time.Now())
+	})
+	defer stopInterrupt()
 
 	buffer := make([]byte, 2*1024*1024) // 2MB buffer
 	for {
+		// the deadline is set before checking the session, so a cancellation
+		// in between still interrupts the read
+		if inactivityTimeout > 0 {
+			responseController.SetReadDeadline(time.Now().Add(inactivityTimeout))
+		}
+
+		if session.ctx.Err() != nil {
+			err := context.Cause(session.ctx)
+			http.Error(w, err.Error(), statusForError(err))
+			transferLogger.Warn("Stopped receiving file: " + err.Error())
+			return
+		}
+
 		n, err := r.Body.Read(buffer)
 		if err != nil && err != io.EOF {
+			if session.ctx.Err() != nil {
+				err = context.Cause(session.ctx)
+				http.Error(w, err.Error(), statusForError(err))
+				transferLogger.Warn("Stopped receiving file: " + err.Error())
+				return
+			}
+
 			http.Error(w, "Failed to read file", http.StatusInternalServerError)
 			transferLogger.Warn("Error reading file: " + err.Error())
 			return
@@ -222,32 +309,121 @@ func ReceiveHandler(config model.LocalSendConfig, message model.BroadcastMessage
 			break
 		}
 
-		_, err = file.Write(buffer[:n])
+		_, err = writer.Write(buffer[:n])
 		if err != nil {
 			http.Error(w, "Failed to write file", http.StatusInternalServerError)
 			transferLogger.Warn("Error writing file: " + err.Error())
 			return
 
 		}
+		received += int64(n)
+
+		// don't let a sender write more than it asked to send
+		if received > fileEntry.Size {
+			break
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		http.Error(w, "Failed to write file", http.StatusInternalServerError)
+		transferLogger.Warn("Error writing file: " + err.Error())
+		return
+	}
+
+	if received != fileEntry.Size {
+		http.Error(w, fmt.Sprintf("Expected %d bytes but received %d", fileEntry.Size, received), http.StatusUnprocessableEntity)
+		transferLogger.Warn(fmt.Sprintf("File failed verification, expected %d bytes but received %d", fileEntry.Size, received))
+		return
 	}
 
-	session.CompletedFiles += 1
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if fileEntry.SHA256 != "" && !strings.EqualFold(fileEntry.SHA256, hash) {
+		http.Error(w, "Checksum mismatch", http.StatusUnprocessableEntity)
+		transferLogger.Warn(fmt.Sprintf("File failed verification, expected hash %s but got %s", fileEntry.SHA256, hash))
+
+		notify.Notify(notify.Event{
+			Type:    notify.EventChecksumMismatch,
+			Title:   "Checksum mismatch during localsend transfer",
+			Message: fmt.Sprintf("'%s' from '%s' failed verification, expected hash %s but got %s", fileName, session.Alias, fileEntry.SHA256, hash),
+			Data: map[string]any{
+				"alias": session.Alias,
+				"path":  filePath,
+			},
+		})
+		return
+	}
+
+	if err := os.Rename(tempPath, filePath); err != nil {
+		http.Error(w, "Failed to move file into place", http.StatusInternalServerError)
+		transferLogger.Warn("Error moving file into place: " + err.Error())
+		return
+	}
+
+	success = true
+
+	transferLogger.Info("Finished receiving file", slog.String("SHA256", hash))
+	w.WriteHeader(http.StatusOK)
+}
+
+// CancelHandler ends the active session at the request of its sender
+func CancelHandler(config model.LocalSendConfig, message model.BroadcastMessage, w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("sessionId")
+
+	if err := sessions.cancelSession(sessionID, getSenderIP(r)); err != nil {
+		slog.Warn("Refusing to cancel session: "+err.Error(), slog.String("SessionID", sessionID))
+		http.Error(w, err.Error(), statusForError(err))
+		return
+	}
 
-	transferLogger.Info("Finished receiving file")
 	w.WriteHeader(http.StatusOK)
+}
+
+//
+// private functions
+//
+
+// existingFileMatches checks the hash of a file that was already received
+// against the hash provided by the sender. Without a hash, a file of the same
+// size is assumed to be the same file
+func existingFileMatches(filePath string, fileInfo model.FileInfo) bool {
+	if fileInfo.SHA256 == "" {
+		return true
+	}
 
-	if session.CompletedFiles == session.TotalFiles {
-		slog.Info(
-			fmt.Sprintf("Transfer session complete, transferred %d file(s)", session.CompletedFiles),
-			slog.String("SessionID", sessionID),
-			slog.String("FilesTransferred", fmt.Sprintf("%d", session.CompletedFiles)),
-		)
+	hash, err := util.HashFile(filePath)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to hash existing file '%s': %s", filePath, err.Error()))
+		return false
+	}
 
-		// remove the session from memory
-		delete(sessions, sessionID)
+	return strings.EqualFold(fileInfo.SHA256, hash)
+}
 
-		// the session has finished, execute the callback
-		sessionCompleteCallback(outputDirectory)
+// sessionComplete is called once every file of the session has been received
+func sessionComplete(config model.LocalSendConfig, session *session, sessionCompleteCallback func(string)) {
+	outputDirectory, _, err := getOutputFilePath(config, session.Subdirectory, "")
+	if err != nil {
+		slog.Error("Failed to get absolute output directory: " + err.Error())
+		return
 	}
 
+	slog.Info(
+		fmt.Sprintf("Transfer session complete, transferred %d file(s)", session.CompletedFiles),
+		slog.String("SessionID", session.ID),
+		slog.String("FilesTransferred", fmt.Sprintf("%d", session.CompletedFiles)),
+	)
+
+	notify.Notify(notify.Event{
+		Type:    notify.EventLocalSendCompleted,
+		Title:   "LocalSend transfer received",
+		Message: fmt.Sprintf("Received %d file(s) from '%s' into '%s'", session.CompletedFiles, session.Alias, outputDirectory),
+		Data: map[string]any{
+			"alias":            session.Alias,
+			"files":            session.CompletedFiles,
+			"output_directory": outputDirectory,
+		},
+	})
+
+	// the session has finished, execute the callback
+	sessionCompleteCallback(outputDirectory)
 }
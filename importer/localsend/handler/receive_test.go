@@ -0,0 +1,413 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package handler
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ccmm/model"
+)
+
+// The tests below act as a LocalSend sender against the receive handlers, to
+// check the responses that senders rely on
+
+func newTestLocalSendConfig(t *testing.T) model.LocalSendConfig {
+	config := model.DefaultImporterConfig.LocalSend
+	config.Alias = "ccmm"
+	config.StoragePath = t.TempDir()
+	config.FreeSpaceReserveMB = 0
+	config.UnknownSenders = model.LocalSendAllow
+	config.Senders = []model.LocalSendSender{}
+
+	return config
+}
+
+// newTestReceiver starts the receive handlers with fresh session, PIN and
+// approval state. Completed sessions are reported on the returned channel
+func newTestReceiver(t *testing.T, config model.LocalSendConfig, useTLS bool) (*httptest.Server, chan string) {
+	t.Helper()
+
+	sessions = &sessionManager{}
+	pins = &pinLimiter{failures: make(map[string]*pinFailures)}
+	approvals = &approvalManager{pending: make(map[string]*pendingSender)}
+
+	completed := make(chan string, 10)
+	message := model.BroadcastMessage{Alias: config.Alias, Version: "2.0"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/localsend/v2/prepare-upload", func(w http.ResponseWriter, r *http.Request) {
+		PrepareReceive(config, message, w, r)
+	})
+	mux.HandleFunc("/api/localsend/v2/upload", func(w http.ResponseWriter, r *http.Request) {
+		ReceiveHandler(config, message, w, r, func(outputDirectory string) { completed <- outputDirectory })
+	})
+	mux.HandleFunc("/api/localsend/v2/cancel", func(w http.ResponseWriter, r *http.Request) {
+		CancelHandler(config, message, w, r)
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	if useTLS {
+		server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+
+	return server, completed
+}
+
+// fakeSender is a minimal LocalSend client
+type fakeSender struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+	info   model.Info
+}
+
+func newFakeSender(t *testing.T, server *httptest.Server, alias string) *fakeSender {
+	return &fakeSender{
+		t:      t,
+		server: server,
+		client: server.Client(),
+		info:   model.Info{Alias: alias, Version: "2.0", Fingerprint: alias + "-fingerprint", Protocol: "http"},
+	}
+}
+
+// newClientCertificate returns a self-signed certificate for a sender to
+// present, along with its fingerprint
+func newClientCertificate(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := sha256.Sum256(raw)
+	return tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: key}, hex.EncodeToString(fingerprint[:])
+}
+
+// withCertificate makes the sender present the certificate
+func (fs *fakeSender) withCertificate(certificate tls.Certificate) {
+	transport := fs.server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	fs.client = &http.Client{Transport: transport}
+}
+
+func (fs *fakeSender) prepare(files map[string]string) (int, model.PrepareReceiveResponse) {
+	fs.t.Helper()
+
+	request := model.PrepareReceiveRequest{Info: fs.info, Files: make(map[string]model.FileInfo)}
+	for fileName, content := range files {
+		hash := sha256.Sum256([]byte(content))
+		request.Files[fileName+"-id"] = model.FileInfo{
+			ID:       fileName + "-id",
+			FileName: fileName,
+			Size:     int64(len(content)),
+			FileType: "video/mp4",
+			SHA256:   hex.EncodeToString(hash[:]),
+		}
+	}
+
+	body, _ := json.Marshal(request)
+	response, err := fs.client.Post(fs.server.URL+"/api/localsend/v2/prepare-upload", "application/json", bytes.NewReader(body))
+	if err != nil {
+		fs.t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var prepared model.PrepareReceiveResponse
+	if response.StatusCode == http.StatusOK {
+		json.NewDecoder(response.Body).Decode(&prepared)
+	}
+
+	return response.StatusCode, prepared
+}
+
+func (fs *fakeSender) upload(sessionID string, fileID string, token string, body io.Reader) int {
+	fs.t.Helper()
+
+	query := url.Values{"sessionId": {sessionID}, "fileId": {fileID}, "token": {token}}
+	response, err := fs.client.Post(fs.server.URL+"/api/localsend/v2/upload?"+query.Encode(), "application/octet-stream", body)
+	if err != nil {
+		fs.t.Fatal(err)
+	}
+	response.Body.Close()
+
+	return response.StatusCode
+}
+
+func (fs *fakeSender) cancel(sessionID string) int {
+	fs.t.Helper()
+
+	response, err := fs.client.Post(fs.server.URL+"/api/localsend/v2/cancel?sessionId="+url.QueryEscape(sessionID), "", nil)
+	if err != nil {
+		fs.t.Fatal(err)
+	}
+	response.Body.Close()
+
+	return response.StatusCode
+}
+
+func TestLocalSendPrepareAndUpload(t *testing.T) {
+	config := newTestLocalSendConfig(t)
+	server, completed := newTestReceiver(t, config, false)
+	sender := newFakeSender(t, server, "phone")
+
+	files := map[string]string{"IMG_0001.MOV": "first video", "IMG_0002.MOV": "second video"}
+
+	statusCode, prepared := sender.prepare(files)
+	if statusCode != http.StatusOK || prepared.SessionID == "" || len(prepared.Files) != 2 {
+		t.Fatalf("unexpected prepare-upload response %d %+v", statusCode, prepared)
+	}
+
+	for fileName, content := range files {
+		fileID := fileName + "-id"
+		if statusCode := sender.upload(prepared.SessionID, fileID, prepared.Files[fileID], bytes.NewBufferString(content)); statusCode != http.StatusOK {
+			t.Fatalf("upload of '%s' returned %d", fileName, statusCode)
+		}
+
+		// the same file can't be uploaded twice
+		if statusCode := sender.upload(prepared.SessionID, fileID, prepared.Files[fileID], bytes.NewBufferString(content)); statusCode != http.StatusConflict && statusCode != http.StatusForbidden {
+			t.Errorf("second upload of '%s' returned %d", fileName, statusCode)
+		}
+	}
+
+	select {
+	case outputDirectory := <-completed:
+		if outputDirectory != filepath.Join(config.StoragePath, "phone") {
+			t.Errorf("unexpected output directory '%s'", outputDirectory)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not completed")
+	}
+
+	for fileName, content := range files {
+		received, err := os.ReadFile(filepath.Join(config.StoragePath, "phone", fileName))
+		if err != nil || string(received) != content {
+			t.Errorf("unexpected content of '%s': '%s' %v", fileName, received, err)
+		}
+	}
+
+	// files that were already received are skipped
+	if statusCode, _ := sender.prepare(files); statusCode != http.StatusNoContent {
+		t.Errorf("expected 204 for files already received, got %d", statusCode)
+	}
+}
+
+func TestLocalSendUploadRequiresSessionToken(t *testing.T) {
+	server, _ := newTestReceiver(t, newTestLocalSendConfig(t), false)
+	sender := newFakeSender(t, server, "phone")
+
+	_, prepared := sender.prepare(map[string]string{"IMG_0001.MOV": "video"})
+
+	tests := []struct {
+		name      string
+		sessionID string
+		token     string
+		want      int
+	}{
+		{"missing token", prepared.SessionID, "", http.StatusBadRequest},
+		{"wrong token", prepared.SessionID, "not-the-token", http.StatusForbidden},
+		{"other session", "not-the-session", prepared.Files["IMG_0001.MOV-id"], http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		if statusCode := sender.upload(test.sessionID, "IMG_0001.MOV-id", test.token, bytes.NewBufferString("video")); statusCode != test.want {
+			t.Errorf("%s: expected %d, got %d", test.name, test.want, statusCode)
+		}
+	}
+}
+
+func TestLocalSendBlocksSecondSession(t *testing.T) {
+	server, _ := newTestReceiver(t, newTestLocalSendConfig(t), false)
+
+	if statusCode, _ := newFakeSender(t, server, "phone").prepare(map[string]string{"IMG_0001.MOV": "video"}); statusCode != http.StatusOK {
+		t.Fatalf("first prepare-upload returned %d", statusCode)
+	}
+
+	if statusCode, _ := newFakeSender(t, server, "tablet").prepare(map[string]string{"IMG_0002.MOV": "video"}); statusCode != http.StatusConflict {
+		t.Errorf("expected 409 while another session is active, got %d", statusCode)
+	}
+}
+
+func TestLocalSendCancel(t *testing.T) {
+	server, _ := newTestReceiver(t, newTestLocalSendConfig(t), false)
+	sender := newFakeSender(t, server, "phone")
+
+	_, prepared := sender.prepare(map[string]string{"IMG_0001.MOV": "video"})
+
+	if statusCode := sender.cancel("not-the-session"); statusCode != http.StatusForbidden {
+		t.Errorf("expected 403 cancelling another session, got %d", statusCode)
+	}
+	if statusCode := sender.cancel(prepared.SessionID); statusCode != http.StatusOK {
+		t.Fatalf("cancel returned %d", statusCode)
+	}
+
+	if statusCode := sender.upload(prepared.SessionID, "IMG_0001.MOV-id", prepared.Files["IMG_0001.MOV-id"], bytes.NewBufferString("video")); statusCode != http.StatusForbidden {
+		t.Errorf("expected 403 uploading to a cancelled session, got %d", statusCode)
+	}
+
+	if statusCode, _ := newFakeSender(t, server, "tablet").prepare(map[string]string{"IMG_0002.MOV": "video"}); statusCode != http.StatusOK {
+		t.Errorf("expected a new session once cancelled, got %d", statusCode)
+	}
+}
+
+func TestLocalSendStalledUploadReleasesSession(t *testing.T) {
+	config := newTestLocalSendConfig(t)
+	config.SessionTimeout = 1
+
+	server, _ := newTestReceiver(t, config, false)
+	sender := newFakeSender(t, server, "phone")
+
+	_, prepared := sender.prepare(map[string]string{"IMG_0001.MOV": "0123456789"})
+
+	// the sender sends part of the file, then stops
+	bodyReader, bodyWriter := io.Pipe()
+	defer bodyWriter.Close()
+	go bodyWriter.Write([]byte("0123"))
+
+	done := make(chan int, 1)
+	go func() {
+		done <- sender.upload(prepared.SessionID, "IMG_0001.MOV-id", prepared.Files["IMG_0001.MOV-id"], bodyReader)
+	}()
+
+	select {
+	case statusCode := <-done:
+		if statusCode == http.StatusOK {
+			t.Errorf("expected the stalled upload to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled upload was never cut off")
+	}
+
+	// once nothing was received for the session timeout, the session is over
+	time.Sleep(time.Duration(config.SessionTimeout)*time.Second + 200*time.Millisecond)
+
+	if statusCode, _ := newFakeSender(t, server, "tablet").prepare(map[string]string{"IMG_0002.MOV": "video"}); statusCode != http.StatusOK {
+		t.Errorf("expected a new session once the stalled one expired, got %d", statusCode)
+	}
+}
+
+func TestLocalSendFingerprintFromCertificate(t *testing.T) {
+	trustedCertificate, trustedFingerprint := newClientCertificate(t)
+	otherCertificate, _ := newClientCertificate(t)
+
+	config := newTestLocalSendConfig(t)
+	config.UnknownSenders = model.LocalSendDeny
+	config.Senders = []model.LocalSendSender{{Fingerprint: trustedFingerprint}}
+
+	tests := []struct {
+		name        string
+		certificate tls.Certificate
+		claimed     string
+		want        int
+	}{
+		{"certificate matches the rule", trustedCertificate, "something-else", http.StatusOK},
+		{"claimed fingerprint is ignored", otherCertificate, trustedFingerprint, http.StatusForbidden},
+		{"claimed fingerprint without a certificate", tls.Certificate{}, trustedFingerprint, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newTestReceiver(t, config, true)
+
+			sender := newFakeSender(t, server, "phone")
+			if test.certificate.Certificate != nil {
+				sender.withCertificate(test.certificate)
+			}
+			sender.info.Fingerprint = test.claimed
+
+			if statusCode, _ := sender.prepare(map[string]string{"IMG_0001.MOV": "video"}); statusCode != test.want {
+				t.Errorf("expected %d, got %d", test.want, statusCode)
+			}
+		})
+	}
+}
+
+func TestLocalSendClaimedFingerprintOverHttp(t *testing.T) {
+	_, trustedFingerprint := newClientCertificate(t)
+
+	// plain http can't prove a fingerprint, so the sender is unknown
+	config := newTestLocalSendConfig(t)
+	config.UnknownSenders = model.LocalSendDeny
+	config.Senders = []model.LocalSendSender{{Fingerprint: trustedFingerprint, Subdirectory: "trusted"}}
+
+	server, _ := newTestReceiver(t, config, false)
+
+	sender := newFakeSender(t, server, "phone")
+	sender.info.Fingerprint = trustedFingerprint
+
+	if statusCode, _ := sender.prepare(map[string]string{"IMG_0001.MOV": "video"}); statusCode != http.StatusForbidden {
+		t.Errorf("expected the sender to be refused, got %d", statusCode)
+	}
+}
+
+func TestLocalSendSessionTimeoutDisabled(t *testing.T) {
+	config := newTestLocalSendConfig(t)
+	config.SessionTimeout = 0
+
+	server, _ := newTestReceiver(t, config, false)
+	sender := newFakeSender(t, server, "phone")
+
+	_, prepared := sender.prepare(map[string]string{"IMG_0001.MOV": "video"})
+
+	// without a timeout, the session is still active after a pause
+	time.Sleep(100 * time.Millisecond)
+	ExpireSessions(config)
+
+	if statusCode := sender.upload(prepared.SessionID, "IMG_0001.MOV-id", prepared.Files["IMG_0001.MOV-id"], bytes.NewBufferString("video")); statusCode != http.StatusOK {
+		t.Errorf("expected the upload to succeed without a session timeout, got %d", statusCode)
+	}
+}
@@ -0,0 +1,249 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"ccmm/model"
+)
+
+// Errors returned by the session manager, see statusForError for the HTTP
+// status that the LocalSend protocol expects for each of them
+var (
+	errSessionBlocked = errors.New("blocked by another session")
+	errInvalidSession = errors.New("invalid session")
+	errWrongSender    = errors.New("request is not from the sender of the session")
+	errInvalidToken   = errors.New("invalid file ID or token")
+	errFileReceiving  = errors.New("file is already being received")
+	errFileReceived   = errors.New("file was already received")
+	errCancelled      = errors.New("session was cancelled")
+)
+
+// session is a single transfer from a sender. Only one session can be active
+// at a time, as required by the LocalSend protocol
+type session struct {
+	model.ReceiveSession
+
+	ID       string
+	SenderIP string
+
+	// Subdirectory of the storage path that the files are placed in
+	Subdirectory string
+
+	// Tokens maps each file ID to the token that the sender must provide when
+	// uploading that file
+	Tokens map[string]string
+
+	receiving    map[string]bool
+	received     map[string]bool
+	lastActivity time.Time
+
+	// ctx is cancelled when the session is cancelled or expires, which aborts
+	// the uploads that are in progress
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// sessionManager keeps track of the active session. It is safe to use from
+// concurrent handlers
+type sessionManager struct {
+	mutex  sync.Mutex
+	active *session
+}
+
+var sessions = &sessionManager{}
+
+func newSession(alias string, senderIP string) *session {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	return &session{
+		ReceiveSession: model.ReceiveSession{
+			Alias: alias,
+			Files: make(map[string]model.FileInfo),
+		},
+		SenderIP:  senderIP,
+		Tokens:    make(map[string]string),
+		receiving: make(map[string]bool),
+		received:  make(map[string]bool),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// ExpireSessions ends the active session if it has been abandoned by its
+// sender, meaning no file has been received for config.SessionTimeout seconds.
+// Sessions never expire if config.SessionTimeout is 0
+func ExpireSessions(config model.LocalSendConfig) {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	sessions.expire(config)
+}
+
+//
+// private functions
+//
+
+// start makes the provided session the active one, unless another session is
+// still active
+func (sm *sessionManager) start(config model.LocalSendConfig, s *session) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.expire(config)
+	if sm.active != nil {
+		return errSessionBlocked
+	}
+
+	s.lastActivity = time.Now()
+	sm.active = s
+
+	return nil
+}
+
+// beginUpload checks that the upload belongs to the active session and marks
+// the file as being received. finishUpload must be called once the upload is over
+func (sm *sessionManager) beginUpload(config model.LocalSendConfig, sessionID string, fileID string, token string, senderIP string) (*session, model.FileInfo, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.expire(config)
+
+	s := sm.active
+	switch {
+	case s == nil:
+		return nil, model.FileInfo{}, errInvalidSession
+	case s.ID != sessionID:
+		return nil, model.FileInfo{}, errSessionBlocked
+	case s.SenderIP != senderIP:
+		return nil, model.FileInfo{}, errWrongSender
+	}
+
+	fileInfo, ok := s.Files[fileID]
+	if !ok || s.Tokens[fileID] != token {
+		return nil, model.FileInfo{}, errInvalidToken
+	}
+	if s.received[fileID] {
+		return nil, model.FileInfo{}, errFileReceived
+	}
+	if s.receiving[fileID] {
+		return nil, model.FileInfo{}, errFileReceiving
+	}
+
+	s.receiving[fileID] = true
+	s.lastActivity = time.Now()
+
+	return s, fileInfo, nil
+}
+
+// finishUpload records the outcome of an upload. true is returned if every
+// file of the session has now been received, in which case the session is over
+func (sm *sessionManager) finishUpload(s *session, fileID string, success bool) bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	delete(s.receiving, fileID)
+	s.lastActivity = time.Now()
+
+	if !success {
+		return false
+	}
+
+	s.received[fileID] = true
+	s.CompletedFiles = len(s.received)
+
+	if s.CompletedFiles < s.TotalFiles || sm.active != s {
+		return false
+	}
+
+	sm.active = nil
+	s.cancel(nil)
+
+	return true
+}
+
+// cancelSession ends the active session, aborting the uploads in progress. If
+// sessionID is empty, the active session is cancelled as long as it belongs
+// to the sender
+func (sm *sessionManager) cancelSession(sessionID string, senderIP string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	s := sm.active
+	switch {
+	case s == nil || (sessionID != "" && s.ID != sessionID):
+		return errInvalidSession
+	case s.SenderIP != senderIP:
+		return errWrongSender
+	}
+
+	slog.Info(fmt.Sprintf("Transfer session cancelled by '%s' after %d of %d file(s)", s.Alias, s.CompletedFiles, s.TotalFiles),
+		slog.String("SessionID", s.ID))
+
+	sm.active = nil
+	s.cancel(errCancelled)
+
+	return nil
+}
+
+// expire must only be called while holding the mutex
+func (sm *sessionManager) expire(config model.LocalSendConfig) {
+	s := sm.active
+	if s == nil || len(s.receiving) > 0 {
+		return
+	}
+
+	// like the inactivity timeout of uploads, 0 disables the timeout
+	timeout := time.Duration(config.SessionTimeout) * time.Second
+	if timeout <= 0 || time.Since(s.lastActivity) < timeout {
+		return
+	}
+
+	slog.Warn(fmt.Sprintf("Transfer session from '%s' abandoned after %d of %d file(s), nothing received for %s", s.Alias, s.CompletedFiles, s.TotalFiles, timeout),
+		slog.String("SessionID", s.ID))
+
+	sm.active = nil
+	s.cancel(errors.New("session expired"))
+}
+
+// statusForError returns the HTTP status the LocalSend protocol expects for
+// the provided session error
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, errSessionBlocked), errors.Is(err, errFileReceiving), errors.Is(err, errFileReceived), errors.Is(err, errCancelled):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidSession), errors.Is(err, errWrongSender), errors.Is(err, errInvalidToken):
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
@@ -24,14 +24,31 @@ import (
 	"ccmm/importer/localsend/discovery"
 	"ccmm/importer/localsend/handler"
 	"ccmm/model"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
 	"runtime"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// sessionCleanupInterval is how often to check for sessions that were abandoned
+	sessionCleanupInterval = 10 * time.Second
+
+	// readHeaderTimeout limits how long a sender may take to send the headers
+	// of a request. There is no limit on the whole request because uploads of
+	// large files take a while, instead the upload handler cuts off a sender
+	// that stops sending (see session_timeout)
+	readHeaderTimeout = 10 * time.Second
+
+	// idleTimeout is how long a keep-alive connection may sit between requests
+	idleTimeout = 60 * time.Second
+)
+
 func RunServer(config model.LocalSendConfig, sessionCompleteCallback func(string)) {
 	message := model.BroadcastMessage{
 		Alias:       config.Alias,
@@ -45,9 +62,36 @@ func RunServer(config model.LocalSendConfig, sessionCompleteCallback func(string
 		Announce:    true,
 	}
 
+	// with https, clients identify the server by the fingerprint of its certificate
+	var tlsConfig *tls.Config
+	if config.Https {
+		certificate, fingerprint, err := loadOrCreateCertificate(config.TlsDirectory, config.Alias)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to load localsend TLS certificate from '%s': %s", config.TlsDirectory, err.Error()))
+			os.Exit(1)
+		}
+
+		// ask senders for their certificate so sender rules can match on the
+		// fingerprint it proves rather than the one the sender claims
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientAuth:   tls.RequestClientCert,
+		}
+		message.Protocol = "https"
+		message.Fingerprint = fingerprint
+	}
+
 	// Enable broadcast and monitoring functions
 	go discovery.StartBroadcastUDP(config, message)
 	go discovery.StartBroadcastHTTP(config, message)
+	go discovery.ListenForAnnouncements(config, message)
+
+	// clean up sessions abandoned by their sender
+	go func() {
+		for range time.Tick(sessionCleanupInterval) {
+			handler.ExpireSessions(config)
+		}
+	}()
 
 	// Start HTTP Server
 	httpServer := http.NewServeMux()
@@ -59,6 +103,9 @@ func RunServer(config model.LocalSendConfig, sessionCompleteCallback func(string
 	httpServer.HandleFunc("/api/localsend/v2/upload", func(w http.ResponseWriter, r *http.Request) {
 		handler.ReceiveHandler(config, message, w, r, sessionCompleteCallback)
 	})
+	httpServer.HandleFunc("/api/localsend/v2/cancel", func(w http.ResponseWriter, r *http.Request) {
+		handler.CancelHandler(config, message, w, r)
+	})
 	httpServer.HandleFunc("/api/localsend/v2/info", func(w http.ResponseWriter, r *http.Request) {
 		handler.RegisterHandler(config, message, w, r)
 	})
@@ -67,11 +114,29 @@ func RunServer(config model.LocalSendConfig, sessionCompleteCallback func(string
 	})
 
 	go func() {
-		slog.Info(fmt.Sprintf("Started localsend server '%s' on %s:%d", config.Alias, config.ListenAddress, config.ListenPort))
+		slog.Info(fmt.Sprintf("Started localsend server '%s' on %s://%s:%d", config.Alias, message.Protocol, config.ListenAddress, config.ListenPort))
+		if config.Https {
+			slog.Info("Localsend certificate fingerprint: " + message.Fingerprint)
+		}
 
 		slog.Info("Configured localsend upload directory: " + config.StoragePath)
 
-		if err := http.ListenAndServe(fmt.Sprintf("%s:%d", config.ListenAddress, config.ListenPort), httpServer); err != nil {
+		server := &http.Server{
+			Addr:              fmt.Sprintf("%s:%d", config.ListenAddress, config.ListenPort),
+			Handler:           httpServer,
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: readHeaderTimeout,
+			IdleTimeout:       idleTimeout,
+		}
+
+		var err error
+		if config.Https {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if err != nil {
 			slog.Error(fmt.Sprintf("Localsend Server '%s' failed: %v", config.Alias, err))
 			return
 		}
@@ -25,10 +25,11 @@
 package behringerX32
 
 import (
+	"context"
 	// "encoding/xml"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"regexp"
 	"strings"
@@ -49,73 +50,69 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	volumeFormat string
+	volume model.Volume
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "behringerX32"))
 
-	return &Processor{
-		sourceDir: sourceDir,
-	}
+	return &Processor{}
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// verify volume label matches what is expected
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", t.sourceDir))
-	if label := util.GetVolumeName(t.sourceDir); !strings.HasPrefix(label, expectedVolumeName) {
-		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not start with required '%s' value, disqualified", label, expectedVolumeName))
-		return false
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+	if !strings.HasPrefix(volume.Label, expectedVolumeName) {
+		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not start with required '%s' value, disqualified", volume.Label, expectedVolumeName))
+		return model.Detection{Reason: fmt.Sprintf("volume label '%s' does not start with '%s'", volume.Label, expectedVolumeName)}, nil
 	}
 
 	// check for recorded audio files
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of '%s' file in volume '%s'", fileMatchPatterns[0], t.sourceDir))
-	exists, _ := util.RequireRegexFileMatch(t.sourceDir, fileMatchPatterns[0])
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of '%s' file in volume '%s'", fileMatchPatterns[0], volume.Path))
+	exists, _ := util.RequireRegexFileMatch(volume.FS, ".", fileMatchPatterns[0])
 	if !exists {
 		logger.Debug(fmt.Sprintf("[CheckSource]: No '%s' file found, disqualified", fileMatchPatterns[0]))
-		return false
+		return model.Detection{Reason: "no R_yyyymmdd-hhmmss.wav recording"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "volume label and recordings match"}, nil
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
-	return t.scanDirectory(t.sourceDir, "")
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+	return t.scanDirectory(ctx, volume.Path, ".")
 }
 
 // private functions
 
-func getCaptureDate(fileName string) time.Time {
-	datePart := fileName[2:10]
-
-	zone, _ := time.Now().Zone()
-	dtmStr := fmt.Sprintf("%s %s", datePart, zone)
-	dtm, err := time.Parse("20060102 MST", dtmStr)
+func (t *Processor) getCaptureTime(fileName string) time.Time {
+	dtmStr := fileName[2:17]
+	dtm, err := util.ParseWallClock("20060102-150405", dtmStr, t.volume.TimeLocation())
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[getCaptureDate]: Failed to parse date '%s': %s", dtmStr, err.Error()))
+		logger.Error(fmt.Sprintf("[getCaptureTime]: Failed to parse date '%s': %s", dtmStr, err.Error()))
 	}
 
 	return dtm
 }
 
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
 	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
 	var files []model.SourceFile
 
 	// For this processor, we only care about .wav files
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	for _, entry := range entries {
@@ -135,7 +132,10 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 			if foundMatch {
 				logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
 
 				if stat.Size() == 0 {
 					logger.Info(fmt.Sprintf("[scanDirectory]: Skipping 0 byte file '%s'", fullPath))
@@ -148,9 +148,9 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 					MediaType:    mediaType,
 					Size:         stat.Size(),
 					SourceName:   "X32",
-					CaptureDate:  getCaptureDate(entry.Name()),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					CaptureTime:  t.getCaptureTime(entry.Name()),
+					FileModTime:  util.VolumeModTime(t.volume, stat.ModTime()),
+					VolumeFormat: t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -158,5 +158,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
@@ -25,15 +25,15 @@
 package behringerXLIVE
 
 import (
+	"context"
 	// "encoding/xml"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"ccmm/model"
 	"ccmm/util"
@@ -51,17 +51,14 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	volumeFormat string
-	fileRegexes  []regexp.Regexp
+	volume      model.Volume
+	fileRegexes []regexp.Regexp
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "behringerXLIVE"))
 
-	processor := &Processor{
-		sourceDir: sourceDir,
-	}
+	processor := &Processor{}
 
 	for _, pattern := range fileMatchPatterns {
 		regexC, err := regexp.Compile(pattern)
@@ -77,72 +74,63 @@ func New(sourceDir string) *Processor {
 	return processor
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// verify volume label matches what is expected
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", t.sourceDir))
-	if label := util.GetVolumeName(t.sourceDir); !strings.HasPrefix(label, expectedVolumeName) {
-		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not start with required '%s' value, disqualified", label, expectedVolumeName))
-		return false
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+	if !strings.HasPrefix(volume.Label, expectedVolumeName) {
+		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not start with required '%s' value, disqualified", volume.Label, expectedVolumeName))
+		return model.Detection{Reason: fmt.Sprintf("volume label '%s' does not start with '%s'", volume.Label, expectedVolumeName)}, nil
 	}
 
 	// check for /X_LIVE directory
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
-	if !util.RequireDirs(t.sourceDir, []string{"X_LIVE"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
+	if !util.RequireDirs(volume.FS, ".", []string{"X_LIVE"}) {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no X_LIVE directory"}, nil
 	}
 
 	// check for recorded audio files
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of '%s' file in volume '%s'", fileMatchPatterns[0], t.sourceDir))
-	exists, subDir := util.RequireRegexDirMatch(path.Join(t.sourceDir, "X_LIVE"), `[A-Z|0-9]{8}`)
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of '%s' file in volume '%s'", fileMatchPatterns[0], volume.Path))
+	exists, subDir := util.RequireRegexDirMatch(volume.FS, "X_LIVE", `[A-Z|0-9]{8}`)
 	if !exists {
 		logger.Debug("[CheckSource]: No directory found matching regex '[A-Z|0-9]{8}', disqualified")
-		return false
+		return model.Detection{Reason: "no X_LIVE/XXXXXXXX session directory"}, nil
 	}
 
 	// check for X_LIVE/[A-Z|0-9]{8}/SE_LOG.BIN file
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of X_LIVE/XXXXXXXX/SE_LOG.BIN file in volume '%s'", t.sourceDir))
-	if !util.RequireFiles(subDir, []string{"SE_LOG.BIN"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of X_LIVE/XXXXXXXX/SE_LOG.BIN file in volume '%s'", volume.Path))
+	if !util.RequireFiles(volume.FS, subDir, []string{"SE_LOG.BIN"}) {
 		logger.Debug("[CheckSource]: No '/X_LIVE/XXXXXXXX/SE_LOG.BIN' file found, disqualified")
-		return false
+		return model.Detection{Reason: "no X_LIVE/XXXXXXXX/SE_LOG.BIN session log"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "volume label and session directories match"}, nil
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
-	return t.scanDirectory(path.Join(t.sourceDir, "X_LIVE"), "X_LIVE")
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+	return t.scanDirectory(ctx, path.Join(volume.Path, "X_LIVE"), "X_LIVE")
 }
 
 // private functions
 
-func (t *Processor) getCaptureDate(dtm time.Time) time.Time {
-	format := "2006-01-02 MST"
-	date, err := time.Parse(format, dtm.Format(format))
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
+	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to parse date, error: %s", err.Error()))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
 
-	return date
-}
-
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
-	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
-
 	var files []model.SourceFile
 
 	// For this processor, we only care about .wav files
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	for _, entry := range entries {
@@ -150,7 +138,12 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		relativePath := path.Join(relativeDirPath, entry.Name())
 
 		if entry.IsDir() {
-			files = append(files, t.scanDirectory(fullPath, path.Join(relativeDirPath, entry.Name()))...)
+			subdirFiles, err := t.scanDirectory(ctx, fullPath, path.Join(relativeDirPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, subdirFiles...)
 		} else {
 			foundMatch := false
 
@@ -165,7 +158,14 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 			if foundMatch {
 				logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
+
+				// the time of the recording isn't kept anywhere else, but the
+				// device sets the modification time from its own clock
+				modTime := util.VolumeModTime(t.volume, stat.ModTime())
 
 				if stat.Size() == 0 {
 					logger.Info(fmt.Sprintf("[scanDirectory]: Skipping 0 byte file '%s'", fullPath))
@@ -180,9 +180,9 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 					MediaType:    mediaType,
 					Size:         stat.Size(),
 					SourceName:   "X-Live",
-					CaptureDate:  t.getCaptureDate(stat.ModTime()),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					CaptureTime:  modTime,
+					FileModTime:  modTime,
+					VolumeFormat: t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -190,5 +190,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
@@ -25,9 +25,10 @@
 package blackmagicIOS
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -44,77 +45,83 @@ var (
 )
 
 type Processor struct {
-	sourceDir string
+	volume model.Volume
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "blackmagicIOS"))
 
-	return &Processor{
-		sourceDir: sourceDir,
-	}
+	return &Processor{}
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// check for DCIM/EOSMISC/Mxxxx.CTG file
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of '%s' file in volume '%s'", fileMatchPattern, t.sourceDir))
-	exists, foundFile := util.RequireRegexFileMatch(t.sourceDir, fileMatchPattern)
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of '%s' file in volume '%s'", fileMatchPattern, volume.Path))
+	exists, foundFile := util.RequireRegexFileMatch(volume.FS, ".", fileMatchPattern)
 	if !exists {
 		logger.Debug(fmt.Sprintf("[CheckSource]: No '%s' file found, disqualified", fileMatchPattern))
-		return false
+		return model.Detection{Reason: fmt.Sprintf("no %s recording", fileMatchPattern)}, nil
+	}
+
+	// the metadata is read by mediainfo, which needs a real file
+	if volume.Archive {
+		logger.Debug("[CheckSource]: Recording metadata can't be read from an archive, disqualified")
+		return model.Detection{Reason: "recording metadata can't be read from an archived volume"}, nil
 	}
 
-	modelName := util.MediaInfo_GetGeneralParameter(foundFile, "com.apple.quicktime.software")
+	modelName := util.MediaInfo_GetGeneralParameter(path.Join(volume.Path, foundFile), "com.apple.quicktime.software")
 
 	if !strings.HasPrefix(modelName, "Blackmagic Cam") {
 		logger.Debug(fmt.Sprintf("[CheckSource]: Camera model '%s' does not begin with the required 'Blackmagic Cam', disqualified", modelName))
-		return false
+		return model.Detection{Reason: fmt.Sprintf("recording made with '%s' rather than a Blackmagic Cam", modelName)}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "recording metadata names the Blackmagic Cam app"}, nil
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
-	return t.scanDirectory(t.sourceDir, "")
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+	return t.scanDirectory(ctx, volume.Path, ".")
 }
 
 //
 // private functions
 //
 
-func (t *Processor) getCaptureDate(filePath string) time.Time {
-	format := "2006-01-02 15:04:05 MST"
-	dateOnlyFormat := "2006-01-02 MST"
+func (t *Processor) getCaptureTime(filePath string) time.Time {
 	result := util.MediaInfo_GetGeneralParameter(filePath, "Encoded_Date")
 
-	dtm, err := time.Parse(format, result)
+	dtm, err := parseEncodedDate(result)
 
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to parse dtm, error: %s", err.Error()))
 	}
 
-	date, err := time.Parse(dateOnlyFormat, dtm.Local().Format(dateOnlyFormat))
-
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to parse date, error: %s", err.Error()))
-	}
+	return dtm
+}
 
-	return date
+// parseEncodedDate parses the encoded date reported by mediainfo. The encoded
+// date is UTC, unlike most devices which only know their wall clock
+func parseEncodedDate(encodedDate string) (time.Time, error) {
+	return time.Parse("2006-01-02 15:04:05 MST", encodedDate)
 }
 
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
 	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
 	var files []model.SourceFile
 
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	for _, entry := range entries {
@@ -124,7 +131,10 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		if matched, _ := regexp.MatchString(fileMatchPattern, relativePath); matched {
 			logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-			stat, _ := os.Stat(fullPath)
+			stat, err := fs.Stat(t.volume.FS, relativePath)
+			if err != nil {
+				return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+			}
 			label := filepath.Base(absoluteDirPath)
 
 			newFile := model.SourceFile{
@@ -133,13 +143,13 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 				MediaType:   "Video",
 				Size:        stat.Size(),
 				SourceName:  label,
-				CaptureDate: t.getCaptureDate(fullPath),
-				FileModTime: stat.ModTime(),
+				CaptureTime: t.getCaptureTime(fullPath),
+				FileModTime: util.VolumeModTime(t.volume, stat.ModTime()),
 			}
 
 			files = append(files, newFile)
 		}
 	}
 
-	return files
+	return files, nil
 }
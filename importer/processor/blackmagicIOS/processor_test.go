@@ -0,0 +1,96 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package blackmagicIOS
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ccmm/model"
+)
+
+func newTestVolume(archive bool, files ...string) model.Volume {
+	fsys := fstest.MapFS{}
+	for _, name := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte("data"), ModTime: time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC)}
+	}
+
+	return model.Volume{Path: "/media/iPhone", FS: fsys, Archive: archive, FsType: "NTFS", Location: time.UTC}
+}
+
+// the app is only recognized by the metadata of a recording, which needs
+// mediainfo, so only the volumes that are turned away before it is read are
+// checked here
+func TestCheckSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		archive    bool
+		files      []string
+		wantReason string
+	}{
+		{"no recording", false, []string{"IMG_0001.MOV"}, "no "},
+		{"recording in a directory", false, []string{"Blackmagic/A001_03150930_C001.mov"}, "no "},
+		{"archived volume", true, []string{"A001_03150930_C001.mov"}, "archived volume"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detection, err := New().CheckSource(context.Background(), newTestVolume(test.archive, test.files...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Confidence != model.NotDetected || !strings.Contains(detection.Reason, test.wantReason) {
+				t.Errorf("expected confidence %v because of '%s', got %v (%s)", model.NotDetected, test.wantReason, detection.Confidence, detection.Reason)
+			}
+		})
+	}
+}
+
+func TestParseEncodedDate(t *testing.T) {
+	tests := []struct {
+		encodedDate string
+		want        time.Time
+		wantErr     bool
+	}{
+		{"2024-03-16 03:30:00 UTC", time.Date(2024, 3, 16, 3, 30, 0, 0, time.UTC), false},
+		{"2024-12-31 23:59:59 UTC", time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC), false},
+		{"", time.Time{}, true},
+		{"2024-03-16", time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		captureTime, err := parseEncodedDate(test.encodedDate)
+		if (err != nil) != test.wantErr {
+			t.Errorf("'%s': expected error %t, got %v", test.encodedDate, test.wantErr, err)
+			continue
+		}
+		if !test.wantErr && !captureTime.Equal(test.want) {
+			t.Errorf("'%s': expected capture time %s, got %s", test.encodedDate, test.want, captureTime)
+		}
+	}
+}
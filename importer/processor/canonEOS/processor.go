@@ -25,9 +25,11 @@
 package canonEOS
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"regexp"
 	"strings"
@@ -51,83 +53,93 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	volumeFormat string
-	etHandle     *exiftool.Exiftool
+	volume   model.Volume
+	etHandle *exiftool.Exiftool
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "canonEOS"))
 
-	return &Processor{
-		sourceDir: sourceDir,
-	}
+	return &Processor{}
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// verify volume label matches what the camera sets
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", t.sourceDir))
-	if label := util.GetVolumeName(t.sourceDir); label != expectedVolumeName {
-		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", label, expectedVolumeName))
-		return false
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+	if volume.Label != expectedVolumeName {
+		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", volume.Label, expectedVolumeName))
+		return model.Detection{Reason: fmt.Sprintf("volume label '%s' is not '%s'", volume.Label, expectedVolumeName)}, nil
 	}
 
 	// check for /DCIM and /MISC directories
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
-	if !util.RequireDirs(t.sourceDir, []string{"DCIM", "MISC"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
+	if !util.RequireDirs(volume.FS, ".", []string{"DCIM", "MISC"}) {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no DCIM and MISC directories"}, nil
 	}
 
 	foundMiscDirAndFile := false
-	if util.DirectoryExists(path.Join(t.sourceDir, "DCIM", "EOSMISC")) {
+	if util.RequireDirs(volume.FS, "DCIM", []string{"EOSMISC"}) {
 		// check for DCIM/EOSMISC/Mxxxx.CTG file
-		logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of DCIM/EOSMISC/Mxxxx.CTG file in volume '%s'", t.sourceDir))
+		logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of DCIM/EOSMISC/Mxxxx.CTG file in volume '%s'", volume.Path))
 
-		if exists, _ := util.RequireRegexFileMatch(path.Join(t.sourceDir, "DCIM", "EOSMISC"), `M(\d+).CTG`); exists {
+		if exists, _ := util.RequireRegexFileMatch(volume.FS, "DCIM/EOSMISC", `M(\d+).CTG`); exists {
 			foundMiscDirAndFile = true
 		}
 	}
 
-	if util.DirectoryExists(path.Join(t.sourceDir, "DCIM", "CANONMSC")) {
+	if util.RequireDirs(volume.FS, "DCIM", []string{"CANONMSC"}) {
 		// check for DCIM/EOSMISC/Mxxxx.CTG file
-		logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of DCIM/CANONMSC/Mxxxx.CTG file in volume '%s'", t.sourceDir))
+		logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of DCIM/CANONMSC/Mxxxx.CTG file in volume '%s'", volume.Path))
 
-		if exists, _ := util.RequireRegexFileMatch(path.Join(t.sourceDir, "DCIM", "CANONMSC"), `M(\d+).CTG`); exists {
+		if exists, _ := util.RequireRegexFileMatch(volume.FS, "DCIM/CANONMSC", `M(\d+).CTG`); exists {
 			foundMiscDirAndFile = true
 		}
 	}
 
 	if !foundMiscDirAndFile {
 		logger.Debug("[CheckSource]: No '/DCIM/(EOSMISC|CANONMISC)/Mxxxx.CTG' file found, disqualified")
-		return false
+		return model.Detection{Reason: "no DCIM/(EOSMISC|CANONMSC)/Mxxxx.CTG catalog"}, nil
 	}
 
 	// check for DCIM/(\d+)(CANON|EOS)([A-Za-z0-9]+) directory
-	logger.Debug(fmt.Sprintf(`[CheckSource]: Testing for existence of DCIM/(\d+)(CANON|EOS)([\w\d]{0,}) directory in volume '%s'`, t.sourceDir))
-	if exists, _ := util.RequireRegexDirMatch(path.Join(t.sourceDir, "DCIM"), `(\d+)(CANON|EOS)([\w\d]{0,})`); !exists {
+	logger.Debug(fmt.Sprintf(`[CheckSource]: Testing for existence of DCIM/(\d+)(CANON|EOS)([\w\d]{0,}) directory in volume '%s'`, volume.Path))
+	if exists, _ := util.RequireRegexDirMatch(volume.FS, "DCIM", `(\d+)(CANON|EOS)([\w\d]{0,})`); !exists {
 		logger.Debug(`[CheckSource]: No '(\d+)(CANON|EOS)([\w\d]{0,})/' directory found, disqualified`)
-		return false
+		return model.Detection{Reason: "no DCIM/xxxCANON directory"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "volume label and DCIM catalog match"}, nil
+}
+
+// ControlFiles returns patterns matching the files that the device needs in
+// order to recognize the card, which is the MISC directory and the catalog directories in DCIM
+func (t *Processor) ControlFiles() []string {
+	return []string{
+		`^MISC(/|$)`,
+		`^DCIM/(EOSMISC|CANONMSC)(/|$)`,
+	}
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+
+	// the metadata is read by exiftool, which needs a real file
+	if volume.Archive {
+		return nil, &model.ProcessorError{Kind: model.ErrMetadata, Path: volume.Path, Err: errors.New("EXIF data can't be read from an archived volume")}
+	}
+
 	et, err := exiftool.NewExiftool()
 	if err != nil {
-		fmt.Printf("Error when intializing: %v\n", err)
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrMetadata, Path: volume.Path, Err: fmt.Errorf("failed to start exiftool: %w", err)}
 	}
 	t.etHandle = et
 	defer t.etHandle.Close()
 
-	return t.scanDirectory(path.Join(t.sourceDir, "DCIM"), "DCIM")
+	return t.scanDirectory(ctx, path.Join(volume.Path, "DCIM"), "DCIM")
 }
 
 func (t *Processor) readExif(imagePath string) *exiftool.FileMetadata {
@@ -152,35 +164,57 @@ func (t *Processor) getCameraModel(exifData *exiftool.FileMetadata) string {
 	return camModelName
 }
 
-func (t *Processor) getCaptureDate(exifData *exiftool.FileMetadata) time.Time {
+func (t *Processor) getCameraSerial(exifData *exiftool.FileMetadata) string {
+	serial, ok := exifData.Fields["SerialNumber"]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", serial)
+}
+
+func (t *Processor) getCaptureTime(exifData *exiftool.FileMetadata) time.Time {
 	//[DateTimeOriginal] 2024:12:01 11:45:31
-	dtmOriginal := fmt.Sprintf("%v", exifData.Fields["DateTimeOriginal"])[:10]
+	dtmOriginal := fmt.Sprintf("%v", exifData.Fields["DateTimeOriginal"])
+	if len(dtmOriginal) > 19 {
+		dtmOriginal = dtmOriginal[:19]
+	}
+
+	format := "2006:01:02 15:04:05"
 
-	zone, _ := time.Now().Zone()
-	dtmStr := fmt.Sprintf("%s %s", dtmOriginal, zone)
+	// newer bodies also record the offset of the camera's timezone setting
+	//[OffsetTimeOriginal] -06:00
+	if offset, ok := exifData.Fields["OffsetTimeOriginal"]; ok {
+		dtm, err := time.Parse(format+"-07:00", dtmOriginal+fmt.Sprintf("%v", offset))
+		if err == nil {
+			return dtm
+		}
+	}
 
-	format := "2006:01:02 MST"
-	date, err := time.Parse(format, dtmStr)
+	dtm, err := time.ParseInLocation(format, dtmOriginal, t.volume.TimeLocation())
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to parse date, error: %s", err.Error()))
+		logger.Error(fmt.Sprintf("Failed to parse dtm, error: %s", err.Error()))
 	}
 
-	return date
+	return dtm
 }
 
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
 	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
 	var files []model.SourceFile
 
 	// For this processor, we only care about .MXF files and the sidecar XML files
 	// and we read the source name from the sidecar XML
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	for _, entry := range entries {
@@ -188,7 +222,12 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		relativePath := path.Join(relativeDirPath, entry.Name())
 
 		if entry.IsDir() {
-			files = append(files, t.scanDirectory(fullPath, path.Join(relativeDirPath, entry.Name()))...)
+			subdirFiles, err := t.scanDirectory(ctx, fullPath, path.Join(relativeDirPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, subdirFiles...)
 		} else {
 			foundMatch := false
 
@@ -202,7 +241,10 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 			if foundMatch {
 				logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
 
 				mediaType := "Photo"
 
@@ -223,9 +265,10 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 					MediaType:    mediaType,
 					Size:         stat.Size(),
 					SourceName:   t.getCameraModel(exif),
-					CaptureDate:  t.getCaptureDate(exif),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					SourceSerial: t.getCameraSerial(exif),
+					CaptureTime:  t.getCaptureTime(exif),
+					FileModTime:  util.VolumeModTime(t.volume, stat.ModTime()),
+					VolumeFormat: t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -233,5 +276,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
@@ -0,0 +1,118 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package canonEOS
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ccmm/model"
+
+	"github.com/barasher/go-exiftool"
+)
+
+var testLocation = time.FixedZone("UTC-6", -6*60*60)
+
+// newTestVolume is a volume holding files with the provided modification
+// times, names ending in "/" are empty directories. It isn't FAT, so the
+// modification times are taken as they are
+func newTestVolume(label string, files map[string]time.Time) model.Volume {
+	fsys := fstest.MapFS{}
+	for name, modTime := range files {
+		if strings.HasSuffix(name, "/") {
+			fsys[strings.TrimSuffix(name, "/")] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: modTime}
+			continue
+		}
+		fsys[name] = &fstest.MapFile{Data: []byte("data"), ModTime: modTime}
+	}
+
+	return model.Volume{Path: "/media/" + label, FS: fsys, Label: label, FsType: "NTFS", Location: testLocation}
+}
+
+func TestCheckSource(t *testing.T) {
+	modTime := time.Date(2024, 3, 15, 9, 30, 0, 0, testLocation)
+
+	tests := []struct {
+		name  string
+		label string
+		files []string
+		want  model.Confidence
+	}{
+		{"EOSMISC catalog", "EOS_DIGITAL", []string{"DCIM/100CANON/IMG_0001.CR2", "DCIM/EOSMISC/M0001.CTG", "MISC/"}, model.HighConfidence},
+		{"CANONMSC catalog", "EOS_DIGITAL", []string{"DCIM/100EOS5D/IMG_0001.CR3", "DCIM/CANONMSC/M0001.CTG", "MISC/"}, model.HighConfidence},
+		{"other label", "NO NAME", []string{"DCIM/100CANON/IMG_0001.CR2", "DCIM/EOSMISC/M0001.CTG", "MISC/"}, model.NotDetected},
+		{"no MISC directory", "EOS_DIGITAL", []string{"DCIM/100CANON/IMG_0001.CR2", "DCIM/EOSMISC/M0001.CTG"}, model.NotDetected},
+		{"no catalog", "EOS_DIGITAL", []string{"DCIM/100CANON/IMG_0001.CR2", "DCIM/EOSMISC/", "MISC/"}, model.NotDetected},
+		{"no camera directory", "EOS_DIGITAL", []string{"DCIM/100NIKON/DSC_0001.NEF", "DCIM/EOSMISC/M0001.CTG", "MISC/"}, model.NotDetected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := make(map[string]time.Time)
+			for _, name := range test.files {
+				files[name] = modTime
+			}
+
+			detection, err := New().CheckSource(context.Background(), newTestVolume(test.label, files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Confidence != test.want {
+				t.Errorf("expected confidence %v, got %v (%s)", test.want, detection.Confidence, detection.Reason)
+			}
+		})
+	}
+}
+
+func TestGetCaptureTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   time.Time
+	}{
+		{"wall clock", map[string]interface{}{"DateTimeOriginal": "2024:12:01 21:45:31"}, time.Date(2024, 12, 1, 21, 45, 31, 0, testLocation)},
+		{"sub-seconds", map[string]interface{}{"DateTimeOriginal": "2024:12:01 21:45:31.27"}, time.Date(2024, 12, 1, 21, 45, 31, 0, testLocation)},
+		{"timezone offset", map[string]interface{}{"DateTimeOriginal": "2024:12:01 21:45:31", "OffsetTimeOriginal": "-05:00"}, time.Date(2024, 12, 2, 2, 45, 31, 0, time.UTC)},
+		{"invalid offset", map[string]interface{}{"DateTimeOriginal": "2024:12:01 21:45:31", "OffsetTimeOriginal": "unknown"}, time.Date(2024, 12, 1, 21, 45, 31, 0, testLocation)},
+	}
+
+	// EXIF data is read by exiftool, so the capture time is checked against
+	// the metadata it would return
+	processor := New()
+	processor.volume = newTestVolume("EOS_DIGITAL", nil)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captureTime := processor.getCaptureTime(&exiftool.FileMetadata{Fields: test.fields})
+			if !captureTime.Equal(test.want) {
+				t.Errorf("expected capture time %s, got %s", test.want, captureTime)
+			}
+		})
+	}
+}
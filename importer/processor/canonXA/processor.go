@@ -25,11 +25,11 @@
 package canonXA
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
-	"io"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"regexp"
 	"strings"
@@ -54,63 +54,67 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	volumeFormat string
+	volume model.Volume
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "canonXA"))
 
-	return &Processor{
-		sourceDir: sourceDir,
-	}
+	return &Processor{}
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// verify volume label matches what the camera sets
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", t.sourceDir))
-	if label := util.GetVolumeName(t.sourceDir); label != expectedVolumeName {
-		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", label, expectedVolumeName))
-		return false
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+	if volume.Label != expectedVolumeName {
+		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", volume.Label, expectedVolumeName))
+		return model.Detection{Reason: fmt.Sprintf("volume label '%s' is not '%s'", volume.Label, expectedVolumeName)}, nil
 	}
 
 	// check for /CONTENTS and /DCIM directories
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
-	if !util.RequireDirs(t.sourceDir, []string{"CONTENTS", "DCIM"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
+	if !util.RequireDirs(volume.FS, ".", []string{"CONTENTS", "DCIM"}) {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no CONTENTS and DCIM directories"}, nil
 	}
 
 	// check for CONTENTS/CLIPS(\d+)
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of CONTENTS/CLIPSxxx directory in volume '%s'", t.sourceDir))
-	exists, clipsPath := util.RequireRegexDirMatch(path.Join(t.sourceDir, "CONTENTS"), `CLIPS(\d+)`)
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of CONTENTS/CLIPSxxx directory in volume '%s'", volume.Path))
+	exists, clipsPath := util.RequireRegexDirMatch(volume.FS, "CONTENTS", `CLIPS(\d+)`)
 	if !exists {
 		logger.Debug("[CheckSource]: No '/CONTENTS/CLIPSXXX/' directory found, disqualified")
-		return false
+		return model.Detection{Reason: "no CONTENTS/CLIPSxxx directory"}, nil
 	}
 
 	// check for CONTENTS/CLIPS(\d+)/INDEX.MIF file
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of CONTENTS/CLIPSxxx/INDEX.MIF in volume '%s'", t.sourceDir))
-	if !util.RequireFiles(clipsPath, []string{"INDEX.MIF"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of CONTENTS/CLIPSxxx/INDEX.MIF in volume '%s'", volume.Path))
+	if !util.RequireFiles(volume.FS, clipsPath, []string{"INDEX.MIF"}) {
 		logger.Debug("[CheckSource]: INDEX.MIF file not found in CLIPS directory, disqualified")
-		return false
+		return model.Detection{Reason: "no CONTENTS/CLIPSxxx/INDEX.MIF clip index"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "volume label and clip directories match"}, nil
+}
+
+// ControlFiles returns patterns matching the files that the device needs in
+// order to recognize the card, which is the clip index in each CLIPSxxx directory
+func (t *Processor) ControlFiles() []string {
+	return []string{
+		`^CONTENTS/CLIPS\d+/INDEX\.MIF$`,
+	}
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
-	return t.scanDirectory(path.Join(t.sourceDir, "CONTENTS"), "CONTENTS")
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+	return t.scanDirectory(ctx, path.Join(volume.Path, "CONTENTS"), "CONTENTS")
 }
 
 // private functions
 
-func getSourceName(mediaPath string) string {
+func (t *Processor) getSourceName(mediaPath string) string {
 	sidecarFile := mediaPath
 	if strings.HasSuffix(sidecarFile, "MXF") {
 		sidecarFile = strings.TrimSuffix(sidecarFile, "MXF") + "XML"
@@ -120,13 +124,10 @@ func getSourceName(mediaPath string) string {
 
 	logger.Debug(fmt.Sprintf("[getSourceName]: Reading SourceName from file '%s'", sidecarFile))
 
-	xmlFile, err := os.Open(sidecarFile)
+	byteValue, err := fs.ReadFile(t.volume.FS, sidecarFile)
 	if err != nil {
 		logger.Error(fmt.Sprintf("[getSourceName]: Failed to open sidecar file '%s': %s", sidecarFile, err.Error()))
 	} else {
-		defer xmlFile.Close()
-
-		byteValue, _ := io.ReadAll(xmlFile)
 		err := xml.Unmarshal(byteValue, &x)
 		if err != nil {
 			logger.Error(fmt.Sprintf("error: %v", err))
@@ -136,30 +137,37 @@ func getSourceName(mediaPath string) string {
 	return x.Value
 }
 
-func getCaptureDate(fileName string) time.Time {
+// getCaptureTime returns the capture time of the clip, along with false when
+// only the date could be determined. The file name only carries the date, so
+// the time of day is taken from the modification time when it agrees
+func (t *Processor) getCaptureTime(fileName string, modTime time.Time) (time.Time, bool) {
+	location := t.volume.TimeLocation()
 	datePart := strings.Split(fileName, "_")[1][:6]
-	zone, _ := time.Now().Zone()
-	dtm, err := time.Parse("060102 MST", fmt.Sprintf("%s %s", datePart, zone))
+	date, err := time.Parse("060102", datePart)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[getCaptureDate]: Failed to parse date '%s': %s", datePart, err.Error()))
+		logger.Error(fmt.Sprintf("[getCaptureTime]: Failed to parse date '%s': %s", datePart, err.Error()))
+		return modTime, true
 	}
 
-	return dtm
+	return util.DateWithTimeOf(date, modTime, location)
 }
 
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
 	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
 	var files []model.SourceFile
 
 	// For this processor, we only care about .MXF files and the sidecar XML files
 	// and we read the source name from the sidecar XML
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	for _, entry := range entries {
@@ -167,7 +175,12 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		relativePath := path.Join(relativeDirPath, entry.Name())
 
 		if entry.IsDir() {
-			files = append(files, t.scanDirectory(fullPath, path.Join(relativeDirPath, entry.Name()))...)
+			subdirFiles, err := t.scanDirectory(ctx, fullPath, path.Join(relativeDirPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, subdirFiles...)
 		} else {
 			foundMatch := false
 
@@ -181,17 +194,24 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 			if foundMatch {
 				logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
+
+				modTime := util.VolumeModTime(t.volume, stat.ModTime())
+				captureTime, hasTime := t.getCaptureTime(entry.Name(), modTime)
 
 				newFile := model.SourceFile{
-					FileName:     entry.Name(),
-					SourcePath:   fullPath,
-					MediaType:    mediaType,
-					Size:         stat.Size(),
-					SourceName:   getSourceName(fullPath),
-					CaptureDate:  getCaptureDate(entry.Name()),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					FileName:        entry.Name(),
+					SourcePath:      fullPath,
+					MediaType:       mediaType,
+					Size:            stat.Size(),
+					SourceName:      t.getSourceName(relativePath),
+					CaptureTime:     captureTime,
+					CaptureDateOnly: !hasTime,
+					FileModTime:     modTime,
+					VolumeFormat:    t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -199,5 +219,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
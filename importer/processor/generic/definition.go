@@ -0,0 +1,265 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+// Package generic provides a processor that is driven entirely by a YAML
+// definition, allowing support for new devices to be added without recompiling
+package generic
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Supported capture date sources
+const (
+	DateSourceFilename  = "filename"
+	DateSourceSidecar   = "sidecar"
+	DateSourceExif      = "exif"
+	DateSourceMediaInfo = "mediainfo"
+	DateSourceModTime   = "mtime"
+)
+
+// Definition describes how to detect and import media created by a single
+// type of device. For example:
+//
+//	name: sonyFX30
+//	source_name: Sony FX30
+//	volume_label: Untitled
+//	require_dirs: [PRIVATE/M4ROOT]
+//	include_patterns:
+//	  - 'PRIVATE/M4ROOT/CLIP/\w+\.MP4'
+//	media_types:
+//	  - pattern: '\.MP4$'
+//	    media_type: Video
+//	capture_date:
+//	  source: filename
+//	  pattern: '(\d{8})'
+//	  layout: '20060102'
+type Definition struct {
+	// Name is the processor name used in enabled_processors and processors config
+	Name string `yaml:"name"`
+
+	// SourceName is the value used for SourceName on every file, unless
+	// SourceNameField is able to read one from the file itself
+	SourceName      string         `yaml:"source_name"`
+	SourceNameField *MetadataField `yaml:"source_name_field,omitempty"`
+
+	// VolumeLabel must match the volume label exactly, if set
+	VolumeLabel string `yaml:"volume_label,omitempty"`
+
+	// VolumeLabelPattern is a regex that the volume label must match, if set
+	VolumeLabelPattern string `yaml:"volume_label_pattern,omitempty"`
+
+	// RequireDirs and RequireFiles are paths relative to the root of the volume
+	// that must exist for the volume to be considered compatible
+	RequireDirs  []string `yaml:"require_dirs,omitempty"`
+	RequireFiles []string `yaml:"require_files,omitempty"`
+
+	// RequireMatches is a list of regexes that must each match the relative path
+	// of at least one file or directory on the volume
+	RequireMatches []string `yaml:"require_matches,omitempty"`
+
+	// IncludePatterns is a list of regexes. Any file whose path, relative to the
+	// root of the volume, matches one of these will be imported
+	IncludePatterns []string `yaml:"include_patterns"`
+
+	// MediaTypes maps files to a media type. The first matching entry wins and
+	// DefaultMediaType is used when nothing matches
+	MediaTypes       []MediaTypeMapping `yaml:"media_types,omitempty"`
+	DefaultMediaType string             `yaml:"default_media_type,omitempty"`
+
+	// SkipEmptyFiles causes 0 byte files to be ignored
+	SkipEmptyFiles bool `yaml:"skip_empty_files,omitempty"`
+
+	// ControlFiles is a list of regexes matching files or directories, relative
+	// to the root of the volume, that the device needs in order to recognize the
+	// card. These are never removed by a post-import action
+	ControlFiles []string `yaml:"control_files,omitempty"`
+
+	CaptureDate DateSource `yaml:"capture_date"`
+}
+
+// MediaTypeMapping maps files whose relative path matches Pattern to MediaType
+type MediaTypeMapping struct {
+	Pattern   string `yaml:"pattern"`
+	MediaType string `yaml:"media_type"`
+}
+
+// MetadataField describes a single metadata value to be read from a file
+type MetadataField struct {
+	// Source is either "exif" or "mediainfo"
+	Source string `yaml:"source"`
+
+	// Field is the exif tag (ex: Model) or mediainfo general parameter (ex: Encoded_Date)
+	Field string `yaml:"field"`
+}
+
+// DateSource describes where the capture date of a file is read from
+type DateSource struct {
+	// Source is one of: filename, sidecar, exif, mediainfo or mtime
+	Source string `yaml:"source"`
+
+	// Pattern is a regex containing a single capture group that extracts the
+	// date string. Used by the filename and sidecar sources. For the filename
+	// source, it is matched against the path relative to the volume root
+	Pattern string `yaml:"pattern,omitempty"`
+
+	// Layout is the go time layout used to parse the extracted date string
+	Layout string `yaml:"layout,omitempty"`
+
+	// Sidecar is a regex that identifies the sidecar file in the same directory
+	// as the media file. The date is read from the sidecar file name
+	Sidecar string `yaml:"sidecar,omitempty"`
+
+	// Field is the exif tag or mediainfo parameter containing the date
+	Field string `yaml:"field,omitempty"`
+
+	// FallbackToModTime causes the file mod time to be used if the date could
+	// not be read from the configured source
+	FallbackToModTime bool `yaml:"fallback_to_mtime,omitempty"`
+}
+
+// LoadDefinitions reads every .yml/.yaml file in the provided directory and
+// returns the processor definitions that they contain. Each file may contain
+// a single definition
+func LoadDefinitions(definitionsDir string) ([]Definition, error) {
+	entries, err := os.ReadDir(definitionsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]Definition, 0)
+
+	for _, entry := range entries {
+		extension := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (extension != ".yml" && extension != ".yaml") {
+			continue
+		}
+
+		definitionPath := path.Join(definitionsDir, entry.Name())
+		definition, err := LoadDefinition(definitionPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", definitionPath, err)
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, nil
+}
+
+// LoadDefinition reads and validates a single processor definition file
+func LoadDefinition(definitionPath string) (Definition, error) {
+	var definition Definition
+
+	data, err := os.ReadFile(definitionPath)
+	if err != nil {
+		return definition, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, &definition); err != nil {
+		return definition, err
+	}
+
+	return definition, definition.Validate()
+}
+
+// Validate ensures that the definition is complete and that every regex in it compiles
+func (d Definition) Validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if d.VolumeLabel == "" && d.VolumeLabelPattern == "" && len(d.RequireDirs) == 0 &&
+		len(d.RequireFiles) == 0 && len(d.RequireMatches) == 0 {
+		return fmt.Errorf("at least one volume label, required dir, required file or required match must be provided")
+	}
+
+	if len(d.IncludePatterns) == 0 {
+		return fmt.Errorf("at least one include pattern is required")
+	}
+
+	patterns := append([]string{}, d.IncludePatterns...)
+	patterns = append(patterns, d.RequireMatches...)
+	patterns = append(patterns, d.ControlFiles...)
+
+	if d.VolumeLabelPattern != "" {
+		patterns = append(patterns, d.VolumeLabelPattern)
+	}
+
+	for _, mapping := range d.MediaTypes {
+		if mapping.MediaType == "" {
+			return fmt.Errorf("media type mapping for pattern '%s' is missing media_type", mapping.Pattern)
+		}
+		patterns = append(patterns, mapping.Pattern)
+	}
+
+	if len(d.MediaTypes) == 0 && d.DefaultMediaType == "" {
+		return fmt.Errorf("either media_types or default_media_type must be provided")
+	}
+
+	if d.SourceNameField != nil && d.SourceNameField.Source != DateSourceExif && d.SourceNameField.Source != DateSourceMediaInfo {
+		return fmt.Errorf("source_name_field.source must be either '%s' or '%s'", DateSourceExif, DateSourceMediaInfo)
+	}
+
+	switch d.CaptureDate.Source {
+	case DateSourceFilename:
+		if d.CaptureDate.Pattern == "" || d.CaptureDate.Layout == "" {
+			return fmt.Errorf("capture_date source '%s' requires both pattern and layout", d.CaptureDate.Source)
+		}
+		patterns = append(patterns, d.CaptureDate.Pattern)
+	case DateSourceSidecar:
+		if d.CaptureDate.Sidecar == "" || d.CaptureDate.Pattern == "" || d.CaptureDate.Layout == "" {
+			return fmt.Errorf("capture_date source '%s' requires sidecar, pattern and layout", d.CaptureDate.Source)
+		}
+		patterns = append(patterns, d.CaptureDate.Sidecar, d.CaptureDate.Pattern)
+	case DateSourceExif, DateSourceMediaInfo:
+		if d.CaptureDate.Field == "" {
+			return fmt.Errorf("capture_date source '%s' requires field", d.CaptureDate.Source)
+		}
+	case DateSourceModTime:
+	default:
+		return fmt.Errorf("unknown capture_date source '%s'", d.CaptureDate.Source)
+	}
+
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid regex '%s': %w", pattern, err)
+		}
+	}
+
+	if d.CaptureDate.Pattern != "" {
+		if regexp.MustCompile(d.CaptureDate.Pattern).NumSubexp() != 1 {
+			return fmt.Errorf("capture_date pattern '%s' must contain exactly one capture group", d.CaptureDate.Pattern)
+		}
+	}
+
+	return nil
+}
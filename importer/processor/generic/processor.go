@@ -0,0 +1,404 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package generic
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+
+	"github.com/barasher/go-exiftool"
+)
+
+// default layouts used when none is provided for the exif and mediainfo date sources
+const (
+	defaultExifLayout      = "2006:01:02 15:04:05"
+	defaultMediaInfoLayout = "2006-01-02 15:04:05 MST"
+)
+
+type Processor struct {
+	definition      Definition
+	volume          model.Volume
+	logger          *slog.Logger
+	includeRegexes  []*regexp.Regexp
+	requireRegexes  []*regexp.Regexp
+	mediaTypeRegexs []*regexp.Regexp
+	labelRegex      *regexp.Regexp
+	dateRegex       *regexp.Regexp
+	sidecarRegex    *regexp.Regexp
+	etHandle        *exiftool.Exiftool
+}
+
+// New creates a new processor for the provided definition. The definition
+// is expected to have already been validated
+func New(definition Definition) *Processor {
+	processor := &Processor{
+		definition: definition,
+		logger:     slog.Default().With(slog.String("processor", definition.Name)),
+	}
+
+	for _, pattern := range definition.IncludePatterns {
+		processor.includeRegexes = append(processor.includeRegexes, regexp.MustCompile(pattern))
+	}
+
+	for _, pattern := range definition.RequireMatches {
+		processor.requireRegexes = append(processor.requireRegexes, regexp.MustCompile(pattern))
+	}
+
+	for _, mapping := range definition.MediaTypes {
+		processor.mediaTypeRegexs = append(processor.mediaTypeRegexs, regexp.MustCompile(mapping.Pattern))
+	}
+
+	if definition.VolumeLabelPattern != "" {
+		processor.labelRegex = regexp.MustCompile(definition.VolumeLabelPattern)
+	}
+
+	if definition.CaptureDate.Pattern != "" {
+		processor.dateRegex = regexp.MustCompile(definition.CaptureDate.Pattern)
+	}
+
+	if definition.CaptureDate.Sidecar != "" {
+		processor.sidecarRegex = regexp.MustCompile(definition.CaptureDate.Sidecar)
+	}
+
+	return processor
+}
+
+// Name returns the name of the processor, as provided by the definition
+func (t *Processor) Name() string {
+	return t.definition.Name
+}
+
+// ControlFiles returns the control file patterns provided by the definition
+func (t *Processor) ControlFiles() []string {
+	return t.definition.ControlFiles
+}
+
+// CheckSource reports a high confidence if the definition requires a volume
+// label and it matched, otherwise the directory layout alone can only give
+// a medium confidence
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	t.logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
+
+	t.volume = volume
+	confidence := model.MediumConfidence
+
+	if t.definition.VolumeLabel != "" || t.labelRegex != nil {
+		t.logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+		label := volume.Label
+
+		if t.definition.VolumeLabel != "" && label != t.definition.VolumeLabel {
+			t.logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", label, t.definition.VolumeLabel))
+			return model.Detection{Reason: fmt.Sprintf("volume label '%s' is not '%s'", label, t.definition.VolumeLabel)}, nil
+		}
+
+		if t.labelRegex != nil && !t.labelRegex.MatchString(label) {
+			t.logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' pattern, disqualified", label, t.definition.VolumeLabelPattern))
+			return model.Detection{Reason: fmt.Sprintf("volume label '%s' does not match '%s'", label, t.definition.VolumeLabelPattern)}, nil
+		}
+
+		confidence = model.HighConfidence
+	}
+
+	t.logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories and files for volume '%s'", volume.Path))
+	if !util.RequireDirs(volume.FS, ".", t.definition.RequireDirs) || !util.RequireFiles(volume.FS, ".", t.definition.RequireFiles) {
+		t.logger.Debug("[CheckSource]: One or more required directories or files does not exist on source, disqualified")
+		return model.Detection{Reason: "one or more required directories or files are missing"}, nil
+	}
+
+	if len(t.requireRegexes) > 0 {
+		matched := make([]bool, len(t.requireRegexes))
+
+		err := t.walk(ctx, func(relativePath string, _ fs.DirEntry) error {
+			for idx, regexC := range t.requireRegexes {
+				if !matched[idx] && regexC.MatchString(relativePath) {
+					matched[idx] = true
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return model.Detection{}, err
+		}
+
+		for idx, found := range matched {
+			if !found {
+				t.logger.Debug(fmt.Sprintf("[CheckSource]: Nothing on the volume matches required pattern '%s', disqualified", t.definition.RequireMatches[idx]))
+				return model.Detection{Reason: fmt.Sprintf("nothing matches required pattern '%s'", t.definition.RequireMatches[idx])}, nil
+			}
+		}
+	}
+
+	t.logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: confidence, Reason: "matches the processor definition"}, nil
+}
+
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+
+	if !volume.Archive && (t.definition.CaptureDate.Source == DateSourceExif || (t.definition.SourceNameField != nil && t.definition.SourceNameField.Source == DateSourceExif)) {
+		et, err := exiftool.NewExiftool()
+		if err != nil {
+			return nil, &model.ProcessorError{Kind: model.ErrMetadata, Path: volume.Path, Err: fmt.Errorf("failed to start exiftool: %w", err)}
+		}
+		t.etHandle = et
+		defer t.etHandle.Close()
+	}
+
+	var files []model.SourceFile
+
+	err := t.walk(ctx, func(relativePath string, entry fs.DirEntry) error {
+		if entry.IsDir() || !t.included(relativePath) {
+			return nil
+		}
+
+		fullPath := path.Join(volume.Path, relativePath)
+		t.logger.Debug(fmt.Sprintf("[EnumerateFiles]: Matched file '%s'", fullPath))
+
+		stat, err := fs.Stat(volume.FS, relativePath)
+		if err != nil {
+			return &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+		}
+
+		if t.definition.SkipEmptyFiles && stat.Size() == 0 {
+			t.logger.Info(fmt.Sprintf("[EnumerateFiles]: Skipping 0 byte file '%s'", fullPath))
+			return nil
+		}
+
+		modTime := util.VolumeModTime(t.volume, stat.ModTime())
+		captureTime, hasTime, ok := t.getCaptureTime(fullPath, relativePath, modTime)
+		if !ok {
+			t.logger.Warn(fmt.Sprintf("[EnumerateFiles]: Could not determine capture date for '%s', skipping!", fullPath))
+			return nil
+		}
+
+		files = append(files, model.SourceFile{
+			FileName:        entry.Name(),
+			SourcePath:      fullPath,
+			MediaType:       t.getMediaType(relativePath),
+			Size:            stat.Size(),
+			SourceName:      t.getSourceName(fullPath),
+			CaptureTime:     captureTime,
+			CaptureDateOnly: !hasTime,
+			FileModTime:     modTime,
+			VolumeFormat:    t.volume.FsType,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+//
+// private functions
+//
+
+// walk calls walkFunc for every file and directory on the volume, providing
+// the path relative to the root of the volume. Hidden entries are skipped.
+// The walk stops at the first error, including one returned by walkFunc
+func (t *Processor) walk(ctx context.Context, walkFunc func(relativePath string, entry fs.DirEntry) error) error {
+	return fs.WalkDir(t.volume.FS, ".", func(relativePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: path.Join(t.volume.Path, relativePath), Err: err}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if relativePath == "." {
+			return nil
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		return walkFunc(relativePath, entry)
+	})
+}
+
+func (t *Processor) included(relativePath string) bool {
+	for _, regexC := range t.includeRegexes {
+		if regexC.MatchString(relativePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Processor) getMediaType(relativePath string) string {
+	for idx, regexC := range t.mediaTypeRegexs {
+		if regexC.MatchString(relativePath) {
+			return t.definition.MediaTypes[idx].MediaType
+		}
+	}
+
+	return t.definition.DefaultMediaType
+}
+
+func (t *Processor) getSourceName(fullPath string) string {
+	if t.definition.SourceNameField != nil {
+		if value := t.readMetadataField(fullPath, t.definition.SourceNameField.Source, t.definition.SourceNameField.Field); value != "" {
+			return value
+		}
+	}
+
+	return t.definition.SourceName
+}
+
+// readMetadataField reads a field using exiftool or mediainfo, which both need
+// a real file, so nothing can be read from an archived volume
+func (t *Processor) readMetadataField(fullPath string, source string, field string) string {
+	if t.volume.Archive {
+		return ""
+	}
+
+	switch source {
+	case DateSourceExif:
+		if t.etHandle == nil {
+			return ""
+		}
+
+		fileInfos := t.etHandle.ExtractMetadata(fullPath)
+		if len(fileInfos) == 0 || fileInfos[0].Err != nil {
+			return ""
+		}
+
+		if value, ok := fileInfos[0].Fields[field]; ok {
+			return fmt.Sprintf("%v", value)
+		}
+	case DateSourceMediaInfo:
+		return util.MediaInfo_GetGeneralParameter(fullPath, field)
+	}
+
+	return ""
+}
+
+// getCaptureTime returns the capture time of the file based on the configured
+// date source. The second return value is false if only the date is known and
+// the third is false if no date could be determined
+func (t *Processor) getCaptureTime(fullPath string, relativePath string, modTime time.Time) (time.Time, bool, bool) {
+	dateSource := t.definition.CaptureDate
+	var dateStr, layout string
+
+	switch dateSource.Source {
+	case DateSourceFilename:
+		dateStr = t.extractDate(relativePath)
+		layout = dateSource.Layout
+
+	case DateSourceSidecar:
+		exists, sidecarPath := util.RequireRegexFileMatch(t.volume.FS, path.Dir(relativePath), t.sidecarRegex.String())
+		if exists {
+			dateStr = t.extractDate(path.Base(sidecarPath))
+		}
+		layout = dateSource.Layout
+
+	case DateSourceExif:
+		dateStr = t.readMetadataField(fullPath, DateSourceExif, dateSource.Field)
+		layout = dateSource.Layout
+		if layout == "" {
+			layout = defaultExifLayout
+			// exiftool may append sub-seconds or a timezone, which we don't care about here
+			if len(dateStr) > len(layout) {
+				dateStr = dateStr[:len(layout)]
+			}
+		}
+
+	case DateSourceMediaInfo:
+		dateStr = t.readMetadataField(fullPath, DateSourceMediaInfo, dateSource.Field)
+		layout = dateSource.Layout
+		if layout == "" {
+			layout = defaultMediaInfoLayout
+		}
+
+	case DateSourceModTime:
+		return modTime, true, true
+	}
+
+	if dateStr != "" {
+		location := t.volume.TimeLocation()
+
+		// a date without a time of day is only a calendar date, which parsing
+		// in the location could move to the day before
+		parseLocation := location
+		if !hasTimeOfDay(layout) {
+			parseLocation = time.UTC
+		}
+
+		dtm, err := time.ParseInLocation(layout, dateStr, parseLocation)
+
+		if err == nil {
+			if !hasTimeOfDay(layout) {
+				dtm, hasTime := util.DateWithTimeOf(dtm, modTime, location)
+				return dtm, hasTime, true
+			}
+
+			return dtm, true, true
+		}
+
+		t.logger.Debug(fmt.Sprintf("[getCaptureTime]: Failed to parse date '%s' from '%s': %s", dateStr, fullPath, err.Error()))
+	}
+
+	if dateSource.FallbackToModTime {
+		return modTime, true, true
+	}
+
+	return time.Time{}, false, false
+}
+
+func (t *Processor) extractDate(value string) string {
+	matches := t.dateRegex.FindStringSubmatch(value)
+	if len(matches) < 2 {
+		return ""
+	}
+
+	return matches[1]
+}
+
+// hasTimeOfDay returns true if the go time layout includes an hour, otherwise
+// the parsed time only carries a date
+func hasTimeOfDay(layout string) bool {
+	return strings.Contains(layout, "15") || strings.Contains(layout, "3")
+}
@@ -0,0 +1,166 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package generic
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ccmm/model"
+)
+
+var testLocation = time.FixedZone("UTC-6", -6*60*60)
+
+// newTestVolume is a volume holding files with the provided modification
+// times, names ending in "/" are empty directories. It isn't FAT, so the
+// modification times are taken as they are
+func newTestVolume(label string, files map[string]time.Time) model.Volume {
+	fsys := fstest.MapFS{}
+	for name, modTime := range files {
+		if strings.HasSuffix(name, "/") {
+			fsys[strings.TrimSuffix(name, "/")] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: modTime}
+			continue
+		}
+		fsys[name] = &fstest.MapFile{Data: []byte("data"), ModTime: modTime}
+	}
+
+	return model.Volume{Path: "/media/" + label, FS: fsys, Label: label, FsType: "NTFS", Location: testLocation}
+}
+
+func newTestDefinition(t *testing.T, update func(definition *Definition)) Definition {
+	t.Helper()
+
+	definition := Definition{
+		Name:             "testRecorder",
+		SourceName:       "Test Recorder",
+		IncludePatterns:  []string{`^REC/.+\.WAV$`},
+		DefaultMediaType: "Audio",
+		RequireDirs:      []string{"REC"},
+		CaptureDate:      DateSource{Source: DateSourceModTime},
+	}
+	update(&definition)
+
+	if err := definition.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return definition
+}
+
+func TestCheckSource(t *testing.T) {
+	modTime := time.Date(2024, 3, 15, 9, 30, 0, 0, testLocation)
+
+	tests := []struct {
+		name   string
+		update func(definition *Definition)
+		label  string
+		files  []string
+		want   model.Confidence
+	}{
+		{"required dir", func(d *Definition) {}, "NO NAME", []string{"REC/0001.WAV"}, model.MediumConfidence},
+		{"missing required dir", func(d *Definition) {}, "NO NAME", []string{"0001.WAV"}, model.NotDetected},
+		{"volume label", func(d *Definition) { d.VolumeLabel = "RECORDER" }, "RECORDER", []string{"REC/"}, model.HighConfidence},
+		{"other volume label", func(d *Definition) { d.VolumeLabel = "RECORDER" }, "NO NAME", []string{"REC/"}, model.NotDetected},
+		{"volume label pattern", func(d *Definition) { d.VolumeLabelPattern = `^REC_\d+$` }, "REC_01", []string{"REC/"}, model.HighConfidence},
+		{"other volume label pattern", func(d *Definition) { d.VolumeLabelPattern = `^REC_\d+$` }, "REC_A", []string{"REC/"}, model.NotDetected},
+		{"required file", func(d *Definition) { d.RequireFiles = []string{"SYSTEM.BIN"} }, "NO NAME", []string{"REC/", "SYSTEM.BIN"}, model.MediumConfidence},
+		{"missing required file", func(d *Definition) { d.RequireFiles = []string{"SYSTEM.BIN"} }, "NO NAME", []string{"REC/"}, model.NotDetected},
+		{"required match", func(d *Definition) { d.RequireMatches = []string{`^REC/\d{4}\.WAV$`} }, "NO NAME", []string{"REC/0001.WAV"}, model.MediumConfidence},
+		{"hidden required match", func(d *Definition) { d.RequireMatches = []string{`\.WAV$`} }, "NO NAME", []string{"REC/", ".Trash/0001.WAV"}, model.NotDetected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := make(map[string]time.Time)
+			for _, name := range test.files {
+				files[name] = modTime
+			}
+
+			detection, err := New(newTestDefinition(t, test.update)).CheckSource(context.Background(), newTestVolume(test.label, files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Confidence != test.want {
+				t.Errorf("expected confidence %v, got %v (%s)", test.want, detection.Confidence, detection.Reason)
+			}
+		})
+	}
+}
+
+func TestEnumerateFilesCaptureTime(t *testing.T) {
+	modTime := time.Date(2024, 3, 15, 21, 0, 0, 0, testLocation)
+
+	tests := []struct {
+		name         string
+		captureDate  DateSource
+		files        []string
+		want         time.Time
+		wantDateOnly bool
+		wantSkipped  bool
+	}{
+		{"mtime", DateSource{Source: DateSourceModTime}, []string{"REC/0001.WAV"}, modTime, false, false},
+		{"file name with time", DateSource{Source: DateSourceFilename, Pattern: `(\d{8}_\d{6})`, Layout: "20060102_150405"}, []string{"REC/20240315_233000.WAV"}, time.Date(2024, 3, 15, 23, 30, 0, 0, testLocation), false, false},
+		{"file name date", DateSource{Source: DateSourceFilename, Pattern: `(\d{8})`, Layout: "20060102"}, []string{"REC/20240315.WAV"}, modTime, false, false},
+		{"file name date of another day", DateSource{Source: DateSourceFilename, Pattern: `(\d{8})`, Layout: "20060102"}, []string{"REC/20240316.WAV"}, time.Date(2024, 3, 16, 0, 0, 0, 0, testLocation), true, false},
+		{"date from directory", DateSource{Source: DateSourceFilename, Pattern: `^REC/(\d{4}-\d{2}-\d{2})/`, Layout: "2006-01-02"}, []string{"REC/2024-03-14/0001.WAV"}, time.Date(2024, 3, 14, 0, 0, 0, 0, testLocation), true, false},
+		{"sidecar", DateSource{Source: DateSourceSidecar, Sidecar: `\.hprj$`, Pattern: `(\d{6}-\d{6})`, Layout: "060102-150405"}, []string{"REC/0001.WAV", "REC/240315-093000.hprj"}, time.Date(2024, 3, 15, 9, 30, 0, 0, testLocation), false, false},
+		{"unparsable date", DateSource{Source: DateSourceFilename, Pattern: `(\d{8})`, Layout: "20060102"}, []string{"REC/20241399.WAV"}, time.Time{}, false, true},
+		{"unparsable date falls back to mtime", DateSource{Source: DateSourceFilename, Pattern: `(\d{8})`, Layout: "20060102", FallbackToModTime: true}, []string{"REC/20241399.WAV"}, modTime, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition := newTestDefinition(t, func(d *Definition) { d.CaptureDate = test.captureDate })
+
+			files := make(map[string]time.Time)
+			for _, name := range test.files {
+				files[name] = modTime
+			}
+
+			sourceFiles, err := New(definition).EnumerateFiles(context.Background(), newTestVolume("NO NAME", files))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.wantSkipped {
+				if len(sourceFiles) != 0 {
+					t.Errorf("expected the file to be skipped, got %+v", sourceFiles)
+				}
+				return
+			}
+
+			if len(sourceFiles) != 1 {
+				t.Fatalf("expected one file, got %+v", sourceFiles)
+			}
+			if !sourceFiles[0].CaptureTime.Equal(test.want) || sourceFiles[0].CaptureDateOnly != test.wantDateOnly {
+				t.Errorf("expected capture time %s (date only %t), got %s (date only %t)", test.want, test.wantDateOnly, sourceFiles[0].CaptureTime, sourceFiles[0].CaptureDateOnly)
+			}
+		})
+	}
+}
@@ -0,0 +1,587 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+	"ccmm/util/notify"
+)
+
+// maxConflictSuffix is the highest numeric suffix that the rename-with-suffix
+// conflict policy will try before giving up
+const maxConflictSuffix = 999
+
+var (
+	destinationMutex sync.Mutex
+
+	// destinationsInUse holds the destination paths that an import is currently
+	// handling, so that concurrent imports never write to the same path. The
+	// channel is closed once the path is released
+	destinationsInUse = map[string]chan struct{}{}
+
+	// destinationSlots limits the number of files being copied to each
+	// destination device at the same time (see config.DestinationIOLimit)
+	destinationSlots = map[string]chan struct{}{}
+
+	// reservedBytes is the number of bytes that running imports still expect to
+	// write to config.LiveDataDir, so that imports running at the same time
+	// don't all count on the same free space
+	reservedBytes int64
+	spaceMutex    sync.Mutex
+)
+
+// ImportProgressCallback is used by ImportFiles to report progress back to the
+// caller. fileBytesCopied is the number of bytes of sourceFile that have been
+// copied so far and fileDone is set once the file has been completely handled.
+// A file that was skipped or failed is reported as done with only the bytes
+// that were actually copied before it stopped (0 for a skipped file)
+type ImportProgressCallback func(sourceFile model.SourceFile, fileBytesCopied int64, fileDone bool)
+
+// ImportResultCallback is used by ImportFiles to report the outcome of each
+// file as soon as it has been handled
+type ImportResultCallback func(result model.ImportFileResult)
+
+// ImportFiles copies the provided source files to their destination below
+// config.LiveDataDir. Every copied file is hashed and verified, and a manifest
+// entry recording where it came from is written to the service directory. If
+// a different file already exists at the destination, the configured conflict
+// policy decides what happens. A result describing what happened to each file
+// is returned, along with a joined error describing every file that failed
+// to import. If ctx is cancelled, the file being copied is aborted and the
+// results of the files handled so far are returned along with the context error.
+// Before anything is copied, the free space of config.LiveDataDir is checked
+// and util.ErrInsufficientSpace is returned if the files won't fit
+func ImportFiles(ctx context.Context, config model.ImporterConfig, files []model.SourceFile, dryRun bool, jobID int, progressCallback ImportProgressCallback, resultCallback ImportResultCallback) ([]model.ImportFileResult, error) {
+	var importErrors []error
+	results := make([]model.ImportFileResult, 0, len(files))
+
+	// the bytes of the current file that have been copied so far
+	var currentFileBytes int64
+
+	importer := &fileImporter{
+		ctx:       ctx,
+		config:    config,
+		dryRun:    dryRun,
+		jobID:     jobID,
+		manifests: newManifestBatch(),
+		progressCallback: func(sourceFile model.SourceFile, fileBytesCopied int64, fileDone bool) {
+			currentFileBytes = fileBytesCopied
+			if progressCallback != nil {
+				progressCallback(sourceFile, fileBytesCopied, fileDone)
+			}
+		},
+	}
+
+	if !dryRun {
+		reservation, err := reserveSpace(config, files)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Not starting import #%d: %s", jobID, err.Error()))
+			return results, err
+		}
+		defer reservation.releaseAll()
+
+		importer.reservation = reservation
+	}
+
+	for _, sourceFile := range files {
+		if ctx.Err() != nil {
+			importErrors = append(importErrors, ctx.Err())
+			break
+		}
+
+		currentFileBytes = 0
+		result, err := importer.importFile(sourceFile)
+		if err == nil && result.Action == "copied" {
+			currentFileBytes = sourceFile.Size
+		}
+		importer.progressCallback(sourceFile, currentFileBytes, true)
+		if importer.reservation != nil {
+			importer.reservation.release(sourceFile.SourcePath)
+		}
+
+		if errors.Is(err, util.ErrChecksumMismatch) {
+			notify.Notify(notify.Event{
+				Type:    notify.EventChecksumMismatch,
+				JobID:   jobID,
+				Title:   "Checksum mismatch during import",
+				Message: err.Error(),
+				Data: map[string]any{
+					"source_path": result.SourcePath,
+					"dest_path":   result.DestPath,
+				},
+			})
+		}
+
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to import '%s': %s", sourceFile.SourcePath, err.Error()))
+			importErrors = append(importErrors, fmt.Errorf("%s: %w", sourceFile.SourcePath, err))
+			result.Action = "failed"
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+
+		if resultCallback != nil {
+			resultCallback(result)
+		}
+	}
+
+	if !dryRun {
+		importErrors = append(importErrors, importer.manifests.flush()...)
+	}
+
+	return results, errors.Join(importErrors...)
+}
+
+//
+// private functions
+//
+
+type fileImporter struct {
+	ctx              context.Context
+	config           model.ImporterConfig
+	dryRun           bool
+	jobID            int
+	manifests        *manifestBatch
+	reservation      *spaceReservation
+	progressCallback ImportProgressCallback
+}
+
+// importFile handles a single source file. The returned result is always
+// populated as far as possible, even when an error is returned
+func (fi *fileImporter) importFile(sourceFile model.SourceFile) (model.ImportFileResult, error) {
+	result := model.ImportFileResult{
+		SourcePath: sourceFile.SourcePath,
+		Size:       sourceFile.Size,
+	}
+
+	destPath, err := util.GetDestinationPath(fi.config, sourceFile)
+	if err != nil {
+		return result, fmt.Errorf("failed to determine destination: %w", err)
+	}
+	result.DestPath = destPath
+
+	// another import could be deciding what to do with the same destination
+	// path right now, so wait for it to finish before looking at what's there
+	releaseDestination, err := claimDestination(fi.ctx, destPath)
+	if err != nil {
+		return result, err
+	}
+	defer releaseDestination()
+
+	manifestDir, err := util.GetManifestDirectory(fi.config, sourceFile)
+	if err != nil {
+		// fall back to storing the manifest alongside the file
+		manifestDir = filepath.Dir(destPath)
+	}
+
+	// Create the dir and parents, if needed
+	if !fi.dryRun {
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return result, err
+		}
+	}
+
+	// the source hash is only calculated if we need to compare against an existing file
+	sourceHash := ""
+	getSourceHash := func() (string, error) {
+		if sourceHash == "" {
+			hash, err := util.HashFile(sourceFile.SourcePath)
+			if err != nil {
+				return "", err
+			}
+			sourceHash = hash
+		}
+		return sourceHash, nil
+	}
+
+	matches, exists, err := fi.destinationMatches(manifestDir, destPath, sourceFile, getSourceHash)
+	if err != nil {
+		return result, err
+	}
+
+	if matches {
+		return fi.skipExisting(result, destPath, manifestDir, sourceFile, sourceHash), nil
+	}
+
+	if exists {
+		// A different file already exists at the destination, so we need to
+		// decide what to do based on the configured conflict policy
+		policy := getConflictPolicy(fi.config, sourceFile.ProcessorName)
+		result.Conflict = policy
+		result.ConflictWith = destPath
+
+		switch policy {
+		case model.ConflictSkip:
+			slog.Warn(fmt.Sprintf("A different file already exists at '%s', skipping '%s' due to conflict policy", destPath, sourceFile.SourcePath))
+			result.Action = "skipped"
+			return result, nil
+
+		case model.ConflictOverwrite:
+			slog.Warn(fmt.Sprintf("A different file already exists at '%s', it will be overwritten due to conflict policy", destPath))
+
+		case model.ConflictRenameWithSuffix, model.ConflictKeepBothByHash:
+			hash, err := getSourceHash()
+			if err != nil {
+				return result, err
+			}
+
+			newPath, matches, err := fi.findAlternatePath(policy, manifestDir, destPath, sourceFile, hash, getSourceHash)
+			if err != nil {
+				return result, err
+			}
+
+			if matches {
+				return fi.skipExisting(result, newPath, manifestDir, sourceFile, hash), nil
+			}
+
+			slog.Warn(fmt.Sprintf("A different file already exists at '%s', '%s' will be imported to '%s' instead", destPath, sourceFile.SourcePath, newPath))
+			destPath = newPath
+			result.DestPath = newPath
+		}
+	}
+
+	if fi.dryRun {
+		slog.Info(fmt.Sprintf("[Dry run] Would copy '%s' to '%s'", sourceFile.SourcePath, destPath))
+		result.Action = "dry_run"
+		return result, nil
+	}
+
+	releaseSlot, err := acquireCopySlot(fi.ctx, fi.config, destPath)
+	if err != nil {
+		return result, err
+	}
+
+	slog.Info(fmt.Sprintf("Copying '%s' to '%s'", sourceFile.SourcePath, destPath))
+
+	_, hash, err := util.CopyFile(fi.ctx, sourceFile.SourcePath, destPath, func(bytesCopied int64) {
+		fi.progressCallback(sourceFile, bytesCopied, false)
+	})
+	releaseSlot()
+
+	if err != nil {
+		return result, err
+	}
+
+	os.Chtimes(destPath, time.Time{}, sourceFile.FileModTime)
+	fi.manifests.record(manifestDir, destPath, fi.newManifestEntry(sourceFile, hash))
+
+	result.Action = "copied"
+	result.Hash = hash
+	result.Verified = true
+
+	return result, nil
+}
+
+// destinationMatches checks whether the file at destPath is identical to the
+// source file. A file of the same size is only considered identical if its
+// content hash also matches the source. Returns whether the file matches and
+// whether a file exists at the destination at all
+func (fi *fileImporter) destinationMatches(manifestDir string, destPath string, sourceFile model.SourceFile, getSourceHash func() (string, error)) (bool, bool, error) {
+	stat, err := os.Stat(destPath)
+	if err != nil || !stat.Mode().IsRegular() {
+		return false, false, nil
+	}
+
+	if stat.Size() != sourceFile.Size {
+		slog.Debug(fmt.Sprintf("File already exists but is different size at '%s'", destPath))
+		return false, true, nil
+	}
+
+	hash, err := getSourceHash()
+	if err != nil {
+		return false, true, err
+	}
+
+	existingHash := ""
+	if entry, ok := fi.manifests.lookup(manifestDir, destPath); ok && entry.HashAlgorithm == util.HashAlgorithm && entry.Size == sourceFile.Size {
+		existingHash = entry.Hash
+	} else if existingHash, err = util.HashFile(destPath); err != nil {
+		return false, true, err
+	}
+
+	if existingHash != hash {
+		slog.Debug(fmt.Sprintf("File already exists with the same size but a different hash at '%s'", destPath))
+		return false, true, nil
+	}
+
+	return true, true, nil
+}
+
+// findAlternatePath returns the path that a conflicting file should be imported
+// to, based on the provided policy. If an identical copy of the source already
+// exists at the alternate path, the second return value is true
+func (fi *fileImporter) findAlternatePath(policy string, manifestDir string, destPath string, sourceFile model.SourceFile, sourceHash string, getSourceHash func() (string, error)) (string, bool, error) {
+	if policy == model.ConflictKeepBothByHash {
+		candidate := addFileNameSuffix(destPath, sourceHash[:8])
+		matches, _, err := fi.destinationMatches(manifestDir, candidate, sourceFile, getSourceHash)
+		return candidate, matches, err
+	}
+
+	for i := 1; i <= maxConflictSuffix; i++ {
+		candidate := addFileNameSuffix(destPath, fmt.Sprintf("%d", i))
+
+		matches, exists, err := fi.destinationMatches(manifestDir, candidate, sourceFile, getSourceHash)
+		if err != nil {
+			return "", false, err
+		}
+
+		if matches || !exists {
+			return candidate, matches, nil
+		}
+	}
+
+	return "", false, fmt.Errorf("no free file name found for '%s' after %d attempts", destPath, maxConflictSuffix)
+}
+
+func (fi *fileImporter) skipExisting(result model.ImportFileResult, destPath string, manifestDir string, sourceFile model.SourceFile, sourceHash string) model.ImportFileResult {
+	slog.Debug(fmt.Sprintf("Not copying file because the destination already exists with a matching hash at '%s'", destPath))
+
+	if _, ok := fi.manifests.lookup(manifestDir, destPath); !ok {
+		fi.manifests.record(manifestDir, destPath, fi.newManifestEntry(sourceFile, sourceHash))
+	}
+
+	result.DestPath = destPath
+	result.Action = "skipped"
+	result.Hash = sourceHash
+	result.Verified = true
+
+	return result
+}
+
+func (fi *fileImporter) newManifestEntry(sourceFile model.SourceFile, hash string) model.ManifestEntry {
+	return model.ManifestEntry{
+		SourceName:    sourceFile.SourceName,
+		OriginalPath:  sourceFile.SourcePath,
+		Size:          sourceFile.Size,
+		HashAlgorithm: util.HashAlgorithm,
+		Hash:          hash,
+		CaptureTime:   sourceFile.CaptureTime,
+		CaptureDate:   sourceFile.CaptureDate,
+		ImportJobID:   fi.jobID,
+		ImportedAt:    time.Now(),
+	}
+}
+
+// getConflictPolicy returns the conflict policy for the provided processor,
+// falling back to the global policy if the processor doesn't override it
+func getConflictPolicy(config model.ImporterConfig, processorName string) string {
+	if processorConfig, ok := config.Processors[processorName]; ok && processorConfig.ConflictPolicy != "" {
+		return processorConfig.ConflictPolicy
+	}
+
+	return config.ConflictPolicy
+}
+
+// addFileNameSuffix adds the suffix to the file name, before the extension
+// (ex: /path/MVI_0001.MOV -> /path/MVI_0001_1.MOV)
+func addFileNameSuffix(filePath string, suffix string) string {
+	extension := filepath.Ext(filePath)
+	return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(filePath, extension), suffix, extension)
+}
+
+// manifestBatch caches manifests that have been read and collects new entries
+// so that each manifest is only written once per import
+type manifestBatch struct {
+	cache   map[string]model.Manifest
+	updates map[string]map[string]model.ManifestEntry
+}
+
+func newManifestBatch() *manifestBatch {
+	return &manifestBatch{
+		cache:   make(map[string]model.Manifest),
+		updates: make(map[string]map[string]model.ManifestEntry),
+	}
+}
+
+func (mb *manifestBatch) lookup(manifestDir string, destPath string) (model.ManifestEntry, bool) {
+	manifestKey := getManifestKey(manifestDir, destPath)
+
+	if entry, ok := mb.updates[manifestDir][manifestKey]; ok {
+		return entry, true
+	}
+
+	if _, ok := mb.cache[manifestDir]; !ok {
+		manifest, err := util.ReadManifest(manifestDir)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Failed to read manifest in '%s', ignoring it: %s", manifestDir, err.Error()))
+		}
+		mb.cache[manifestDir] = manifest
+	}
+
+	entry, ok := mb.cache[manifestDir].Files[manifestKey]
+	return entry, ok
+}
+
+func (mb *manifestBatch) record(manifestDir string, destPath string, entry model.ManifestEntry) {
+	if _, ok := mb.updates[manifestDir]; !ok {
+		mb.updates[manifestDir] = make(map[string]model.ManifestEntry)
+	}
+
+	mb.updates[manifestDir][getManifestKey(manifestDir, destPath)] = entry
+}
+
+func (mb *manifestBatch) flush() []error {
+	var flushErrors []error
+
+	for manifestDir, entries := range mb.updates {
+		if err := util.UpdateManifest(manifestDir, entries); err != nil {
+			slog.Error(fmt.Sprintf("Failed to write manifest in '%s': %s", manifestDir, err.Error()))
+			flushErrors = append(flushErrors, fmt.Errorf("manifest %s: %w", manifestDir, err))
+		}
+	}
+
+	return flushErrors
+}
+
+// claimDestination waits until no other import is handling destPath and then
+// claims it. The returned function must be called to release the path
+func claimDestination(ctx context.Context, destPath string) (func(), error) {
+	for {
+		destinationMutex.Lock()
+		inUse, ok := destinationsInUse[destPath]
+
+		if !ok {
+			released := make(chan struct{})
+			destinationsInUse[destPath] = released
+			destinationMutex.Unlock()
+
+			return func() {
+				destinationMutex.Lock()
+				delete(destinationsInUse, destPath)
+				destinationMutex.Unlock()
+				close(released)
+			}, nil
+		}
+
+		destinationMutex.Unlock()
+
+		select {
+		case <-inUse:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquireCopySlot waits until fewer than config.DestinationIOLimit files are
+// being copied to the device that holds destPath. The returned function must
+// be called to release the slot
+func acquireCopySlot(ctx context.Context, config model.ImporterConfig, destPath string) (func(), error) {
+	device := util.GetPhysicalDevice(filepath.Dir(destPath))
+	if device == "" {
+		device = config.LiveDataDir
+	}
+
+	destinationMutex.Lock()
+	slots, ok := destinationSlots[device]
+	if !ok {
+		slots = make(chan struct{}, max(config.DestinationIOLimit, 1))
+		destinationSlots[device] = slots
+	}
+	destinationMutex.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// spaceReservation holds the space reserved for the files of a single import
+// that haven't been handled yet, by source path
+type spaceReservation struct {
+	files map[string]int64
+}
+
+// reserveSpace makes sure that config.LiveDataDir has room for the files that
+// still need to be copied, on top of the space reserved by other running
+// imports. Files that are already at their destination with the same size
+// are not counted, so that resumed imports aren't refused
+func reserveSpace(config model.ImporterConfig, files []model.SourceFile) (*spaceReservation, error) {
+	reservation := &spaceReservation{files: make(map[string]int64)}
+
+	var required int64
+	for _, sourceFile := range files {
+		destPath, err := util.GetDestinationPath(config, sourceFile)
+		if err == nil && util.GetFileSize(destPath) == sourceFile.Size {
+			continue
+		}
+
+		reservation.files[sourceFile.SourcePath] += sourceFile.Size
+		required += sourceFile.Size
+	}
+
+	if required == 0 {
+		return reservation, nil
+	}
+
+	spaceMutex.Lock()
+	defer spaceMutex.Unlock()
+
+	if err := util.CheckFreeSpace(config.LiveDataDir, reservedBytes+required, config.FreeSpaceReserveMB*1024*1024); err != nil {
+		return nil, err
+	}
+	reservedBytes += required
+
+	return reservation, nil
+}
+
+// release gives back the space reserved for a file once it has been handled
+func (sr *spaceReservation) release(sourcePath string) {
+	spaceMutex.Lock()
+	defer spaceMutex.Unlock()
+
+	reservedBytes -= sr.files[sourcePath]
+	delete(sr.files, sourcePath)
+}
+
+// releaseAll gives back the space reserved for every file not yet handled
+func (sr *spaceReservation) releaseAll() {
+	spaceMutex.Lock()
+	defer spaceMutex.Unlock()
+
+	for sourcePath, size := range sr.files {
+		reservedBytes -= size
+		delete(sr.files, sourcePath)
+	}
+}
+
+func getManifestKey(manifestDir string, destPath string) string {
+	manifestKey, _ := filepath.Rel(manifestDir, destPath)
+	return filepath.ToSlash(manifestKey)
+}
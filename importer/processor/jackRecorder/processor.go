@@ -25,9 +25,10 @@
 package jackRecorder
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"regexp"
 	"strings"
@@ -47,71 +48,74 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	volumeFormat string
+	volume model.Volume
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "jackRecorder"))
 
-	return &Processor{
-		sourceDir: sourceDir,
-	}
+	return &Processor{}
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// check for jack directory
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
-	if !util.RequireDirs(t.sourceDir, []string{"jack"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
+	if !util.RequireDirs(volume.FS, ".", []string{"jack"}) {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no jack directory"}, nil
 	}
 
 	// check for jack/(\d{4})-(\d{2})-(\d{2})
-	logger.Debug(fmt.Sprintf(`[CheckSource]: Testing for existence of jack/(\d{4})-(\d{2})-(\d{2}) directory in volume '%s'`, t.sourceDir))
-	exists, _ := util.RequireRegexDirMatch(path.Join(t.sourceDir, "jack"), `(\d{4})-(\d{2})-(\d{2})`)
+	logger.Debug(fmt.Sprintf(`[CheckSource]: Testing for existence of jack/(\d{4})-(\d{2})-(\d{2}) directory in volume '%s'`, volume.Path))
+	exists, _ := util.RequireRegexDirMatch(volume.FS, "jack", `(\d{4})-(\d{2})-(\d{2})`)
 	if !exists {
 		logger.Debug(`[CheckSource]: No '/jack/(\d{4})-(\d{2})-(\d{2})/' directory found, disqualified`)
-		return false
+		return model.Detection{Reason: "no jack/yyyy-mm-dd directory"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.MediumConfidence, Reason: "jack/yyyy-mm-dd directories found"}, nil
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
-	return t.scanDirectory(path.Join(t.sourceDir, "jack"), "jack")
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+	return t.scanDirectory(ctx, path.Join(volume.Path, "jack"), "jack")
 }
 
 // private functions
 
-func getCaptureDate(directoryName string) time.Time {
-	zone, _ := time.Now().Zone()
-	dtmStr := fmt.Sprintf("%s %s", strings.TrimSuffix(directoryName[5:15], "/"), zone)
-	dtm, err := time.Parse("2006-01-02 MST", dtmStr)
+// getCaptureTime returns the capture time of the recording, along with false
+// when only the date could be determined. The directory name only carries the
+// date, so the time of day is taken from the modification time when it agrees
+func (t *Processor) getCaptureTime(directoryName string, modTime time.Time) (time.Time, bool) {
+	location := t.volume.TimeLocation()
+	dateStr := strings.TrimSuffix(directoryName[5:15], "/")
+	date, err := time.Parse("2006-01-02", dateStr)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[getCaptureDate]: Failed to parse date '%s': %s", dtmStr, err.Error()))
+		logger.Error(fmt.Sprintf("[getCaptureTime]: Failed to parse date '%s': %s", dateStr, err.Error()))
+		return modTime, true
 	}
 
-	return dtm
+	return util.DateWithTimeOf(date, modTime, location)
 }
 
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
 	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
 	var files []model.SourceFile
 
 	// For this processor, we only care about .wav files
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	for _, entry := range entries {
@@ -119,7 +123,12 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		relativePath := path.Join(relativeDirPath, entry.Name())
 
 		if entry.IsDir() {
-			files = append(files, t.scanDirectory(fullPath, path.Join(relativeDirPath, entry.Name()))...)
+			subdirFiles, err := t.scanDirectory(ctx, fullPath, path.Join(relativeDirPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, subdirFiles...)
 		} else {
 			foundMatch := false
 
@@ -139,18 +148,25 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 					parentName = strings.TrimSuffix(relativeDirPath[16:], "/") + "/"
 				}
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
 				// _, dirName := path.Split(absoluteDirPath)
 
+				modTime := util.VolumeModTime(t.volume, stat.ModTime())
+				captureTime, hasTime := t.getCaptureTime(relativePath, modTime)
+
 				newFile := model.SourceFile{
-					FileName:     parentName + entry.Name(),
-					SourcePath:   fullPath,
-					MediaType:    mediaType,
-					Size:         stat.Size(),
-					SourceName:   "Jack",
-					CaptureDate:  getCaptureDate(relativePath),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					FileName:        parentName + entry.Name(),
+					SourcePath:      fullPath,
+					MediaType:       mediaType,
+					Size:            stat.Size(),
+					SourceName:      "Jack",
+					CaptureTime:     captureTime,
+					CaptureDateOnly: !hasTime,
+					FileModTime:     modTime,
+					VolumeFormat:    t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -158,5 +174,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
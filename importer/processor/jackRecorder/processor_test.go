@@ -0,0 +1,123 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package jackRecorder
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ccmm/model"
+)
+
+var testLocation = time.FixedZone("UTC-6", -6*60*60)
+
+// newTestVolume is a volume holding files with the provided modification
+// times, names ending in "/" are empty directories. It isn't FAT, so the
+// modification times are taken as they are
+func newTestVolume(label string, files map[string]time.Time) model.Volume {
+	fsys := fstest.MapFS{}
+	for name, modTime := range files {
+		if strings.HasSuffix(name, "/") {
+			fsys[strings.TrimSuffix(name, "/")] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: modTime}
+			continue
+		}
+		fsys[name] = &fstest.MapFile{Data: []byte("data"), ModTime: modTime}
+	}
+
+	return model.Volume{Path: "/media/" + label, FS: fsys, Label: label, FsType: "NTFS", Location: testLocation}
+}
+
+func TestCheckSource(t *testing.T) {
+	modTime := time.Date(2024, 3, 15, 9, 30, 0, 0, testLocation)
+
+	tests := []struct {
+		name  string
+		label string
+		files []string
+		want  model.Confidence
+	}{
+		{"recordings", "NO NAME", []string{"jack/2024-03-15/service.wav"}, model.MediumConfidence},
+		{"empty date directory", "RECORDER", []string{"jack/2024-03-15/"}, model.MediumConfidence},
+		{"no jack directory", "NO NAME", []string{"2024-03-15/service.wav"}, model.NotDetected},
+		{"no date directory", "NO NAME", []string{"jack/service.wav"}, model.NotDetected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := make(map[string]time.Time)
+			for _, name := range test.files {
+				files[name] = modTime
+			}
+
+			detection, err := New().CheckSource(context.Background(), newTestVolume(test.label, files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Confidence != test.want {
+				t.Errorf("expected confidence %v, got %v (%s)", test.want, detection.Confidence, detection.Reason)
+			}
+		})
+	}
+}
+
+func TestEnumerateFilesCaptureTime(t *testing.T) {
+	// the directory name only carries the date, the time of day comes from
+	// the modification time unless it is on another day (ex: a file that was
+	// copied to the card later)
+	sameDay := time.Date(2024, 3, 15, 21, 0, 0, 0, testLocation)
+	volume := newTestVolume("NO NAME", map[string]time.Time{
+		"jack/2024-03-15/service.wav":     sameDay,
+		"jack/2024-03-15/band/mic-01.wav": sameDay,
+		"jack/2024-03-16/rehearsal.wav":   sameDay,
+	})
+
+	files, err := New().EnumerateFiles(context.Background(), volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct {
+		captureTime time.Time
+		dateOnly    bool
+	}{
+		"service.wav":     {sameDay, false},
+		"band/mic-01.wav": {sameDay, false},
+		"rehearsal.wav":   {time.Date(2024, 3, 16, 0, 0, 0, 0, testLocation), true},
+	}
+
+	if len(files) != len(want) {
+		t.Fatalf("expected %d files, got %+v", len(want), files)
+	}
+	for _, file := range files {
+		expected := want[file.FileName]
+		if !file.CaptureTime.Equal(expected.captureTime) || file.CaptureDateOnly != expected.dateOnly {
+			t.Errorf("'%s': expected capture time %s (date only %t), got %s (date only %t)", file.FileName, expected.captureTime, expected.dateOnly, file.CaptureTime, file.CaptureDateOnly)
+		}
+	}
+}
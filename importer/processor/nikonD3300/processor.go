@@ -25,13 +25,13 @@
 package nikonD3300
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"regexp"
 	"strings"
-	"time"
 
 	"ccmm/model"
 	"ccmm/util"
@@ -50,52 +50,49 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	sourceName   string
-	volumeFormat string
+	sourceName string
+	volume     model.Volume
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "nikonD3300"))
 
 	return &Processor{
-		sourceDir:  sourceDir,
 		sourceName: "",
 	}
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// verify volume label matches what the camera sets
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", t.sourceDir))
-	if label := util.GetVolumeName(t.sourceDir); label != expectedVolumeName {
-		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", label, expectedVolumeName))
-		return false
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+	if volume.Label != expectedVolumeName {
+		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", volume.Label, expectedVolumeName))
+		return model.Detection{Reason: fmt.Sprintf("volume label '%s' is not '%s'", volume.Label, expectedVolumeName)}, nil
 	}
 
 	// check for /DCIM directories
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
-	if !util.RequireDirs(t.sourceDir, []string{"DCIM"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
+	if !util.RequireDirs(volume.FS, ".", []string{"DCIM"}) {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no DCIM directory"}, nil
 	}
 
 	// check for DCIM/\d+{3}D3300 directory
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of DCIM/xxxD3300 directory in volume '%s'", t.sourceDir))
-	if exists, _ := util.RequireRegexDirMatch(path.Join(t.sourceDir, "DCIM"), `\d{3}D3300`); !exists {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of DCIM/xxxD3300 directory in volume '%s'", volume.Path))
+	if exists, _ := util.RequireRegexDirMatch(volume.FS, "DCIM", `\d{3}D3300`); !exists {
 		logger.Debug("[CheckSource]: No '/DCIM/xxxD3300/' directory found, disqualified")
-		return false
+		return model.Detection{Reason: "no DCIM/xxxD3300 directory"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "volume label and DCIM directories match"}, nil
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
-	return t.scanDirectory(path.Join(t.sourceDir, "DCIM"), "DCIM")
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+	return t.scanDirectory(ctx, path.Join(volume.Path, "DCIM"), "DCIM")
 }
 
 // private functions
@@ -109,7 +106,7 @@ func (t *Processor) getCameraModel(imagePath string) string {
 	}
 
 	logger.Debug(fmt.Sprintf("Reading EXIF data from '%s'", imagePath))
-	imageFile, err := os.Open(imagePath)
+	imageFile, err := t.volume.FS.Open(imagePath)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to open image file: %s", err.Error()))
 		return ""
@@ -135,29 +132,21 @@ func (t *Processor) getCameraModel(imagePath string) string {
 	return camModelName
 }
 
-func (t *Processor) getCaptureDate(dtm time.Time) time.Time {
-	format := "2006-01-02 MST"
-	date, err := time.Parse(format, dtm.Format(format))
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
+	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to parse date, error: %s", err.Error()))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
 
-	return date
-}
-
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
-	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
-
 	var files []model.SourceFile
 
 	// For this processor, we only care about .MXF files and the sidecar XML files
 	// and we read the source name from the sidecar XML
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	for _, entry := range entries {
@@ -165,7 +154,12 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		relativePath := path.Join(relativeDirPath, entry.Name())
 
 		if entry.IsDir() {
-			files = append(files, t.scanDirectory(fullPath, path.Join(relativeDirPath, entry.Name()))...)
+			subdirFiles, err := t.scanDirectory(ctx, fullPath, path.Join(relativeDirPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, subdirFiles...)
 		} else {
 			foundMatch := false
 
@@ -179,7 +173,14 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 			if foundMatch {
 				logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
+
+				// the time of the recording isn't kept anywhere else, but the
+				// device sets the modification time from its own clock
+				modTime := util.VolumeModTime(t.volume, stat.ModTime())
 
 				mediaType := "Photo"
 
@@ -192,10 +193,10 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 					SourcePath:   fullPath,
 					MediaType:    mediaType,
 					Size:         stat.Size(),
-					SourceName:   t.getCameraModel(fullPath),
-					CaptureDate:  t.getCaptureDate(stat.ModTime()),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					SourceName:   t.getCameraModel(relativePath),
+					CaptureTime:  modTime,
+					FileModTime:  modTime,
+					VolumeFormat: t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -203,5 +204,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
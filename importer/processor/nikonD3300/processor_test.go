@@ -0,0 +1,112 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package nikonD3300
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ccmm/model"
+)
+
+var testLocation = time.FixedZone("UTC-6", -6*60*60)
+
+// newTestVolume is a volume holding files with the provided modification
+// times, names ending in "/" are empty directories. It isn't FAT, so the
+// modification times are taken as they are
+func newTestVolume(label string, files map[string]time.Time) model.Volume {
+	fsys := fstest.MapFS{}
+	for name, modTime := range files {
+		if strings.HasSuffix(name, "/") {
+			fsys[strings.TrimSuffix(name, "/")] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: modTime}
+			continue
+		}
+		fsys[name] = &fstest.MapFile{Data: []byte("data"), ModTime: modTime}
+	}
+
+	return model.Volume{Path: "/media/" + label, FS: fsys, Label: label, FsType: "NTFS", Location: testLocation}
+}
+
+func TestCheckSource(t *testing.T) {
+	modTime := time.Date(2024, 3, 15, 9, 30, 0, 0, testLocation)
+
+	tests := []struct {
+		name  string
+		label string
+		files []string
+		want  model.Confidence
+	}{
+		{"photos", "NIKON D3300", []string{"DCIM/100D3300/DSC_0001.NEF"}, model.HighConfidence},
+		{"other label", "NIKON D5300", []string{"DCIM/100D3300/DSC_0001.NEF"}, model.NotDetected},
+		{"no DCIM directory", "NIKON D3300", []string{"100D3300/DSC_0001.NEF"}, model.NotDetected},
+		{"other camera directory", "NIKON D3300", []string{"DCIM/100CANON/IMG_0001.CR2"}, model.NotDetected},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := make(map[string]time.Time)
+			for _, name := range test.files {
+				files[name] = modTime
+			}
+
+			detection, err := New().CheckSource(context.Background(), newTestVolume(test.label, files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detection.Confidence != test.want {
+				t.Errorf("expected confidence %v, got %v (%s)", test.want, detection.Confidence, detection.Reason)
+			}
+		})
+	}
+}
+
+func TestEnumerateFilesCaptureTime(t *testing.T) {
+	// the capture time is the modification time set by the camera, read in
+	// the location of the volume so that late evening photos keep their day
+	modTime := time.Date(2024, 3, 16, 3, 0, 0, 0, time.UTC)
+	volume := newTestVolume("NIKON D3300", map[string]time.Time{
+		"DCIM/100D3300/DSC_0001.NEF": modTime,
+		"DCIM/100D3300/DSC_0002.MOV": modTime,
+		"DCIM/100D3300/DSC_0003.JPG": modTime,
+	})
+
+	files, err := New().EnumerateFiles(context.Background(), volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %+v", files)
+	}
+	for _, file := range files {
+		if !file.CaptureTime.Equal(modTime) || file.CaptureTime.Format(time.DateOnly) != "2024-03-15" {
+			t.Errorf("'%s': expected capture time %s, got %s", file.FileName, modTime.In(testLocation), file.CaptureTime)
+		}
+	}
+}
@@ -25,16 +25,16 @@
 package processor
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
-	"path"
-	"path/filepath"
 	"reflect"
-	"runtime"
 	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"ccmm/importer/processor/behringerX32"
@@ -42,6 +42,7 @@ import (
 	"ccmm/importer/processor/blackmagicIOS"
 	"ccmm/importer/processor/canonEOS"
 	"ccmm/importer/processor/canonXA"
+	"ccmm/importer/processor/generic"
 	"ccmm/importer/processor/jackRecorder"
 	"ccmm/importer/processor/nikonD3300"
 	"ccmm/importer/processor/zoomH1n"
@@ -50,93 +51,232 @@ import (
 	"ccmm/util"
 )
 
+// Processor recognizes the volumes written by one type of device and lists the
+// media files on them. Processors are handed a volume that was already probed,
+// so they don't need to look up its label or format themselves
 type Processor interface {
-	CheckSource() bool
-	EnumerateFiles() []model.SourceFile
+	// CheckSource reports how confident the processor is that it handles the
+	// volume. A volume that doesn't match is reported with a NotDetected
+	// confidence and the reason, an error means the check couldn't be finished
+	CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error)
+
+	// EnumerateFiles lists the media files on the volume. An error means that
+	// the list is incomplete, files that can't be used are skipped instead
+	EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error)
 }
 
-func useProcessor(enabledProcessors []string, name string) bool {
-	return len(enabledProcessors) == 0 || slices.Contains(enabledProcessors, name)
+// ProcessorFactory creates a new instance of a processor. An instance is only
+// used for a single import job
+type ProcessorFactory func() Processor
+
+// namedProcessor is implemented by processors whose name can't be derived
+// from their package name (ex: processors loaded from YAML definitions)
+type namedProcessor interface {
+	Name() string
 }
 
-func InitProcessors(enabledProcessors []string, volumePath string) []Processor {
-	processors := []Processor{}
+// byConfidence sorts processors along with their diagnostics, most confident first
+type byConfidence struct {
+	processors  []Processor
+	diagnostics []model.ProcessorDiagnostic
+}
 
-	// I hate this. it hurts me. this should be in a map that is referenced and
-	// then dynamically creates a new instance using reflection, but i'm still
-	// new and couldn't figure it out quickly in go land, so i decided to go this
-	// route for now. gross, but works.
+func (b byConfidence) Len() int { return len(b.processors) }
+func (b byConfidence) Less(i, j int) bool {
+	return b.diagnostics[i].Confidence > b.diagnostics[j].Confidence
+}
+func (b byConfidence) Swap(i, j int) {
+	b.processors[i], b.processors[j] = b.processors[j], b.processors[i]
+	b.diagnostics[i], b.diagnostics[j] = b.diagnostics[j], b.diagnostics[i]
+}
 
-	if useProcessor(enabledProcessors, "behringerX32") {
-		processors = append(processors, behringerX32.New(volumePath))
-	}
+// controlFileProcessor is implemented by processors for devices that keep
+// control files on the volume (ex: a catalog or index) that must survive a
+// post-import action in order for the device to keep recognizing the card
+type controlFileProcessor interface {
+	ControlFiles() []string
+}
 
-	if useProcessor(enabledProcessors, "behringerXLIVE") {
-		processors = append(processors, behringerXLIVE.New(volumePath))
+var (
+	processorRegistry = map[string]ProcessorFactory{
+		"behringerX32":   func() Processor { return behringerX32.New() },
+		"behringerXLIVE": func() Processor { return behringerXLIVE.New() },
+		"blackmagicIOS":  func() Processor { return blackmagicIOS.New() },
+		"canonEOS":       func() Processor { return canonEOS.New() },
+		"canonXA":        func() Processor { return canonXA.New() },
+		"jackRecorder":   func() Processor { return jackRecorder.New() },
+		"nikonD3300":     func() Processor { return nikonD3300.New() },
+		"zoomH1n":        func() Processor { return zoomH1n.New() },
+		"zoomH6":         func() Processor { return zoomH6.New() },
 	}
+	registryMutex sync.RWMutex
+)
 
-	if useProcessor(enabledProcessors, "blackmagicIOS") {
-		processors = append(processors, blackmagicIOS.New(volumePath))
-	}
+// RegisterProcessor adds a processor to the registry under the provided name.
+// An error is returned if a processor with that name is already registered
+func RegisterProcessor(name string, factory ProcessorFactory) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
 
-	if useProcessor(enabledProcessors, "canonEOS") {
-		processors = append(processors, canonEOS.New(volumePath))
+	if _, exists := processorRegistry[name]; exists {
+		return fmt.Errorf("a processor named '%s' is already registered", name)
 	}
 
-	if useProcessor(enabledProcessors, "canonXA") {
-		processors = append(processors, canonXA.New(volumePath))
-	}
+	processorRegistry[name] = factory
+	return nil
+}
 
-	if useProcessor(enabledProcessors, "jackRecorder") {
-		processors = append(processors, jackRecorder.New(volumePath))
+// LoadProcessorDefinitions reads every YAML processor definition in the provided
+// directory and registers a generic processor for each of them
+func LoadProcessorDefinitions(definitionsDir string) error {
+	definitions, err := generic.LoadDefinitions(definitionsDir)
+	if err != nil {
+		return err
 	}
 
-	if useProcessor(enabledProcessors, "nikonD3300") {
-		processors = append(processors, nikonD3300.New(volumePath))
+	for _, definition := range definitions {
+		definition := definition
+
+		err := RegisterProcessor(definition.Name, func() Processor {
+			return generic.New(definition)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		slog.Info(fmt.Sprintf("processor.LoadProcessorDefinitions: Registered processor '%s' from definition", definition.Name))
 	}
 
-	if useProcessor(enabledProcessors, "zoomH1n") {
-		processors = append(processors, zoomH1n.New(volumePath))
+	return nil
+}
+
+// GetProcessorNames returns the sorted names of all registered processors
+func GetProcessorNames() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(processorRegistry))
+	for name := range processorRegistry {
+		names = append(names, name)
 	}
 
-	if useProcessor(enabledProcessors, "zoomH6") {
-		processors = append(processors, zoomH6.New(volumePath))
+	sort.Strings(names)
+
+	return names
+}
+
+func useProcessor(enabledProcessors []string, name string) bool {
+	return len(enabledProcessors) == 0 || slices.Contains(enabledProcessors, name)
+}
+
+func InitProcessors(enabledProcessors []string) []Processor {
+	processors := []Processor{}
+
+	for _, name := range GetProcessorNames() {
+		if !useProcessor(enabledProcessors, name) {
+			continue
+		}
+
+		registryMutex.RLock()
+		factory := processorRegistry[name]
+		registryMutex.RUnlock()
+
+		processors = append(processors, factory())
 	}
 
 	return processors
 }
 
-func FindProcessors(config model.ImporterConfig, volumePath string) []Processor {
-	slog.Info(fmt.Sprintf("processor.FindProcessors: Looking for processors to handle path '%s'", volumePath))
-	processors := InitProcessors(config.EnabledProcessors, volumePath)
-	var foundProcessors []Processor
-
-	for _, processor := range processors {
-		if processor.CheckSource() {
-			foundProcessors = append(foundProcessors, processor)
+// FindProcessors checks the volume against every enabled processor and returns
+// the processors that detected it, most confident first. A diagnostic is
+// returned for every enabled processor, in the same order, whether it
+// detected the volume or not
+func FindProcessors(ctx context.Context, config model.ImporterConfig, volume model.Volume) ([]Processor, []model.ProcessorDiagnostic) {
+	slog.Info(fmt.Sprintf("processor.FindProcessors: Looking for processors to handle path '%s'", volume.Path))
+	processors := InitProcessors(config.EnabledProcessors)
+	diagnostics := make([]model.ProcessorDiagnostic, len(processors))
+
+	for idx, processor := range processors {
+		diagnostics[idx].Processor = GetProcessorName(processor)
+
+		detection, err := processor.CheckSource(ctx, volume)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("processor.FindProcessors: Processor '%s' failed to check path '%s': %s", diagnostics[idx].Processor, volume.Path, err.Error()))
+			diagnostics[idx].Error = err.Error()
+			detection.Confidence = model.NotDetected
 		}
+
+		diagnostics[idx].Detection = detection
 	}
 
-	if len(foundProcessors) == 0 {
-		slog.Warn(fmt.Sprintf("processor.FindProcessors: No processor found for volume path '%s', skipping", volumePath))
-		return nil
+	sort.Stable(byConfidence{processors, diagnostics})
+
+	var foundProcessors []Processor
+	for idx, processor := range processors {
+		if diagnostics[idx].Confidence == model.NotDetected {
+			break
+		}
+
+		slog.Info(fmt.Sprintf("processor.FindProcessors: Found processor '%s' to handle path '%s'", diagnostics[idx].Processor, volume.Path),
+			slog.Int("Confidence", int(diagnostics[idx].Confidence)), slog.String("Reason", diagnostics[idx].Reason))
+		foundProcessors = append(foundProcessors, processor)
 	}
 
-	for _, processor := range foundProcessors {
-		processorName := strings.Split(reflect.TypeOf(processor).String(), ".")[0][1:]
-		slog.Info(fmt.Sprintf("processor.FindProcessors: Found processor '%s' to handle path '%s'", processorName, volumePath))
+	if len(foundProcessors) == 0 {
+		slog.Warn(fmt.Sprintf("processor.FindProcessors: No processor found for volume path '%s', skipping", volume.Path))
 	}
 
-	return foundProcessors
+	return foundProcessors, diagnostics
 }
 
-func EnumerateSources(processors []Processor, dump bool) []model.SourceFile {
+// EnumerateSources lists the files found on the volume by each of the provided
+// processors, which are expected to be ordered by confidence as returned by
+// FindProcessors. If more than one processor finds the same file, it is left
+// to the first one. The diagnostics of the processors are updated with the
+// number of files found and any error.
+//
+// Processors read the wall clock times recorded by devices in the configured
+// site timezone. The capture time of every file is then corrected for the
+// clock offset configured for its processor, and the service date is derived
+// from the corrected capture time.
+//
+// An error is returned if any processor failed to list its files, as the list
+// of files would be incomplete
+func EnumerateSources(ctx context.Context, config model.ImporterConfig, processors []Processor, volume model.Volume, diagnostics []model.ProcessorDiagnostic, dump bool) ([]model.SourceFile, error) {
+	location := util.GetSiteLocation(config)
+	volume.Location = location
+
 	var allFiles []model.SourceFile
+	var enumerateErrors []error
+	claimed := make(map[string]bool)
 
 	for _, processor := range processors {
-		processorFiles := processor.EnumerateFiles()
+		processorName := GetProcessorName(processor)
+		diagnostic := &model.ProcessorDiagnostic{}
+		if idx := slices.IndexFunc(diagnostics, func(d model.ProcessorDiagnostic) bool { return d.Processor == processorName }); idx >= 0 {
+			diagnostic = &diagnostics[idx]
+		}
+
+		processorFiles, err := processor.EnumerateFiles(ctx, volume)
+		if err != nil {
+			slog.Error(fmt.Sprintf("processor.EnumerateSources: Processor '%s' failed to list the files on '%s': %s", processorName, volume.Path, err.Error()))
+			diagnostic.Error = err.Error()
+			enumerateErrors = append(enumerateErrors, fmt.Errorf("processor '%s': %w", processorName, err))
+			continue
+		}
 
-		allFiles = append(allFiles, processorFiles...)
+		for _, file := range processorFiles {
+			if claimed[file.SourcePath] {
+				diagnostic.Duplicates++
+				continue
+			}
+
+			claimed[file.SourcePath] = true
+			file.ProcessorName = processorName
+			allFiles = append(allFiles, file)
+			diagnostic.Files++
+		}
 	}
 
 	for idx := range allFiles {
@@ -149,11 +289,16 @@ func EnumerateSources(processors []Processor, dump bool) []model.SourceFile {
 		//   - files older than a certain period
 		//   - other stuff?
 
-		// this is to fix how linux handles file mod time on xfat/exfat devices
-		if runtime.GOOS == "linux" && time.Local != time.UTC && (file.VolumeFormat == util.FAT32 || file.VolumeFormat == util.ExFAT) {
-			patchedDtm, _ := time.ParseInLocation(time.DateTime, file.FileModTime.UTC().Format(time.DateTime), time.Local)
-			file.FileModTime = patchedDtm
+		if file.CaptureTime.IsZero() {
+			continue
+		}
+
+		if offset := config.Processors[file.ProcessorName].ClockOffset; offset != 0 && !file.CaptureDateOnly {
+			file.CaptureTime = file.CaptureTime.Add(-time.Duration(offset) * time.Second)
 		}
+
+		file.CaptureTime = file.CaptureTime.In(location)
+		file.CaptureDate = util.GetServiceDate(file.CaptureTime, location)
 	}
 
 	if dump {
@@ -161,44 +306,32 @@ func EnumerateSources(processors []Processor, dump bool) []model.SourceFile {
 		fmt.Println(string(j))
 	}
 
-	return allFiles
+	return allFiles, errors.Join(enumerateErrors...)
 }
 
-func ImportFiles(config model.ImporterConfig, files []model.SourceFile, dryRun bool) {
-	for _, sourceFile := range files {
-		destPath := path.Join(util.GetDestinationDirectory(config.LiveDataDir, sourceFile), sourceFile.FileName)
-
-		// Create the dir and parents, if needed
-		if !dryRun {
-			destDir := filepath.Dir(destPath)
-			os.MkdirAll(destDir, 0755)
-		}
-
-		stat, err := os.Stat(destPath)
-		fileExists := err == nil && stat.Mode().IsRegular()
-		sameSize := false
-		if stat != nil {
-			sameSize = stat.Size() == sourceFile.Size
-		}
-
-		if fileExists && sameSize {
-			slog.Debug(fmt.Sprintf("Not copying file because the destination already exists and is same size at '%s'", destPath))
-			continue
-		}
+// GetProcessorName returns the short name of the provided processor (ex: canonEOS)
+func GetProcessorName(processor Processor) string {
+	if named, ok := processor.(namedProcessor); ok {
+		return named.Name()
+	}
 
-		if fileExists && !sameSize {
-			slog.Debug(fmt.Sprintf("File already exists but is different size, will copy to '%s'", destPath))
-		}
+	return strings.Split(reflect.TypeOf(processor).String(), ".")[0][1:]
+}
 
-		if dryRun {
-			slog.Info(fmt.Sprintf("[Dry run] Would copy '%s' to '%s'", sourceFile.SourcePath, destPath))
-		} else {
-			slog.Info(fmt.Sprintf("Copying '%s' to '%s'", sourceFile.SourcePath, destPath))
+// GetControlFiles returns the regex patterns, relative to the root of the volume,
+// of the control files for the provided processor. This includes the patterns
+// provided by the processor itself as well as any configured in control_files
+// for the processor
+func GetControlFiles(config model.ImporterConfig, processor Processor) []string {
+	patterns := make([]string, 0)
 
-			// TODO: find a way to add transfer speeds to this
-			util.CopyFile(sourceFile.SourcePath, destPath)
-		}
+	if controlFiles, ok := processor.(controlFileProcessor); ok {
+		patterns = append(patterns, controlFiles.ControlFiles()...)
+	}
 
-		os.Chtimes(destPath, time.Time{}, sourceFile.FileModTime)
+	if processorConfig, ok := config.Processors[GetProcessorName(processor)]; ok {
+		patterns = append(patterns, processorConfig.ControlFiles...)
 	}
+
+	return patterns
 }
@@ -0,0 +1,153 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package processor
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ccmm/importer/processor/jackRecorder"
+	"ccmm/importer/processor/zoomH6"
+	"ccmm/model"
+)
+
+// newFixtureVolume is a volume holding files with the provided modification
+// times. It isn't FAT, so the modification times are taken as they are
+func newFixtureVolume(label string, files map[string]time.Time) model.Volume {
+	fsys := fstest.MapFS{}
+	for name, modTime := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte("data"), ModTime: modTime}
+	}
+
+	return model.Volume{Path: "/media/" + label, FS: fsys, Label: label, FsType: "NTFS"}
+}
+
+func TestEnumerateSourcesCaptureTime(t *testing.T) {
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		t.Skipf("timezone is not available: %s", err)
+	}
+
+	config := model.DefaultImporterConfig
+	config.SiteTimezone = "America/New_York"
+	config.Processors = map[string]model.ProcessorConfig{
+		"jackRecorder": {ClockOffset: 120},
+		"zoomH6":       {ClockOffset: -3600},
+	}
+
+	jackVolume := newFixtureVolume("JACK", map[string]time.Time{
+		// the recording of the day, and one copied on a later day
+		"jack/2024-03-10/set1/track01.wav": time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC),
+		"jack/2024-03-10/set1/track02.wav": time.Date(2024, 3, 12, 15, 0, 0, 0, time.UTC),
+	})
+
+	// the project starts in the hour skipped when daylight saving time starts
+	zoomVolume := newFixtureVolume("H6_SD", map[string]time.Time{
+		"FOLDER01/ZOOM0001/240310-023000.hprj":   time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC),
+		"FOLDER01/ZOOM0001/ZOOM0001_Tr1.WAV":     time.Date(2024, 3, 10, 8, 30, 0, 0, time.UTC),
+		"FOLDER01/ZOOM0001/ZOOM0001_LR-0001.WAV": time.Date(2024, 3, 10, 8, 30, 0, 0, time.UTC),
+	})
+
+	tests := []struct {
+		name      string
+		processor Processor
+		volume    model.Volume
+		want      map[string]string
+	}{
+		{
+			name:      "jack recorder",
+			processor: jackRecorder.New(),
+			volume:    jackVolume,
+			want: map[string]string{
+				// two minutes fast
+				"set1/track01.wav": "2024-03-10T10:58:00-04:00",
+				// only the date is known, which the offset doesn't apply to
+				"set1/track02.wav": "2024-03-10T00:00:00-05:00",
+			},
+		},
+		{
+			name:      "zoom H6",
+			processor: zoomH6.New(),
+			volume:    zoomVolume,
+			want: map[string]string{
+				// an hour slow, after moving 02:30 forward to 03:30
+				"ZOOM0001_Tr1.WAV":     "2024-03-10T04:30:00-04:00",
+				"ZOOM0001_LR-0001.WAV": "2024-03-10T04:30:00-04:00",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := EnumerateSources(context.Background(), config, []Processor{test.processor}, test.volume, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(files) != len(test.want) {
+				t.Fatalf("expected %d files, got %+v", len(test.want), files)
+			}
+
+			for _, file := range files {
+				want, found := test.want[file.FileName]
+				if !found {
+					t.Errorf("unexpected file '%s'", file.FileName)
+					continue
+				}
+
+				if got := file.CaptureTime.Format(time.RFC3339); got != want {
+					t.Errorf("'%s': expected capture time %s, got %s", file.FileName, want, got)
+				}
+				if got := file.CaptureDate.Format(time.DateOnly); got != "2024-03-10" {
+					t.Errorf("'%s': expected service date 2024-03-10, got %s", file.FileName, got)
+				}
+			}
+		})
+	}
+}
+
+func TestEnumerateSourcesServiceDateAtSkippedMidnight(t *testing.T) {
+	if _, err := time.LoadLocation("America/Santiago"); err != nil {
+		t.Skipf("timezone is not available: %s", err)
+	}
+
+	config := model.DefaultImporterConfig
+	config.SiteTimezone = "America/Santiago"
+
+	// daylight saving time starts at midnight, so the day starts at 01:00
+	volume := newFixtureVolume("JACK", map[string]time.Time{
+		"jack/2024-09-08/set1/track01.wav": time.Date(2024, 9, 10, 15, 0, 0, 0, time.UTC),
+	})
+
+	files, err := EnumerateSources(context.Background(), config, []Processor{jackRecorder.New()}, volume, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].CaptureDate.Format(time.DateOnly) != "2024-09-08" {
+		t.Errorf("expected a service date of 2024-09-08, got %+v", files)
+	}
+}
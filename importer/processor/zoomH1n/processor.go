@@ -25,12 +25,12 @@
 package zoomH1n
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"regexp"
-	"time"
 
 	"ccmm/model"
 	"ccmm/util"
@@ -46,20 +46,17 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	sourceName   string
-	volumeFormat string
-	fileRegexes  []regexp.Regexp
+	sourceName  string
+	volume      model.Volume
+	fileRegexes []regexp.Regexp
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "zoomH1n"))
 
 	processor := &Processor{
-		sourceDir:    sourceDir,
-		sourceName:   "",
-		volumeFormat: "",
-		fileRegexes:  make([]regexp.Regexp, 0),
+		sourceName:  "",
+		fileRegexes: make([]regexp.Regexp, 0),
 	}
 
 	for _, pattern := range fileMatchPatterns {
@@ -76,67 +73,58 @@ func New(sourceDir string) *Processor {
 	return processor
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// verify volume label matches what the recorder sets
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", t.sourceDir))
-	if label := util.GetVolumeName(t.sourceDir); label != expectedVolumeName {
-		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", label, expectedVolumeName))
-		return false
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+	if volume.Label != expectedVolumeName {
+		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", volume.Label, expectedVolumeName))
+		return model.Detection{Reason: fmt.Sprintf("volume label '%s' is not '%s'", volume.Label, expectedVolumeName)}, nil
 	}
 
 	// check for /STEREO directory
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
-	if !util.RequireDirs(t.sourceDir, []string{"STEREO"}) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
+	if !util.RequireDirs(volume.FS, ".", []string{"STEREO"}) {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no STEREO directory"}, nil
 	}
 
 	// check for /STEREO/FOLDERxx directories
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
 
-	if exists, _ := util.RequireRegexDirMatch(path.Join(t.sourceDir, "STEREO"), `FOLDER\d{2}`); !exists {
+	if exists, _ := util.RequireRegexDirMatch(volume.FS, "STEREO", `FOLDER\d{2}`); !exists {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no STEREO/FOLDERxx directory"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "volume label and STEREO directories match"}, nil
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
 	// TODO: does this thing use a dir other than STEREO, perhaps if recording in dual mono?
-	return t.scanDirectory(path.Join(t.sourceDir, "STEREO"), "STEREO")
+	t.volume = volume
+	return t.scanDirectory(ctx, path.Join(volume.Path, "STEREO"), "STEREO")
 }
 
 // private functions
-func (t *Processor) getCaptureDate(dtm time.Time) time.Time {
-	format := "2006-01-02 MST"
-	date, err := time.Parse(format, dtm.Format(format))
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
+	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to parse date, error: %s", err.Error()))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
 
-	return date
-}
-
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
-	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
-
 	var files []model.SourceFile
 
 	// For this processor, we only care about .MXF files and the sidecar XML files
 	// and we read the source name from the sidecar XML
 	// TODO: Create a shared ReadDir that includes global filtering but mimmics the API of os.ReadDir
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	folderRegex, _ := regexp.Compile(`FOLDER\d{2}`)
@@ -154,7 +142,12 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 				}
 			}
 
-			files = append(files, t.scanDirectory(fullPath, path.Join(relativeDirPath, entry.Name()))...)
+			subdirFiles, err := t.scanDirectory(ctx, fullPath, path.Join(relativeDirPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, subdirFiles...)
 		} else {
 			foundMatch := false
 
@@ -168,7 +161,14 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 			if foundMatch {
 				logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
+
+				// the time of the recording isn't kept anywhere else, but the
+				// device sets the modification time from its own clock
+				modTime := util.VolumeModTime(t.volume, stat.ModTime())
 
 				newFile := model.SourceFile{
 					FileName:     entry.Name(),
@@ -176,9 +176,9 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 					MediaType:    "Audio",
 					Size:         stat.Size(),
 					SourceName:   "Zoom H6",
-					CaptureDate:  t.getCaptureDate(stat.ModTime()),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					CaptureTime:  modTime,
+					FileModTime:  modTime,
+					VolumeFormat: t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -186,5 +186,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
@@ -25,9 +25,11 @@
 package zoomH6
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -37,7 +39,12 @@ import (
 	"ccmm/util"
 )
 
-const expectedVolumeName = "H6_SD"
+const (
+	expectedVolumeName = "H6_SD"
+
+	// projectFilePattern matches the name of the yymmdd-hhmmss.hprj project file
+	projectFilePattern = `^\d{6}-\d{6}\.hprj$`
+)
 
 var (
 	fileMatchPatterns = [...]string{
@@ -47,20 +54,17 @@ var (
 )
 
 type Processor struct {
-	sourceDir    string
-	sourceName   string
-	volumeFormat string
-	fileRegexes  []regexp.Regexp
+	sourceName  string
+	volume      model.Volume
+	fileRegexes []regexp.Regexp
 }
 
-func New(sourceDir string) *Processor {
+func New() *Processor {
 	logger = slog.Default().With(slog.String("processor", "zoomH6"))
 
 	processor := &Processor{
-		sourceDir:    sourceDir,
-		sourceName:   "",
-		volumeFormat: "",
-		fileRegexes:  make([]regexp.Regexp, 0),
+		sourceName:  "",
+		fileRegexes: make([]regexp.Regexp, 0),
 	}
 
 	for _, pattern := range fileMatchPatterns {
@@ -77,83 +81,95 @@ func New(sourceDir string) *Processor {
 	return processor
 }
 
-func (t *Processor) CheckSource() bool {
-	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", t.sourceDir))
-
-	t.volumeFormat = util.GetVolumeFormat(t.sourceDir)
+func (t *Processor) CheckSource(ctx context.Context, volume model.Volume) (model.Detection, error) {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Beginning to test volume compatibility for '%s'", volume.Path))
 
 	// verify volume label matches what the recorder sets
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", t.sourceDir))
-	if label := util.GetVolumeName(t.sourceDir); label != expectedVolumeName {
-		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", label, expectedVolumeName))
-		return false
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing volume name at '%s'", volume.Path))
+	if volume.Label != expectedVolumeName {
+		logger.Debug(fmt.Sprintf("[CheckSource]: Volume label '%s' does not match required '%s' value, disqualified", volume.Label, expectedVolumeName))
+		return model.Detection{Reason: fmt.Sprintf("volume label '%s' is not '%s'", volume.Label, expectedVolumeName)}, nil
 	}
 
 	// check for /FOLDERxx directories
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", t.sourceDir))
-	exists, folderPath := util.RequireRegexDirMatch(t.sourceDir, `FOLDER\d{2}`)
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for required directories for volume '%s'", volume.Path))
+	exists, folderPath := util.RequireRegexDirMatch(volume.FS, ".", `FOLDER\d{2}`)
 	if !exists {
 		logger.Debug("[CheckSource]: One or more required directories does not exist on source, disqualified")
-		return false
+		return model.Detection{Reason: "no FOLDERxx directory"}, nil
 	}
 
 	// check for FOLDERxx/ZOOMxxxx directory
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of FOLDERxx/ZOOMxxxx directory in volume '%s'", t.sourceDir))
-	exists, folderPath = util.RequireRegexDirMatch(folderPath, `ZOOM\d{4}`)
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of FOLDERxx/ZOOMxxxx directory in volume '%s'", volume.Path))
+	exists, folderPath = util.RequireRegexDirMatch(volume.FS, folderPath, `ZOOM\d{4}`)
 	if !exists {
 		logger.Debug("[CheckSource]: No '/FOLDERxx/ZOOMxxxx' directory found, disqualified")
-		return false
+		return model.Detection{Reason: "no FOLDERxx/ZOOMxxxx directory"}, nil
 	}
 
 	// check for FOLDERxx/ZOOMxxxx/xxxxxx-xxxxxx.hprj file
-	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of FOLDERxx/ZOOMxxxx/xxxxxx-xxxxxx.hprj file in volume '%s'", t.sourceDir))
-	if exists, _ := util.RequireRegexFileMatch(folderPath, `\d{6}-\d{6}.hprj`); !exists {
+	logger.Debug(fmt.Sprintf("[CheckSource]: Testing for existence of FOLDERxx/ZOOMxxxx/xxxxxx-xxxxxx.hprj file in volume '%s'", volume.Path))
+	if exists, _ := util.RequireRegexFileMatch(volume.FS, folderPath, projectFilePattern); !exists {
 		logger.Debug("[CheckSource]: No '/FOLDERxx/ZOOMxxxx/xxxxxx-xxxxxx.hprj' file found, disqualified")
-		return false
+		return model.Detection{Reason: "no FOLDERxx/ZOOMxxxx/xxxxxx-xxxxxx.hprj project file"}, nil
 	}
 
-	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", t.sourceDir))
-	return true
+	logger.Debug(fmt.Sprintf("[CheckSource]: Volume '%s' is compatible", volume.Path))
+	return model.Detection{Confidence: model.HighConfidence, Reason: "volume label and project files match"}, nil
 }
 
-func (t *Processor) EnumerateFiles() []model.SourceFile {
-	return t.scanDirectory(t.sourceDir, "")
+// ControlFiles returns patterns matching the files that the device needs in
+// order to recognize the card, which is the project file in each ZOOMxxxx directory
+func (t *Processor) ControlFiles() []string {
+	return []string{
+		`^FOLDER\d{2}/ZOOM\d{4}/\d{6}-\d{6}\.hprj$`,
+	}
+}
+
+func (t *Processor) EnumerateFiles(ctx context.Context, volume model.Volume) ([]model.SourceFile, error) {
+	t.volume = volume
+	return t.scanDirectory(ctx, volume.Path, ".")
 }
 
 // private functions
-func (t *Processor) getCaptureDate(captureDirectory string) time.Time {
-	exists, sidecarFile := util.RequireRegexFileMatch(captureDirectory, `\d{6}-\d{6}.hprj`)
+
+// getCaptureTime reads the date and time from the name of the project file
+// (yymmdd-hhmmss.hprj) that the recorder writes next to the recordings of
+// every project
+func (t *Processor) getCaptureTime(captureDirectory string) (time.Time, error) {
+	exists, sidecarFile := util.RequireRegexFileMatch(t.volume.FS, captureDirectory, projectFilePattern)
 
 	if !exists {
-		panic("We should never make it here")
+		return time.Time{}, &model.ProcessorError{Kind: model.ErrUnexpectedLayout, Path: captureDirectory, Err: errors.New("no xxxxxx-xxxxxx.hprj project file")}
 	}
 
 	basename := filepath.Base(sidecarFile)
 
-	format := "060102 MST"
-	zone, _ := time.Now().Zone()
-	date, err := time.Parse(format, fmt.Sprintf("%s %s", basename[0:6], zone))
+	dtm, err := util.ParseWallClock("060102-150405", basename[0:13], t.volume.TimeLocation())
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to parse date, error: %s", err.Error()))
+		return time.Time{}, &model.ProcessorError{Kind: model.ErrUnexpectedLayout, Path: sidecarFile, Err: fmt.Errorf("project file name is not a valid date: %w", err)}
 	}
 
-	return date
+	return dtm, nil
 }
 
-func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string) []model.SourceFile {
+func (t *Processor) scanDirectory(ctx context.Context, absoluteDirPath string, relativeDirPath string) ([]model.SourceFile, error) {
 	logger.Debug(fmt.Sprintf("[scanDirectory]: Scanning for source files at path '%s'", absoluteDirPath))
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
 	var files []model.SourceFile
 
 	// For this processor, we only care about .MXF files and the sidecar XML files
 	// and we read the source name from the sidecar XML
 	// TODO: Create a shared ReadDir that includes global filtering but mimmics the API of os.ReadDir
-	entries, err := os.ReadDir(absoluteDirPath)
+	entries, err := fs.ReadDir(t.volume.FS, relativeDirPath)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("[scanDirectory]: Error occured while scanning directory '%s': %s", absoluteDirPath, err.Error()))
-		return nil
+		return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: absoluteDirPath, Err: err}
 	}
 
 	folderRegex, _ := regexp.Compile(`FOLDER\d{2}`)
@@ -164,14 +180,19 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 
 		if entry.IsDir() {
 			// only ascend into known top-level directories named FOLDERxx
-			if relativeDirPath == "" {
+			if relativeDirPath == "." {
 				if !folderRegex.MatchString(entry.Name()) {
 					slog.Debug("Skipping unknown top-level directory: " + entry.Name())
 					continue
 				}
 			}
 
-			files = append(files, t.scanDirectory(fullPath, path.Join(relativeDirPath, entry.Name()))...)
+			subdirFiles, err := t.scanDirectory(ctx, fullPath, path.Join(relativeDirPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, subdirFiles...)
 		} else {
 			foundMatch := false
 
@@ -185,7 +206,15 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 			if foundMatch {
 				logger.Debug(fmt.Sprintf("[scanDirectory]: Matched file '%s'", fullPath))
 
-				stat, _ := os.Stat(fullPath)
+				stat, err := fs.Stat(t.volume.FS, relativePath)
+				if err != nil {
+					return nil, &model.ProcessorError{Kind: model.ErrVolumeUnreadable, Path: fullPath, Err: err}
+				}
+
+				captureTime, err := t.getCaptureTime(relativeDirPath)
+				if err != nil {
+					return nil, err
+				}
 
 				newFile := model.SourceFile{
 					FileName:     entry.Name(),
@@ -193,9 +222,9 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 					MediaType:    "Audio",
 					Size:         stat.Size(),
 					SourceName:   "Zoom H6",
-					CaptureDate:  t.getCaptureDate(absoluteDirPath),
-					FileModTime:  stat.ModTime(),
-					VolumeFormat: t.volumeFormat,
+					CaptureTime:  captureTime,
+					FileModTime:  util.VolumeModTime(t.volume, stat.ModTime()),
+					VolumeFormat: t.volume.FsType,
 				}
 
 				files = append(files, newFile)
@@ -203,5 +232,5 @@ func (t *Processor) scanDirectory(absoluteDirPath string, relativeDirPath string
 		}
 	}
 
-	return files
+	return files, nil
 }
@@ -0,0 +1,106 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package zoomH6
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"ccmm/model"
+)
+
+func newTestVolume(files ...string) model.Volume {
+	fsys := fstest.MapFS{}
+	for _, name := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte("data"), ModTime: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	}
+
+	return model.Volume{Path: "/media/H6_SD", FS: fsys, Label: expectedVolumeName, FsType: "FAT32", Location: time.UTC}
+}
+
+func TestEnumerateFilesCaptureTime(t *testing.T) {
+	volume := newTestVolume(
+		"FOLDER01/ZOOM0001/240315-093000.hprj",
+		"FOLDER01/ZOOM0001/ZOOM0001_Tr1.WAV",
+	)
+
+	files, err := New().EnumerateFiles(context.Background(), volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC)
+	if len(files) != 1 || !files[0].CaptureTime.Equal(want) {
+		t.Errorf("expected one file captured at %s, got %+v", want, files)
+	}
+}
+
+func TestEnumerateFilesUnexpectedProjectFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		projectFile string
+	}{
+		{"invalid date", "FOLDER01/ZOOM0001/241399-250000.hprj"},
+		{"unanchored name", "FOLDER01/ZOOM0001/x240315-093000.hprj"},
+		{"other extension", "FOLDER01/ZOOM0001/240315-093000.hprj.bak"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			volume := newTestVolume(test.projectFile, "FOLDER01/ZOOM0001/ZOOM0001_Tr1.WAV")
+
+			_, err := New().EnumerateFiles(context.Background(), volume)
+
+			var processorError *model.ProcessorError
+			if !errors.As(err, &processorError) || processorError.Kind != model.ErrUnexpectedLayout {
+				t.Errorf("expected an unexpected layout error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckSourceRequiresProjectFile(t *testing.T) {
+	tests := []struct {
+		projectFile string
+		want        bool
+	}{
+		{"FOLDER01/ZOOM0001/240315-093000.hprj", true},
+		{"FOLDER01/ZOOM0001/240315-093000.hprj.bak", false},
+		{"FOLDER01/ZOOM0001/240315-093000xhprj", false},
+	}
+
+	for _, test := range tests {
+		detection, err := New().CheckSource(context.Background(), newTestVolume(test.projectFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched := detection.Confidence == model.HighConfidence; matched != test.want {
+			t.Errorf("'%s': matched %t, want %t (%s)", test.projectFile, matched, test.want, detection.Reason)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"runtime"
 	"time"
 
 	"ccmm/importer/action"
@@ -51,9 +52,38 @@ func initDeviceAttachedThread(config model.ImporterConfig) {
 	go deviceAttachedRoutine(config, deviceAttacherQueueChan, shutdownDeviceAttacherChan)
 }
 
+// startDeviceWatcher starts watching for devices attached to this system, if
+// enabled, and queues a device attached job for each one
+func startDeviceWatcher(config model.ImporterConfig) {
+	if !config.DeviceWatcher.Enabled {
+		slog.Info("Device watcher is disabled by config file")
+		return
+	}
+
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		slog.Warn("Device watcher is not supported on " + runtime.GOOS)
+		return
+	}
+
+	go util.WatchForDeviceAttached(config.DeviceWatcher, func(devicePath string, volumePath string) {
+		if config.DisableAutoProcessing {
+			slog.Info(fmt.Sprintf("Auto processing is disabled by config file, ignoring device '%s'", devicePath))
+			return
+		}
+
+		deviceAttacherQueueChan <- model.DeviceAttached{
+			DevicePath:     devicePath,
+			DryRun:         config.ForceDryRun,
+			AlreadyMounted: volumePath != "",
+			MountPath:      volumePath,
+		}
+	})
+}
+
+// cleanupDeviceAttachedThread stops the device attached routine. The queue
+// channel is left open, as the device watcher may still be sending to it
 func cleanupDeviceAttachedThread() {
-	defer close(shutdownDeviceAttacherChan)
-	defer close(deviceAttacherQueueChan)
+	close(shutdownDeviceAttacherChan)
 }
 
 func deviceAttachedPost(config model.ImporterConfig, w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,106 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ccmm/importer/action"
+	"ccmm/model"
+)
+
+//
+// private functions
+//
+
+func historyGet(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := action.GetImportHistory(filter)
+	if errors.Is(err, action.ErrHistoryUnavailable) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		slog.Error("Failed to read import history: " + err.Error())
+		http.Error(w, "Failed to read import history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(w, http.StatusOK, jobs)
+}
+
+// parseHistoryFilter reads the history filter from the query string. Dates
+// may be provided as YYYY-MM-DD (local time) or RFC 3339 timestamps. A date
+// provided for `until` includes the whole day
+func parseHistoryFilter(r *http.Request) (model.ImportHistoryFilter, error) {
+	query := r.URL.Query()
+
+	filter := model.ImportHistoryFilter{
+		VolumeLabel: query.Get("label"),
+		Processor:   query.Get("processor"),
+	}
+
+	var err error
+	if filter.Since, err = parseHistoryDate(query.Get("since"), false); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+
+	if filter.Until, err = parseHistoryDate(query.Get("until"), true); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit '%s'", limit)
+		}
+	}
+
+	return filter, nil
+}
+
+func parseHistoryDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		if endOfDay {
+			date = date.AddDate(0, 0, 1)
+		}
+
+		return date, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -44,8 +44,8 @@ func importPost(config model.ImporterConfig, w http.ResponseWriter, r *http.Requ
 	if !util.DirectoryExists(importConfig.VolumePath) {
 		w.WriteHeader(500)
 	} else {
-		action.Import(config, importConfig, func(_ *action.ImportQueueItem) {})
-		w.WriteHeader(201)
+		jobID := action.Import(config, importConfig, func(_ *action.ImportQueueItem) {})
+		writeJson(w, 201, map[string]int{"id": jobID})
 	}
 
 }
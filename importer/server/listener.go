@@ -25,11 +25,16 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
+	"ccmm/importer/action"
+	"ccmm/importer/statuslight"
 	"ccmm/model"
 
 	"github.com/go-chi/chi/v5"
@@ -40,24 +45,61 @@ import (
 // public functoins
 //
 
-func StartServer(config model.ImporterConfig, listenAddress string, listenPort int32) {
+// StartServer runs the importer server until ctx is cancelled. On shutdown,
+// running imports are given config.ShutdownTimeout seconds to finish before
+// they are stopped and left to be resumed at next startup
+func StartServer(ctx context.Context, config model.ImporterConfig, listenAddress string, listenPort int32) {
+	if err := action.OpenJobStore(config); err != nil {
+		slog.Error("Failed to open job database: " + err.Error())
+		os.Exit(1)
+	}
+	defer action.CloseJobStore()
+
+	statusLight, err := statuslight.New(config.StatusLight)
+	if err != nil {
+		slog.Error("Failed to start status lights: " + err.Error())
+		os.Exit(1)
+	}
+	if statusLight != nil {
+		statuslight.SetDefault(statusLight)
+		defer statuslight.Close()
+		go statusLight.Watch()
+	}
+
+	action.ResumeInterruptedJobs(config)
+
 	initDeviceAttachedThread(config)
+	startDeviceWatcher(config)
 
-	startServer(listenAddress, listenPort, setupRouting(config))
+	startServer(ctx, listenAddress, listenPort, setupRouting(config))
 
 	cleanupDeviceAttachedThread()
+
+	slog.Info("Waiting for running imports to finish")
+	action.StopScheduler(time.Duration(config.ShutdownTimeout) * time.Second)
 }
 
 //
 // private functions
 //
 
-func startServer(listenAddress string, listenPort int32, router *chi.Mux) {
+func startServer(ctx context.Context, listenAddress string, listenPort int32, router *chi.Mux) {
 	listen := fmt.Sprintf("%s:%d", listenAddress, listenPort)
+	server := &http.Server{Addr: listen, Handler: router}
+
+	go func() {
+		<-ctx.Done()
+		slog.Info("Shutting down ccmm importer server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
 	slog.Info("Started ccmm importer server on " + listen)
-	err := http.ListenAndServe(listen, router)
+	err := server.ListenAndServe()
 
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("http server error", "error", err)
 		os.Exit(1)
 	}
@@ -87,7 +129,15 @@ func setupRouting(config model.ImporterConfig) *chi.Mux {
 	router.Post("/device_attached", func(w http.ResponseWriter, r *http.Request) {
 		deviceAttachedPost(config, w, r)
 	})
-	// TODO: add /status route
+	router.Get("/api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		statusGet(config, w, r)
+	})
+	router.Get("/api/v1/jobs/{id}", jobGet)
+	router.Post("/api/v1/jobs/{id}/cancel", jobCancelPost)
+	router.Get("/api/v1/history", historyGet)
+	router.Get("/api/v1/localsend/pending", localsendPendingGet)
+	router.Post("/api/v1/localsend/pending/{id}/approve", localsendApprovePost)
+	router.Post("/api/v1/localsend/pending/{id}/deny", localsendDenyPost)
 
 	return router
 }
@@ -0,0 +1,75 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"ccmm/importer/localsend/handler"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//
+// private functions
+//
+
+func localsendPendingGet(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, handler.GetPendingSenders())
+}
+
+func localsendApprovePost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	remember := false
+	if value := r.URL.Query().Get("remember"); value != "" {
+		var err error
+		if remember, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid value for remember", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := handler.ApproveSender(id, remember); errors.Is(err, handler.ErrPendingSenderNotFound) {
+		http.Error(w, fmt.Sprintf("Pending sender %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func localsendDenyPost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if err := handler.DenySender(id); errors.Is(err, handler.ErrPendingSenderNotFound) {
+		http.Error(w, fmt.Sprintf("Pending sender %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
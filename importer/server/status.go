@@ -0,0 +1,101 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"ccmm/importer/action"
+	"ccmm/model"
+	"ccmm/util"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//
+// private functions
+//
+
+func statusGet(config model.ImporterConfig, w http.ResponseWriter, r *http.Request) {
+	status := model.ImporterStatus{
+		Hostname: util.GetHostname(),
+		DryRun:   config.ForceDryRun,
+		Devices:  action.GetDeviceStatus(),
+		Jobs:     action.GetImportStatus(),
+	}
+
+	writeJson(w, http.StatusOK, status)
+}
+
+func jobGet(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+
+	job := action.GetImportJob(jobID)
+	if job == nil {
+		http.Error(w, fmt.Sprintf("Job %d not found", jobID), http.StatusNotFound)
+		return
+	}
+
+	writeJson(w, http.StatusOK, job)
+}
+
+func jobCancelPost(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+
+	switch err := action.CancelImport(jobID); {
+	case errors.Is(err, action.ErrJobNotFound):
+		http.Error(w, fmt.Sprintf("Job %d not found", jobID), http.StatusNotFound)
+	case errors.Is(err, action.ErrJobFinished):
+		http.Error(w, fmt.Sprintf("Job %d has already finished", jobID), http.StatusConflict)
+	default:
+		writeJson(w, http.StatusAccepted, action.GetImportJob(jobID))
+	}
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, body any) {
+	res, err := json.Marshal(body)
+	if err != nil {
+		slog.Error(fmt.Sprintf("json convert failed: %s", err.Error()))
+		http.Error(w, "json convert failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(res)
+}
@@ -0,0 +1,136 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package statuslight
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+)
+
+const gpioSysfsPath = "/sys/class/gpio"
+
+// gpioColors lists the colors of an RGB LED that can be assigned a pin
+var gpioColors = []string{"red", "green", "blue"}
+
+// stateColors decides which colors of the LED are lit for each state
+var stateColors = map[State][]string{
+	Idle:      {},
+	Detecting: {"blue"},
+	Importing: {"red", "green"},
+	Done:      {"green"},
+	Error:     {"red"},
+}
+
+// gpioBackend drives an RGB LED per slot through the sysfs GPIO interface
+type gpioBackend struct {
+	pins map[int]map[string]int
+}
+
+func newGpioBackend(config model.StatusLightConfig) (*gpioBackend, error) {
+	backend := &gpioBackend{pins: make(map[int]map[string]int)}
+
+	for _, slot := range config.Slots {
+		if len(slot.GpioPins) == 0 {
+			return nil, fmt.Errorf("status light slot %d has no gpio_pins", slot.ID)
+		}
+
+		for color, pin := range slot.GpioPins {
+			if !slices.Contains(gpioColors, color) {
+				return nil, fmt.Errorf("status light slot %d has unknown gpio_pins color '%s', must be one of %v", slot.ID, color, gpioColors)
+			}
+
+			if err := exportGpioPin(pin); err != nil {
+				return nil, fmt.Errorf("failed to set up GPIO pin %d for status light slot %d: %w", pin, slot.ID, err)
+			}
+		}
+
+		backend.pins[slot.ID] = slot.GpioPins
+	}
+
+	return backend, nil
+}
+
+func (b *gpioBackend) SetState(slot int, state State) error {
+	pins, ok := b.pins[slot]
+	if !ok {
+		return fmt.Errorf("no GPIO pins configured for slot %d", slot)
+	}
+
+	var errs []error
+	for color, pin := range pins {
+		value := "0"
+		if slices.Contains(stateColors[state], color) {
+			value = "1"
+		}
+
+		if err := writeGpioFile(pin, "value", value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *gpioBackend) Close() error {
+	return nil
+}
+
+//
+// private functions
+//
+
+// exportGpioPin makes the pin available in sysfs and sets it up as an output
+func exportGpioPin(pin int) error {
+	pinPath := filepath.Join(gpioSysfsPath, fmt.Sprintf("gpio%d", pin))
+
+	if !util.DirectoryExists(pinPath) {
+		if err := os.WriteFile(filepath.Join(gpioSysfsPath, "export"), []byte(strconv.Itoa(pin)), 0); err != nil {
+			return err
+		}
+	}
+
+	// udev may need a moment to set the permissions of a newly exported pin
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = writeGpioFile(pin, "direction", "out"); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return err
+}
+
+func writeGpioFile(pin int, name string, value string) error {
+	return os.WriteFile(filepath.Join(gpioSysfsPath, fmt.Sprintf("gpio%d", pin), name), []byte(value), 0)
+}
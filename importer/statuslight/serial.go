@@ -0,0 +1,75 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package statuslight
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ccmm/model"
+)
+
+// serialResetDelay is how long to wait after opening the serial port before
+// sending anything, since most Arduino boards reset when the port is opened
+const serialResetDelay = 2 * time.Second
+
+// serialBackend sends each state change to a microcontroller as a line of
+// text: SLOT <id> <STATE>, ex: "SLOT 2 IMPORTING". The microcontroller is
+// responsible for deciding what each state looks like
+type serialBackend struct {
+	port  *os.File
+	mutex sync.Mutex
+}
+
+func newSerialBackend(config model.StatusLightConfig) (*serialBackend, error) {
+	if config.SerialPort == "" {
+		return nil, errors.New("serial_port is required by the serial status light backend")
+	}
+
+	port, err := openSerialPort(config.SerialPort, config.BaudRate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open serial port '%s': %w", config.SerialPort, err)
+	}
+
+	time.Sleep(serialResetDelay)
+
+	return &serialBackend{port: port}, nil
+}
+
+func (b *serialBackend) SetState(slot int, state State) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	_, err := fmt.Fprintf(b.port, "SLOT %d %s\n", slot, strings.ToUpper(state.String()))
+	return err
+}
+
+func (b *serialBackend) Close() error {
+	return b.port.Close()
+}
@@ -0,0 +1,38 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+//go:build !linux
+
+package statuslight
+
+import (
+	"log/slog"
+	"os"
+)
+
+// openSerialPort opens the serial port as is, the baud rate can't be set on
+// this platform and must already be configured (ex: using stty)
+func openSerialPort(path string, baudRate int) (*os.File, error) {
+	slog.Warn("Setting the serial baud rate isn't supported on this platform, make sure the port is already configured")
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
@@ -0,0 +1,76 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package statuslight
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSerialLineProtocol(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	controller := &Controller{
+		backend: &serialBackend{port: writer},
+		ports:   map[string]int{"1-1.2": 1, "1-1.3": 2},
+		states:  make(map[int]State),
+		devices: make(map[string]int),
+	}
+
+	controller.SetSlotState(2, Detecting)
+	controller.SetSlotState(2, Importing)
+
+	// a state that doesn't change isn't sent again
+	controller.SetSlotState(2, Importing)
+	controller.SetSlotState(2, Done)
+	controller.SetSlotState(1, Error)
+
+	// closing turns every light off, then closes the port
+	controller.Close()
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SLOT 2 DETECTING",
+		"SLOT 2 IMPORTING",
+		"SLOT 2 DONE",
+		"SLOT 1 ERROR",
+		"SLOT 1 IDLE",
+		"SLOT 2 IDLE",
+		"",
+	}
+	if lines := strings.Split(string(output), "\n"); strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("expected lines %q, got %q", want, lines)
+	}
+}
@@ -0,0 +1,319 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package statuslight
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"ccmm/model"
+	"ccmm/util"
+)
+
+// State is what a single slot's light shows
+type State int
+
+const (
+	// Idle means there is no card in the slot
+	Idle State = iota
+
+	// Detecting means a card was inserted and is being mounted and identified
+	Detecting
+
+	// Importing means the card is being scanned and copied
+	Importing
+
+	// Done means the import finished and the card is safe to remove
+	Done
+
+	// Error means the import failed or was cancelled
+	Error
+)
+
+// States lists every state, in the order a card normally goes through them
+var States = []State{Idle, Detecting, Importing, Done, Error}
+
+func (s State) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Detecting:
+		return "detecting"
+	case Importing:
+		return "importing"
+	case Done:
+		return "done"
+	case Error:
+		return "error"
+	}
+
+	return "unknown"
+}
+
+// Backend shows the state of a slot on a piece of hardware
+type Backend interface {
+	SetState(slot int, state State) error
+	Close() error
+}
+
+// Controller keeps track of the state of every slot and passes changes on
+// to the backend. Devices are matched to a slot by the USB port they are
+// attached to
+type Controller struct {
+	backend Backend
+	ports   map[string]int
+	mutex   sync.Mutex
+	states  map[int]State
+
+	// devices remembers the slot of each device that has a state, since the
+	// device can no longer be looked up in sysfs once it has been removed
+	devices map[string]int
+
+	// usbPort returns the USB port a device is attached to
+	usbPort func(devicePath string) string
+
+	// source is set while watching for removed devices
+	source util.UeventSource
+	closed bool
+}
+
+var (
+	defaultController *Controller
+	defaultMutex      sync.Mutex
+)
+
+// New creates a controller for the configured backend and turns every slot's
+// light to idle. A nil controller is returned if the status lights are disabled
+func New(config model.StatusLightConfig) (*Controller, error) {
+	if config.Backend == "" || config.Backend == model.StatusLightNone {
+		return nil, nil
+	}
+
+	backend, err := newBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	controller := &Controller{
+		backend: backend,
+		ports:   make(map[string]int),
+		states:  make(map[int]State),
+		devices: make(map[string]int),
+		usbPort: util.GetUsbPort,
+	}
+
+	for _, slot := range config.Slots {
+		controller.ports[slot.UsbPort] = slot.ID
+		controller.SetSlotState(slot.ID, Idle)
+	}
+
+	return controller, nil
+}
+
+// SetDefault sets the controller used by the package level functions
+func SetDefault(controller *Controller) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	defaultController = controller
+}
+
+// SetDeviceState shows the state on the light of the slot that the device
+// (ex: /dev/sdb1) is in, using the default controller. Does nothing if no
+// default controller was set or the device isn't in a known slot
+func SetDeviceState(devicePath string, state State) {
+	if controller := getDefault(); controller != nil {
+		controller.SetDeviceState(devicePath, state)
+	}
+}
+
+// ForgetDevice stops tracking the device with the default controller, see
+// Controller.ForgetDevice
+func ForgetDevice(devicePath string) {
+	if controller := getDefault(); controller != nil {
+		controller.ForgetDevice(devicePath)
+	}
+}
+
+// Close turns off the default controller
+func Close() {
+	defaultMutex.Lock()
+	controller := defaultController
+	defaultController = nil
+	defaultMutex.Unlock()
+
+	if controller != nil {
+		controller.Close()
+	}
+}
+
+// SetDeviceState shows the state on the light of the slot that the device
+// (ex: /dev/sdb1) is in. Does nothing if the device isn't in a known slot
+func (c *Controller) SetDeviceState(devicePath string, state State) {
+	if devicePath == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	slot, ok := c.devices[devicePath]
+	c.mutex.Unlock()
+
+	if !ok {
+		port := c.usbPort(devicePath)
+		if slot, ok = c.ports[port]; !ok {
+			slog.Debug(fmt.Sprintf("Device '%s' on USB port '%s' is not in a status light slot", devicePath, port))
+			return
+		}
+	}
+
+	c.mutex.Lock()
+	c.devices[devicePath] = slot
+	c.mutex.Unlock()
+
+	c.SetSlotState(slot, state)
+}
+
+// ForgetDevice stops tracking the device, so that it being removed won't
+// change the slot's light. Used before a device is powered off, because the
+// card reader disappears along with the card and the slot should keep showing
+// that the card is safe to remove
+func (c *Controller) ForgetDevice(devicePath string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.devices, devicePath)
+}
+
+// SetSlotState shows the state on the slot's light
+func (c *Controller) SetSlotState(slot int, state State) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if current, ok := c.states[slot]; ok && current == state {
+		return
+	}
+
+	slog.Debug(fmt.Sprintf("Status light slot %d: %s", slot, state))
+	c.states[slot] = state
+
+	if err := c.backend.SetState(slot, state); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to set status light slot %d to %s: %s", slot, state, err.Error()))
+	}
+}
+
+// GetSlotStates returns the current state of every slot
+func (c *Controller) GetSlotStates() map[int]State {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	states := make(map[int]State, len(c.states))
+	for slot, state := range c.states {
+		states[slot] = state
+	}
+
+	return states
+}
+
+// Slots returns the ID of every slot, in order
+func (c *Controller) Slots() []int {
+	slots := make([]int, 0, len(c.ports))
+	for _, slot := range c.ports {
+		slots = append(slots, slot)
+	}
+	slices.Sort(slots)
+
+	return slots
+}
+
+// Close stops watching for removed devices, turns every light off and closes
+// the backend
+func (c *Controller) Close() {
+	c.mutex.Lock()
+	source := c.source
+	c.source = nil
+	c.closed = true
+	c.mutex.Unlock()
+
+	if source != nil {
+		source.Close()
+	}
+
+	for _, slot := range c.Slots() {
+		c.SetSlotState(slot, Idle)
+	}
+
+	if err := c.backend.Close(); err != nil {
+		slog.Warn("Failed to close status light backend: " + err.Error())
+	}
+}
+
+//
+// private functions
+//
+
+func getDefault() *Controller {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	return defaultController
+}
+
+func newBackend(config model.StatusLightConfig) (Backend, error) {
+	switch config.Backend {
+	case model.StatusLightSimulated:
+		return newSimulatedBackend(), nil
+	case model.StatusLightSerial:
+		return newSerialBackend(config)
+	case model.StatusLightGpio:
+		return newGpioBackend(config)
+	}
+
+	return nil, fmt.Errorf("unknown status light backend '%s'", config.Backend)
+}
+
+// removed is called when a device has been removed from the system. A card
+// that is pulled once it's done (or failed) turns the slot back to idle, one
+// that is pulled while it is still being used is an error
+func (c *Controller) removed(devicePath string) {
+	c.mutex.Lock()
+	slot, ok := c.devices[devicePath]
+	delete(c.devices, devicePath)
+	state := c.states[slot]
+	c.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if state == Detecting || state == Importing {
+		slog.Warn(fmt.Sprintf("Device '%s' was removed from status light slot %d while %s", devicePath, slot, state))
+		c.SetSlotState(slot, Error)
+		return
+	}
+
+	c.SetSlotState(slot, Idle)
+}
@@ -0,0 +1,159 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package statuslight
+
+import (
+	"maps"
+	"testing"
+
+	"ccmm/model"
+)
+
+// newTestController creates a controller for the simulated backend with a
+// slot for each of the provided USB ports, numbered from 1. Devices are
+// looked up in ports rather than in sysfs
+func newTestController(t *testing.T, ports map[string]string, usbPorts ...string) *Controller {
+	t.Helper()
+
+	config := model.StatusLightConfig{Backend: model.StatusLightSimulated}
+	for idx, usbPort := range usbPorts {
+		config.Slots = append(config.Slots, model.StatusLightSlot{ID: idx + 1, UsbPort: usbPort})
+	}
+
+	controller, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller.usbPort = func(devicePath string) string { return ports[devicePath] }
+	t.Cleanup(controller.Close)
+
+	return controller
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         model.StatusLightConfig
+		wantController bool
+		wantErr        bool
+	}{
+		{"not configured", model.StatusLightConfig{}, false, false},
+		{"none", model.StatusLightConfig{Backend: model.StatusLightNone}, false, false},
+		{"simulated", model.StatusLightConfig{Backend: model.StatusLightSimulated}, true, false},
+		{"serial without a port", model.StatusLightConfig{Backend: model.StatusLightSerial}, false, true},
+		{"unknown backend", model.StatusLightConfig{Backend: "lasers"}, false, true},
+	}
+
+	for _, test := range tests {
+		controller, err := New(test.config)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.name, test.wantErr, err)
+		}
+		if (controller != nil) != test.wantController {
+			t.Errorf("%s: expected a controller %t, got %v", test.name, test.wantController, controller)
+		}
+		if controller != nil {
+			controller.Close()
+		}
+	}
+}
+
+func TestNewStartsIdle(t *testing.T) {
+	controller := newTestController(t, nil, "1-1.3", "1-1.2")
+
+	if slots := controller.Slots(); len(slots) != 2 || slots[0] != 1 || slots[1] != 2 {
+		t.Errorf("expected slots [1 2], got %v", slots)
+	}
+
+	want := map[int]State{1: Idle, 2: Idle}
+	if states := controller.GetSlotStates(); !maps.Equal(states, want) {
+		t.Errorf("expected states %v, got %v", want, states)
+	}
+}
+
+func TestSetDeviceStateMapsUsbPortToSlot(t *testing.T) {
+	ports := map[string]string{
+		"/dev/sdb1": "1-1.2",
+		"/dev/sdc1": "1-1.3",
+		"/dev/sdd1": "2-1",
+	}
+	controller := newTestController(t, ports, "1-1.2", "1-1.3")
+
+	controller.SetDeviceState("/dev/sdb1", Importing)
+	controller.SetDeviceState("/dev/sdc1", Detecting)
+
+	// devices that aren't in a slot, or aren't known at all, are ignored
+	controller.SetDeviceState("/dev/sdd1", Error)
+	controller.SetDeviceState("/dev/sde1", Error)
+	controller.SetDeviceState("", Error)
+
+	want := map[int]State{1: Importing, 2: Detecting}
+	if states := controller.GetSlotStates(); !maps.Equal(states, want) {
+		t.Errorf("expected states %v, got %v", want, states)
+	}
+
+	// the slot of a device is remembered, since it can't be looked up once
+	// the card reader has gone
+	delete(ports, "/dev/sdb1")
+	controller.SetDeviceState("/dev/sdb1", Done)
+
+	if state := controller.GetSlotStates()[1]; state != Done {
+		t.Errorf("expected slot 1 %s, got %s", Done, state)
+	}
+}
+
+func TestRemovedDevice(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  State
+		forget bool
+		want   State
+	}{
+		{"removed while detecting", Detecting, false, Error},
+		{"removed while importing", Importing, false, Error},
+		{"removed once done", Done, false, Idle},
+		{"removed after an error", Error, false, Idle},
+		{"forgotten before removal", Done, true, Done},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := newTestController(t, map[string]string{"/dev/sdb1": "1-1.2"}, "1-1.2", "1-1.3")
+
+			controller.SetDeviceState("/dev/sdb1", test.state)
+			if test.forget {
+				controller.ForgetDevice("/dev/sdb1")
+			}
+
+			controller.removed("/dev/sdb1")
+			controller.removed("/dev/sdc1")
+
+			want := map[int]State{1: test.want, 2: Idle}
+			if states := controller.GetSlotStates(); !maps.Equal(states, want) {
+				t.Errorf("expected states %v, got %v", want, states)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+//go:build linux
+
+package statuslight
+
+import (
+	"log/slog"
+
+	"ccmm/util"
+)
+
+// Watch listens to kernel uevents for removed devices, so that a slot turns
+// back to idle once its card is pulled. Blocks until the controller is closed
+func (c *Controller) Watch() {
+	source, err := util.NewNetlinkUeventSource()
+	if err != nil {
+		slog.Error("Failed to watch for removed devices, status lights won't return to idle: " + err.Error())
+		return
+	}
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		source.Close()
+		return
+	}
+	c.source = source
+	c.mutex.Unlock()
+
+	for {
+		event, err := source.ReadEvent()
+		if err != nil {
+			c.mutex.Lock()
+			closed := c.closed
+			c.mutex.Unlock()
+
+			if !closed {
+				slog.Error("Stopped watching for removed devices: " + err.Error())
+				source.Close()
+			}
+			return
+		}
+
+		if event.Action == "remove" && event.Subsystem == "block" && event.DevName != "" {
+			c.removed("/dev/" + event.DevName)
+		}
+	}
+}
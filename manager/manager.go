@@ -28,6 +28,7 @@ import (
 	"ccmm/manager/server"
 	"ccmm/model"
 	"ccmm/util"
+	"ccmm/util/notify"
 	"log/slog"
 	"os"
 )
@@ -49,6 +50,13 @@ func main() {
 
 	slog.Info("Configured services data directory: " + config.DataDirs.Services)
 
+	notifier, err := notify.New("manager", config.Notifications)
+	if err != nil {
+		slog.Error("Invalid notification config: " + err.Error())
+		os.Exit(1)
+	}
+	notify.SetDefault(notifier)
+
 	server.StartServer(config)
 }
 
@@ -0,0 +1,72 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package server
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+//
+// private functions
+//
+
+// requireAuthToken is middleware that requires a bearer token matching one of
+// the configured auth_tokens. If no tokens are configured, every request is
+// refused so that the endpoints are never accidentally left open
+func requireAuthToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := getConfig(r)
+
+		if len(config.AuthTokens) == 0 {
+			slog.Warn("Refusing authenticated request because no auth_tokens are configured")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || !validToken(config.AuthTokens, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validToken(validTokens []string, token string) bool {
+	valid := false
+
+	// check every token so the time taken doesn't reveal which one matched
+	for _, validToken := range validTokens {
+		if validToken != "" && subtle.ConstantTimeCompare([]byte(validToken), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
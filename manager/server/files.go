@@ -0,0 +1,332 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+	"ccmm/util/notify"
+	"ccmm/util/sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// uploadSuffix is appended to the name of a file while it is being uploaded.
+// The partial file is kept in the same directory so that the final rename is atomic
+const uploadSuffix = ".ccmm-upload"
+
+// uploadLocks holds a mutex per destination path so that only one upload of
+// a given file can happen at a time
+var uploadLocks gosync.Map
+
+//
+// private functions
+//
+
+// fileGet sends a file to the client. Range requests are supported so that
+// large files can be resumed
+func fileGet(w http.ResponseWriter, r *http.Request) {
+	filePath, _, err := resolveFilePath(getConfig(r), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set(model.ModTimeHeader, stat.ModTime().Format(time.RFC3339Nano))
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+}
+
+// uploadStatusGet returns how much of a file has been uploaded so far, so that
+// an interrupted upload can be resumed
+func uploadStatusGet(w http.ResponseWriter, r *http.Request) {
+	filePath, relativePath, err := resolveFilePath(getConfig(r), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := model.UploadStatus{Path: relativePath}
+
+	if stat, err := os.Stat(filePath + uploadSuffix); err == nil {
+		status.Offset = stat.Size()
+	}
+
+	writeUploadStatus(w, http.StatusOK, status)
+}
+
+// filePut receives a file from the client. The file is written to a temporary
+// file which is moved into place once complete. A Content-Range header
+// (ex: bytes 1048576-2097151/5000000) can be provided to upload the file in
+// chunks, or resume an interrupted upload, with each chunk starting where the
+// previous one ended. A chunk that resumes a partial file from a previous request
+// may provide a PartialHashHeader, which must match the partial file or the
+// partial file is discarded. The modification time is taken from the
+// ModTimeHeader and, if a HashHeader is provided, the complete file is verified
+// against it
+func filePut(w http.ResponseWriter, r *http.Request) {
+	config := getConfig(r)
+
+	if config.ForceReadOnly {
+		http.Error(w, "manager is read-only", http.StatusForbidden)
+		return
+	}
+
+	filePath, relativePath, err := resolveFilePath(config, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lock, _ := uploadLocks.LoadOrStore(filePath, &gosync.Mutex{})
+	if !lock.(*gosync.Mutex).TryLock() {
+		http.Error(w, "an upload of this file is already in progress", http.StatusConflict)
+		return
+	}
+	defer lock.(*gosync.Mutex).Unlock()
+
+	tempPath := filePath + uploadSuffix
+	status := model.UploadStatus{Path: relativePath}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		slog.Error(fmt.Sprintf("Failed to create directory for '%s': %s", filePath, err.Error()))
+		http.Error(w, "failed to create directory", http.StatusInternalServerError)
+		return
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if start == 0 {
+		flags |= os.O_TRUNC
+	} else if stat, err := os.Stat(tempPath); err != nil || stat.Size() != start {
+		// the chunk must start exactly where the partial file ends
+		if err == nil {
+			status.Offset = stat.Size()
+		}
+		writeUploadStatus(w, http.StatusConflict, status)
+		return
+	} else if partialHash := r.Header.Get(model.PartialHashHeader); partialHash != "" {
+		// the partial file may be the start of a different version of the file
+		if hash, err := util.HashFile(tempPath); err != nil || !strings.EqualFold(partialHash, hash) {
+			slog.Warn(fmt.Sprintf("Partial upload of '%s' doesn't match the file being resumed, starting over", filePath))
+			os.Remove(tempPath)
+			writeUploadStatus(w, http.StatusPreconditionFailed, status)
+			return
+		}
+	}
+
+	tempFile, err := os.OpenFile(tempPath, flags, 0644)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to open '%s' for writing: %s", tempPath, err.Error()))
+		http.Error(w, "failed to open file for writing", http.StatusInternalServerError)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if end >= 0 {
+		body = io.LimitReader(r.Body, end-start+1)
+	}
+
+	written, err := io.Copy(tempFile, body)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	tempFile.Close()
+
+	status.Offset = start + written
+
+	if err != nil {
+		// the partial file is kept so that the upload can be resumed
+		slog.Warn(fmt.Sprintf("Upload of '%s' interrupted at %d bytes: %s", filePath, status.Offset, err.Error()))
+		writeUploadStatus(w, http.StatusBadRequest, status)
+		return
+	}
+
+	if end >= 0 && status.Offset != end+1 {
+		writeUploadStatus(w, http.StatusBadRequest, status)
+		return
+	}
+
+	// more chunks to come
+	if total >= 0 && status.Offset < total {
+		writeUploadStatus(w, http.StatusAccepted, status)
+		return
+	}
+
+	hash, err := util.HashFile(tempPath)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to hash '%s': %s", tempPath, err.Error()))
+		http.Error(w, "failed to hash uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	if expectedHash := r.Header.Get(model.HashHeader); expectedHash != "" && !strings.EqualFold(expectedHash, hash) {
+		slog.Warn(fmt.Sprintf("Upload of '%s' failed verification, expected hash %s but got %s", filePath, expectedHash, hash))
+		os.Remove(tempPath)
+		status.Offset = 0
+
+		notify.Notify(notify.Event{
+			Type:    notify.EventChecksumMismatch,
+			Title:   "Checksum mismatch during upload",
+			Message: fmt.Sprintf("Upload of '%s' failed verification, expected hash %s but got %s", filePath, expectedHash, hash),
+			Data: map[string]any{
+				"path": filePath,
+			},
+		})
+
+		writeUploadStatus(w, http.StatusUnprocessableEntity, status)
+		return
+	}
+
+	if modTime, err := time.Parse(time.RFC3339Nano, r.Header.Get(model.ModTimeHeader)); err == nil {
+		os.Chtimes(tempPath, time.Time{}, modTime)
+	}
+
+	if err := os.Rename(tempPath, filePath); err != nil {
+		slog.Error(fmt.Sprintf("Failed to move '%s' into place: %s", tempPath, err.Error()))
+		http.Error(w, "failed to move uploaded file into place", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info(fmt.Sprintf("Received '%s' (%d bytes)", filePath, status.Offset))
+
+	notify.Notify(notify.Event{
+		Type:    notify.EventFileUploaded,
+		Title:   "File received from client",
+		Message: fmt.Sprintf("Received '%s' (%d bytes)", filePath, status.Offset),
+		Data: map[string]any{
+			"path": filePath,
+			"size": status.Offset,
+			"hash": hash,
+		},
+	})
+
+	status.Complete = true
+	status.Hash = hash
+	writeUploadStatus(w, http.StatusCreated, status)
+}
+
+// resolveFilePath returns the absolute path of the file referenced by the
+// service, media type and relative path of the request, along with the path
+// relative to the media type directory
+func resolveFilePath(config model.ManagerConfig, r *http.Request) (string, string, error) {
+	serviceDir, err := sync.GetServiceDirectory(chi.URLParam(r, "service"), config.DataDirs.Services)
+	if err != nil {
+		return "", "", err
+	}
+
+	mediaType := chi.URLParam(r, "mediaType")
+	if mediaType == "" || strings.HasPrefix(mediaType, ".") || strings.ContainsAny(mediaType, `/\`) {
+		return "", "", fmt.Errorf("invalid media type '%s'", mediaType)
+	}
+
+	// cleaning a rooted path removes any attempt to escape with ".."
+	relativePath := strings.TrimPrefix(path.Clean("/"+chi.URLParam(r, "*")), "/")
+	if relativePath == "" || strings.HasSuffix(relativePath, uploadSuffix) || path.Base(relativePath) == util.ManifestFileName {
+		return "", "", fmt.Errorf("invalid file path '%s'", relativePath)
+	}
+
+	return filepath.Join(serviceDir, mediaType, filepath.FromSlash(relativePath)), relativePath, nil
+}
+
+// parseContentRange parses a header such as "bytes 0-1023/4096". If the header
+// is empty, the whole file is expected in one request and -1 is returned for
+// both end and total. The total size of the file is required
+func parseContentRange(header string) (int64, int64, int64, error) {
+	if header == "" {
+		return 0, -1, -1, nil
+	}
+
+	invalid := errors.New("invalid Content-Range header")
+
+	rangeSpec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, 0, invalid
+	}
+
+	byteRange, totalStr, found := strings.Cut(rangeSpec, "/")
+	if !found {
+		return 0, 0, 0, invalid
+	}
+
+	startStr, endStr, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, 0, invalid
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, 0, invalid
+	}
+
+	end, err := strconv.ParseInt(endStr, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+
+	total, err := strconv.ParseInt(totalStr, 10, 64)
+	if err != nil || end >= total {
+		return 0, 0, 0, invalid
+	}
+
+	return start, end, total, nil
+}
+
+func writeUploadStatus(w http.ResponseWriter, statusCode int, status model.UploadStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,155 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ccmm/model"
+)
+
+const testToken = "test-token"
+
+func newTestManager(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	config := model.DefaultManagerConfig
+	config.DataDirs.Services = t.TempDir()
+	config.AuthTokens = []string{testToken}
+
+	server := httptest.NewServer(setupRouting(config))
+	t.Cleanup(server.Close)
+
+	return server, config.DataDirs.Services
+}
+
+func putChunk(t *testing.T, server *httptest.Server, content string, start int, total int, headers map[string]string) (int, model.UploadStatus) {
+	t.Helper()
+
+	request, err := http.NewRequest("PUT", server.URL+"/api/v1/files/2024-11-03/Audio/Tr1.WAV", strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	request.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+len(content)-1, total))
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var status model.UploadStatus
+	json.NewDecoder(response.Body).Decode(&status)
+
+	return response.StatusCode, status
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFilePutResumesMatchingPartialUpload(t *testing.T) {
+	server, servicesDir := newTestManager(t)
+
+	if statusCode, status := putChunk(t, server, "01234", 0, 10, nil); statusCode != http.StatusAccepted || status.Offset != 5 {
+		t.Fatalf("expected the first chunk to be accepted, got %d %+v", statusCode, status)
+	}
+
+	headers := map[string]string{
+		model.PartialHashHeader: sha256Hex("01234"),
+		model.HashHeader:        sha256Hex("0123456789"),
+	}
+	if statusCode, status := putChunk(t, server, "56789", 5, 10, headers); statusCode != http.StatusCreated || !status.Complete {
+		t.Fatalf("expected the upload to complete, got %d %+v", statusCode, status)
+	}
+
+	content, err := os.ReadFile(filepath.Join(servicesDir, "2024 Q4", "2024-11-03", "Audio", "Tr1.WAV"))
+	if err != nil || string(content) != "0123456789" {
+		t.Errorf("unexpected uploaded file '%s': %v", content, err)
+	}
+}
+
+func TestFilePutDiscardsMismatchedPartialUpload(t *testing.T) {
+	server, servicesDir := newTestManager(t)
+
+	putChunk(t, server, "01234", 0, 10, nil)
+
+	headers := map[string]string{model.PartialHashHeader: sha256Hex("abcde")}
+	if statusCode, status := putChunk(t, server, "56789", 5, 10, headers); statusCode != http.StatusPreconditionFailed || status.Offset != 0 {
+		t.Fatalf("expected the resume to be refused, got %d %+v", statusCode, status)
+	}
+
+	partialPath := filepath.Join(servicesDir, "2024 Q4", "2024-11-03", "Audio", "Tr1.WAV"+uploadSuffix)
+	if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+		t.Errorf("expected the partial upload to be removed, got %v", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		end    int64
+		total  int64
+		valid  bool
+	}{
+		{"", 0, -1, -1, true},
+		{"bytes 0-1023/4096", 0, 1023, 4096, true},
+		{"bytes 4095-4095/4096", 4095, 4095, 4096, true},
+		{"bytes 4096-4095/4096", 0, 0, 0, false},
+		{"bytes 0-4096/4096", 0, 0, 0, false},
+		{"bytes -1-10/20", 0, 0, 0, false},
+		{"bytes 0-10", 0, 0, 0, false},
+		{"items 0-10/20", 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		start, end, total, err := parseContentRange(test.header)
+
+		if (err == nil) != test.valid {
+			t.Errorf("parseContentRange('%s') error = %v, want valid %t", test.header, err, test.valid)
+			continue
+		}
+
+		if test.valid && (start != test.start || end != test.end || total != test.total) {
+			t.Errorf("parseContentRange('%s') = %d, %d, %d, want %d, %d, %d", test.header, start, end, total, test.start, test.end, test.total)
+		}
+	}
+}
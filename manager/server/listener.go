@@ -106,8 +106,18 @@ func setupRouting(config model.ManagerConfig) *chi.Mux {
 
 	r.Get("/health", healthCheck)
 	r.Get("/api/v1/quarters", getQuarters)
+	r.Get("/api/v1/services", getServices)
 	r.Post("/api/v1/sync_request", syncRequest)
 
+	r.Group(func(r chi.Router) {
+		r.Use(requireAuthToken)
+
+		r.Get("/api/v1/files/{service}/{mediaType}/*", fileGet)
+		r.Head("/api/v1/files/{service}/{mediaType}/*", fileGet)
+		r.Put("/api/v1/files/{service}/{mediaType}/*", filePut)
+		r.Get("/api/v1/uploads/{service}/{mediaType}/*", uploadStatusGet)
+	})
+
 	return r
 }
 
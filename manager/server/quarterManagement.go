@@ -30,6 +30,8 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+
+	"ccmm/util/sync"
 )
 
 //
@@ -58,3 +60,18 @@ func getQuarters(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	w.Write(res)
 }
+
+func getServices(w http.ResponseWriter, r *http.Request) {
+	config := getConfig(r)
+
+	services, err := sync.ListServices(config.DataDirs.Services)
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to list services: %s", err.Error()))
+		http.Error(w, "failed to list services", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services)
+}
@@ -29,8 +29,8 @@ import (
 	"ccmm/util"
 	"encoding/json"
 	"fmt"
-	"slices"
 
+	"ccmm/util/notify"
 	"ccmm/util/sync"
 	"net/http"
 )
@@ -44,33 +44,45 @@ func syncRequest(w http.ResponseWriter, r *http.Request) {
 
 	syncRequest := util.ReadJsonBody[model.SyncRequest](r)
 
-	for _, serviceDateStr := range syncRequest.Services {
-		theirFiles := syncRequest.ServiceFiles[serviceDateStr]
-		myFiles := sync.ScanService(serviceDateStr, syncRequest.MediaTypes, config.DataDirs.Services)
+	if syncRequest.SyncType != model.SyncTypeRequest {
+		w.WriteHeader(400)
+		return
+	}
+
+	plan := sync.PlanSync(syncRequest, func(serviceDateStr string) []model.SyncFile {
+		return sync.ScanService(serviceDateStr, syncRequest.MediaTypes, config.DataDirs.Services)
+	})
 
-		// look for files that we have and the they also have
-		for _, myFile := range myFiles {
-			idx := slices.IndexFunc(theirFiles, func(f model.SyncFile) bool { return f.FilePath == myFile.FilePath })
+	notifySyncRequested(plan)
 
-			// found the file
-			if idx >= 0 {
-				theirFile := &syncRequest.ServiceFiles[serviceDateStr][idx]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
 
-				if theirFile.Size == myFile.Size {
-					if theirFile.FileModTime == myFile.FileModTime {
-						theirFile.ClientAction = "none"
-						theirFile.ServerAction = "none"
-					} else {
-						fmt.Printf("   My DTM: %v\n", myFile.FileModTime)
-						fmt.Printf("Their DTM: %v\n", theirFile.FileModTime)
-					}
-				}
+// notifySyncRequested sends a notification summarizing what the client was
+// asked to do
+func notifySyncRequested(plan model.SyncRequest) {
+	clientActions := make(map[model.SyncAction]int)
+	serverActions := make(map[model.SyncAction]int)
 
-			}
+	for _, files := range plan.ServiceFiles {
+		for _, file := range files {
+			clientActions[file.ClientAction]++
+			serverActions[file.ServerAction]++
 		}
-
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(syncRequest)
+	notify.Notify(notify.Event{
+		Type:  notify.EventSyncRequested,
+		Title: fmt.Sprintf("Sync requested by %s", plan.ClientName),
+		Message: fmt.Sprintf("Client '%s' requested a sync of %d service(s): %d file(s) to send to the client, %d file(s) to receive from it",
+			plan.ClientName, len(plan.Services), clientActions[model.SyncActionAdd]+clientActions[model.SyncActionUpdate],
+			serverActions[model.SyncActionAdd]+serverActions[model.SyncActionUpdate]),
+		Data: map[string]any{
+			"client":         plan.ClientName,
+			"services":       plan.Services,
+			"client_actions": clientActions,
+			"server_actions": serverActions,
+		},
+	})
 }
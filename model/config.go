@@ -33,14 +33,234 @@ const (
 )
 
 type ImporterConfig struct {
-	LiveDataDir           string          `yaml:"live_data_dir"`
-	LogLevel              int8            `yaml:"log_level"`
-	ListenAddress         string          `yaml:"listen_address"`
-	ListenPort            int32           `yaml:"listen_port"`
-	ForceDryRun           bool            `yaml:"force_dry_run"`
-	DisableAutoProcessing bool            `yaml:"disable_auto_processing"`
-	EnabledProcessors     []string        `yaml:"enabled_processors"`
-	LocalSend             LocalSendConfig `yaml:"localsend"`
+	LiveDataDir              string                     `yaml:"live_data_dir"`
+	JobDatabase              string                     `yaml:"job_database"`
+	LogLevel                 int8                       `yaml:"log_level"`
+	ListenAddress            string                     `yaml:"listen_address"`
+	ListenPort               int32                      `yaml:"listen_port"`
+	ForceDryRun              bool                       `yaml:"force_dry_run"`
+	DisableAutoProcessing    bool                       `yaml:"disable_auto_processing"`
+	MaxConcurrentImports     int                        `yaml:"max_concurrent_imports"`
+	DestinationIOLimit       int                        `yaml:"destination_io_limit"`
+	ShutdownTimeout          int                        `yaml:"shutdown_timeout"`
+	FreeSpaceReserveMB       int64                      `yaml:"free_space_reserve_mb"`
+	SiteTimezone             string                     `yaml:"site_timezone"`
+	EnabledProcessors        []string                   `yaml:"enabled_processors"`
+	ProcessorDefinitionsDir  string                     `yaml:"processor_definitions_dir"`
+	DestinationTemplate      string                     `yaml:"destination_template"`
+	ServiceDirectoryTemplate string                     `yaml:"service_directory_template"`
+	ConflictPolicy           string                     `yaml:"conflict_policy"`
+	PostImportAction         string                     `yaml:"post_import_action"`
+	EventNames               map[string]string          `yaml:"event_names"`
+	Processors               map[string]ProcessorConfig `yaml:"processors"`
+	DeviceWatcher            DeviceWatcherConfig        `yaml:"device_watcher"`
+	Notifications            []NotificationConfig       `yaml:"notifications"`
+	LocalSend                LocalSendConfig            `yaml:"localsend"`
+	StatusLight              StatusLightConfig          `yaml:"status_light"`
+}
+
+// ProcessorConfig contains settings that override the global importer settings
+// for a single processor
+type ProcessorConfig struct {
+	DestinationTemplate string   `yaml:"destination_template,omitempty"`
+	ConflictPolicy      string   `yaml:"conflict_policy,omitempty"`
+	PostImportAction    string   `yaml:"post_import_action,omitempty"`
+	ControlFiles        []string `yaml:"control_files,omitempty"`
+
+	// ClockOffset is the number of seconds the clock of the device is ahead
+	// of the real time (negative when it is behind)
+	ClockOffset int `yaml:"clock_offset,omitempty"`
+}
+
+// Conflict policies decide what happens when a different file already exists
+// at the destination path of a file being imported
+const (
+	// ConflictSkip leaves the existing file alone and doesn't import the new one
+	ConflictSkip = "skip"
+
+	// ConflictRenameWithSuffix imports the new file with a numeric suffix (ex: MVI_0001_1.MOV)
+	ConflictRenameWithSuffix = "rename-with-suffix"
+
+	// ConflictKeepBothByHash imports the new file with a suffix made from the
+	// start of its hash (ex: MVI_0001_3fa8b2c1.MOV)
+	ConflictKeepBothByHash = "keep-both-by-hash"
+
+	// ConflictOverwrite replaces the existing file with the new one
+	ConflictOverwrite = "overwrite"
+)
+
+// ConflictPolicies lists every valid conflict policy
+var ConflictPolicies = []string{ConflictSkip, ConflictRenameWithSuffix, ConflictKeepBothByHash, ConflictOverwrite}
+
+// Post-import actions decide what happens to the files on an attached device
+// once they have all been imported and verified
+const (
+	// PostImportNone leaves the volume untouched
+	PostImportNone = "none"
+
+	// PostImportDelete deletes only the files that were imported, keeping the
+	// control files of the device. Reformatting the volume is not supported
+	PostImportDelete = "delete"
+)
+
+// PostImportActions lists every valid post-import action
+var PostImportActions = []string{PostImportNone, PostImportDelete}
+
+// DeviceWatcherConfig controls the built-in watcher that triggers an import when
+// a device is attached to the system running the importer server
+type DeviceWatcherConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// AllowedFormats lists the filesystem types (as reported by lsblk, ex: vfat)
+	// that will be imported. Empty allows any filesystem
+	AllowedFormats []string `yaml:"allowed_formats"`
+
+	// AllowedLabels is a list of regexes, the volume label must match one of
+	// these to be imported. Empty allows any label
+	AllowedLabels []string `yaml:"allowed_labels"`
+
+	// IgnoredLabels is a list of regexes, a volume whose label matches any of
+	// these will never be imported
+	IgnoredLabels []string `yaml:"ignored_labels"`
+
+	// SettleTimeout is the number of seconds to wait for a newly attached device
+	// node to appear and for its filesystem to be identified
+	SettleTimeout int `yaml:"settle_timeout"`
+}
+
+// Notification types, each one delivers notifications to a different kind of destination
+const (
+	// NotifyWebhook POSTs the event as JSON to URL
+	NotifyWebhook = "webhook"
+
+	// NotifyEmail sends the event by email through an SMTP server
+	NotifyEmail = "email"
+
+	// NotifyNtfy publishes the event to the ntfy topic at URL (ex: https://ntfy.sh/mytopic)
+	NotifyNtfy = "ntfy"
+
+	// NotifyGotify pushes the event to the Gotify server at URL
+	NotifyGotify = "gotify"
+
+	// NotifyCommand runs a shell command with the event as JSON on stdin
+	NotifyCommand = "command"
+)
+
+// NotificationTypes lists every valid notification type
+var NotificationTypes = []string{NotifyWebhook, NotifyEmail, NotifyNtfy, NotifyGotify, NotifyCommand}
+
+// NotificationConfig describes a single destination that notifications are
+// sent to. Which fields are used depends on the type
+type NotificationConfig struct {
+	Type string `yaml:"type"`
+
+	// Events lists the event types (ex: job.failed) that are sent to this
+	// destination. A trailing * matches any event starting with the prefix
+	// (ex: job.*). Empty sends every event
+	Events []string `yaml:"events,omitempty"`
+
+	// Timeout is the number of seconds allowed to deliver a single event
+	Timeout int `yaml:"timeout,omitempty"`
+
+	// Retries is the number of times a failed delivery is tried again. Zero
+	// uses the default and a negative number disables retries
+	Retries int `yaml:"retries,omitempty"`
+
+	// webhook, ntfy and gotify
+	URL      string            `yaml:"url,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Token    string            `yaml:"token,omitempty"`
+	Priority int               `yaml:"priority,omitempty"`
+
+	// email
+	SmtpHost string   `yaml:"smtp_host,omitempty"`
+	SmtpPort int      `yaml:"smtp_port,omitempty"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	From     string   `yaml:"from,omitempty"`
+	To       []string `yaml:"to,omitempty"`
+
+	// command
+	Command string `yaml:"command,omitempty"`
+}
+
+// Status light backends, each one drives the lights of the card reader bays differently
+const (
+	// StatusLightNone disables the status lights
+	StatusLightNone = "none"
+
+	// StatusLightSimulated logs every state change instead of driving hardware
+	StatusLightSimulated = "simulated"
+
+	// StatusLightSerial sends each state change as a line of text to a
+	// microcontroller (ex: Arduino or RP2040) on a serial port
+	StatusLightSerial = "serial"
+
+	// StatusLightGpio drives an RGB LED per slot through the sysfs GPIO interface
+	StatusLightGpio = "gpio"
+)
+
+// StatusLightBackends lists every valid status light backend
+var StatusLightBackends = []string{StatusLightNone, StatusLightSimulated, StatusLightSerial, StatusLightGpio}
+
+// StatusLightConfig controls the lights that show the state of each card
+// reader bay (ex: importing or safe to remove)
+type StatusLightConfig struct {
+	Backend string `yaml:"backend"`
+
+	// serial
+	SerialPort string `yaml:"serial_port,omitempty"`
+	BaudRate   int    `yaml:"baud_rate,omitempty"`
+
+	// Slots maps the physical USB port of each card reader to a slot
+	Slots []StatusLightSlot `yaml:"slots"`
+}
+
+// StatusLightSlot is a single card reader bay with its own light
+type StatusLightSlot struct {
+	ID int `yaml:"id"`
+
+	// UsbPort is the USB port path of the card reader as named in sysfs
+	// (ex: 1-1.2), use the test_statuslight command to find it
+	UsbPort string `yaml:"usb_port"`
+
+	// GpioPins maps the colors red, green and blue to the GPIO pin driving
+	// that color of the slot's LED. Only used by the gpio backend
+	GpioPins map[string]int `yaml:"gpio_pins,omitempty"`
+}
+
+// Policies for localsend senders that don't match any of the senders rules
+const (
+	// LocalSendAllow accepts transfers from unknown senders
+	LocalSendAllow = "allow"
+
+	// LocalSendDeny refuses transfers from unknown senders
+	LocalSendDeny = "deny"
+
+	// LocalSendApprove holds transfers from unknown senders until an operator
+	// approves or denies them through the importer API
+	LocalSendApprove = "approve"
+)
+
+// LocalSendSenderPolicies lists every valid policy for unknown senders
+var LocalSendSenderPolicies = []string{LocalSendAllow, LocalSendDeny, LocalSendApprove}
+
+// LocalSendSender is a rule for a single localsend sender, matched by alias
+// (case-insensitive) and/or by the fingerprint of its certificate. When both
+// are set, both must match
+type LocalSendSender struct {
+	Alias       string `yaml:"alias,omitempty"`
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+
+	// Deny refuses every transfer from the sender
+	Deny bool `yaml:"deny,omitempty"`
+
+	// Pin is required from this sender instead of the global require_password
+	Pin string `yaml:"pin,omitempty"`
+
+	// Subdirectory of the storage path that files from this sender are placed
+	// in, instead of the sender's alias
+	Subdirectory string `yaml:"subdirectory,omitempty"`
 }
 
 type LocalSendConfig struct {
@@ -51,18 +271,54 @@ type LocalSendConfig struct {
 	ListenPort          int      `yaml:"listen_port,omitempty"`
 	UdpBroadcastAddress string   `yaml:"udp_broadcast_address,omitempty"`
 	UdpBroadcastPort    int      `yaml:"udp_broadcast_port,omitempty"`
+	Https               bool     `yaml:"https"`
+	TlsDirectory        string   `yaml:"tls_directory"`
+	SessionTimeout      int      `yaml:"session_timeout"`
 	AllowedAliases      []string `yaml:"allowed_aliases"`
 	RequirePassword     string   `yaml:"require_password"`
+	FreeSpaceReserveMB  int64    `yaml:"free_space_reserve_mb"`
+
+	// Senders are checked in order, the first rule matching the sender applies.
+	// Senders that don't match any rule are handled by UnknownSenders
+	Senders         []LocalSendSender `yaml:"senders"`
+	UnknownSenders  string            `yaml:"unknown_senders"`
+	ApprovalTimeout int               `yaml:"approval_timeout"`
+
+	// PinAttempts is the number of wrong PINs a sender may try before it is
+	// locked out for PinLockout seconds
+	PinAttempts int `yaml:"pin_attempts"`
+	PinLockout  int `yaml:"pin_lockout"`
 }
 
 var DefaultImporterConfig = ImporterConfig{
-	LiveDataDir:           "./uploads",
-	LogLevel:              0,
-	ListenAddress:         "127.0.0.1",
-	ListenPort:            7273,
-	ForceDryRun:           false,
-	DisableAutoProcessing: false,
-	EnabledProcessors:     []string{},
+	LiveDataDir:              "./uploads",
+	JobDatabase:              "./ccmm_importer.db",
+	LogLevel:                 0,
+	ListenAddress:            "127.0.0.1",
+	ListenPort:               7273,
+	ForceDryRun:              false,
+	DisableAutoProcessing:    false,
+	MaxConcurrentImports:     6,
+	DestinationIOLimit:       2,
+	ShutdownTimeout:          30,
+	FreeSpaceReserveMB:       1024,
+	SiteTimezone:             "",
+	EnabledProcessors:        []string{},
+	ProcessorDefinitionsDir:  "",
+	DestinationTemplate:      "{{.Quarter}}/{{.Date}}/{{.MediaType}}/{{.SourceName}}/{{.FileName}}",
+	ServiceDirectoryTemplate: "{{.Quarter}}/{{.Date}}",
+	ConflictPolicy:           ConflictKeepBothByHash,
+	PostImportAction:         PostImportNone,
+	EventNames:               map[string]string{},
+	Processors:               map[string]ProcessorConfig{},
+	DeviceWatcher: DeviceWatcherConfig{
+		Enabled:        true,
+		AllowedFormats: []string{"vfat", "exfat"},
+		AllowedLabels:  []string{},
+		IgnoredLabels:  []string{"^EFI$"},
+		SettleTimeout:  15,
+	},
+	Notifications: []NotificationConfig{},
 	LocalSend: LocalSendConfig{
 		Alias:               "",
 		StoragePath:         "./uloads",
@@ -71,8 +327,23 @@ var DefaultImporterConfig = ImporterConfig{
 		ListenPort:          53317,
 		UdpBroadcastAddress: "224.0.0.167",
 		UdpBroadcastPort:    53317,
+		Https:               true,
+		TlsDirectory:        "./localsend_tls",
+		SessionTimeout:      60,
 		AllowedAliases:      []string{"__ALL__"},
 		RequirePassword:     "",
+		FreeSpaceReserveMB:  1024,
+		Senders:             []LocalSendSender{},
+		UnknownSenders:      "",
+		ApprovalTimeout:     120,
+		PinAttempts:         5,
+		PinLockout:          300,
+	},
+	StatusLight: StatusLightConfig{
+		Backend:    StatusLightNone,
+		SerialPort: "",
+		BaudRate:   115200,
+		Slots:      []StatusLightSlot{},
 	},
 }
 
@@ -81,6 +352,9 @@ type ClientConfig struct {
 	LogLevel       int8            `yaml:"log_level"`
 	ClientName     string          `yaml:"client_name"`
 	ManagerAddress string          `yaml:"manager_address"`
+	ManagerToken   string          `yaml:"manager_token"`
+	SyncWorkers    int             `yaml:"sync_workers"`
+	SyncRetries    int             `yaml:"sync_retries"`
 }
 
 var DefaultClientConfig = ClientConfig{
@@ -90,14 +364,19 @@ var DefaultClientConfig = ClientConfig{
 	LogLevel:       -4,
 	ClientName:     "",
 	ManagerAddress: "http://localhost:7280",
+	ManagerToken:   "",
+	SyncWorkers:    4,
+	SyncRetries:    3,
 }
 
 type ManagerConfig struct {
-	DataDirs      DataDirectories `yaml:"data_dirs"`
-	LogLevel      int8            `yaml:"log_level"`
-	ListenAddress string          `yaml:"listen_address"`
-	ListenPort    int32           `yaml:"listen_port"`
-	ForceReadOnly bool            `yaml:"force_read_only"`
+	DataDirs      DataDirectories      `yaml:"data_dirs"`
+	LogLevel      int8                 `yaml:"log_level"`
+	ListenAddress string               `yaml:"listen_address"`
+	ListenPort    int32                `yaml:"listen_port"`
+	ForceReadOnly bool                 `yaml:"force_read_only"`
+	AuthTokens    []string             `yaml:"auth_tokens"`
+	Notifications []NotificationConfig `yaml:"notifications"`
 }
 
 type DataDirectories struct {
@@ -112,4 +391,6 @@ var DefaultManagerConfig = ManagerConfig{
 	ListenAddress: "0.0.0.0",
 	ListenPort:    7280,
 	ForceReadOnly: false,
+	AuthTokens:    []string{},
+	Notifications: []NotificationConfig{},
 }
@@ -29,14 +29,29 @@ import "time"
 // SourceFile desribes a file that is identified to be imported by
 // the importer tool
 type SourceFile struct {
-	FileName     string
-	SourcePath   string
-	Size         int64
-	MediaType    string
-	SourceName   string
-	CaptureDate  time.Time
-	FileModTime  time.Time
-	VolumeFormat string
+	FileName      string `json:"file_name"`
+	SourcePath    string `json:"source_path"`
+	Size          int64  `json:"size"`
+	MediaType     string `json:"media_type"`
+	SourceName    string `json:"source_name"`
+	SourceSerial  string `json:"source_serial,omitempty"`
+	ProcessorName string `json:"processor_name"`
+
+	// CaptureTime is when the file was recorded, in the site timezone and
+	// corrected for the clock offset of the source
+	CaptureTime time.Time `json:"capture_time"`
+
+	// CaptureDateOnly is set when the processor could only determine the date
+	// of the recording, in which case CaptureTime is midnight of that date and
+	// no clock offset is applied to it
+	CaptureDateOnly bool `json:"capture_date_only,omitempty"`
+
+	// CaptureDate is the service date the file belongs to, which is derived
+	// from CaptureTime (midnight of its date in the site timezone)
+	CaptureDate time.Time `json:"capture_date"`
+
+	FileModTime  time.Time `json:"mod_dtm"`
+	VolumeFormat string    `json:"volume_format"`
 }
 
 // SyncRequest describes a request to synchronize between client
@@ -45,12 +60,8 @@ type SyncRequest struct {
 	// ClientName is the hostname of the client machine requesting the sync
 	ClientName string `json:"client_name"`
 
-	// SyncType is what phase of the sync process does this describe.
-	// Valid values:
-	//   - request
-	//   - plan
-	// TODO: make this an enum?
-	SyncType     string                `json:"sync_type"`
+	// SyncType is what phase of the sync process does this describe
+	SyncType     SyncType              `json:"sync_type"`
 	Services     []string              `json:"services"`
 	MediaTypes   []string              `json:"media_types"`
 	ServiceFiles map[string][]SyncFile `json:"service_files"`
@@ -67,14 +78,76 @@ type SyncFile struct {
 	FileModTime time.Time `json:"mod_dtm"`
 	Service     string    `json:"service"`
 
-	// possible actions:
-	//   none (file exists in both locations) - no transmission required
-	//   update (file needs to be updated on the manager or the client side) - requires send on other side
-	//   add (file doesn't exist and needs to be added on the manager or client side) - requires send on other side
-	//   send (file needs to be sent from the manager or client side to the opposing side) - requires "add" or "update" on other side
-	//   moved (file needs to be moved on either the manager or client side to a new location) - no transmission required
-	//   delete (file needs to be deleted on either the manager or client side) - no transmission required
-	// TODO: make these enums?
-	ServerAction string `json:"manager_action"`
-	ClientAction string `json:"client_action"`
+	// Hash is the sha256 hash of the file, if known (ex: from the import manifest)
+	Hash string `json:"hash,omitempty"`
+
+	// PreviousPath is set when the action is "moved" and is the current path of
+	// the file that needs to be moved to FilePath
+	PreviousPath string `json:"previous_path,omitempty"`
+
+	ServerAction SyncAction `json:"manager_action"`
+	ClientAction SyncAction `json:"client_action"`
+}
+
+// SyncType describes which phase of the sync process a SyncRequest belongs to
+type SyncType string
+
+const (
+	// SyncTypeRequest is sent by the client and lists the files the client has
+	SyncTypeRequest SyncType = "request"
+
+	// SyncTypePlan is returned by the manager and lists the actions each side
+	// needs to take for every file
+	SyncTypePlan SyncType = "plan"
+)
+
+// SyncAction describes what the manager or client needs to do with a file
+type SyncAction string
+
+const (
+	// SyncActionNone the file exists in both locations - no transmission required
+	SyncActionNone SyncAction = "none"
+
+	// SyncActionUpdate the file needs to be updated on this side - requires send on other side
+	SyncActionUpdate SyncAction = "update"
+
+	// SyncActionAdd the file doesn't exist and needs to be added on this side - requires send on other side
+	SyncActionAdd SyncAction = "add"
+
+	// SyncActionSend the file needs to be sent from this side to the opposing side - requires "add" or "update" on other side
+	SyncActionSend SyncAction = "send"
+
+	// SyncActionMoved the file needs to be moved on this side from PreviousPath to FilePath - no transmission required
+	SyncActionMoved SyncAction = "moved"
+
+	// SyncActionDelete the file needs to be deleted on this side - no transmission required
+	SyncActionDelete SyncAction = "delete"
+)
+
+// Headers used when transferring files between the client and manager
+const (
+	// ModTimeHeader carries the modification time of the file being transferred, in RFC3339 format
+	ModTimeHeader = "X-Ccmm-Mod-Time"
+
+	// HashHeader carries the sha256 hash of the complete file being uploaded, if known
+	HashHeader = "X-Ccmm-Sha256"
+
+	// PartialHashHeader carries the sha256 hash of the part of the file that was
+	// already uploaded, when resuming an upload. The manager only appends to its
+	// partial file if the hash matches
+	PartialHashHeader = "X-Ccmm-Partial-Sha256"
+)
+
+// UploadStatus describes the state of a file upload to the manager
+type UploadStatus struct {
+	// Path is the path of the file, relative to the media type directory of the service
+	Path string `json:"path"`
+
+	// Offset is the number of bytes already received. When resuming an upload,
+	// the next chunk must start at this offset
+	Offset int64 `json:"offset"`
+
+	// Complete is true once the whole file was received and moved into place
+	Complete bool   `json:"complete"`
+	Hash     string `json:"hash,omitempty"`
 }
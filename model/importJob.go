@@ -0,0 +1,122 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package model
+
+import "time"
+
+// ImportJobStatus describes the current state of a single import job, as
+// exposed by the importer status API
+type ImportJobStatus struct {
+	ID          int                   `json:"id"`
+	VolumePath  string                `json:"volume_path"`
+	VolumeLabel string                `json:"volume_label"`
+	Device      string                `json:"device"`
+	DryRun      bool                  `json:"dry_run"`
+	Status      string                `json:"status"`
+	Processors  []string              `json:"processors"`
+	Files       []SourceFile          `json:"files,omitempty"`
+	Results     []ImportFileResult    `json:"results,omitempty"`
+	Progress    ImportProgress        `json:"progress"`
+	QueuedAt    time.Time             `json:"queued_at"`
+	PostImport  *PostImportReport     `json:"post_import,omitempty"`
+	Transitions []ImportJobTransition `json:"transitions,omitempty"`
+
+	// Volume describes the volume as it was probed when the job started scanning
+	Volume *Volume `json:"volume,omitempty"`
+
+	// Diagnostics describes what each enabled processor made of the volume
+	Diagnostics []ProcessorDiagnostic `json:"diagnostics,omitempty"`
+
+	// Resumed is the number of times the job was picked back up after the
+	// importer was restarted while the job was running
+	Resumed int `json:"resumed,omitempty"`
+}
+
+// ImportJobTransition records the time at which an import job entered a state
+type ImportJobTransition struct {
+	Status  string    `json:"status"`
+	At      time.Time `json:"at"`
+	Message string    `json:"message,omitempty"`
+}
+
+// ImportHistoryFilter narrows down the list of past import jobs returned by
+// the importer history API. Zero values match everything
+type ImportHistoryFilter struct {
+	Since       time.Time
+	Until       time.Time
+	VolumeLabel string
+	Processor   string
+	Limit       int
+}
+
+// ImportProgress describes how far along the copy phase of an import job is
+type ImportProgress struct {
+	TotalFiles       int       `json:"total_files"`
+	CompletedFiles   int       `json:"completed_files"`
+	TotalBytes       int64     `json:"total_bytes"`
+	BytesCopied      int64     `json:"bytes_copied"`
+	CurrentFile      string    `json:"current_file"`
+	CurrentFileBytes int64     `json:"current_file_bytes"`
+	BytesPerSecond   float64   `json:"bytes_per_second"`
+	StartedAt        time.Time `json:"started_at"`
+	FinishedAt       time.Time `json:"finished_at"`
+}
+
+// PostImportReport describes what the post-import action did, or would do in
+// a dry run, to the volume once an import job finished
+type PostImportReport struct {
+	Action string `json:"action"`
+	DryRun bool   `json:"dry_run"`
+
+	// SkippedReason is set when the safety checks prevented the action from running
+	SkippedReason string `json:"skipped_reason,omitempty"`
+
+	// Deleted lists the files that were deleted, or would be deleted in a dry run
+	Deleted []string `json:"deleted"`
+
+	// Protected lists the control files that were left on the volume
+	Protected []string `json:"protected"`
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImporterStatus is the response returned by the importer status endpoint
+type ImporterStatus struct {
+	Hostname string               `json:"hostname"`
+	DryRun   bool                 `json:"dry_run"`
+	Devices  []ImportDeviceStatus `json:"devices"`
+	Jobs     []ImportJobStatus    `json:"jobs"`
+}
+
+// ImportDeviceStatus describes the import jobs of a single physical source
+// device. Only one job runs per device at a time
+type ImportDeviceStatus struct {
+	Device     string `json:"device"`
+	RunningJob int    `json:"running_job,omitempty"`
+	QueuedJobs []int  `json:"queued_jobs"`
+
+	// BytesPerSecond is the throughput of the running job, if any
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
@@ -20,6 +20,8 @@
 
 package model
 
+import "time"
+
 type ReceiveSession struct {
 	Alias          string
 	TotalFiles     int
@@ -66,3 +68,17 @@ type PrepareReceiveResponse struct {
 	SessionID string            `json:"sessionId"`
 	Files     map[string]string `json:"files"` // File ID to Token map
 }
+
+// LocalSendPendingSender is a transfer from an unknown sender that is waiting
+// for an operator to approve or deny it
+type LocalSendPendingSender struct {
+	ID          string    `json:"id"`
+	Alias       string    `json:"alias"`
+	Fingerprint string    `json:"fingerprint"`
+	DeviceModel string    `json:"device_model,omitempty"`
+	IPAddress   string    `json:"ip_address"`
+	Files       int       `json:"files"`
+	TotalBytes  int64     `json:"total_bytes"`
+	RequestedAt time.Time `json:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
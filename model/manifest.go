@@ -0,0 +1,77 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package model
+
+import "time"
+
+// Manifest describes the contents of a manifest file that is written to each
+// service date directory by the importer. It records where every imported file
+// came from along with the hash that was verified at import time so that later
+// tooling can trust the hash instead of relying on size and mod time alone
+type Manifest struct {
+	Version int `json:"version"`
+
+	// Files is keyed by the path of the imported file, relative to the
+	// directory containing the manifest
+	Files map[string]ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes a single imported file in a manifest
+type ManifestEntry struct {
+	SourceName    string    `json:"source_name"`
+	OriginalPath  string    `json:"original_path"`
+	Size          int64     `json:"size"`
+	HashAlgorithm string    `json:"hash_algorithm"`
+	Hash          string    `json:"hash"`
+	CaptureTime   time.Time `json:"capture_time"`
+	CaptureDate   time.Time `json:"capture_date"`
+	ImportJobID   int       `json:"import_job_id"`
+	ImportedAt    time.Time `json:"imported_at"`
+}
+
+// ImportFileResult describes the outcome of importing a single file
+type ImportFileResult struct {
+	SourcePath string `json:"source_path"`
+	DestPath   string `json:"dest_path"`
+	Size       int64  `json:"size"`
+	Hash       string `json:"hash,omitempty"`
+
+	// Action is what happened to the file. Valid values:
+	//   - copied
+	//   - skipped (the destination already contained an identical file)
+	//   - dry_run
+	//   - failed
+	Action string `json:"action"`
+
+	// Verified is true when the destination file hash was confirmed to match the source
+	Verified bool `json:"verified"`
+
+	// Conflict is the conflict policy that was applied if a different file
+	// already existed at ConflictWith, the original destination path
+	Conflict     string `json:"conflict,omitempty"`
+	ConflictWith string `json:"conflict_with,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
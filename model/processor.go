@@ -0,0 +1,117 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Confidence is how sure a processor is that a volume was written by the
+// device it handles, from 0 (not at all) to 100
+type Confidence int
+
+const (
+	// NotDetected the volume isn't one the processor handles
+	NotDetected Confidence = 0
+
+	// LowConfidence only signs shared by many devices were found
+	LowConfidence Confidence = 25
+
+	// MediumConfidence the directory layout matches, but nothing that
+	// identifies the device itself (ex: a volume label) was checked
+	MediumConfidence Confidence = 50
+
+	// HighConfidence the directory layout matches and the device was
+	// identified by its volume label or file metadata
+	HighConfidence Confidence = 90
+)
+
+// Detection is the result of a processor checking a volume
+type Detection struct {
+	Confidence Confidence `json:"confidence"`
+
+	// Reason describes what matched, or why the volume was disqualified
+	Reason string `json:"reason"`
+}
+
+// Kinds of ProcessorError
+var (
+	// ErrVolumeUnreadable a file or directory on the volume couldn't be read
+	ErrVolumeUnreadable = errors.New("volume could not be read")
+
+	// ErrUnexpectedLayout the volume was detected, but files the device always
+	// writes alongside the media are missing
+	ErrUnexpectedLayout = errors.New("unexpected volume layout")
+
+	// ErrMetadata the tools used to read metadata from the media files failed
+	ErrMetadata = errors.New("failed to read metadata")
+)
+
+// ProcessorError is returned by processors that couldn't finish checking a
+// volume or listing its files. Use errors.Is to test for its Kind
+type ProcessorError struct {
+	// Kind is one of ErrVolumeUnreadable, ErrUnexpectedLayout or ErrMetadata
+	Kind error
+
+	// Path is the file or directory that caused the error
+	Path string
+
+	Err error
+}
+
+func (e *ProcessorError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: '%s'", e.Kind, e.Path)
+	}
+
+	return fmt.Sprintf("%s: '%s': %s", e.Kind, e.Path, e.Err)
+}
+
+func (e *ProcessorError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+
+	return []error{e.Kind, e.Err}
+}
+
+// ProcessorDiagnostic describes how a single processor handled the volume of
+// an import job, so that it can be seen in the job why a volume was or wasn't
+// imported the way it was
+type ProcessorDiagnostic struct {
+	Processor string `json:"processor"`
+	Detection
+
+	// Files is the number of files the processor found on the volume
+	Files int `json:"files"`
+
+	// Duplicates is the number of files that were left to a processor with a
+	// higher confidence, as both found them
+	Duplicates int `json:"duplicates,omitempty"`
+
+	// Error is set if the processor failed to check the volume or list its files
+	Error string `json:"error,omitempty"`
+}
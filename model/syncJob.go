@@ -24,12 +24,20 @@
 
 package model
 
+import "time"
+
 // SyncConfig is currently limited to providing sync support for services. Additional
 // resources, such as graphics, clips, documents, etc are not supported. That will be
 // designed and built later
 type SyncConfig struct {
 	Services   []string `json:"services"`
 	MediaTypes []string `json:"media_types"`
-	DryRun     bool     `json:"dry_run"`
-	Dump       bool     `json:"dump"`
+
+	// Quarters (ex: 2024 Q4) and Since select every service, on either the client
+	// or the manager, in the provided quarters or on or after the provided date
+	Quarters []string  `json:"quarters"`
+	Since    time.Time `json:"since"`
+
+	DryRun bool `json:"dry_run"`
+	Dump   bool `json:"dump"`
 }
@@ -0,0 +1,78 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package model
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Volume describes a volume that is being imported. It is probed once, when
+// the import job starts scanning, and handed to every processor so that they
+// don't each have to look up the same details. Details that couldn't be
+// determined (ex: for a plain directory) are left empty
+type Volume struct {
+	// Path is where the volume is mounted, the directory being imported or the
+	// archive standing in for a volume
+	Path string `json:"path"`
+
+	// FS holds the files of the volume, processors read the volume through it
+	// rather than through Path. For a mount or directory, the files are also
+	// available at Path, which external tools (ex: exiftool) need
+	FS fs.FS `json:"-"`
+
+	// Archive is true when the volume is read from a tar or zip archive, in
+	// which case its files don't exist outside of FS and can't be imported
+	Archive bool `json:"archive,omitempty"`
+
+	// Device is the device node holding the volume (ex: /dev/sdb1)
+	Device string `json:"device,omitempty"`
+
+	Label string `json:"label"`
+
+	// FsType is the format of the filesystem (FAT12, FAT16, FAT32, ExFAT, NTFS
+	// or HFS+), or empty if it is some other, unsupported, format
+	FsType string `json:"fs_type"`
+
+	UUID string `json:"uuid,omitempty"`
+
+	// Size is the total size of the filesystem in bytes
+	Size int64 `json:"size"`
+
+	// Location is the timezone of the site the volume was recorded at. Devices
+	// record wall clock times without a timezone (ex: in file names, EXIF or
+	// FAT modification times), which are read in this timezone
+	Location *time.Location `json:"-"`
+}
+
+// TimeLocation returns the Location of the volume, or the local timezone of
+// the system if none was set
+func (v Volume) TimeLocation() *time.Location {
+	if v.Location == nil {
+		return time.Local
+	}
+
+	return v.Location
+}
@@ -36,10 +36,13 @@ if [ ! -f "$SCRIPT_DIR/config.yml" ]; then
     cp "$SCRIPT_DIR/config.example.yml" "$SCRIPT_DIR/config.yml"
 fi
 
-## patch and copy the udev rule
-echo "Installing udev rule and reloading udev rules..."
-sed "$SED_EXPRESSION" $SCRIPT_DIR/supporting/99-ccmm_importer.rules > /etc/udev/rules.d/99-ccmm_importer.rules
-udevadm control --reload-rules
+## the importer server now watches for attached devices itself, so remove the
+## udev rule left behind by older versions
+if [ -f /etc/udev/rules.d/99-ccmm_importer.rules ]; then
+    echo "Removing old udev rule and reloading udev rules..."
+    rm -f /etc/udev/rules.d/99-ccmm_importer.rules
+    udevadm control --reload-rules
+fi
 
 ## patch and copy the polkit rules, if needed
 if [ -d /etc/polkit-1/rules.d ]; then
@@ -0,0 +1,107 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+// Reference firmware for the serial status light backend. Drives an RGB LED
+// (common cathode) per card reader slot from the lines sent by the importer:
+//
+//   SLOT <id> <STATE>
+//
+// where STATE is one of IDLE, DETECTING, IMPORTING, DONE or ERROR. Slots are
+// numbered starting at 1, set the pins of each slot below to match your wiring.
+// Works on any Arduino compatible board, including the RP2040
+
+const int SLOT_COUNT = 2;
+
+// red, green, blue pin of each slot
+const int SLOT_PINS[SLOT_COUNT][3] = {
+  {2, 3, 4},
+  {5, 6, 7},
+};
+
+enum State { IDLE, DETECTING, IMPORTING, DONE, ERROR_STATE };
+
+State slotStates[SLOT_COUNT];
+String line;
+
+void setup() {
+  Serial.begin(115200);
+
+  for (int slot = 0; slot < SLOT_COUNT; slot++) {
+    for (int color = 0; color < 3; color++) {
+      pinMode(SLOT_PINS[slot][color], OUTPUT);
+    }
+    slotStates[slot] = IDLE;
+  }
+}
+
+void loop() {
+  while (Serial.available() > 0) {
+    char c = Serial.read();
+    if (c == '\n') {
+      handleLine(line);
+      line = "";
+    } else if (c != '\r') {
+      line += c;
+    }
+  }
+
+  // detecting blinks blue, everything else is a solid color
+  bool blinkOn = (millis() / 250) % 2 == 0;
+  for (int slot = 0; slot < SLOT_COUNT; slot++) {
+    switch (slotStates[slot]) {
+      case IDLE:        setColor(slot, false, false, false); break;
+      case DETECTING:   setColor(slot, false, false, blinkOn); break;
+      case IMPORTING:   setColor(slot, true, true, false); break;
+      case DONE:        setColor(slot, false, true, false); break;
+      case ERROR_STATE: setColor(slot, true, false, false); break;
+    }
+  }
+}
+
+void handleLine(String text) {
+  int slot;
+  char state[16];
+  if (sscanf(text.c_str(), "SLOT %d %15s", &slot, state) != 2 || slot < 1 || slot > SLOT_COUNT) {
+    return;
+  }
+
+  String name = String(state);
+  if (name == "IDLE") {
+    slotStates[slot - 1] = IDLE;
+  } else if (name == "DETECTING") {
+    slotStates[slot - 1] = DETECTING;
+  } else if (name == "IMPORTING") {
+    slotStates[slot - 1] = IMPORTING;
+  } else if (name == "DONE") {
+    slotStates[slot - 1] = DONE;
+  } else if (name == "ERROR") {
+    slotStates[slot - 1] = ERROR_STATE;
+  }
+}
+
+void setColor(int slot, bool red, bool green, bool blue) {
+  digitalWrite(SLOT_PINS[slot][0], red ? HIGH : LOW);
+  digitalWrite(SLOT_PINS[slot][1], green ? HIGH : LOW);
+  digitalWrite(SLOT_PINS[slot][2], blue ? HIGH : LOW);
+}
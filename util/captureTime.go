@@ -0,0 +1,127 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"time"
+
+	"ccmm/model"
+)
+
+// GetSiteLocation returns the timezone configured by site_timezone, which
+// wall clock times recorded by devices are read in. The local timezone of the
+// system is used if none is configured or it can't be loaded
+func GetSiteLocation(config model.ImporterConfig) *time.Location {
+	if config.SiteTimezone == "" {
+		return time.Local
+	}
+
+	location, err := time.LoadLocation(config.SiteTimezone)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not load site_timezone '%s', using the local timezone: %s", config.SiteTimezone, err.Error()))
+		return time.Local
+	}
+
+	return location
+}
+
+// WallClockIn returns the time with the same date and time of day as the
+// provided time, but in the provided location. A wall clock time that is
+// skipped when daylight saving time starts is moved forward by the length of
+// the gap, one that happens twice when it ends is taken as the first
+func WallClockIn(dtm time.Time, location *time.Location) time.Time {
+	result := time.Date(dtm.Year(), dtm.Month(), dtm.Day(), dtm.Hour(), dtm.Minute(), dtm.Second(), dtm.Nanosecond(), location)
+
+	// time.Date may move a skipped wall clock time either way, so it is read
+	// with the offset in effect before the gap instead
+	if result.Hour() != dtm.Hour() || result.Minute() != dtm.Minute() {
+		wallClock := time.Date(dtm.Year(), dtm.Month(), dtm.Day(), dtm.Hour(), dtm.Minute(), dtm.Second(), dtm.Nanosecond(), time.UTC)
+		_, offsetBefore := wallClock.Add(-24 * time.Hour).In(location).Zone()
+		result = wallClock.Add(-time.Duration(offsetBefore) * time.Second).In(location)
+	}
+
+	return result
+}
+
+// ParseWallClock parses a date and time recorded by the clock of a device,
+// which has no timezone, as a time in the location. Unlike
+// time.ParseInLocation, a time skipped when daylight saving time starts is
+// moved forward (see WallClockIn). The layout must not include a timezone
+func ParseWallClock(layout string, value string, location *time.Location) (time.Time, error) {
+	dtm, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return WallClockIn(dtm, location), nil
+}
+
+// VolumeModTime returns the modification time of a file on the volume in the
+// location of the volume. FAT and exFAT keep modification times as wall clock
+// times without a timezone, which linux reads as UTC unless the volume was
+// mounted with a tz option, so their wall clock is moved to the location
+func VolumeModTime(volume model.Volume, modTime time.Time) time.Time {
+	if runtime.GOOS == "linux" && slices.Contains([]string{FAT12, FAT16, FAT32, ExFAT}, volume.FsType) {
+		return WallClockIn(modTime.UTC(), volume.TimeLocation())
+	}
+
+	return modTime.In(volume.TimeLocation())
+}
+
+// DateWithTimeOf returns the provided date with the time of day of clock, when
+// clock falls on that date in the location. Otherwise the clock belongs to some
+// other recording (ex: a file modified after the fact) and the start of the
+// date is returned, with false to indicate that only the date is known. Only
+// the year, month and day of date are used
+func DateWithTimeOf(date time.Time, clock time.Time, location *time.Location) (time.Time, bool) {
+	clock = clock.In(location)
+
+	if clock.Year() == date.Year() && clock.Month() == date.Month() && clock.Day() == date.Day() {
+		return clock, true
+	}
+
+	return startOfDay(date, location), false
+}
+
+// GetServiceDate returns the service date of a file captured at the provided
+// time, which is the start of its date in the location
+func GetServiceDate(captureTime time.Time, location *time.Location) time.Time {
+	return startOfDay(captureTime.In(location), location)
+}
+
+//
+// private functions
+//
+
+// startOfDay returns midnight of the date in the location, or the first time
+// of the date where daylight saving time starts at midnight (ex: 01:00 in
+// America/Santiago), which time.Date would place on the day before
+func startOfDay(date time.Time, location *time.Location) time.Time {
+	return WallClockIn(time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC), location)
+}
@@ -0,0 +1,180 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"ccmm/model"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone '%s' is not available: %s", name, err)
+	}
+
+	return location
+}
+
+func TestWallClockIn(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		wall     time.Time
+		want     string
+	}{
+		{"standard time", "America/New_York", time.Date(2024, 1, 14, 10, 30, 0, 0, time.UTC), "2024-01-14T10:30:00-05:00"},
+		{"daylight saving time", "America/New_York", time.Date(2024, 7, 14, 10, 30, 0, 0, time.UTC), "2024-07-14T10:30:00-04:00"},
+		{"skipped in spring", "America/New_York", time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC), "2024-03-10T03:30:00-04:00"},
+		{"repeated in autumn", "America/New_York", time.Date(2024, 11, 3, 1, 30, 0, 0, time.UTC), "2024-11-03T01:30:00-04:00"},
+		{"after the repeat", "America/New_York", time.Date(2024, 11, 3, 2, 30, 0, 0, time.UTC), "2024-11-03T02:30:00-05:00"},
+		{"skipped in Europe", "Europe/London", time.Date(2024, 3, 31, 1, 15, 0, 0, time.UTC), "2024-03-31T02:15:00+01:00"},
+		{"half hour shift", "Australia/Lord_Howe", time.Date(2024, 10, 6, 2, 15, 0, 0, time.UTC), "2024-10-06T02:45:00+11:00"},
+		{"skipped midnight", "America/Santiago", time.Date(2024, 9, 8, 0, 0, 0, 0, time.UTC), "2024-09-08T01:00:00-03:00"},
+		{"other zone", "America/New_York", time.Date(2024, 1, 14, 10, 30, 0, 0, time.FixedZone("", 3600)), "2024-01-14T10:30:00-05:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := WallClockIn(test.wall, loadLocation(t, test.location))
+			if got.Format(time.RFC3339) != test.want {
+				t.Errorf("expected %s, got %s", test.want, got.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestParseWallClock(t *testing.T) {
+	location := loadLocation(t, "America/New_York")
+
+	got, err := ParseWallClock("060102-150405", "240310-023000", location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2024-03-10T03:30:00-04:00"; got.Format(time.RFC3339) != want {
+		t.Errorf("expected %s, got %s", want, got.Format(time.RFC3339))
+	}
+
+	if _, err := ParseWallClock("060102-150405", "241399-023000", location); err == nil {
+		t.Error("expected an error for an invalid date")
+	}
+}
+
+func TestVolumeModTime(t *testing.T) {
+	location := loadLocation(t, "America/New_York")
+
+	// linux reads the wall clock of a FAT file as UTC
+	modTime := time.Date(2024, 3, 10, 14, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		fsType string
+		want   string
+	}{
+		{FAT32, "2024-03-10T14:00:00-04:00"},
+		{ExFAT, "2024-03-10T14:00:00-04:00"},
+		{NTFS, "2024-03-10T10:00:00-04:00"},
+		{HFSPlus, "2024-03-10T10:00:00-04:00"},
+	}
+
+	for _, test := range tests {
+		want := test.want
+		if runtime.GOOS != "linux" {
+			want = "2024-03-10T10:00:00-04:00"
+		}
+
+		volume := model.Volume{FsType: test.fsType, Location: location}
+		if got := VolumeModTime(volume, modTime); got.Format(time.RFC3339) != want || got.Location() != location {
+			t.Errorf("%s: expected %s, got %s", test.fsType, want, got.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestDateWithTimeOf(t *testing.T) {
+	newYork := loadLocation(t, "America/New_York")
+	santiago := loadLocation(t, "America/Santiago")
+
+	tests := []struct {
+		name     string
+		location *time.Location
+		date     time.Time
+		clock    time.Time
+		want     string
+		hasTime  bool
+	}{
+		{"same day", newYork, time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC), "2024-03-10T11:00:00-04:00", true},
+		{"same day in UTC only", newYork, time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC), "2024-03-10T00:00:00-05:00", false},
+		{"other day", newYork, time.Date(2024, 11, 3, 0, 0, 0, 0, time.UTC), time.Date(2024, 11, 5, 15, 0, 0, 0, time.UTC), "2024-11-03T00:00:00-04:00", false},
+		{"skipped midnight", santiago, time.Date(2024, 9, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 9, 9, 15, 0, 0, 0, time.UTC), "2024-09-08T01:00:00-03:00", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, hasTime := DateWithTimeOf(test.date, test.clock, test.location)
+			if got.Format(time.RFC3339) != test.want || hasTime != test.hasTime {
+				t.Errorf("expected %s %t, got %s %t", test.want, test.hasTime, got.Format(time.RFC3339), hasTime)
+			}
+		})
+	}
+}
+
+func TestGetServiceDate(t *testing.T) {
+	newYork := loadLocation(t, "America/New_York")
+	santiago := loadLocation(t, "America/Santiago")
+
+	tests := []struct {
+		name        string
+		location    *time.Location
+		captureTime time.Time
+		want        string
+	}{
+		{"evening before the change", newYork, time.Date(2024, 3, 10, 4, 59, 0, 0, time.UTC), "2024-03-09"},
+		{"just before the change", newYork, time.Date(2024, 3, 10, 6, 59, 0, 0, time.UTC), "2024-03-10"},
+		{"just after the change", newYork, time.Date(2024, 3, 10, 7, 1, 0, 0, time.UTC), "2024-03-10"},
+		{"repeated hour", newYork, time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), "2024-11-03"},
+		{"late on the repeated day", newYork, time.Date(2024, 11, 4, 4, 59, 0, 0, time.UTC), "2024-11-03"},
+		{"skipped midnight", santiago, time.Date(2024, 9, 8, 14, 0, 0, 0, time.UTC), "2024-09-08"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := GetServiceDate(test.captureTime, test.location)
+
+			if got.Format(time.DateOnly) != test.want || got.Location() != test.location {
+				t.Errorf("expected %s, got %s", test.want, got)
+			}
+			if !got.Before(test.captureTime) && !got.Equal(test.captureTime) {
+				t.Errorf("service date %s is after the capture time %s", got, test.captureTime)
+			}
+			if got.Add(-time.Second).Format(time.DateOnly) == test.want {
+				t.Errorf("service date %s is not the start of the day", got)
+			}
+		})
+	}
+}
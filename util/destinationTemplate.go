@@ -0,0 +1,250 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"ccmm/model"
+)
+
+// DestinationTemplateData is the data made available to destination and
+// service directory templates. For example:
+//
+//	{{.Quarter}}/{{.Date}}/{{.MediaType}}/{{.SourceName}}/{{.FileName}}
+//
+// In addition to these fields, the following functions are available:
+//   - weekday: name of the weekday of a date (ex: {{weekday .CaptureDate}} -> Sunday)
+//   - event: configured event name for a date (ex: {{event .CaptureDate}} -> Youth)
+//   - serial: serial number of the camera/recorder, or "Unknown" (ex: {{serial .}})
+//   - date: format a date using a go layout (ex: {{date "2006" .CaptureDate}} -> 2024)
+//   - lower / upper: change the case of a string
+type DestinationTemplateData struct {
+	Quarter       string
+	Date          string
+	Year          string
+	Month         string
+	Day           string
+	MediaType     string
+	SourceName    string
+	SourceSerial  string
+	ProcessorName string
+	FileName      string
+	CaptureDate   time.Time
+	CaptureTime   time.Time
+}
+
+// defaultEventName is returned by the event template function when no event
+// name has been configured for the given weekday
+const defaultEventName = "Service"
+
+var (
+	templateCache      = make(map[string]*template.Template)
+	templateCacheMutex sync.Mutex
+)
+
+// GetDestinationPath returns the full path to which the provided source file
+// should be imported, based on the configured destination template (or the
+// template override of the processor that found the file)
+func GetDestinationPath(config model.ImporterConfig, sourceFile model.SourceFile) (string, error) {
+	templateText := config.DestinationTemplate
+
+	if processorConfig, ok := config.Processors[sourceFile.ProcessorName]; ok && processorConfig.DestinationTemplate != "" {
+		templateText = processorConfig.DestinationTemplate
+	}
+
+	relativePath, err := renderPathTemplate(config, templateText, sourceFile)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(config.LiveDataDir, relativePath), nil
+}
+
+// GetManifestDirectory returns the service directory in which the manifest for
+// the provided source file is stored, based on the configured service directory
+// template
+func GetManifestDirectory(config model.ImporterConfig, sourceFile model.SourceFile) (string, error) {
+	relativePath, err := renderPathTemplate(config, config.ServiceDirectoryTemplate, sourceFile)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(config.LiveDataDir, relativePath), nil
+}
+
+// ValidateDestinationTemplates verifies that the destination templates in the
+// provided config can be parsed and that they produce usable paths. This is
+// intended to be called when the config is loaded so that a bad template is
+// caught before any files are imported
+func ValidateDestinationTemplates(config model.ImporterConfig) error {
+	sampleDate := time.Date(2024, 11, 3, 10, 30, 0, 0, time.Local)
+	sampleFiles := []model.SourceFile{
+		{FileName: "SAMPLE_0001.MOV", MediaType: "Video", SourceName: "Sample", CaptureDate: sampleDate, CaptureTime: sampleDate},
+		{FileName: "SAMPLE_0002.MOV", MediaType: "Video", SourceName: "Sample", CaptureDate: sampleDate, CaptureTime: sampleDate},
+	}
+
+	if _, err := renderPathTemplate(config, config.ServiceDirectoryTemplate, sampleFiles[0]); err != nil {
+		return fmt.Errorf("service_directory_template: %w", err)
+	}
+
+	templates := map[string]string{"destination_template": config.DestinationTemplate}
+	for processorName, processorConfig := range config.Processors {
+		if processorConfig.DestinationTemplate != "" {
+			templates[fmt.Sprintf("processors.%s.destination_template", processorName)] = processorConfig.DestinationTemplate
+		}
+	}
+
+	for configKey, templateText := range templates {
+		first, err := renderPathTemplate(config, templateText, sampleFiles[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", configKey, err)
+		}
+
+		second, err := renderPathTemplate(config, templateText, sampleFiles[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", configKey, err)
+		}
+
+		// if two different files end up at the same path, the template is missing {{.FileName}}
+		if first == second {
+			return fmt.Errorf("%s: template must produce a unique path per file (missing {{.FileName}}?)", configKey)
+		}
+	}
+
+	return nil
+}
+
+//
+// private functions
+//
+
+func renderPathTemplate(config model.ImporterConfig, templateText string, sourceFile model.SourceFile) (string, error) {
+	tmpl, err := getTemplate(config, templateText)
+	if err != nil {
+		return "", err
+	}
+
+	data := DestinationTemplateData{
+		Quarter:       GetServiceQuarter(sourceFile.CaptureDate),
+		Date:          sourceFile.CaptureDate.Format("2006-01-02"),
+		Year:          sourceFile.CaptureDate.Format("2006"),
+		Month:         sourceFile.CaptureDate.Format("01"),
+		Day:           sourceFile.CaptureDate.Format("02"),
+		MediaType:     sourceFile.MediaType,
+		SourceName:    sourceFile.SourceName,
+		SourceSerial:  sourceFile.SourceSerial,
+		ProcessorName: sourceFile.ProcessorName,
+		FileName:      sourceFile.FileName,
+		CaptureDate:   sourceFile.CaptureDate,
+		CaptureTime:   sourceFile.CaptureTime,
+	}
+
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, data); err != nil {
+		return "", err
+	}
+
+	rendered := strings.TrimSpace(output.String())
+
+	if rendered == "" {
+		return "", fmt.Errorf("template '%s' produced an empty path", templateText)
+	}
+
+	if path.IsAbs(rendered) {
+		return "", fmt.Errorf("template '%s' produced an absolute path '%s'", templateText, rendered)
+	}
+
+	cleaned := path.Clean(rendered)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("template '%s' produced a path outside of the data directory '%s'", templateText, rendered)
+	}
+
+	return cleaned, nil
+}
+
+func getTemplate(config model.ImporterConfig, templateText string) (*template.Template, error) {
+	templateCacheMutex.Lock()
+	defer templateCacheMutex.Unlock()
+
+	if tmpl, ok := templateCache[templateText]; ok {
+		return tmpl, nil
+	}
+
+	funcs := template.FuncMap{
+		"weekday": func(date time.Time) string {
+			return date.Weekday().String()
+		},
+		"event": func(date time.Time) string {
+			return getEventName(config.EventNames, date)
+		},
+		"serial": func(data DestinationTemplateData) string {
+			if data.SourceSerial == "" {
+				return "Unknown"
+			}
+			return data.SourceSerial
+		},
+		"date": func(layout string, date time.Time) string {
+			return date.Format(layout)
+		},
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}
+
+	tmpl, err := template.New("destination").Option("missingkey=error").Funcs(funcs).Parse(templateText)
+	if err != nil {
+		return nil, err
+	}
+
+	templateCache[templateText] = tmpl
+
+	return tmpl, nil
+}
+
+// getEventName looks up the event name configured for the weekday of the
+// provided date. Weekday names are matched case-insensitively and a "default"
+// entry may be provided to override the fallback name
+func getEventName(eventNames map[string]string, date time.Time) string {
+	weekday := date.Weekday().String()
+	fallback := defaultEventName
+
+	for day, eventName := range eventNames {
+		if strings.EqualFold(day, weekday) {
+			return eventName
+		}
+
+		if strings.EqualFold(day, "default") {
+			fallback = eventName
+		}
+	}
+
+	return fallback
+}
@@ -25,6 +25,12 @@
 
 package util
 
+import (
+	"errors"
+
+	"ccmm/model"
+)
+
 func TestPlatform() {
 	platformNotSupported(TestPlatform)
 }
@@ -49,7 +55,33 @@ func GetVolumeFormat(device string) string {
 	return ""
 }
 
+// ProbeVolume isn't supported on this platform, only the path of the volume
+// is filled in
+func ProbeVolume(mountPath string) model.Volume {
+	return model.Volume{Path: mountPath}
+}
+
 func PowerOffDevice(device string) bool {
 	platformNotSupported(GetVolumeName)
 	return false
 }
+
+// GetPhysicalDevice isn't supported on this platform, so every path is
+// treated as its own device
+func GetPhysicalDevice(path string) string {
+	return ""
+}
+
+// GetUsbPort isn't supported on this platform, an empty string is always returned
+func GetUsbPort(devicePath string) string {
+	return ""
+}
+
+// GetFreeSpace isn't supported on this platform, an error is always returned
+func GetFreeSpace(path string) (int64, error) {
+	return 0, errors.New("free space can't be determined on this platform")
+}
+
+func WatchForDeviceAttached(config model.DeviceWatcherConfig, deviceMountedCallback func(devicePath string, volumePath string)) {
+	platformNotSupported(WatchForDeviceAttached)
+}
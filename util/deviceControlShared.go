@@ -24,7 +24,36 @@
 
 package util
 
+import (
+	"regexp"
+	"strings"
+)
+
 const (
-	FAT32 = "FAT32"
-	ExFAT = "ExFAT"
+	FAT12   = "FAT12"
+	FAT16   = "FAT16"
+	FAT32   = "FAT32"
+	ExFAT   = "ExFAT"
+	NTFS    = "NTFS"
+	HFSPlus = "HFS+"
 )
+
+// usbPortPattern matches the sysfs name of a USB port (ex: 1-1.2), as opposed
+// to a bus (usb1) or an interface (1-1.2:1.0)
+var usbPortPattern = regexp.MustCompile(`^\d+-\d+(\.\d+)*$`)
+
+// UsbPortFromDevPath returns the USB port (ex: 1-1.2) that the device at the
+// provided sysfs path (ex: /devices/pci0000:00/.../usb1/1-1/1-1.2/1-1.2:1.0/
+// host0/target0:0:0/0:0:0:0/block/sdb/sdb1) is attached to. When hubs are
+// involved, the port closest to the device is returned. An empty string is
+// returned if the device isn't attached through USB
+func UsbPortFromDevPath(devPath string) string {
+	port := ""
+	for _, component := range strings.Split(devPath, "/") {
+		if usbPortPattern.MatchString(component) {
+			port = component
+		}
+	}
+
+	return port
+}
@@ -32,6 +32,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"ccmm/model"
 )
 
 // TestPlatform is really intended only for development purposes while
@@ -91,32 +94,35 @@ func UnmountVolume(device string) bool {
 // If an error occurs or an unknown format is mounted there, an empty
 // string will be returned instead
 func GetVolumeFormat(mountPath string) string {
-	command := "diskutil info %s0"
-	output, exitCode, _ := callExternalCommand(command, mountPath)
-
-	if exitCode != 0 {
+	info, ok := getDiskInfo(mountPath)
+	if !ok {
 		return ""
 	}
 
-	for _, line := range strings.Split(output, "\n") {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "File System Personality:") {
-			format := strings.TrimSpace(strings.Split(line, ":")[1])
+	return getFormat(info["File System Personality"])
+}
 
-			if format == "ExFAT" {
-				return ExFAT
-			}
+// ProbeVolume describes the volume mounted at the provided path (ex:
+// /Volumes/CANON). If the path isn't a mount point, only the path, label and
+// size of the volume are filled in
+func ProbeVolume(mountPath string) model.Volume {
+	volume := model.Volume{
+		Path:  mountPath,
+		Label: GetVolumeName(mountPath),
+	}
 
-			if format == "MS-DOS FAT32" {
-				return FAT32
-			}
+	if info, ok := getDiskInfo(mountPath); ok {
+		volume.Device = info["Device Node"]
+		volume.UUID = info["Volume UUID"]
+		volume.FsType = getFormat(info["File System Personality"])
+	}
 
-			slog.Warn("Unknown filesystem type: " + format)
-			break
-		}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mountPath, &stat); err == nil {
+		volume.Size = int64(stat.Blocks) * int64(stat.Bsize)
 	}
 
-	return ""
+	return volume
 }
 
 // PowerOffDevice attempts to eject or power off the device
@@ -146,8 +152,9 @@ func PowerOffDevice(device string) bool {
 // to watch the system for changes to storage devices, When a
 // new devices is added and automatically mounted by the operating
 // system, the function deviceMountedCallback will be executed with
-// the devicePath and the volumePath of the newly mounted volume.
-func WatchForDeviceAttached(deviceMountedCallback func(devicePath string, volumePath string)) {
+// the devicePath and the volumePath of the newly mounted volume,
+// if the volume label is allowed by the provided config.
+func WatchForDeviceAttached(config model.DeviceWatcherConfig, deviceMountedCallback func(devicePath string, volumePath string)) {
 	// run diskutil actiity and watch for "***DiskDescriptionChanged"
 	cmd := exec.Command("diskutil", "activity")
 	stdout, err := cmd.StdoutPipe()
@@ -189,15 +196,84 @@ func WatchForDeviceAttached(deviceMountedCallback func(devicePath string, volume
 			devicePath := "/dev/" + strings.Trim(strings.Split(mountLog, ",")[0], "'")   // /dev/disk7s1
 			volumePath := strings.TrimSuffix(strings.Split(mountLog, "file://")[1], "/") // /Volumes/CANON
 
+			if !DeviceAllowed(config, GetVolumeName(volumePath), "") {
+				slog.Info(fmt.Sprintf("Ignoring volume '%s', not allowed by device_watcher config", volumePath))
+				continue
+			}
+
 			deviceMountedCallback(devicePath, volumePath)
 		}
 	}
 }
 
+// GetPhysicalDevice returns an identifier for the device that holds the
+// filesystem at the provided path. On mac, each partition is treated as its
+// own device. An empty string is returned if the path can't be read
+func GetPhysicalDevice(path string) string {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("dev-%d", stat.Dev)
+}
+
+// GetUsbPort isn't supported on mac, an empty string is always returned
+func GetUsbPort(devicePath string) string {
+	return ""
+}
+
+// GetFreeSpace returns the number of bytes available to unprivileged users
+// on the filesystem that holds the provided path
+func GetFreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
 //
 // private functions
 //
 
+// getDiskInfo returns the fields reported by `diskutil info` for the provided
+// path. The second return value is false if diskutil doesn't know the path
+func getDiskInfo(path string) (map[string]string, bool) {
+	command := "diskutil info %s0"
+	output, exitCode, _ := callExternalCommand(command, path)
+
+	if exitCode != 0 {
+		return nil, false
+	}
+
+	info := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, found := strings.Cut(line, ":"); found {
+			info[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return info, true
+}
+
+// getFormat converts the file system personality reported by diskutil to
+// one of the supported formats, or an empty string if it isn't supported
+func getFormat(personality string) string {
+	switch personality {
+	case "ExFAT":
+		return ExFAT
+	case "MS-DOS FAT32":
+		return FAT32
+	case "":
+		return ""
+	}
+
+	slog.Warn("Unknown filesystem type: " + personality)
+	return ""
+}
+
 func pathMounted(path string) bool {
 	findmntCommand := "diskutil info %s0"
 	_, exitCode, _ := callExternalCommand(findmntCommand, path)
@@ -28,7 +28,12 @@ package util
 import (
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
+
+	"ccmm/model"
 )
 
 // TestPlatform is really intended only for development purposes while
@@ -45,15 +50,19 @@ func TestPlatform() {
 // and will return the name of the volume as a string
 func GetVolumeName(mountPath string) string {
 	slog.Debug(fmt.Sprintf("Querying volume name at '%s'", mountPath))
-	command := "findmnt -n --output label --mountpoint %s0"
-	output, _, err := callExternalCommand(command, mountPath)
+	mount, found := findMountByPath(mountPath)
+	if !found {
+		slog.Debug(fmt.Sprintf("Could not get volume name from path '%s'", mountPath))
+		return ""
+	}
 
+	info, err := inspectDevice(mount.Source)
 	if err != nil {
 		slog.Debug(fmt.Sprintf("Could not get volume name from path '%s'", mountPath))
 		return ""
 	}
 
-	return strings.TrimSuffix(output, "\n")
+	return info.Label
 }
 
 // MountVolume requires a device node (ex: /dev/sda1) be provided
@@ -64,18 +73,14 @@ func GetVolumeName(mountPath string) string {
 func MountVolume(device string) string {
 	// lets first make sure that the device isn't already mounted elsewhere,
 	// if it is, we'll use the path it is already mounted to
-	findmntCommand := "findmnt -o target -n %s0"
-	output, exitCode, _ := callExternalCommand(findmntCommand, device)
-
-	if exitCode == 0 {
-		existingPath := strings.TrimSuffix(output, "\n")
-		slog.Error(fmt.Sprintf("Device is already mounted at: '%s', no need to mount", existingPath))
-		return existingPath
+	if mount, found := findMountByDevice(device); found {
+		slog.Error(fmt.Sprintf("Device is already mounted at: '%s', no need to mount", mount.MountPoint))
+		return mount.MountPoint
 	}
 
 	slog.Info(fmt.Sprintf("Mounting device '%s'", device))
 	command := "udisksctl mount --block-device %s0"
-	output, exitCode, _ = callExternalCommand(command, device)
+	output, exitCode, _ := callExternalCommand(command, device)
 
 	if exitCode != 0 {
 		// TODO: improve logging here to pull from command stderr output
@@ -83,7 +88,19 @@ func MountVolume(device string) string {
 		return ""
 	}
 
-	return strings.Split(strings.TrimSuffix(output, "\n"), " at ")[1]
+	// the mount table is more reliable than the message udisksctl prints
+	// (ex: Mounted /dev/sdb1 at /media/user/CANON), which has changed wording
+	// between releases
+	if mount, found := findMountByDevice(device); found {
+		return mount.MountPoint
+	}
+
+	if _, mountPath, found := strings.Cut(strings.TrimSpace(output), " at "); found {
+		return strings.TrimSuffix(mountPath, ".")
+	}
+
+	slog.Error(fmt.Sprintf("Mounted device '%s' but could not determine where", device))
+	return ""
 }
 
 // UnmountVolume requires a device node (ex: /dev/sda1) be passed
@@ -124,41 +141,39 @@ func GetVolumeFormat(mountPath string) string {
 	// there may be a need for NTFS or AFS at some point, but i doubt-ish
 	// it (maybe AFS for blackmagic gear)
 
-	command := "findmnt -o source -n %s0"
-	output, exitCode, err := callExternalCommand(command, mountPath)
-
-	// testing err and exit code may be redundant, but whatever
-	if err != nil || exitCode != 0 {
+	mount, found := findMountByPath(mountPath)
+	if !found {
 		slog.Warn("Could not find a device mounted to the following path: " + mountPath)
 		return ""
 	}
 
-	devicePath := strings.TrimSpace(output)
-
-	command = "lsblk -o FSTYPE,FSVER -n -r %s0"
-	output, exitCode, err = callExternalCommand(command, devicePath)
-
-	// testing err and exit code may be redundant, but whatever
-	if err != nil || exitCode != 0 {
-		return ""
-	}
-
-	output = strings.TrimSpace(output)
-	parts := strings.Split(output, " ")
-	fstype := parts[0]
-	fsver := parts[1]
+	return getDeviceFormat(mount.Source)
+}
 
-	if fstype == "vfat" && fsver == "FAT32" {
-		return FAT32
+// ProbeVolume describes the volume mounted at the provided path (ex:
+// /media/user/CANON). If the path isn't a mount point, only the path and size
+// of the volume are filled in
+func ProbeVolume(mountPath string) model.Volume {
+	volume := model.Volume{Path: mountPath}
+
+	if mount, found := findMountByPath(mountPath); found {
+		volume.Device = mount.Source
+
+		if info, err := inspectDevice(mount.Source); err == nil {
+			volume.Label = info.Label
+			volume.UUID = info.UUID
+			volume.FsType = info.Format()
+		}
+	} else {
+		slog.Debug(fmt.Sprintf("'%s' is not a mount point, volume details are not available", mountPath))
 	}
 
-	if fstype == "exfat" {
-		return ExFAT
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mountPath, &stat); err == nil {
+		volume.Size = int64(stat.Blocks) * int64(stat.Bsize)
 	}
 
-	slog.Warn("Unknown filesystem type: " + output)
-
-	return ""
+	return volume
 }
 
 // PowerOffDevice attempts to eject or power off the device
@@ -187,20 +202,113 @@ func PowerOffDevice(device string) bool {
 	return err == nil
 }
 
-// WatchForDeviceAttached is not currently built for linux, but will be in the future
-func WatchForDeviceAttached(deviceMountedCallback func(devicePath string, volumePath string)) {
-	// TODO: build this instead of requiring udev rules be installed to the system
-	platformNotSupported(WatchForDeviceAttached)
+// GetDeviceLabelAndFormat requires a device node (ex: /dev/sda1) and returns
+// the volume label and filesystem type (ex: vfat, exfat) of the device, using
+// the same type names as lsblk. The device does not need to be mounted. If the
+// device can't be read and has not yet been probed by udev, the filesystem
+// type will be empty
+func GetDeviceLabelAndFormat(device string) (string, string, error) {
+	info, err := inspectDevice(device)
+	if err != nil {
+		return "", "", err
+	}
+
+	return info.Label, info.Type, nil
+}
+
+// GetPhysicalDevice returns the name of the disk (ex: sdb) that holds the
+// filesystem at the provided path, so that different partitions of the same
+// disk can be recognized. An empty string is returned if the path isn't on a
+// block device (ex: tmpfs) or can't be read
+func GetPhysicalDevice(path string) string {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return ""
+	}
+
+	// see the kernel's new_decode_dev()
+	dev := uint64(stat.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^uint64(0xfff)
+	minor := dev&0xff | (dev>>12)&^uint64(0xff)
+
+	sysfsPath := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+	devicePath, err := filepath.EvalSymlinks(sysfsPath)
+	if err != nil {
+		return ""
+	}
+
+	// partitions are listed below the disk they belong to
+	if _, err := os.Stat(filepath.Join(devicePath, "partition")); err == nil {
+		devicePath = filepath.Dir(devicePath)
+	}
+
+	return filepath.Base(devicePath)
+}
+
+// GetUsbPort returns the USB port (ex: 1-1.2) that the provided device
+// (ex: /dev/sdb1) is attached to, so that a card reader can be recognized no
+// matter which device name it was given. An empty string is returned if the
+// device isn't attached through USB or doesn't exist
+func GetUsbPort(devicePath string) string {
+	sysfsPath, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", filepath.Base(devicePath)))
+	if err != nil {
+		return ""
+	}
+
+	return UsbPortFromDevPath(sysfsPath)
+}
+
+// GetFreeSpace returns the number of bytes available to unprivileged users
+// on the filesystem that holds the provided path
+func GetFreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
 }
 
 //
 // private functions
 //
 
+// getDeviceFormat returns the format of the filesystem on the provided device
+// (ex: /dev/sdb1), or an empty string if it is an unknown format
+func getDeviceFormat(devicePath string) string {
+	info, err := inspectDevice(devicePath)
+	if err != nil {
+		return ""
+	}
+
+	format := info.Format()
+	if format == "" && info.Type != "" {
+		slog.Warn(fmt.Sprintf("Unknown filesystem type: %s %s", info.Type, info.Version))
+	}
+
+	return format
+}
+
+// parsePairs reads the first line of the KEY="value" pairs output by the -P
+// option of lsblk (ex: LABEL="EOS_DIGITAL" FSTYPE="exfat")
+func parsePairs(output string) map[string]string {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	fields := make(map[string]string)
+
+	for _, field := range strings.Split(line, "\" ") {
+		key, value, found := strings.Cut(field, "=\"")
+		if !found {
+			continue
+		}
+
+		fields[strings.TrimSpace(key)] = strings.TrimSuffix(value, "\"")
+	}
+
+	return fields
+}
+
 func pathMounted(device string) bool {
-	findmntCommand := "findmnt %s0"
-	_, exitCode, _ := callExternalCommand(findmntCommand, device)
+	_, found := findMountByDevice(device)
 
-	// device is mounted
-	return exitCode == 0
+	return found
 }
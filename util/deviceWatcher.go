@@ -0,0 +1,109 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"ccmm/model"
+)
+
+// Uevent is a single device event as reported by the kernel (ex: a partition
+// being added when an SD card is inserted)
+type Uevent struct {
+	Action    string
+	DevPath   string
+	Subsystem string
+	DevName   string
+	DevType   string
+	Env       map[string]string
+}
+
+// UeventSource provides a stream of device events. The real source reads
+// kernel uevents from a netlink socket, but any source can be provided to
+// drive the device watcher (ex: a fake source for testing)
+type UeventSource interface {
+	// ReadEvent blocks until the next event is available
+	ReadEvent() (Uevent, error)
+	Close() error
+}
+
+// ParseUevent parses a raw kernel uevent message. The message consists of a
+// header (ex: add@/devices/...) followed by null separated KEY=VALUE pairs
+func ParseUevent(message []byte) (Uevent, error) {
+	fields := strings.Split(strings.TrimRight(string(message), "\x00"), "\x00")
+
+	if len(fields) < 2 || !strings.Contains(fields[0], "@") {
+		return Uevent{}, fmt.Errorf("invalid uevent header '%s'", fields[0])
+	}
+
+	event := Uevent{Env: make(map[string]string)}
+
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+		event.Env[key] = value
+	}
+
+	event.Action = event.Env["ACTION"]
+	event.DevPath = event.Env["DEVPATH"]
+	event.Subsystem = event.Env["SUBSYSTEM"]
+	event.DevName = event.Env["DEVNAME"]
+	event.DevType = event.Env["DEVTYPE"]
+
+	return event, nil
+}
+
+// DeviceAllowed checks the label and filesystem type of a newly attached device
+// against the device watcher config. If fsType is empty (ex: it couldn't be
+// determined on this platform), the filesystem type isn't checked
+func DeviceAllowed(config model.DeviceWatcherConfig, label string, fsType string) bool {
+	if fsType != "" && len(config.AllowedFormats) > 0 && !slices.Contains(config.AllowedFormats, strings.ToLower(fsType)) {
+		return false
+	}
+
+	for _, pattern := range config.IgnoredLabels {
+		if matched, _ := regexp.MatchString(pattern, label); matched {
+			return false
+		}
+	}
+
+	if len(config.AllowedLabels) == 0 {
+		return true
+	}
+
+	for _, pattern := range config.AllowedLabels {
+		if matched, _ := regexp.MatchString(pattern, label); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,250 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+//go:build linux
+
+package util
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"syscall"
+	"time"
+
+	"ccmm/model"
+)
+
+const (
+	// ueventGroupKernel is the netlink multicast group for events sent directly
+	// by the kernel, as opposed to those re-broadcast by udev
+	ueventGroupKernel = 1
+
+	// ueventBufferSize is large enough to hold any single uevent message
+	ueventBufferSize = 16 * 1024
+
+	devicePollInterval = 500 * time.Millisecond
+)
+
+// DeviceWatcher watches a uevent source for block devices being attached. Once
+// an attached device node exists and its filesystem can be identified, it is
+// checked against the configured allowlist and, if allowed, reported
+type DeviceWatcher struct {
+	config model.DeviceWatcherConfig
+	source UeventSource
+	mutex  sync.Mutex
+
+	// devices holds every device that is settling or has been reported, until
+	// it is removed. The channel is closed to cancel a device that is settling
+	devices map[string]chan struct{}
+
+	// these are replaceable so that the watcher can be driven without real devices
+	deviceExists func(devicePath string) bool
+	probeDevice  func(devicePath string) (string, string, error)
+}
+
+// NewDeviceWatcher creates a new device watcher that reads events from the
+// provided source
+func NewDeviceWatcher(config model.DeviceWatcherConfig, source UeventSource) *DeviceWatcher {
+	return &DeviceWatcher{
+		config:       config,
+		source:       source,
+		devices:      make(map[string]chan struct{}),
+		deviceExists: FileExists,
+		probeDevice:  GetDeviceLabelAndFormat,
+	}
+}
+
+// NewNetlinkUeventSource opens a netlink socket that receives uevents
+// directly from the kernel. No root privileges are required
+func NewNetlinkUeventSource() (UeventSource, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+
+	address := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: ueventGroupKernel,
+	}
+
+	if err := syscall.Bind(fd, address); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	return &netlinkUeventSource{fd: fd, buffer: make([]byte, ueventBufferSize)}, nil
+}
+
+// Run reads events until the source returns an error. deviceAttachedCallback
+// is called with the device path (ex: /dev/sdb1) of each allowed device. The
+// volume path is always empty because the device is not yet mounted
+func (dw *DeviceWatcher) Run(deviceAttachedCallback func(devicePath string, volumePath string)) error {
+	defer dw.source.Close()
+
+	for {
+		event, err := dw.source.ReadEvent()
+		if err != nil {
+			return err
+		}
+
+		if event.Subsystem != "block" || event.DevName == "" {
+			continue
+		}
+
+		devicePath := "/dev/" + event.DevName
+
+		switch event.Action {
+		case "add", "change":
+			dw.schedule(devicePath, deviceAttachedCallback)
+		case "remove":
+			dw.remove(devicePath)
+		}
+	}
+}
+
+// WatchForDeviceAttached listens to kernel uevents for block devices being
+// attached. Once the device node exists and the filesystem has been identified,
+// deviceAttachedCallback will be executed with the devicePath of the device
+// if it matches the label and filesystem allowlist. The device is not mounted
+// so volumePath is always empty
+func WatchForDeviceAttached(config model.DeviceWatcherConfig, deviceAttachedCallback func(devicePath string, volumePath string)) {
+	source, err := NewNetlinkUeventSource()
+	if err != nil {
+		slog.Error("Failed to start device watcher: " + err.Error())
+		return
+	}
+
+	slog.Info("Watching for attached devices using kernel uevents")
+
+	if err := NewDeviceWatcher(config, source).Run(deviceAttachedCallback); err != nil {
+		slog.Error("Device watcher stopped: " + err.Error())
+	}
+}
+
+//
+// private functions
+//
+
+type netlinkUeventSource struct {
+	fd     int
+	buffer []byte
+}
+
+func (s *netlinkUeventSource) ReadEvent() (Uevent, error) {
+	for {
+		n, _, err := syscall.Recvfrom(s.fd, s.buffer, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return Uevent{}, err
+		}
+
+		event, err := ParseUevent(s.buffer[:n])
+		if err != nil {
+			slog.Debug("Ignoring uevent: " + err.Error())
+			continue
+		}
+
+		return event, nil
+	}
+}
+
+func (s *netlinkUeventSource) Close() error {
+	return syscall.Close(s.fd)
+}
+
+// schedule starts waiting for the device to settle, unless we are already
+// waiting on it or it has already been reported. Disks commonly report several
+// events when media is inserted, so this also acts as a debounce
+func (dw *DeviceWatcher) schedule(devicePath string, deviceAttachedCallback func(devicePath string, volumePath string)) {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	if _, ok := dw.devices[devicePath]; ok {
+		return
+	}
+
+	cancel := make(chan struct{})
+	dw.devices[devicePath] = cancel
+
+	go func() {
+		label, fsType, ok := dw.settle(devicePath, cancel)
+
+		if !ok {
+			dw.mutex.Lock()
+			if dw.devices[devicePath] == cancel {
+				delete(dw.devices, devicePath)
+			}
+			dw.mutex.Unlock()
+			return
+		}
+
+		if !DeviceAllowed(dw.config, label, fsType) {
+			slog.Info(fmt.Sprintf("Ignoring device '%s' with label '%s' and filesystem '%s', not allowed by device_watcher config", devicePath, label, fsType))
+			return
+		}
+
+		slog.Info(fmt.Sprintf("Device '%s' attached with label '%s' and filesystem '%s'", devicePath, label, fsType))
+		deviceAttachedCallback(devicePath, "")
+	}()
+}
+
+func (dw *DeviceWatcher) remove(devicePath string) {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	if cancel, ok := dw.devices[devicePath]; ok {
+		slog.Debug(fmt.Sprintf("Device '%s' removed", devicePath))
+		close(cancel)
+		delete(dw.devices, devicePath)
+	}
+}
+
+// settle waits until the device node exists and its filesystem has been
+// identified, or until the settle timeout is reached. Devices without a
+// filesystem (ex: a disk that contains partitions) never settle
+func (dw *DeviceWatcher) settle(devicePath string, cancel chan struct{}) (string, string, bool) {
+	deadline := time.Now().Add(time.Duration(dw.config.SettleTimeout) * time.Second)
+
+	for {
+		if dw.deviceExists(devicePath) {
+			label, fsType, err := dw.probeDevice(devicePath)
+			if err == nil && fsType != "" {
+				return label, fsType, true
+			}
+		}
+
+		if time.Now().After(deadline) {
+			slog.Debug(fmt.Sprintf("Device '%s' has no identifiable filesystem after %d seconds, ignoring", devicePath, dw.config.SettleTimeout))
+			return "", "", false
+		}
+
+		select {
+		case <-cancel:
+			return "", "", false
+		case <-time.After(devicePollInterval):
+		}
+	}
+}
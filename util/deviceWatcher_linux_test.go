@@ -0,0 +1,189 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"ccmm/model"
+)
+
+// fakeUeventSource replays events, then reports io.EOF once they run out
+type fakeUeventSource struct {
+	events chan Uevent
+}
+
+func newFakeUeventSource(events ...Uevent) *fakeUeventSource {
+	source := &fakeUeventSource{events: make(chan Uevent, len(events))}
+	for _, event := range events {
+		source.events <- event
+	}
+	close(source.events)
+
+	return source
+}
+
+func (s *fakeUeventSource) ReadEvent() (Uevent, error) {
+	event, ok := <-s.events
+	if !ok {
+		return Uevent{}, io.EOF
+	}
+
+	return event, nil
+}
+
+func (s *fakeUeventSource) Close() error {
+	return nil
+}
+
+func blockEvent(action string, devName string) Uevent {
+	return Uevent{Action: action, Subsystem: "block", DevName: devName}
+}
+
+// runWatcher runs the watcher against the events and returns every device
+// reported within the wait time
+func runWatcher(t *testing.T, dw *DeviceWatcher, wait time.Duration) []string {
+	t.Helper()
+
+	var mutex sync.Mutex
+	attached := make([]string, 0)
+
+	err := dw.Run(func(devicePath string, volumePath string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if volumePath != "" {
+			t.Errorf("expected an empty volume path, got '%s'", volumePath)
+		}
+		attached = append(attached, devicePath)
+	})
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF from Run, got %v", err)
+	}
+
+	time.Sleep(wait)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return append([]string{}, attached...)
+}
+
+func newTestDeviceWatcher(source UeventSource, devices map[string][2]string) *DeviceWatcher {
+	config := model.DeviceWatcherConfig{
+		AllowedFormats: []string{"vfat", "exfat"},
+		IgnoredLabels:  []string{"^EFI$"},
+		SettleTimeout:  1,
+	}
+
+	dw := NewDeviceWatcher(config, source)
+	dw.deviceExists = func(devicePath string) bool {
+		_, ok := devices[devicePath]
+		return ok
+	}
+	dw.probeDevice = func(devicePath string) (string, string, error) {
+		return devices[devicePath][0], devices[devicePath][1], nil
+	}
+
+	return dw
+}
+
+func TestDeviceWatcherReportsAllowedDevices(t *testing.T) {
+	source := newFakeUeventSource(
+		blockEvent("add", "sdb"),
+		blockEvent("add", "sdb1"),
+		blockEvent("change", "sdb1"),
+		blockEvent("add", "sdc1"),
+		blockEvent("add", "sdd1"),
+		Uevent{Action: "add", Subsystem: "usb", DevName: "bus/usb/001/004"},
+	)
+
+	dw := newTestDeviceWatcher(source, map[string][2]string{
+		"/dev/sdb":  {"", ""},
+		"/dev/sdb1": {"H6_SD", "vfat"},
+		"/dev/sdc1": {"EFI", "vfat"},
+		"/dev/sdd1": {"BACKUP", "ext4"},
+	})
+
+	attached := runWatcher(t, dw, 200*time.Millisecond)
+
+	if len(attached) != 1 || attached[0] != "/dev/sdb1" {
+		t.Errorf("expected only /dev/sdb1 to be reported once, got %v", attached)
+	}
+}
+
+func TestDeviceWatcherWaitsForDeviceToSettle(t *testing.T) {
+	var mutex sync.Mutex
+	devices := map[string][2]string{}
+
+	dw := newTestDeviceWatcher(newFakeUeventSource(blockEvent("add", "sdb1")), nil)
+	dw.deviceExists = func(devicePath string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		_, ok := devices[devicePath]
+		return ok
+	}
+	dw.probeDevice = func(devicePath string) (string, string, error) {
+		return "EOS_DIGITAL", "exfat", nil
+	}
+
+	// the device node shows up after the first poll
+	time.AfterFunc(100*time.Millisecond, func() {
+		mutex.Lock()
+		devices["/dev/sdb1"] = [2]string{}
+		mutex.Unlock()
+	})
+
+	attached := runWatcher(t, dw, devicePollInterval+300*time.Millisecond)
+
+	if len(attached) != 1 || attached[0] != "/dev/sdb1" {
+		t.Errorf("expected /dev/sdb1 to be reported once it settled, got %v", attached)
+	}
+}
+
+func TestDeviceWatcherRemoveCancelsSettlingDevice(t *testing.T) {
+	source := newFakeUeventSource(
+		blockEvent("add", "sdb1"),
+		blockEvent("remove", "sdb1"),
+	)
+
+	dw := newTestDeviceWatcher(source, map[string][2]string{})
+
+	attached := runWatcher(t, dw, 100*time.Millisecond)
+
+	if len(attached) != 0 {
+		t.Errorf("expected no device to be reported, got %v", attached)
+	}
+
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	if len(dw.devices) != 0 {
+		t.Errorf("expected the removed device to be forgotten, got %v", dw.devices)
+	}
+}
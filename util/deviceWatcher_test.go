@@ -0,0 +1,85 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"testing"
+
+	"ccmm/model"
+)
+
+func TestParseUevent(t *testing.T) {
+	message := []byte("add@/devices/pci0000:00/usb1/1-1/host0/block/sdb/sdb1\x00ACTION=add\x00DEVPATH=/devices/pci0000:00/usb1/1-1/host0/block/sdb/sdb1\x00SUBSYSTEM=block\x00DEVNAME=sdb1\x00DEVTYPE=partition\x00SEQNUM=4242\x00")
+
+	event, err := ParseUevent(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Action != "add" || event.Subsystem != "block" || event.DevName != "sdb1" || event.DevType != "partition" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Env["SEQNUM"] != "4242" {
+		t.Errorf("expected SEQNUM 4242, got '%s'", event.Env["SEQNUM"])
+	}
+
+	for _, invalid := range []string{"", "libudev\x00", "ACTION=add\x00SUBSYSTEM=block"} {
+		if _, err := ParseUevent([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestDeviceAllowed(t *testing.T) {
+	config := model.DeviceWatcherConfig{
+		AllowedFormats: []string{"vfat", "exfat"},
+		AllowedLabels:  []string{"^H6_", "^EOS_DIGITAL$"},
+		IgnoredLabels:  []string{"^EFI$", "^H6_BACKUP$"},
+	}
+
+	tests := []struct {
+		label  string
+		fsType string
+		want   bool
+	}{
+		{"H6_SD", "vfat", true},
+		{"EOS_DIGITAL", "EXFAT", true},
+		{"EOS_DIGITAL", "ntfs", false},
+		{"H6_BACKUP", "vfat", false},
+		{"EFI", "vfat", false},
+		{"UNTITLED", "vfat", false},
+		{"H6_SD", "", true},
+	}
+
+	for _, test := range tests {
+		if got := DeviceAllowed(config, test.label, test.fsType); got != test.want {
+			t.Errorf("DeviceAllowed('%s', '%s') = %t, want %t", test.label, test.fsType, got, test.want)
+		}
+	}
+
+	if !DeviceAllowed(model.DeviceWatcherConfig{}, "ANYTHING", "ext4") {
+		t.Error("expected an empty config to allow any device")
+	}
+}
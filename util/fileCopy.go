@@ -25,49 +25,183 @@
 package util
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path"
+)
+
+const (
+	copyBufferSize = 1024 * 1024 // 1MB
 
-	"ccmm/model"
+	// HashAlgorithm is the name of the hash algorithm used by CopyFile and HashFile
+	HashAlgorithm = "sha256"
+
+	// partialCopySuffix is appended to the destination path while a copy is in
+	// progress, so that an interrupted copy never leaves a truncated file at
+	// the real destination
+	partialCopySuffix = ".ccmm-partial"
 )
 
-func GetDestinationDirectoryRelative(sourceFile model.SourceFile) string {
-	quarter := GetServiceQuarter(sourceFile.CaptureDate)
-	serviceDate := sourceFile.CaptureDate.Format("2006-01-02")
+// ErrChecksumMismatch is returned by CopyFile when the file that was written
+// to the destination does not match what was read from the source
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// progressWriter wraps an io.Writer and reports the running total of bytes
+// written to the provided callback
+type progressWriter struct {
+	writer           io.Writer
+	written          int64
+	progressCallback func(bytesCopied int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.writer.Write(p)
+	pw.written += int64(n)
+
+	if pw.progressCallback != nil {
+		pw.progressCallback(pw.written)
+	}
 
-	// TODO: add ability to configure destination folder structure
-	// return path.Join("_Services", quarter, serviceDate, sourceFile.MediaType, sourceFile.SourceName)
-	return path.Join(quarter, serviceDate, sourceFile.MediaType, sourceFile.SourceName)
+	return n, err
 }
 
-func GetDestinationDirectory(destRootDir string, sourceFile model.SourceFile) string {
-	return path.Join(destRootDir, GetDestinationDirectoryRelative(sourceFile))
+// contextReader wraps an io.Reader and stops reading once ctx is cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
 }
 
-func CopyFile(sourcePath string, destPath string) (int64, error) {
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.reader.Read(p)
+}
+
+// CopyFile copies the file at sourcePath to destPath, hashing the data as it
+// is read from the source. Once the copy is complete, the destination is read
+// back and hashed again to verify that what landed on disk matches what was
+// read from the source. The number of bytes copied and the hex encoded hash
+// of the source are returned. If the hashes do not match, ErrChecksumMismatch
+// is returned (wrapped) and the destination file is removed.
+//
+// The data is written to a temporary file next to destPath which is only
+// renamed into place once it has been verified. If ctx is cancelled, the copy
+// is aborted and the temporary file removed
+//
+// If progressCallback is not nil, it will be called after each chunk is
+// written with the total number of bytes copied so far
+func CopyFile(ctx context.Context, sourcePath string, destPath string, progressCallback func(bytesCopied int64)) (int64, string, error) {
 	sourceFileStat, err := os.Stat(sourcePath)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
 	if !sourceFileStat.Mode().IsRegular() {
-		return 0, fmt.Errorf("%s is not a regular file", sourcePath)
+		return 0, "", fmt.Errorf("%s is not a regular file", sourcePath)
 	}
 
 	source, err := os.Open(sourcePath)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	defer source.Close()
 
-	destination, err := os.Create(destPath)
+	partialPath := destPath + partialCopySuffix
+	destination, err := os.Create(partialPath)
 	if err != nil {
-		return 0, err
+		return 0, "", err
+	}
+
+	hasher := sha256.New()
+	writer := &progressWriter{
+		writer:           io.MultiWriter(destination, hasher),
+		progressCallback: progressCallback,
+	}
+
+	buffer := make([]byte, copyBufferSize)
+	nBytes, err := io.CopyBuffer(writer, &contextReader{ctx: ctx, reader: source}, buffer)
+
+	// make sure the data is actually flushed to disk before we read it back
+	if err == nil {
+		err = destination.Sync()
+	}
+
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(partialPath)
+		return nBytes, "", err
+	}
+
+	sourceHash := hex.EncodeToString(hasher.Sum(nil))
+
+	destHash, err := HashFile(partialPath)
+	if err != nil {
+		os.Remove(partialPath)
+		return nBytes, sourceHash, err
+	}
+
+	if destHash != sourceHash {
+		os.Remove(partialPath)
+		return nBytes, sourceHash, fmt.Errorf("%w: source '%s' (%s) does not match destination '%s' (%s)",
+			ErrChecksumMismatch, sourcePath, sourceHash, destPath, destHash)
+	}
+
+	if err := os.Rename(partialPath, destPath); err != nil {
+		os.Remove(partialPath)
+		return nBytes, sourceHash, err
+	}
+
+	return nBytes, sourceHash, nil
+}
+
+// HashFile returns the hex encoded hash of the file at the provided path,
+// using the same algorithm as CopyFile (see HashAlgorithm)
+func HashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	buffer := make([]byte, copyBufferSize)
+
+	if _, err := io.CopyBuffer(hasher, struct{ io.Reader }{file}, buffer); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// HashFilePrefix returns the hex encoded hash (see HashFile) of the first size
+// bytes of the file at the provided path. An error is returned if the file is
+// shorter than that
+func HashFilePrefix(filePath string, size int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	buffer := make([]byte, copyBufferSize)
+
+	if _, err := io.CopyBuffer(hasher, io.LimitReader(file, size), buffer); err != nil {
+		return "", err
+	}
+
+	if stat, err := file.Stat(); err != nil || stat.Size() < size {
+		return "", fmt.Errorf("'%s' is shorter than %d bytes", filePath, size)
 	}
-	defer destination.Close()
 
-	nBytes, err := io.Copy(destination, source)
-	return nBytes, err
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
@@ -26,6 +26,7 @@ package util
 
 import (
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path"
@@ -52,7 +53,7 @@ func GetFileSize(path string) int64 {
 	return stat.Size()
 }
 
-func requireMultipleFileOrDir(rootDir string, items []string, needsDir bool) bool {
+func requireMultipleFileOrDir(fsys fs.FS, rootDir string, items []string, needsDir bool) bool {
 	itemType := "file"
 	if needsDir {
 		itemType = "directory"
@@ -62,7 +63,7 @@ func requireMultipleFileOrDir(rootDir string, items []string, needsDir bool) boo
 		slog.Debug(fmt.Sprintf("util.requireMultipleFileOrDir: Testing for %s '%s' in path '%s'", itemType, checkPath, rootDir))
 		fullPath := path.Join(rootDir, checkPath)
 
-		if stat, err := os.Stat(fullPath); err != nil || (needsDir && !stat.IsDir()) || (!needsDir && stat.IsDir()) {
+		if stat, err := fs.Stat(fsys, fullPath); err != nil || (needsDir && !stat.IsDir()) || (!needsDir && stat.IsDir()) {
 			slog.Debug(fmt.Sprintf("util.requireMultipleFileOrDir: required %s missing: %s", itemType, checkPath))
 			return false
 		}
@@ -71,8 +72,12 @@ func requireMultipleFileOrDir(rootDir string, items []string, needsDir bool) boo
 	return true
 }
 
-func requireRegexFileOrDirMatch(rootDir string, namePattern string, needsDir bool) (bool, string) {
-	entries, err := os.ReadDir(rootDir)
+func requireRegexFileOrDirMatch(fsys fs.FS, rootDir string, namePattern string, needsDir bool) (bool, string) {
+	if rootDir == "" {
+		rootDir = "."
+	}
+
+	entries, err := fs.ReadDir(fsys, rootDir)
 
 	if err != nil {
 		slog.Error(fmt.Sprintf("util.requireRegexFileOrDirMatch: Error occurred when reading directory '%s': %s", rootDir, err))
@@ -90,20 +95,28 @@ func requireRegexFileOrDirMatch(rootDir string, namePattern string, needsDir boo
 	return false, ""
 }
 
-func RequireDirs(rootDir string, dirs []string) bool {
-	return requireMultipleFileOrDir(rootDir, dirs, true)
+// RequireDirs tests that all of the provided directories exist in rootDir,
+// which is a path within fsys ("." or an empty string for the root)
+func RequireDirs(fsys fs.FS, rootDir string, dirs []string) bool {
+	return requireMultipleFileOrDir(fsys, rootDir, dirs, true)
 }
 
-func RequireFiles(rootDir string, files []string) bool {
-	return requireMultipleFileOrDir(rootDir, files, false)
+// RequireFiles tests that all of the provided files exist in rootDir, which
+// is a path within fsys ("." or an empty string for the root)
+func RequireFiles(fsys fs.FS, rootDir string, files []string) bool {
+	return requireMultipleFileOrDir(fsys, rootDir, files, false)
 }
 
-func RequireRegexDirMatch(rootDir string, namePattern string) (bool, string) {
-	return requireRegexFileOrDirMatch(rootDir, namePattern, true)
+// RequireRegexDirMatch returns the path within fsys of the first directory in
+// rootDir whose name matches the pattern
+func RequireRegexDirMatch(fsys fs.FS, rootDir string, namePattern string) (bool, string) {
+	return requireRegexFileOrDirMatch(fsys, rootDir, namePattern, true)
 }
 
-func RequireRegexFileMatch(rootDir string, namePattern string) (bool, string) {
-	return requireRegexFileOrDirMatch(rootDir, namePattern, false)
+// RequireRegexFileMatch returns the path within fsys of the first file in
+// rootDir whose name matches the pattern
+func RequireRegexFileMatch(fsys fs.FS, rootDir string, namePattern string) (bool, string) {
+	return requireRegexFileOrDirMatch(fsys, rootDir, namePattern, false)
 }
 
 func DirectoryExists(testDir string) bool {
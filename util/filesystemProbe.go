@@ -0,0 +1,480 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrUnknownFilesystem is returned when the start of a device doesn't hold any
+// of the filesystems that can be read
+var ErrUnknownFilesystem = errors.New("unknown filesystem")
+
+// FilesystemInfo describes the filesystem on a device, as read from its boot
+// sector or volume header
+type FilesystemInfo struct {
+	// Type is the filesystem type using the names of lsblk and blkid (vfat,
+	// exfat, ntfs or hfsplus)
+	Type string
+
+	// Version distinguishes the variants of a type (ex: FAT16 or FAT32 for vfat)
+	Version string
+
+	Label string
+
+	// UUID is the volume serial number or UUID, formatted the way blkid does
+	UUID string
+}
+
+// Format returns the format of the filesystem as reported for a volume (ex:
+// FAT32), or an empty string if the type is unknown
+func (fi FilesystemInfo) Format() string {
+	switch fi.Type {
+	case "vfat":
+		return fi.Version
+	case "exfat":
+		return ExFAT
+	case "ntfs":
+		return NTFS
+	case "hfsplus":
+		return HFSPlus
+	}
+
+	return ""
+}
+
+// ReadFilesystemInfo identifies the filesystem at the start of the provided
+// device or disk image (ex: /dev/sdb1) by parsing its boot sector or volume
+// header directly. FAT12/16/32, exFAT, NTFS and HFS+ are recognized, anything
+// else results in ErrUnknownFilesystem
+func ReadFilesystemInfo(device io.ReaderAt) (FilesystemInfo, error) {
+	bootSector := make([]byte, 512)
+	if _, err := device.ReadAt(bootSector, 0); err != nil {
+		return FilesystemInfo{}, fmt.Errorf("failed to read boot sector: %w", err)
+	}
+
+	switch {
+	case bytes.Equal(bootSector[3:11], []byte("EXFAT   ")):
+		return readExFAT(device, bootSector)
+	case bytes.Equal(bootSector[3:11], []byte("NTFS    ")):
+		return readNTFS(device, bootSector)
+	}
+
+	header := make([]byte, 512)
+	if _, err := device.ReadAt(header, hfsHeaderOffset); err == nil {
+		if signature := string(header[0:2]); signature == "H+" || signature == "HX" {
+			return readHFSPlus(device, header)
+		}
+	}
+
+	if bootSector[510] == 0x55 && bootSector[511] == 0xaa {
+		if info, err := readFAT(device, bootSector); !errors.Is(err, ErrUnknownFilesystem) {
+			return info, err
+		}
+	}
+
+	return FilesystemInfo{}, ErrUnknownFilesystem
+}
+
+//
+// private functions
+//
+
+const (
+	// hfsHeaderOffset is where the HFS+ volume header starts on the device
+	hfsHeaderOffset = 1024
+
+	// maxDirectoryClusters limits how far a root directory is followed when
+	// looking for the volume label, in case the allocation table is damaged
+	maxDirectoryClusters = 64
+
+	// maxDirectorySize limits how much of a root directory is read, as exFAT
+	// clusters can be as large as 32 MiB
+	maxDirectorySize = 1 << 20
+
+	fatEntryVolumeLabel = 0x08
+	fatEntryLongName    = 0x0f
+	exfatEntryLabel     = 0x83
+	ntfsAttributeName   = 0x60
+	ntfsAttributeEnd    = 0xffffffff
+)
+
+// hfsUUIDNamespace is the namespace blkid hashes the HFS+ volume ID in to
+// create a version 3 UUID
+var hfsUUIDNamespace = []byte{0xb3, 0xe2, 0x0f, 0x39, 0xf2, 0x92, 0x11, 0xd6, 0x97, 0xa4, 0x00, 0x30, 0x65, 0x43, 0xec, 0xac}
+
+func readFAT(device io.ReaderAt, bootSector []byte) (FilesystemInfo, error) {
+	bytesPerSector := int64(binary.LittleEndian.Uint16(bootSector[11:]))
+	sectorsPerCluster := int64(bootSector[13])
+	reservedSectors := int64(binary.LittleEndian.Uint16(bootSector[14:]))
+	fatCount := int64(bootSector[16])
+	rootEntries := int64(binary.LittleEndian.Uint16(bootSector[17:]))
+
+	totalSectors := int64(binary.LittleEndian.Uint16(bootSector[19:]))
+	if totalSectors == 0 {
+		totalSectors = int64(binary.LittleEndian.Uint32(bootSector[32:]))
+	}
+
+	// only FAT32 keeps the size of the FAT in the extended BPB
+	fatSectors := int64(binary.LittleEndian.Uint16(bootSector[22:]))
+	isFAT32 := fatSectors == 0
+	if isFAT32 {
+		fatSectors = int64(binary.LittleEndian.Uint32(bootSector[36:]))
+	}
+
+	validClusterSize := sectorsPerCluster != 0 && sectorsPerCluster&(sectorsPerCluster-1) == 0
+	if !isValidSectorSize(bytesPerSector) || !validClusterSize || reservedSectors == 0 || fatCount == 0 || fatSectors == 0 {
+		return FilesystemInfo{}, ErrUnknownFilesystem
+	}
+
+	// FAT12 and FAT16 are told apart by the number of clusters, see the "FAT
+	// type determination" section of Microsoft's FAT specification
+	rootSectors := (rootEntries*32 + bytesPerSector - 1) / bytesPerSector
+	firstDataSector := reservedSectors + fatCount*fatSectors + rootSectors
+	if totalSectors <= firstDataSector {
+		return FilesystemInfo{}, ErrUnknownFilesystem
+	}
+	clusterCount := (totalSectors - firstDataSector) / sectorsPerCluster
+
+	info := FilesystemInfo{Type: "vfat", Version: FAT32}
+	extendedBootRecord := bootSector[64:]
+	if !isFAT32 {
+		info.Version = FAT16
+		if clusterCount < 4085 {
+			info.Version = FAT12
+		}
+		extendedBootRecord = bootSector[36:]
+	}
+
+	// 0x29 means the serial number, label and type fields are present
+	bootLabel := ""
+	if extendedBootRecord[2] == 0x29 {
+		serial := binary.LittleEndian.Uint32(extendedBootRecord[3:])
+		info.UUID = fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff)
+		bootLabel = trimLabel(extendedBootRecord[7:18])
+	}
+
+	// the label in the root directory is the one that is kept up to date, the
+	// one in the boot sector is often left as NO NAME
+	var rootDirectory []byte
+	var err error
+	if info.Version == FAT32 {
+		rootCluster := int64(binary.LittleEndian.Uint32(bootSector[44:]))
+		clusterSize := bytesPerSector * sectorsPerCluster
+		rootDirectory, err = readClusterChain(device, rootCluster, clusterSize, (reservedSectors+fatCount*fatSectors)*bytesPerSector, reservedSectors*bytesPerSector)
+	} else {
+		rootDirectory = make([]byte, rootEntries*32)
+		_, err = device.ReadAt(rootDirectory, (reservedSectors+fatCount*fatSectors)*bytesPerSector)
+	}
+
+	if err == nil {
+		info.Label = findFATLabel(rootDirectory)
+	}
+
+	if info.Label == "" && bootLabel != "NO NAME" {
+		info.Label = bootLabel
+	}
+
+	return info, nil
+}
+
+// findFATLabel returns the volume label entry of a FAT directory
+func findFATLabel(directory []byte) string {
+	for offset := 0; offset+32 <= len(directory); offset += 32 {
+		entry := directory[offset : offset+32]
+
+		switch {
+		case entry[0] == 0x00:
+			return ""
+		case entry[0] == 0xe5 || entry[11] == fatEntryLongName:
+			continue
+		case entry[11]&fatEntryVolumeLabel != 0:
+			return trimLabel(entry[0:11])
+		}
+	}
+
+	return ""
+}
+
+func readExFAT(device io.ReaderAt, bootSector []byte) (FilesystemInfo, error) {
+	fatOffset := int64(binary.LittleEndian.Uint32(bootSector[80:]))
+	clusterHeapOffset := int64(binary.LittleEndian.Uint32(bootSector[88:]))
+	rootCluster := int64(binary.LittleEndian.Uint32(bootSector[96:]))
+	serial := binary.LittleEndian.Uint32(bootSector[100:])
+	bytesPerSectorShift := bootSector[108]
+	sectorsPerClusterShift := bootSector[109]
+
+	if bytesPerSectorShift < 9 || bytesPerSectorShift > 12 || sectorsPerClusterShift > 25-bytesPerSectorShift {
+		return FilesystemInfo{}, ErrUnknownFilesystem
+	}
+
+	info := FilesystemInfo{
+		Type: "exfat",
+		UUID: fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff),
+	}
+
+	bytesPerSector := int64(1) << bytesPerSectorShift
+	clusterSize := bytesPerSector << sectorsPerClusterShift
+
+	rootDirectory, err := readClusterChain(device, rootCluster, clusterSize, clusterHeapOffset*bytesPerSector, fatOffset*bytesPerSector)
+	if err != nil {
+		return info, nil
+	}
+
+	for offset := 0; offset+32 <= len(rootDirectory); offset += 32 {
+		entry := rootDirectory[offset : offset+32]
+
+		if entry[0] == 0x00 {
+			break
+		}
+
+		if entry[0] == exfatEntryLabel {
+			length := min(int(entry[1]), 11)
+			info.Label = decodeUTF16(entry[2:2+length*2], binary.LittleEndian)
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// readClusterChain reads the chain of clusters starting at firstCluster of a
+// FAT32 or exFAT filesystem, up to maxDirectorySize bytes. The FAT entries of
+// both are 32 bits
+func readClusterChain(device io.ReaderAt, firstCluster int64, clusterSize int64, dataOffset int64, fatOffset int64) ([]byte, error) {
+	var data []byte
+	cluster := firstCluster
+
+	for range maxDirectoryClusters {
+		if cluster < 2 || cluster >= 0x0ffffff7 {
+			break
+		}
+
+		readSize := min(clusterSize, maxDirectorySize-int64(len(data)))
+		if readSize <= 0 {
+			break
+		}
+
+		buffer := make([]byte, readSize)
+		if _, err := device.ReadAt(buffer, dataOffset+(cluster-2)*clusterSize); err != nil {
+			return nil, err
+		}
+		data = append(data, buffer...)
+
+		entry := make([]byte, 4)
+		if _, err := device.ReadAt(entry, fatOffset+cluster*4); err != nil {
+			break
+		}
+		cluster = int64(binary.LittleEndian.Uint32(entry) & 0x0fffffff)
+	}
+
+	if data == nil {
+		return nil, errors.New("empty cluster chain")
+	}
+
+	return data, nil
+}
+
+func readNTFS(device io.ReaderAt, bootSector []byte) (FilesystemInfo, error) {
+	bytesPerSector := int64(binary.LittleEndian.Uint16(bootSector[11:]))
+	sectorsPerCluster := int64(bootSector[13])
+	mftCluster := int64(binary.LittleEndian.Uint64(bootSector[48:]))
+	serial := binary.LittleEndian.Uint64(bootSector[72:])
+
+	// clusters larger than 64 KiB store the sectors per cluster as a negative
+	// power of two
+	if bootSector[13] > 0x80 {
+		sectorsPerCluster = int64(1) << -int8(bootSector[13])
+	}
+
+	validClusterSize := sectorsPerCluster != 0 && sectorsPerCluster&(sectorsPerCluster-1) == 0
+	if !isValidSectorSize(bytesPerSector) || !validClusterSize {
+		return FilesystemInfo{}, ErrUnknownFilesystem
+	}
+
+	info := FilesystemInfo{
+		Type: "ntfs",
+		UUID: fmt.Sprintf("%016X", serial),
+	}
+
+	// a negative size is a power of two in bytes rather than a number of clusters
+	clusterSize := bytesPerSector * sectorsPerCluster
+	recordSize := int64(int8(bootSector[64])) * clusterSize
+	if bootSector[64] >= 0x80 {
+		recordSize = int64(1) << -int8(bootSector[64])
+	}
+
+	if recordSize < 512 || recordSize > 65536 {
+		return info, nil
+	}
+
+	// the label is the $VOLUME_NAME attribute of $Volume, MFT record 3
+	record := make([]byte, recordSize)
+	if _, err := device.ReadAt(record, mftCluster*clusterSize+3*recordSize); err != nil || string(record[0:4]) != "FILE" {
+		return info, nil
+	}
+
+	if !applyNTFSFixups(record, bytesPerSector) {
+		return info, nil
+	}
+
+	offset := int(binary.LittleEndian.Uint16(record[20:]))
+	for offset+24 <= len(record) {
+		attributeType := binary.LittleEndian.Uint32(record[offset:])
+		length := int(binary.LittleEndian.Uint32(record[offset+4:]))
+
+		if attributeType == ntfsAttributeEnd || length == 0 || offset+length > len(record) {
+			break
+		}
+
+		// only a resident attribute holds its value in the record
+		if attributeType == ntfsAttributeName && record[offset+8] == 0 {
+			valueLength := int(binary.LittleEndian.Uint32(record[offset+16:]))
+			valueOffset := offset + int(binary.LittleEndian.Uint16(record[offset+20:]))
+
+			if valueOffset+valueLength <= offset+length {
+				info.Label = decodeUTF16(record[valueOffset:valueOffset+valueLength], binary.LittleEndian)
+			}
+			break
+		}
+
+		offset += length
+	}
+
+	return info, nil
+}
+
+// applyNTFSFixups restores the last two bytes of every sector of an MFT
+// record, which NTFS swaps for a sequence number to detect torn writes
+func applyNTFSFixups(record []byte, bytesPerSector int64) bool {
+	fixupOffset := int(binary.LittleEndian.Uint16(record[4:]))
+	fixupCount := int(binary.LittleEndian.Uint16(record[6:]))
+
+	if fixupCount == 0 || fixupOffset+fixupCount*2 > len(record) {
+		return false
+	}
+
+	for idx := 1; idx < fixupCount; idx++ {
+		sectorEnd := idx*int(bytesPerSector) - 2
+		if sectorEnd < 0 || sectorEnd+2 > len(record) {
+			return false
+		}
+
+		if !bytes.Equal(record[sectorEnd:sectorEnd+2], record[fixupOffset:fixupOffset+2]) {
+			return false
+		}
+
+		copy(record[sectorEnd:sectorEnd+2], record[fixupOffset+idx*2:fixupOffset+idx*2+2])
+	}
+
+	return true
+}
+
+func readHFSPlus(device io.ReaderAt, header []byte) (FilesystemInfo, error) {
+	blockSize := int64(binary.BigEndian.Uint32(header[40:]))
+	if blockSize < 512 || blockSize&(blockSize-1) != 0 {
+		return FilesystemInfo{}, ErrUnknownFilesystem
+	}
+
+	info := FilesystemInfo{Type: "hfsplus"}
+
+	// the last two words of the finder info are the volume ID, which blkid
+	// turns in to a UUID
+	if volumeID := header[104:112]; !bytes.Equal(volumeID, make([]byte, 8)) {
+		hash := md5.Sum(append(append([]byte{}, hfsUUIDNamespace...), volumeID...))
+		hash[6] = hash[6]&0x0f | 0x30
+		hash[8] = hash[8]&0x3f | 0x80
+		info.UUID = fmt.Sprintf("%x-%x-%x-%x-%x", hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
+	}
+
+	// the volume name is the name of the root folder, which is the first
+	// record of the first leaf node of the catalog B-tree
+	catalogOffset := int64(binary.BigEndian.Uint32(header[288:])) * blockSize
+	catalogLength := int64(binary.BigEndian.Uint32(header[292:])) * blockSize
+
+	headerNode := make([]byte, 512)
+	if _, err := device.ReadAt(headerNode, catalogOffset); err != nil {
+		return info, nil
+	}
+
+	firstLeafNode := int64(binary.BigEndian.Uint32(headerNode[24:]))
+	nodeSize := int64(binary.BigEndian.Uint16(headerNode[32:]))
+	if nodeSize < 512 || (firstLeafNode+1)*nodeSize > catalogLength {
+		return info, nil
+	}
+
+	node := make([]byte, nodeSize)
+	if _, err := device.ReadAt(node, catalogOffset+firstLeafNode*nodeSize); err != nil {
+		return info, nil
+	}
+
+	// the record offsets are stored backwards from the end of the node
+	recordOffset := int(binary.BigEndian.Uint16(node[nodeSize-2:]))
+	if recordOffset+8 > len(node) {
+		return info, nil
+	}
+
+	parentID := binary.BigEndian.Uint32(node[recordOffset+2:])
+	nameLength := int(binary.BigEndian.Uint16(node[recordOffset+6:]))
+	nameStart := recordOffset + 8
+
+	if parentID == 1 && nameStart+nameLength*2 <= len(node) {
+		info.Label = decodeUTF16(node[nameStart:nameStart+nameLength*2], binary.BigEndian)
+	}
+
+	return info, nil
+}
+
+// isValidSectorSize checks the bytes per sector of a FAT or NTFS boot sector
+func isValidSectorSize(bytesPerSector int64) bool {
+	return bytesPerSector == 512 || bytesPerSector == 1024 || bytesPerSector == 2048 || bytesPerSector == 4096
+}
+
+// trimLabel converts a space padded FAT label to a string. FAT labels use the
+// OEM code page, anything outside of ASCII is read as Latin-1
+func trimLabel(label []byte) string {
+	runes := make([]rune, len(label))
+	for idx, b := range label {
+		runes[idx] = rune(b)
+	}
+
+	return strings.TrimRight(string(runes), " \x00")
+}
+
+func decodeUTF16(data []byte, byteOrder binary.ByteOrder) string {
+	units := make([]uint16, len(data)/2)
+	for idx := range units {
+		units[idx] = byteOrder.Uint16(data[idx*2:])
+	}
+
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
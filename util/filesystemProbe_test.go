@@ -0,0 +1,394 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"regexp"
+	"testing"
+	"unicode/utf16"
+)
+
+// The images below hold just enough of each filesystem for the probe: the
+// boot sector or volume header, and the structure holding the label
+
+const sectorSize = 512
+
+// limitedReader fails any single read larger than maxRead, to catch buffers
+// sized from untrusted fields of an image
+type limitedReader struct {
+	*bytes.Reader
+	t       *testing.T
+	maxRead int
+}
+
+func (lr limitedReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > lr.maxRead {
+		lr.t.Errorf("read of %d bytes exceeds %d", len(p), lr.maxRead)
+	}
+
+	return lr.Reader.ReadAt(p, off)
+}
+
+func putLabel(data []byte, label string) {
+	copy(data, bytes.Repeat([]byte(" "), 11))
+	copy(data, label)
+}
+
+func encodeUTF16(value string, byteOrder binary.ByteOrder) []byte {
+	units := utf16.Encode([]rune(value))
+	data := make([]byte, len(units)*2)
+	for idx, unit := range units {
+		byteOrder.PutUint16(data[idx*2:], unit)
+	}
+
+	return data
+}
+
+// newFAT16Image is a FAT12 or FAT16 image with labels in the boot sector and
+// root directory. The number of sectors decides between FAT12 and FAT16
+func newFAT16Image(totalSectors uint16, sectorsPerCluster byte, bootLabel string, rootLabel string) []byte {
+	const reservedSectors, fatCount, fatSectors, rootEntries = 1, 2, 20, 512
+	rootOffset := (reservedSectors + fatCount*fatSectors) * sectorSize
+
+	image := make([]byte, rootOffset+rootEntries*32)
+	boot := image[0:sectorSize]
+	copy(boot[3:], "MSDOS5.0")
+	binary.LittleEndian.PutUint16(boot[11:], sectorSize)
+	boot[13] = sectorsPerCluster
+	binary.LittleEndian.PutUint16(boot[14:], reservedSectors)
+	boot[16] = fatCount
+	binary.LittleEndian.PutUint16(boot[17:], rootEntries)
+	binary.LittleEndian.PutUint16(boot[19:], totalSectors)
+	binary.LittleEndian.PutUint16(boot[22:], fatSectors)
+	boot[38] = 0x29
+	binary.LittleEndian.PutUint32(boot[39:], 0x1234abcd)
+	putLabel(boot[43:54], bootLabel)
+	copy(boot[54:], "FAT16   ")
+	boot[510], boot[511] = 0x55, 0xaa
+
+	if rootLabel != "" {
+		putLabel(image[rootOffset:], rootLabel)
+		image[rootOffset+11] = fatEntryVolumeLabel
+	}
+
+	return image
+}
+
+// newFAT32Image is a FAT32 image whose root directory is the two clusters
+// of rootChain, with the label in the last of them
+func newFAT32Image(bootLabel string, rootLabel string, rootChain [2]uint32) []byte {
+	const reservedSectors, fatCount, fatSectors, sectorsPerCluster = 32, 2, 600, 8
+	fatOffset := reservedSectors * sectorSize
+	dataOffset := (reservedSectors + fatCount*fatSectors) * sectorSize
+	clusterSize := sectorsPerCluster * sectorSize
+
+	image := make([]byte, dataOffset+4*clusterSize)
+	boot := image[0:sectorSize]
+	copy(boot[3:], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(boot[11:], sectorSize)
+	boot[13] = sectorsPerCluster
+	binary.LittleEndian.PutUint16(boot[14:], reservedSectors)
+	boot[16] = fatCount
+	binary.LittleEndian.PutUint32(boot[32:], 600000)
+	binary.LittleEndian.PutUint32(boot[36:], fatSectors)
+	binary.LittleEndian.PutUint32(boot[44:], rootChain[0])
+	boot[66] = 0x29
+	binary.LittleEndian.PutUint32(boot[67:], 0xcafe0042)
+	putLabel(boot[71:82], bootLabel)
+	copy(boot[82:], "FAT32   ")
+	boot[510], boot[511] = 0x55, 0xaa
+
+	binary.LittleEndian.PutUint32(image[fatOffset+int(rootChain[0])*4:], rootChain[1])
+	binary.LittleEndian.PutUint32(image[fatOffset+int(rootChain[1])*4:], 0x0fffffff)
+
+	// the first cluster is full of deleted entries, so the label is only
+	// found by following the chain
+	first := image[dataOffset+int(rootChain[0]-2)*clusterSize:]
+	for offset := 0; offset < clusterSize; offset += 32 {
+		first[offset] = 0xe5
+	}
+
+	second := image[dataOffset+int(rootChain[1]-2)*clusterSize:]
+	putLabel(second, rootLabel)
+	second[11] = fatEntryVolumeLabel
+
+	return image
+}
+
+// newExFATImage is an exFAT image with the label in the first cluster of the
+// root directory, whose FAT entry points to nextCluster
+func newExFATImage(label string, clusterShift byte, nextCluster uint32) []byte {
+	const fatOffsetSectors, heapOffsetSectors, rootCluster = 24, 64, 4
+	clusterSize := sectorSize << clusterShift
+
+	image := make([]byte, heapOffsetSectors*sectorSize+(rootCluster-1)*clusterSize)
+	boot := image[0:sectorSize]
+	copy(boot[3:], "EXFAT   ")
+	binary.LittleEndian.PutUint32(boot[80:], fatOffsetSectors)
+	binary.LittleEndian.PutUint32(boot[88:], heapOffsetSectors)
+	binary.LittleEndian.PutUint32(boot[96:], rootCluster)
+	binary.LittleEndian.PutUint32(boot[100:], 0x5e1f0c0d)
+	boot[108] = 9
+	boot[109] = clusterShift
+	boot[510], boot[511] = 0x55, 0xaa
+
+	binary.LittleEndian.PutUint32(image[fatOffsetSectors*sectorSize+rootCluster*4:], nextCluster)
+
+	root := image[heapOffsetSectors*sectorSize+(rootCluster-2)*clusterSize:]
+	root[0] = exfatEntryLabel
+	root[1] = byte(len(label))
+	copy(root[2:], encodeUTF16(label, binary.LittleEndian))
+
+	return image
+}
+
+// newNTFSImage is an NTFS image holding MFT record 3 ($Volume) with its label
+func newNTFSImage(label string, bytesPerSector uint16) []byte {
+	const sectorsPerCluster, mftCluster, recordSize = 8, 4, 1024
+	clusterSize := int(bytesPerSector) * sectorsPerCluster
+	recordOffset := mftCluster*clusterSize + 3*recordSize
+
+	image := make([]byte, recordOffset+recordSize)
+	boot := image[0:sectorSize]
+	copy(boot[3:], "NTFS    ")
+	binary.LittleEndian.PutUint16(boot[11:], bytesPerSector)
+	boot[13] = sectorsPerCluster
+	binary.LittleEndian.PutUint64(boot[48:], mftCluster)
+	boot[64] = 0xf6 // 2^10 byte records
+	binary.LittleEndian.PutUint64(boot[72:], 0x01d2c3b4a5968778)
+	boot[510], boot[511] = 0x55, 0xaa
+
+	record := image[recordOffset : recordOffset+recordSize]
+	copy(record, "FILE")
+
+	// the update sequence array: the sequence number, then the original last
+	// two bytes of each sector
+	fixupCount := recordSize/int(bytesPerSector) + 1
+	binary.LittleEndian.PutUint16(record[4:], 48)
+	binary.LittleEndian.PutUint16(record[6:], uint16(fixupCount))
+	binary.LittleEndian.PutUint16(record[48:], 0x0007)
+	binary.LittleEndian.PutUint16(record[20:], 56)
+
+	name := encodeUTF16(label, binary.LittleEndian)
+	attribute := record[56:]
+	length := (24 + len(name) + 7) &^ 7
+	binary.LittleEndian.PutUint32(attribute[0:], ntfsAttributeName)
+	binary.LittleEndian.PutUint32(attribute[4:], uint32(length))
+	binary.LittleEndian.PutUint32(attribute[16:], uint32(len(name)))
+	binary.LittleEndian.PutUint16(attribute[20:], 24)
+	copy(attribute[24:], name)
+	binary.LittleEndian.PutUint32(attribute[length:], ntfsAttributeEnd)
+
+	for idx := 1; idx < fixupCount; idx++ {
+		sectorEnd := idx*int(bytesPerSector) - 2
+		copy(record[48+idx*2:], record[sectorEnd:sectorEnd+2])
+		binary.LittleEndian.PutUint16(record[sectorEnd:], 0x0007)
+	}
+
+	return image
+}
+
+// newHFSPlusImage is an HFS+ image whose catalog holds just the root folder
+func newHFSPlusImage(label string, volumeID uint64) []byte {
+	const blockSize, catalogBlock, nodeSize = 4096, 2, 4096
+
+	image := make([]byte, (catalogBlock*blockSize)+2*nodeSize)
+	header := image[hfsHeaderOffset:]
+	copy(header, "H+")
+	binary.BigEndian.PutUint32(header[40:], blockSize)
+	binary.BigEndian.PutUint64(header[104:], volumeID)
+	binary.BigEndian.PutUint32(header[288:], catalogBlock)
+	binary.BigEndian.PutUint32(header[292:], 2)
+
+	headerNode := image[catalogBlock*blockSize:]
+	binary.BigEndian.PutUint32(headerNode[24:], 1)
+	binary.BigEndian.PutUint16(headerNode[32:], nodeSize)
+
+	leafNode := image[catalogBlock*blockSize+nodeSize:]
+	binary.BigEndian.PutUint16(leafNode[nodeSize-2:], 14)
+	record := leafNode[14:]
+	name := encodeUTF16(label, binary.BigEndian)
+	binary.BigEndian.PutUint32(record[2:], 1)
+	binary.BigEndian.PutUint16(record[6:], uint16(len(name)/2))
+	copy(record[8:], name)
+
+	return image
+}
+
+func TestReadFilesystemInfo(t *testing.T) {
+	exFATLoop := newExFATImage("LOOP", 3, 4)
+	ntfsBadFixup := newNTFSImage("DATA", sectorSize)
+	ntfsBadFixup[4*8*sectorSize+3*1024+sectorSize-1] ^= 0xff
+
+	// 2 MiB clusters put the MFT past the end of the image
+	ntfsLargeClusters := newNTFSImage("Data", sectorSize)
+	ntfsLargeClusters[13] = 0xf4
+
+	tests := []struct {
+		name  string
+		image []byte
+		want  FilesystemInfo
+	}{
+		{"FAT12", newFAT16Image(2880, 1, "NO NAME", "FLOPPY"), FilesystemInfo{Type: "vfat", Version: FAT12, Label: "FLOPPY", UUID: "1234-ABCD"}},
+		{"FAT16", newFAT16Image(20000, 4, "BOOT", "CARD"), FilesystemInfo{Type: "vfat", Version: FAT16, Label: "CARD", UUID: "1234-ABCD"}},
+		{"FAT16 boot label only", newFAT16Image(20000, 4, "BOOT", ""), FilesystemInfo{Type: "vfat", Version: FAT16, Label: "BOOT", UUID: "1234-ABCD"}},
+		{"FAT16 no label", newFAT16Image(20000, 4, "NO NAME", ""), FilesystemInfo{Type: "vfat", Version: FAT16, UUID: "1234-ABCD"}},
+		{"FAT32", newFAT32Image("NO NAME", "EOS_DIGITAL", [2]uint32{2, 3}), FilesystemInfo{Type: "vfat", Version: FAT32, Label: "EOS_DIGITAL", UUID: "CAFE-0042"}},
+		{"FAT32 root chain out of order", newFAT32Image("NO NAME", "H6_SD", [2]uint32{4, 2}), FilesystemInfo{Type: "vfat", Version: FAT32, Label: "H6_SD", UUID: "CAFE-0042"}},
+		{"exFAT", newExFATImage("Untitled", 3, 0xffffffff), FilesystemInfo{Type: "exfat", Label: "Untitled", UUID: "5E1F-0C0D"}},
+		{"exFAT looping chain", exFATLoop, FilesystemInfo{Type: "exfat", Label: "LOOP", UUID: "5E1F-0C0D"}},
+		{"NTFS", newNTFSImage("Media Drive", sectorSize), FilesystemInfo{Type: "ntfs", Label: "Media Drive", UUID: "01D2C3B4A5968778"}},
+		{"NTFS 4K sectors", newNTFSImage("Data", 4096), FilesystemInfo{Type: "ntfs", Label: "Data", UUID: "01D2C3B4A5968778"}},
+		{"NTFS torn record", ntfsBadFixup, FilesystemInfo{Type: "ntfs", UUID: "01D2C3B4A5968778"}},
+		{"NTFS large clusters", ntfsLargeClusters, FilesystemInfo{Type: "ntfs", UUID: "01D2C3B4A5968778"}},
+		{"HFS+ no volume ID", newHFSPlusImage("Macintosh HD", 0), FilesystemInfo{Type: "hfsplus", Label: "Macintosh HD"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := ReadFilesystemInfo(limitedReader{bytes.NewReader(test.image), t, maxDirectorySize})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, info)
+			}
+		})
+	}
+}
+
+func TestReadFilesystemInfoHFSPlusUUID(t *testing.T) {
+	info, err := ReadFilesystemInfo(bytes.NewReader(newHFSPlusImage("Macintosh HD", 0x0123456789abcdef)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a version 3 UUID, as blkid reports it
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-3[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(info.UUID) {
+		t.Errorf("unexpected UUID '%s'", info.UUID)
+	}
+	if info.Label != "Macintosh HD" || info.Format() != HFSPlus {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestReadFilesystemInfoTruncated(t *testing.T) {
+	tests := []struct {
+		name  string
+		image []byte
+		want  FilesystemInfo
+	}{
+		// the label falls back to the one in the boot sector
+		{"FAT16", newFAT16Image(20000, 4, "BOOT", "CARD")[:sectorSize], FilesystemInfo{Type: "vfat", Version: FAT16, Label: "BOOT", UUID: "1234-ABCD"}},
+		{"FAT32", newFAT32Image("BOOT", "CARD", [2]uint32{2, 3})[:sectorSize], FilesystemInfo{Type: "vfat", Version: FAT32, Label: "BOOT", UUID: "CAFE-0042"}},
+		{"exFAT", newExFATImage("Untitled", 3, 0xffffffff)[:sectorSize], FilesystemInfo{Type: "exfat", UUID: "5E1F-0C0D"}},
+		{"NTFS", newNTFSImage("Data", sectorSize)[:sectorSize], FilesystemInfo{Type: "ntfs", UUID: "01D2C3B4A5968778"}},
+		{"HFS+", newHFSPlusImage("Macintosh HD", 0)[:hfsHeaderOffset+sectorSize], FilesystemInfo{Type: "hfsplus"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := ReadFilesystemInfo(bytes.NewReader(test.image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, info)
+			}
+		})
+	}
+
+	if _, err := ReadFilesystemInfo(bytes.NewReader(make([]byte, 100))); err == nil || errors.Is(err, ErrUnknownFilesystem) {
+		t.Errorf("expected a read error for a device smaller than a sector, got %v", err)
+	}
+}
+
+func TestReadFilesystemInfoMalformed(t *testing.T) {
+	fatBadSectorSize := newFAT16Image(20000, 4, "BOOT", "CARD")
+	binary.LittleEndian.PutUint16(fatBadSectorSize[11:], 200)
+
+	fatBadClusterSize := newFAT16Image(20000, 3, "BOOT", "CARD")
+
+	fatTooSmall := newFAT16Image(40, 4, "BOOT", "CARD")
+
+	exFATBadShift := newExFATImage("Untitled", 3, 0xffffffff)
+	exFATBadShift[108] = 13
+
+	ntfsBadSectorSize := newNTFSImage("Data", sectorSize)
+	binary.LittleEndian.PutUint16(ntfsBadSectorSize[11:], 2)
+
+	ntfsBadClusterSize := newNTFSImage("Data", sectorSize)
+	ntfsBadClusterSize[13] = 0
+
+	hfsBadBlockSize := newHFSPlusImage("Macintosh HD", 0)
+	binary.BigEndian.PutUint32(hfsBadBlockSize[hfsHeaderOffset+40:], 1000)
+
+	tests := []struct {
+		name  string
+		image []byte
+	}{
+		{"empty", make([]byte, 4096)},
+		{"FAT invalid sector size", fatBadSectorSize},
+		{"FAT invalid cluster size", fatBadClusterSize},
+		{"FAT no data sectors", fatTooSmall},
+		{"exFAT invalid sector size", exFATBadShift},
+		{"NTFS invalid sector size", ntfsBadSectorSize},
+		{"NTFS invalid cluster size", ntfsBadClusterSize},
+		{"HFS+ invalid block size", hfsBadBlockSize},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ReadFilesystemInfo(bytes.NewReader(test.image)); !errors.Is(err, ErrUnknownFilesystem) {
+				t.Errorf("expected ErrUnknownFilesystem, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadFilesystemInfoLargeClusters(t *testing.T) {
+	// 32 MiB clusters, only the start of the root directory is read so the
+	// image doesn't need to hold the whole cluster
+	image := newExFATImage("", 3, 0)
+	image[109] = 16
+	binary.LittleEndian.PutUint32(image[96:], 2)
+
+	heapOffset := 64 * sectorSize
+	image = append(image[:heapOffset], make([]byte, maxDirectorySize)...)
+	image[heapOffset] = exfatEntryLabel
+	image[heapOffset+1] = 5
+	copy(image[heapOffset+2:], encodeUTF16("LARGE", binary.LittleEndian))
+
+	info, err := ReadFilesystemInfo(limitedReader{bytes.NewReader(image), t, maxDirectorySize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Label != "LARGE" {
+		t.Errorf("expected label 'LARGE', got %+v", info)
+	}
+}
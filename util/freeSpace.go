@@ -0,0 +1,98 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// ErrInsufficientSpace is returned by CheckFreeSpace when there isn't enough
+// free space for the data that is about to be written
+var ErrInsufficientSpace = errors.New("insufficient free space")
+
+// CheckFreeSpace makes sure that required bytes can be written below path
+// while still leaving reserve bytes free. The path doesn't need to exist yet,
+// its nearest existing parent is checked. If the free space can't be
+// determined, the check is skipped
+func CheckFreeSpace(path string, required int64, reserve int64) error {
+	existingPath := nearestExistingPath(path)
+
+	free, err := GetFreeSpace(existingPath)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Unable to determine free space of '%s', skipping check: %s", existingPath, err.Error()))
+		return nil
+	}
+
+	if required > free-reserve {
+		return fmt.Errorf("%w on '%s': %s needed, %s free with %s reserved", ErrInsufficientSpace,
+			existingPath, FormatBytes(required), FormatBytes(free), FormatBytes(reserve))
+	}
+
+	return nil
+}
+
+// FormatBytes formats a number of bytes for humans (ex: 1.5 GiB)
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+//
+// private functions
+//
+
+func nearestExistingPath(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,109 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"ccmm/model"
+)
+
+const (
+	// ManifestFileName is the name of the manifest file written to each service directory
+	ManifestFileName = ".ccmm-manifest.json"
+
+	manifestVersion = 1
+)
+
+var manifestMutex sync.Mutex
+
+// ReadManifest reads the manifest stored in the provided directory. If no
+// manifest exists yet, an empty manifest is returned without an error
+func ReadManifest(manifestDir string) (model.Manifest, error) {
+	manifest := model.Manifest{
+		Version: manifestVersion,
+		Files:   make(map[string]model.ManifestEntry),
+	}
+
+	data, err := os.ReadFile(path.Join(manifestDir, ManifestFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+
+	if err != nil {
+		return manifest, err
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, err
+	}
+
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]model.ManifestEntry)
+	}
+
+	return manifest, nil
+}
+
+// UpdateManifest adds (or replaces) the provided entries in the manifest stored
+// in manifestDir. Entries are keyed by their path relative to manifestDir. The
+// manifest is written to a temp file first and then renamed into place so that
+// a crash mid-write can never leave a truncated manifest behind
+func UpdateManifest(manifestDir string, entries map[string]model.ManifestEntry) error {
+	manifestMutex.Lock()
+	defer manifestMutex.Unlock()
+
+	manifest, err := ReadManifest(manifestDir)
+	if err != nil {
+		return err
+	}
+
+	for relativePath, entry := range entries {
+		manifest.Files[filepath.ToSlash(relativePath)] = entry
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return err
+	}
+
+	manifestPath := path.Join(manifestDir, ManifestFileName)
+	tempPath := manifestPath + ".tmp"
+
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, manifestPath)
+}
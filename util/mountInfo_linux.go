@@ -0,0 +1,306 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+//go:build linux
+
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mountEntry is a single mount point read from /proc/self/mountinfo
+type mountEntry struct {
+	// Device is the major:minor number of the mounted device (ex: 8:17)
+	Device string
+
+	MountPoint string
+	FsType     string
+
+	// Source is the mounted device (ex: /dev/sdb1) or a pseudo source for
+	// virtual filesystems (ex: tmpfs)
+	Source string
+}
+
+//
+// private functions
+//
+
+// readMountInfo returns all of the mount points visible to this process
+func readMountInfo() ([]mountEntry, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseMountInfo(file)
+}
+
+// parseMountInfo reads the mountinfo format described in proc(5), for example:
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfo(reader io.Reader) ([]mountEntry, error) {
+	var mounts []mountEntry
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+
+		// a variable number of optional fields are terminated by a single hyphen,
+		// followed by the filesystem type and the mount source
+		separator := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				separator = i
+				break
+			}
+		}
+
+		if separator == -1 || separator+2 >= len(fields) {
+			continue
+		}
+
+		mounts = append(mounts, mountEntry{
+			Device:     fields[2],
+			MountPoint: unescapeMountField(fields[4]),
+			FsType:     fields[separator+1],
+			Source:     unescapeMountField(fields[separator+2]),
+		})
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField converts the octal escapes the kernel uses for spaces,
+// tabs, newlines and backslashes in mountinfo (ex: \040) back to characters
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				builder.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+
+		builder.WriteByte(field[i])
+	}
+
+	return builder.String()
+}
+
+// findMountByPath returns the mount whose mount point is the provided path
+// (ex: /media/user/CANON)
+func findMountByPath(mountPath string) (mountEntry, bool) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not read mount table: %s", err.Error()))
+		return mountEntry{}, false
+	}
+
+	if resolved, err := filepath.EvalSymlinks(mountPath); err == nil {
+		mountPath = resolved
+	}
+	mountPath = filepath.Clean(mountPath)
+
+	// a later entry is mounted on top of an earlier one at the same point,
+	// so the last match is the one that is visible
+	var match mountEntry
+	found := false
+	for _, mount := range mounts {
+		if mount.MountPoint == mountPath {
+			match = mount
+			found = true
+		}
+	}
+
+	return match, found
+}
+
+// findMountByDevice returns the first mount of the provided device node (ex:
+// /dev/sdb1). Symlinks such as /dev/disk/by-label/CANON are resolved, and the
+// device number is compared as well, since the mount source doesn't always
+// use the same name as the device node
+func findMountByDevice(devicePath string) (mountEntry, bool) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not read mount table: %s", err.Error()))
+		return mountEntry{}, false
+	}
+
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
+	deviceNumber, _ := getDeviceNumber(devicePath)
+
+	for _, mount := range mounts {
+		if mount.Source == devicePath || (deviceNumber != "" && mount.Device == deviceNumber) {
+			return mount, true
+		}
+	}
+
+	return mountEntry{}, false
+}
+
+// getDeviceNumber returns the major:minor number (ex: 8:17) of the provided
+// device node, as listed in /sys/class/block
+func getDeviceNumber(devicePath string) (string, error) {
+	content, err := os.ReadFile(filepath.Join("/sys/class/block", filepath.Base(devicePath), "dev"))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// inspectDevice identifies the filesystem on the provided device node (ex:
+// /dev/sdb1). The boot sector is read directly when possible, but opening a
+// block device usually requires root, so the udev database and finally lsblk
+// are used when it can't be read or isn't a known filesystem
+func inspectDevice(devicePath string) (FilesystemInfo, error) {
+	info, err := readDeviceFilesystem(devicePath)
+	if err == nil {
+		return info, nil
+	}
+	slog.Debug(fmt.Sprintf("Could not read filesystem of '%s' directly: %s", devicePath, err.Error()))
+
+	if info, err := readUdevFilesystem(devicePath); err == nil {
+		return info, nil
+	}
+
+	return readLsblkFilesystem(devicePath)
+}
+
+// readDeviceFilesystem parses the boot sector of the provided device node
+func readDeviceFilesystem(devicePath string) (FilesystemInfo, error) {
+	device, err := os.Open(devicePath)
+	if err != nil {
+		return FilesystemInfo{}, err
+	}
+	defer device.Close()
+
+	return ReadFilesystemInfo(device)
+}
+
+// readUdevFilesystem reads the filesystem details that udev recorded for the
+// provided device node when it was probed (ex: /run/udev/data/b8:17)
+func readUdevFilesystem(devicePath string) (FilesystemInfo, error) {
+	deviceNumber, err := getDeviceNumber(devicePath)
+	if err != nil {
+		return FilesystemInfo{}, err
+	}
+
+	file, err := os.Open(filepath.Join("/run/udev/data", "b"+deviceNumber))
+	if err != nil {
+		return FilesystemInfo{}, err
+	}
+	defer file.Close()
+
+	properties := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		property, found := strings.CutPrefix(scanner.Text(), "E:")
+		if !found {
+			continue
+		}
+
+		if key, value, found := strings.Cut(property, "="); found {
+			properties[key] = value
+		}
+	}
+
+	if properties["ID_FS_TYPE"] == "" {
+		return FilesystemInfo{}, errors.New("device has not been probed by udev")
+	}
+
+	// ID_FS_LABEL has unsafe characters (including spaces) replaced, so the
+	// encoded label is preferred
+	label := properties["ID_FS_LABEL"]
+	if encoded, found := properties["ID_FS_LABEL_ENC"]; found {
+		label = unescapeUdevValue(encoded)
+	}
+
+	return FilesystemInfo{
+		Type:    properties["ID_FS_TYPE"],
+		Version: properties["ID_FS_VERSION"],
+		Label:   label,
+		UUID:    properties["ID_FS_UUID"],
+	}, nil
+}
+
+// unescapeUdevValue converts the hex escapes udev uses in encoded values
+// (ex: \x20) back to characters
+func unescapeUdevValue(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if decoded, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				builder.WriteByte(byte(decoded))
+				i += 3
+				continue
+			}
+		}
+
+		builder.WriteByte(value[i])
+	}
+
+	return builder.String()
+}
+
+// readLsblkFilesystem asks lsblk for the filesystem details of the provided
+// device node
+func readLsblkFilesystem(devicePath string) (FilesystemInfo, error) {
+	command := "lsblk -n -d -P -o LABEL,FSTYPE,FSVER,UUID %s0"
+	output, exitCode, err := callExternalCommand(command, devicePath)
+
+	// testing err and exit code may be redundant, but whatever
+	if err != nil || exitCode != 0 {
+		return FilesystemInfo{}, fmt.Errorf("failed to query device '%s'", devicePath)
+	}
+
+	fields := parsePairs(output)
+
+	return FilesystemInfo{
+		Type:    fields["FSTYPE"],
+		Version: fields["FSVER"],
+		Label:   fields["LABEL"],
+		UUID:    fields["UUID"],
+	}, nil
+}
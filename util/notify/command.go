@@ -0,0 +1,85 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"ccmm/model"
+)
+
+// commandSender runs a shell command for each event. The event is passed as
+// JSON on stdin and the main fields are also set as environment variables
+// (CCMM_EVENT_TYPE, CCMM_EVENT_TITLE, CCMM_EVENT_MESSAGE and CCMM_EVENT_JOB_ID)
+type commandSender struct {
+	command string
+}
+
+func newCommandSender(config model.NotificationConfig) (Sender, error) {
+	if config.Command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+
+	return &commandSender{command: config.Command}, nil
+}
+
+func (s *commandSender) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", s.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", s.command)
+	}
+
+	var output bytes.Buffer
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = append(os.Environ(),
+		"CCMM_EVENT_TYPE="+event.Type,
+		"CCMM_EVENT_TITLE="+event.Title,
+		"CCMM_EVENT_MESSAGE="+event.Message,
+		"CCMM_EVENT_JOB_ID="+strconv.Itoa(event.JobID),
+	)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ccmm/model"
+)
+
+const defaultSmtpPort = 587
+
+// emailSender sends each event by email. STARTTLS is used when the server
+// supports it
+type emailSender struct {
+	address string
+	auth    smtp.Auth
+	from    string
+	to      []string
+}
+
+func newEmailSender(config model.NotificationConfig) (Sender, error) {
+	if config.SmtpHost == "" {
+		return nil, fmt.Errorf("smtp_host is required")
+	}
+
+	if config.From == "" || len(config.To) == 0 {
+		return nil, fmt.Errorf("from and to are required")
+	}
+
+	port := config.SmtpPort
+	if port == 0 {
+		port = defaultSmtpPort
+	}
+
+	sender := &emailSender{
+		address: net.JoinHostPort(config.SmtpHost, strconv.Itoa(port)),
+		from:    config.From,
+		to:      config.To,
+	}
+
+	if config.Username != "" {
+		sender.auth = smtp.PlainAuth("", config.Username, config.Password, config.SmtpHost)
+	}
+
+	return sender, nil
+}
+
+func (s *emailSender) Send(ctx context.Context, event Event) error {
+	details, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", s.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&message, "Subject: [ccmm] %s\r\n", event.Title)
+	fmt.Fprintf(&message, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&message, "%s\r\n\r\n", event.Message)
+	fmt.Fprintf(&message, "Details:\r\n%s\r\n", strings.ReplaceAll(string(details), "\n", "\r\n"))
+
+	// net/smtp doesn't support contexts, so the send is abandoned (but not
+	// stopped) when the context expires
+	result := make(chan error, 1)
+	go func() {
+		result <- smtp.SendMail(s.address, s.auth, s.from, s.to, []byte(message.String()))
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
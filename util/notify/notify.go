@@ -0,0 +1,346 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ccmm/model"
+	"ccmm/util"
+)
+
+// Event types that notifications are sent for
+const (
+	EventJobQueued          = "job.queued"
+	EventJobStarted         = "job.started"
+	EventJobCompleted       = "job.completed"
+	EventJobFailed          = "job.failed"
+	EventJobCancelled       = "job.cancelled"
+	EventChecksumMismatch   = "file.checksum_mismatch"
+	EventLocalSendCompleted = "localsend.completed"
+	EventLocalSendApproval  = "localsend.approval_required"
+	EventSyncRequested      = "sync.requested"
+	EventFileUploaded       = "sync.file_uploaded"
+	EventTest               = "test"
+)
+
+const (
+	// queueSize is the number of events that can wait to be delivered before
+	// new events are dropped
+	queueSize = 100
+
+	defaultTimeout = 10
+	defaultRetries = 2
+)
+
+// retryWait is multiplied by the attempt number to get the time to wait before
+// trying a failed delivery again
+var retryWait = 2 * time.Second
+
+// Event describes something that happened which someone may want to be told about
+type Event struct {
+	Type     string         `json:"type"`
+	Time     time.Time      `json:"time"`
+	Source   string         `json:"source"`
+	Hostname string         `json:"hostname"`
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	JobID    int            `json:"job_id,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Sender delivers an event to a single destination
+type Sender interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// Notifier delivers events to every configured destination that wants them.
+// Events are delivered in the background, one at a time
+type Notifier struct {
+	source  string
+	sinks   []sink
+	queue   chan Event
+	stopped chan struct{}
+	mutex   sync.Mutex
+	closed  bool
+}
+
+var (
+	defaultNotifier *Notifier
+	defaultMutex    sync.Mutex
+)
+
+// New creates a notifier for the provided destinations and starts delivering
+// events. source names the component sending the events (ex: importer). An
+// error is returned if any destination is configured incorrectly
+func New(source string, configs []model.NotificationConfig) (*Notifier, error) {
+	notifier := &Notifier{
+		source:  source,
+		sinks:   make([]sink, 0, len(configs)),
+		queue:   make(chan Event, queueSize),
+		stopped: make(chan struct{}),
+	}
+
+	for i, config := range configs {
+		sender, err := newSender(config)
+		if err != nil {
+			return nil, fmt.Errorf("notification %d (%s): %w", i+1, config.Type, err)
+		}
+
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+
+		retries := config.Retries
+		if retries == 0 {
+			retries = defaultRetries
+		}
+
+		notifier.sinks = append(notifier.sinks, sink{
+			name:    fmt.Sprintf("%s #%d", config.Type, i+1),
+			events:  config.Events,
+			timeout: time.Duration(timeout) * time.Second,
+			retries: max(retries, 0),
+			sender:  sender,
+		})
+	}
+
+	go notifier.run()
+
+	return notifier, nil
+}
+
+// SetDefault sets the notifier used by the package level Notify function
+func SetDefault(notifier *Notifier) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	defaultNotifier = notifier
+}
+
+// Notify queues the event for delivery by the default notifier. Does nothing
+// if no default notifier was set
+func Notify(event Event) {
+	defaultMutex.Lock()
+	notifier := defaultNotifier
+	defaultMutex.Unlock()
+
+	if notifier != nil {
+		notifier.Notify(event)
+	}
+}
+
+// SendNow delivers the event right away using the default notifier, returning
+// the errors from all destinations that failed
+func SendNow(event Event) error {
+	defaultMutex.Lock()
+	notifier := defaultNotifier
+	defaultMutex.Unlock()
+
+	if notifier == nil {
+		return errors.New("notifications are not configured")
+	}
+
+	return notifier.SendNow(event)
+}
+
+// Close stops the default notifier after delivering the events still queued
+func Close() {
+	defaultMutex.Lock()
+	notifier := defaultNotifier
+	defaultNotifier = nil
+	defaultMutex.Unlock()
+
+	if notifier != nil {
+		notifier.Close()
+	}
+}
+
+// Notify queues the event for delivery. If too many events are already
+// waiting, the event is dropped
+func (n *Notifier) Notify(event Event) {
+	if len(n.sinks) == 0 {
+		return
+	}
+
+	event = n.prepare(event)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.closed {
+		slog.Warn(fmt.Sprintf("Notifier is closed, dropping '%s' event", event.Type))
+		return
+	}
+
+	select {
+	case n.queue <- event:
+	default:
+		slog.Warn(fmt.Sprintf("Notification queue is full, dropping '%s' event", event.Type))
+	}
+}
+
+// SendNow delivers the event right away to every destination that wants it,
+// returning the errors from all destinations that failed
+func (n *Notifier) SendNow(event Event) error {
+	return n.deliver(n.prepare(event))
+}
+
+// Close stops accepting events and waits for the queued ones to be delivered
+func (n *Notifier) Close() {
+	n.mutex.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mutex.Unlock()
+
+	<-n.stopped
+}
+
+//
+// private functions
+//
+
+type sink struct {
+	name    string
+	events  []string
+	timeout time.Duration
+	retries int
+	sender  Sender
+}
+
+// rejectedError is returned by a sender when the destination refused the
+// event (ex: a 4xx response), so sending it again won't help
+type rejectedError struct {
+	error
+}
+
+func (e rejectedError) Unwrap() error {
+	return e.error
+}
+
+func newSender(config model.NotificationConfig) (Sender, error) {
+	switch config.Type {
+	case model.NotifyWebhook:
+		return newWebhookSender(config)
+	case model.NotifyEmail:
+		return newEmailSender(config)
+	case model.NotifyNtfy:
+		return newNtfySender(config)
+	case model.NotifyGotify:
+		return newGotifySender(config)
+	case model.NotifyCommand:
+		return newCommandSender(config)
+	}
+
+	return nil, fmt.Errorf("unknown type, must be one of: %v", model.NotificationTypes)
+}
+
+func (n *Notifier) prepare(event Event) Event {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	event.Source = n.source
+	event.Hostname = util.GetHostname()
+
+	return event
+}
+
+func (n *Notifier) run() {
+	defer close(n.stopped)
+
+	for event := range n.queue {
+		n.deliver(event)
+	}
+}
+
+func (n *Notifier) deliver(event Event) error {
+	var deliveryErrors []error
+
+	for _, sink := range n.sinks {
+		if !sink.wants(event.Type) {
+			continue
+		}
+
+		err := sink.send(event)
+
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Failed to send '%s' notification to %s: %s", event.Type, sink.name, err.Error()))
+			deliveryErrors = append(deliveryErrors, fmt.Errorf("%s: %w", sink.name, err))
+			continue
+		}
+
+		slog.Debug(fmt.Sprintf("Sent '%s' notification to %s", event.Type, sink.name))
+	}
+
+	return errors.Join(deliveryErrors...)
+}
+
+// send delivers the event, trying again after a failure unless the
+// destination rejected the event
+func (s sink) send(event Event) error {
+	var err error
+
+	for attempt := 1; attempt <= s.retries+1; attempt++ {
+		if attempt > 1 {
+			slog.Debug(fmt.Sprintf("Failed to send '%s' notification to %s, trying again [attempt %d/%d]: %s", event.Type, s.name, attempt, s.retries+1, err.Error()))
+			time.Sleep(time.Duration(attempt-1) * retryWait)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		err = s.sender.Send(ctx, event)
+		cancel()
+
+		if err == nil || errors.As(err, &rejectedError{}) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s sink) wants(eventType string) bool {
+	if len(s.events) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(s.events, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(eventType, prefix)
+		}
+
+		return pattern == eventType
+	})
+}
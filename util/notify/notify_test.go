@@ -0,0 +1,208 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ccmm/model"
+)
+
+// webhookStub records the requests it receives and responds with the next
+// status code in statusCodes, or 200 once they run out
+type webhookStub struct {
+	mutex       sync.Mutex
+	statusCodes []int
+	requests    []*http.Request
+	bodies      []map[string]any
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+
+	statusCode := http.StatusOK
+	if len(s.statusCodes) > 0 {
+		statusCode = s.statusCodes[0]
+		s.statusCodes = s.statusCodes[1:]
+	}
+
+	w.WriteHeader(statusCode)
+	w.Write([]byte(http.StatusText(statusCode)))
+}
+
+func (s *webhookStub) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.requests)
+}
+
+func newWebhookNotifier(t *testing.T, stub *webhookStub, config model.NotificationConfig) *Notifier {
+	t.Helper()
+
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	config.Type = model.NotifyWebhook
+	config.URL = server.URL
+
+	notifier, err := New("importer", []model.NotificationConfig{config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(notifier.Close)
+
+	return notifier
+}
+
+func shortenRetryWait(t *testing.T) {
+	previous := retryWait
+	retryWait = 10 * time.Millisecond
+	t.Cleanup(func() { retryWait = previous })
+}
+
+func TestWebhookPayload(t *testing.T) {
+	stub := &webhookStub{}
+	notifier := newWebhookNotifier(t, stub, model.NotificationConfig{
+		Token:   "secret",
+		Headers: map[string]string{"X-Site": "main"},
+	})
+
+	err := notifier.SendNow(Event{
+		Type:    EventJobCompleted,
+		Time:    time.Date(2024, 11, 3, 12, 0, 0, 0, time.UTC),
+		Title:   "Import completed",
+		Message: "Imported 3 files",
+		JobID:   7,
+		Data:    map[string]any{"files": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stub.count() != 1 {
+		t.Fatalf("expected 1 request, got %d", stub.count())
+	}
+
+	request := stub.requests[0]
+	if request.Method != http.MethodPost || request.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected request %s with content type '%s'", request.Method, request.Header.Get("Content-Type"))
+	}
+	if request.Header.Get("Authorization") != "Bearer secret" || request.Header.Get("X-Site") != "main" {
+		t.Errorf("expected the token and configured headers, got %v", request.Header)
+	}
+
+	body := stub.bodies[0]
+	want := map[string]any{
+		"type":    EventJobCompleted,
+		"time":    "2024-11-03T12:00:00Z",
+		"source":  "importer",
+		"title":   "Import completed",
+		"message": "Imported 3 files",
+		"job_id":  float64(7),
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, body[key])
+		}
+	}
+	if hostname, _ := body["hostname"].(string); hostname == "" {
+		t.Error("expected the hostname to be set")
+	}
+	if data, _ := body["data"].(map[string]any); data["files"] != float64(3) {
+		t.Errorf("expected data.files to be 3, got %v", body["data"])
+	}
+}
+
+func TestWebhookRetriesFailedDelivery(t *testing.T) {
+	shortenRetryWait(t)
+
+	stub := &webhookStub{statusCodes: []int{http.StatusServiceUnavailable, http.StatusBadGateway}}
+	notifier := newWebhookNotifier(t, stub, model.NotificationConfig{})
+
+	if err := notifier.SendNow(Event{Type: EventJobFailed}); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %s", err.Error())
+	}
+	if stub.count() != 3 {
+		t.Errorf("expected 3 requests, got %d", stub.count())
+	}
+}
+
+func TestWebhookNon2xxResponse(t *testing.T) {
+	shortenRetryWait(t)
+
+	tests := []struct {
+		name        string
+		retries     int
+		statusCodes []int
+		requests    int
+	}{
+		{"server error after every retry", 1, []int{500, 500, 500}, 2},
+		{"retries disabled", -1, []int{500}, 1},
+		{"rejected is not retried", 0, []int{400}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &webhookStub{statusCodes: test.statusCodes}
+			notifier := newWebhookNotifier(t, stub, model.NotificationConfig{Retries: test.retries})
+
+			err := notifier.SendNow(Event{Type: EventJobFailed})
+			if err == nil || !strings.Contains(err.Error(), http.StatusText(test.statusCodes[0])) {
+				t.Errorf("expected an error describing the response, got %v", err)
+			}
+			if stub.count() != test.requests {
+				t.Errorf("expected %d requests, got %d", test.requests, stub.count())
+			}
+		})
+	}
+}
+
+func TestNotifyFiltersEvents(t *testing.T) {
+	stub := &webhookStub{}
+	notifier := newWebhookNotifier(t, stub, model.NotificationConfig{Events: []string{"job.*", EventChecksumMismatch}})
+
+	notifier.Notify(Event{Type: EventLocalSendCompleted})
+	notifier.Notify(Event{Type: EventJobFailed})
+	notifier.Notify(Event{Type: EventChecksumMismatch})
+	notifier.Close()
+
+	if stub.count() != 2 || stub.bodies[0]["type"] != EventJobFailed || stub.bodies[1]["type"] != EventChecksumMismatch {
+		t.Errorf("expected only the job and checksum events, got %v", stub.bodies)
+	}
+}
@@ -0,0 +1,142 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ccmm/model"
+)
+
+// ntfySender publishes each event to an ntfy topic
+type ntfySender struct {
+	url      string
+	token    string
+	priority int
+}
+
+// gotifySender pushes each event to a Gotify server
+type gotifySender struct {
+	url      string
+	token    string
+	priority int
+}
+
+func newNtfySender(config model.NotificationConfig) (Sender, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("url (including the topic) is required")
+	}
+
+	if config.Priority < 0 || config.Priority > 5 {
+		return nil, fmt.Errorf("priority must be between 1 and 5")
+	}
+
+	return &ntfySender{
+		url:      config.URL,
+		token:    config.Token,
+		priority: config.Priority,
+	}, nil
+}
+
+func (s *ntfySender) Send(ctx context.Context, event Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(event.Message))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Title", event.Title)
+	req.Header.Set("Tags", event.Type)
+
+	priority := s.priority
+	if priority == 0 && isFailure(event.Type) {
+		priority = 4
+	}
+
+	if priority > 0 {
+		req.Header.Set("Priority", strconv.Itoa(priority))
+	}
+
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	return doRequest(req)
+}
+
+func newGotifySender(config model.NotificationConfig) (Sender, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+
+	if config.Token == "" {
+		return nil, fmt.Errorf("token (application token) is required")
+	}
+
+	return &gotifySender{
+		url:      strings.TrimSuffix(config.URL, "/") + "/message",
+		token:    config.Token,
+		priority: config.Priority,
+	}, nil
+}
+
+func (s *gotifySender) Send(ctx context.Context, event Event) error {
+	priority := s.priority
+	if priority == 0 && isFailure(event.Type) {
+		priority = 8
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"title":    event.Title,
+		"message":  event.Message,
+		"priority": priority,
+		"extras": map[string]any{
+			"ccmm::event": event,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", s.token)
+
+	return doRequest(req)
+}
+
+// isFailure returns true for events that someone should look at right away
+func isFailure(eventType string) bool {
+	return eventType == EventJobFailed || eventType == EventChecksumMismatch
+}
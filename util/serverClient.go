@@ -57,3 +57,21 @@ func CallServer(uri string, body any) ([]byte, int) {
 
 	return responseBody, resp.StatusCode
 }
+
+// GetFromServer performs a GET request against the provided URI and returns
+// the response body and status code
+func GetFromServer(uri string) ([]byte, int) {
+	slog.Debug(fmt.Sprintf("util.GetFromServer: Calling URL '%s'", uri))
+
+	resp, err := http.Get(uri)
+	if err != nil {
+		slog.Error(fmt.Sprintf("util.GetFromServer: Error occurred sending request: %s", err.Error()))
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(resp.Body)
+	slog.Debug(fmt.Sprintf("util.GetFromServer: Response status '%s'", resp.Status))
+
+	return responseBody, resp.StatusCode
+}
@@ -0,0 +1,255 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package sync
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"ccmm/model"
+)
+
+// modTimeTolerance is how far apart two modification times can be and still be
+// considered equal. FAT filesystems only store times with 2 second resolution
+const modTimeTolerance = 2 * time.Second
+
+// PlanSync builds a complete sync plan for the provided request. scanService is
+// called for each requested service to get the list of files the manager has.
+// The returned plan contains an entry for every file on either side, with the
+// action that both the client and manager need to take
+func PlanSync(request model.SyncRequest, scanService func(serviceDateStr string) []model.SyncFile) model.SyncRequest {
+	plan := model.SyncRequest{
+		ClientName:   request.ClientName,
+		SyncType:     model.SyncTypePlan,
+		Services:     request.Services,
+		MediaTypes:   request.MediaTypes,
+		ServiceFiles: make(map[string][]model.SyncFile),
+	}
+
+	for _, serviceDateStr := range request.Services {
+		clientFiles := filterMediaTypes(request.ServiceFiles[serviceDateStr], request.MediaTypes)
+		managerFiles := filterMediaTypes(scanService(serviceDateStr), request.MediaTypes)
+
+		plan.ServiceFiles[serviceDateStr] = PlanService(clientFiles, managerFiles)
+	}
+
+	return plan
+}
+
+// PlanService compares the client and manager file lists for a single service
+// and returns the planned actions, sorted by media type and path. Files are
+// only compared with other files of the same media type.
+//
+// The directory layout on the manager is considered authoritative, so when the
+// same file (by size and hash) exists at different paths, the client is told to
+// move its copy to match the manager
+func PlanService(clientFiles []model.SyncFile, managerFiles []model.SyncFile) []model.SyncFile {
+	clientByType := groupByMediaType(clientFiles)
+	managerByType := groupByMediaType(managerFiles)
+
+	mediaTypes := make(map[string]bool)
+	for mediaType := range clientByType {
+		mediaTypes[mediaType] = true
+	}
+	for mediaType := range managerByType {
+		mediaTypes[mediaType] = true
+	}
+
+	plan := make([]model.SyncFile, 0, len(clientFiles)+len(managerFiles))
+
+	for mediaType := range mediaTypes {
+		plan = append(plan, planMediaType(clientByType[mediaType], managerByType[mediaType])...)
+	}
+
+	sort.Slice(plan, func(i, j int) bool {
+		if plan[i].MediaType != plan[j].MediaType {
+			return plan[i].MediaType < plan[j].MediaType
+		}
+		if plan[i].FilePath != plan[j].FilePath {
+			return plan[i].FilePath < plan[j].FilePath
+		}
+		return plan[i].PreviousPath < plan[j].PreviousPath
+	})
+
+	return plan
+}
+
+//
+// private functions
+//
+
+func planMediaType(clientFiles []model.SyncFile, managerFiles []model.SyncFile) []model.SyncFile {
+	plan := make([]model.SyncFile, 0)
+
+	clientByPath := make(map[string]model.SyncFile)
+	for _, file := range clientFiles {
+		clientByPath[file.FilePath] = file
+	}
+
+	managerByPath := make(map[string]model.SyncFile)
+	for _, file := range managerFiles {
+		managerByPath[file.FilePath] = file
+	}
+
+	clientOnly := make([]model.SyncFile, 0)
+	managerOnly := make([]model.SyncFile, 0)
+
+	// hashes of files that already exist at the same path on both sides
+	matchedHashes := make(map[string]bool)
+
+	for _, clientFile := range clientFiles {
+		managerFile, ok := managerByPath[clientFile.FilePath]
+		if !ok {
+			clientOnly = append(clientOnly, clientFile)
+			continue
+		}
+
+		planned := compareFiles(clientFile, managerFile)
+		if planned.ServerAction == model.SyncActionNone && planned.Hash != "" {
+			matchedHashes[planned.Hash] = true
+		}
+
+		plan = append(plan, planned)
+	}
+
+	for _, managerFile := range managerFiles {
+		if _, ok := clientByPath[managerFile.FilePath]; !ok {
+			managerOnly = append(managerOnly, managerFile)
+		}
+	}
+
+	// match files that only exist on one side by size and hash to find moves
+	movedTo := make(map[string]bool)
+	unmatched := make([]model.SyncFile, 0)
+
+	for _, clientFile := range clientOnly {
+		idx := -1
+		for i, managerFile := range managerOnly {
+			if clientFile.Hash != "" && !movedTo[managerFile.FilePath] && managerFile.Size == clientFile.Size && managerFile.Hash == clientFile.Hash {
+				idx = i
+				break
+			}
+		}
+
+		if idx < 0 {
+			unmatched = append(unmatched, clientFile)
+			continue
+		}
+
+		moved := withActions(managerOnly[idx], model.SyncActionMoved, model.SyncActionNone)
+		moved.PreviousPath = clientFile.FilePath
+		movedTo[moved.FilePath] = true
+		matchedHashes[moved.Hash] = true
+
+		plan = append(plan, moved)
+	}
+
+	// anything left over on the client that is an extra copy of a file the client
+	// already has in the right place is deleted, otherwise it is sent
+	for _, clientFile := range unmatched {
+		if clientFile.Hash != "" && matchedHashes[clientFile.Hash] {
+			slog.Debug(fmt.Sprintf("Client has a duplicate copy of '%s' at '%s'", clientFile.Hash, clientFile.FilePath))
+			plan = append(plan, withActions(clientFile, model.SyncActionDelete, model.SyncActionNone))
+			continue
+		}
+
+		plan = append(plan, withActions(clientFile, model.SyncActionSend, model.SyncActionAdd))
+	}
+
+	for _, managerFile := range managerOnly {
+		if !movedTo[managerFile.FilePath] {
+			plan = append(plan, withActions(managerFile, model.SyncActionAdd, model.SyncActionSend))
+		}
+	}
+
+	return plan
+}
+
+// compareFiles plans the actions for a file that exists at the same path on
+// both sides. If the content differs, the side with the newest modification
+// time is sent to the other side. If that can't be determined, the manager wins
+func compareFiles(clientFile model.SyncFile, managerFile model.SyncFile) model.SyncFile {
+	// the planned entry describes the manager copy, but keeps whichever hash is known
+	planned := managerFile
+	if planned.Hash == "" {
+		planned.Hash = clientFile.Hash
+	}
+
+	if clientFile.Size == managerFile.Size {
+		if clientFile.Hash != "" && managerFile.Hash != "" {
+			if clientFile.Hash == managerFile.Hash {
+				return withActions(planned, model.SyncActionNone, model.SyncActionNone)
+			}
+		} else if modTimesEqual(clientFile.FileModTime, managerFile.FileModTime) {
+			return withActions(planned, model.SyncActionNone, model.SyncActionNone)
+		}
+	}
+
+	if clientFile.FileModTime.After(managerFile.FileModTime.Add(modTimeTolerance)) {
+		return withActions(clientFile, model.SyncActionSend, model.SyncActionUpdate)
+	}
+
+	if !modTimesEqual(clientFile.FileModTime, managerFile.FileModTime) {
+		return withActions(planned, model.SyncActionUpdate, model.SyncActionSend)
+	}
+
+	slog.Warn(fmt.Sprintf("File '%s' differs between client and manager but has the same modification time, manager copy wins", managerFile.FilePath))
+	return withActions(planned, model.SyncActionUpdate, model.SyncActionSend)
+}
+
+func withActions(file model.SyncFile, clientAction model.SyncAction, serverAction model.SyncAction) model.SyncFile {
+	file.ClientAction = clientAction
+	file.ServerAction = serverAction
+	return file
+}
+
+func modTimesEqual(a time.Time, b time.Time) bool {
+	diff := a.Sub(b)
+	return diff < modTimeTolerance && diff > -modTimeTolerance
+}
+
+func groupByMediaType(files []model.SyncFile) map[string][]model.SyncFile {
+	grouped := make(map[string][]model.SyncFile)
+
+	for _, file := range files {
+		grouped[file.MediaType] = append(grouped[file.MediaType], file)
+	}
+
+	return grouped
+}
+
+func filterMediaTypes(files []model.SyncFile, allowedMediaTypes []string) []model.SyncFile {
+	filtered := make([]model.SyncFile, 0, len(files))
+
+	for _, file := range files {
+		if mediaTypeRequested(allowedMediaTypes, file.MediaType) {
+			filtered = append(filtered, file)
+		}
+	}
+
+	return filtered
+}
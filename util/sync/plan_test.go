@@ -0,0 +1,210 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"ccmm/model"
+)
+
+var planBaseTime = time.Date(2024, 11, 3, 10, 0, 0, 0, time.UTC)
+
+func syncFile(mediaType string, filePath string, size int64, hash string, modOffset time.Duration) model.SyncFile {
+	return model.SyncFile{
+		FilePath:    filePath,
+		MediaType:   mediaType,
+		Size:        size,
+		Hash:        hash,
+		FileModTime: planBaseTime.Add(modOffset),
+	}
+}
+
+// planned is the part of a planned entry that the tests check
+type planned struct {
+	filePath     string
+	previousPath string
+	client       model.SyncAction
+	server       model.SyncAction
+}
+
+func (p planned) String() string {
+	return fmt.Sprintf("%s <- '%s' client:%s manager:%s", p.filePath, p.previousPath, p.client, p.server)
+}
+
+func TestPlanService(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  []model.SyncFile
+		manager []model.SyncFile
+		want    []planned
+	}{
+		{
+			name:    "unchanged by hash",
+			client:  []model.SyncFile{syncFile("Audio", "a.wav", 10, "h1", 0)},
+			manager: []model.SyncFile{syncFile("Audio", "a.wav", 10, "h1", time.Hour)},
+			want:    []planned{{"a.wav", "", model.SyncActionNone, model.SyncActionNone}},
+		},
+		{
+			name:    "unchanged by size and mod time within tolerance",
+			client:  []model.SyncFile{syncFile("Audio", "a.wav", 10, "", time.Second)},
+			manager: []model.SyncFile{syncFile("Audio", "a.wav", 10, "", 0)},
+			want:    []planned{{"a.wav", "", model.SyncActionNone, model.SyncActionNone}},
+		},
+		{
+			name:    "client newer updates manager",
+			client:  []model.SyncFile{syncFile("Audio", "a.wav", 12, "h2", time.Hour)},
+			manager: []model.SyncFile{syncFile("Audio", "a.wav", 10, "h1", 0)},
+			want:    []planned{{"a.wav", "", model.SyncActionSend, model.SyncActionUpdate}},
+		},
+		{
+			name:    "manager newer updates client",
+			client:  []model.SyncFile{syncFile("Audio", "a.wav", 10, "h1", 0)},
+			manager: []model.SyncFile{syncFile("Audio", "a.wav", 10, "h2", time.Hour)},
+			want:    []planned{{"a.wav", "", model.SyncActionUpdate, model.SyncActionSend}},
+		},
+		{
+			name:    "conflict with the same mod time, manager wins",
+			client:  []model.SyncFile{syncFile("Audio", "a.wav", 10, "h1", 0)},
+			manager: []model.SyncFile{syncFile("Audio", "a.wav", 10, "h2", time.Second)},
+			want:    []planned{{"a.wav", "", model.SyncActionUpdate, model.SyncActionSend}},
+		},
+		{
+			name:    "conflict in size without hashes, manager wins",
+			client:  []model.SyncFile{syncFile("Audio", "a.wav", 12, "", 0)},
+			manager: []model.SyncFile{syncFile("Audio", "a.wav", 10, "", 0)},
+			want:    []planned{{"a.wav", "", model.SyncActionUpdate, model.SyncActionSend}},
+		},
+		{
+			name:    "client only is sent",
+			client:  []model.SyncFile{syncFile("Video", "b.mov", 10, "h1", 0)},
+			manager: []model.SyncFile{},
+			want:    []planned{{"b.mov", "", model.SyncActionSend, model.SyncActionAdd}},
+		},
+		{
+			name:    "manager only is added",
+			client:  nil,
+			manager: []model.SyncFile{syncFile("Video", "b.mov", 10, "h1", 0)},
+			want:    []planned{{"b.mov", "", model.SyncActionAdd, model.SyncActionSend}},
+		},
+		{
+			name:    "same file at a different path is moved",
+			client:  []model.SyncFile{syncFile("Video", "old/b.mov", 10, "h1", 0)},
+			manager: []model.SyncFile{syncFile("Video", "new/b.mov", 10, "h1", time.Hour)},
+			want:    []planned{{"new/b.mov", "old/b.mov", model.SyncActionMoved, model.SyncActionNone}},
+		},
+		{
+			name: "extra client copy of a moved file is deleted",
+			client: []model.SyncFile{
+				syncFile("Video", "old/b.mov", 10, "h1", 0),
+				syncFile("Video", "copy/b.mov", 10, "h1", 0),
+			},
+			manager: []model.SyncFile{syncFile("Video", "new/b.mov", 10, "h1", 0)},
+			want: []planned{
+				{"copy/b.mov", "", model.SyncActionDelete, model.SyncActionNone},
+				{"new/b.mov", "old/b.mov", model.SyncActionMoved, model.SyncActionNone},
+			},
+		},
+		{
+			name: "extra client copy of an unchanged file is deleted",
+			client: []model.SyncFile{
+				syncFile("Photo", "a.jpg", 10, "h1", 0),
+				syncFile("Photo", "dup/a.jpg", 10, "h1", 0),
+			},
+			manager: []model.SyncFile{syncFile("Photo", "a.jpg", 10, "h1", 0)},
+			want: []planned{
+				{"a.jpg", "", model.SyncActionNone, model.SyncActionNone},
+				{"dup/a.jpg", "", model.SyncActionDelete, model.SyncActionNone},
+			},
+		},
+		{
+			name:    "files without a hash are never moved",
+			client:  []model.SyncFile{syncFile("Video", "old/b.mov", 10, "", 0)},
+			manager: []model.SyncFile{syncFile("Video", "new/b.mov", 10, "", 0)},
+			want: []planned{
+				{"new/b.mov", "", model.SyncActionAdd, model.SyncActionSend},
+				{"old/b.mov", "", model.SyncActionSend, model.SyncActionAdd},
+			},
+		},
+		{
+			name:    "files of different media types are not matched",
+			client:  []model.SyncFile{syncFile("Audio", "a.wav", 10, "h1", 0)},
+			manager: []model.SyncFile{syncFile("Video", "a.wav", 10, "h1", 0)},
+			want: []planned{
+				{"a.wav", "", model.SyncActionSend, model.SyncActionAdd},
+				{"a.wav", "", model.SyncActionAdd, model.SyncActionSend},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := PlanService(test.client, test.manager)
+
+			got := make([]planned, 0, len(plan))
+			for _, file := range plan {
+				got = append(got, planned{file.FilePath, file.PreviousPath, file.ClientAction, file.ServerAction})
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("unexpected plan\n got: %v\nwant: %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlanSyncFiltersMediaTypes(t *testing.T) {
+	request := model.SyncRequest{
+		ClientName: "laptop",
+		Services:   []string{"2024-11-03"},
+		MediaTypes: []string{"audio"},
+		ServiceFiles: map[string][]model.SyncFile{
+			"2024-11-03": {
+				syncFile("Audio", "a.wav", 10, "h1", 0),
+				syncFile("Video", "b.mov", 10, "h2", 0),
+			},
+		},
+	}
+
+	scanned := make([]string, 0)
+	plan := PlanSync(request, func(serviceDateStr string) []model.SyncFile {
+		scanned = append(scanned, serviceDateStr)
+		return []model.SyncFile{syncFile("Photo", "c.jpg", 10, "h3", 0)}
+	})
+
+	if plan.SyncType != model.SyncTypePlan || plan.ClientName != "laptop" {
+		t.Errorf("unexpected plan header %+v", plan)
+	}
+	if len(scanned) != 1 || scanned[0] != "2024-11-03" {
+		t.Errorf("expected the requested service to be scanned, got %v", scanned)
+	}
+
+	files := plan.ServiceFiles["2024-11-03"]
+	if len(files) != 1 || files[0].FilePath != "a.wav" || files[0].ClientAction != model.SyncActionSend {
+		t.Errorf("expected only the audio file to be planned, got %+v", files)
+	}
+}
@@ -31,26 +31,82 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 )
 
-func ScanService(serviceDateStr string, allowedMediaTypes []string, serviceStorageRootPath string) []model.SyncFile {
+// GetServiceDirectory returns the directory that the provided service
+// (ex: 2024-11-03) is stored in below serviceStorageRootPath
+func GetServiceDirectory(serviceDateStr string, serviceStorageRootPath string) (string, error) {
 	if len(serviceDateStr) < 10 {
-		slog.Error(fmt.Sprintf("provided service doesn't appread to be a date: '%s'", serviceDateStr))
-		return nil
+		return "", fmt.Errorf("provided service doesn't appread to be a date: '%s'", serviceDateStr)
 	}
 
 	date, err := time.Parse("2006-01-02", serviceDateStr)
 
 	if err != nil {
-		slog.Error(fmt.Sprintf("failed to parse service date '%s': %v", serviceDateStr, err))
-		return nil
+		return "", fmt.Errorf("failed to parse service date '%s': %v", serviceDateStr, err)
 	}
 
 	quarter := util.GetServiceQuarter(date)
 
-	serviceRootDir := path.Join(serviceStorageRootPath, quarter, serviceDateStr)
+	return path.Join(serviceStorageRootPath, quarter, serviceDateStr), nil
+}
+
+// ListServices returns the date (ex: 2024-11-03) of every service stored below
+// serviceStorageRootPath, sorted oldest first
+func ListServices(serviceStorageRootPath string) ([]string, error) {
+	quarterEntries, err := os.ReadDir(serviceStorageRootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]string, 0)
+
+	for _, quarterEntry := range quarterEntries {
+		if !quarterEntry.IsDir() || strings.HasPrefix(quarterEntry.Name(), ".") {
+			continue
+		}
+
+		serviceEntries, err := os.ReadDir(path.Join(serviceStorageRootPath, quarterEntry.Name()))
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Failed to read quarter directory '%s': %s", quarterEntry.Name(), err.Error()))
+			continue
+		}
+
+		for _, serviceEntry := range serviceEntries {
+			if !serviceEntry.IsDir() || len(serviceEntry.Name()) < 10 {
+				continue
+			}
+
+			serviceDateStr := serviceEntry.Name()[:10]
+			date, err := time.Parse("2006-01-02", serviceDateStr)
+
+			// only directories in the quarter they belong to are considered services
+			if err != nil || util.GetServiceQuarter(date) != quarterEntry.Name() {
+				continue
+			}
+
+			if !slices.Contains(services, serviceDateStr) {
+				services = append(services, serviceDateStr)
+			}
+		}
+	}
+
+	sort.Strings(services)
+
+	return services, nil
+}
+
+func ScanService(serviceDateStr string, allowedMediaTypes []string, serviceStorageRootPath string) []model.SyncFile {
+	serviceRootDir, err := GetServiceDirectory(serviceDateStr, serviceStorageRootPath)
+
+	if err != nil {
+		slog.Error(err.Error())
+		return nil
+	}
 
 	entries, err := os.ReadDir(serviceRootDir)
 
@@ -61,6 +117,12 @@ func ScanService(serviceDateStr string, allowedMediaTypes []string, serviceStora
 
 	var allFiles []model.SyncFile
 
+	// hashes recorded by the importer are used to detect moved and changed files
+	manifest, err := util.ReadManifest(serviceRootDir)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to read manifest in '%s', file hashes will not be available: %s", serviceRootDir, err.Error()))
+	}
+
 	for _, entry := range entries {
 		fullPath := path.Join(serviceRootDir, entry.Name())
 
@@ -80,6 +142,14 @@ func ScanService(serviceDateStr string, allowedMediaTypes []string, serviceStora
 		}
 	}
 
+	for idx := range allFiles {
+		entry, ok := manifest.Files[strings.TrimPrefix(allFiles[idx].FilePath, "/")]
+
+		if ok && entry.HashAlgorithm == util.HashAlgorithm && entry.Size == allFiles[idx].Size {
+			allFiles[idx].Hash = entry.Hash
+		}
+	}
+
 	return allFiles
 }
 
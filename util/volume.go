@@ -0,0 +1,463 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ccmm/model"
+
+	"gopkg.in/yaml.v2"
+)
+
+// VolumeDescriptorName is the file, at the root of a directory or archive that
+// stands in for a volume, that provides the details a plain directory doesn't
+// have (ex: the volume label)
+const VolumeDescriptorName = ".ccmm-volume.yml"
+
+// volumeDescriptor is the content of a VolumeDescriptorName file, for example:
+//
+//	label: H6_SD
+//	fs_type: FAT32
+type volumeDescriptor struct {
+	Label  string `yaml:"label"`
+	FsType string `yaml:"fs_type"`
+	UUID   string `yaml:"uuid"`
+}
+
+// OpenDirectoryVolume describes the volume mounted at the provided path (ex:
+// /media/user/CANON), or a plain directory standing in for one. Details found
+// in a VolumeDescriptorName file at the root of the path replace the ones
+// probed from the mount
+func OpenDirectoryVolume(volumePath string) model.Volume {
+	volume := ProbeVolume(volumePath)
+	volume.FS = os.DirFS(volumePath)
+
+	applyVolumeDescriptor(&volume)
+
+	return volume
+}
+
+// OpenVolume is like OpenDirectoryVolume, but the path may also be a tar
+// (optionally gzipped) or zip archive of a volume, which is read in to memory.
+// Archives are meant for small fixtures of a volume, such as those used to
+// check what a processor detects or a snapshot written by WriteVolumeSnapshot,
+// and their files can't be imported
+func OpenVolume(volumePath string) (model.Volume, error) {
+	stat, err := os.Stat(volumePath)
+	if err != nil {
+		return model.Volume{}, err
+	}
+
+	if stat.IsDir() {
+		return OpenDirectoryVolume(volumePath), nil
+	}
+
+	archive, err := readArchive(volumePath)
+	if err != nil {
+		return model.Volume{}, fmt.Errorf("failed to read archive '%s': %w", volumePath, err)
+	}
+
+	volume := model.Volume{
+		Path:    volumePath,
+		FS:      archive,
+		Archive: true,
+		Size:    archive.size,
+	}
+
+	applyVolumeDescriptor(&volume)
+
+	return volume, nil
+}
+
+//
+// private functions
+//
+
+func applyVolumeDescriptor(volume *model.Volume) {
+	data, err := fs.ReadFile(volume.FS, VolumeDescriptorName)
+	if err != nil {
+		return
+	}
+
+	var descriptor volumeDescriptor
+	if err := yaml.Unmarshal(data, &descriptor); err != nil {
+		slog.Warn(fmt.Sprintf("Ignoring invalid volume descriptor in '%s': %s", volume.Path, err.Error()))
+		return
+	}
+
+	slog.Debug(fmt.Sprintf("Using volume descriptor in '%s'", volume.Path))
+
+	if descriptor.Label != "" {
+		volume.Label = descriptor.Label
+	}
+
+	if descriptor.FsType != "" {
+		volume.FsType = descriptor.FsType
+	}
+
+	if descriptor.UUID != "" {
+		volume.UUID = descriptor.UUID
+	}
+}
+
+// readArchive reads every file of a .tar, .tar.gz, .tgz or .zip archive
+func readArchive(archivePath string) (*archiveFS, error) {
+	lowerPath := strings.ToLower(archivePath)
+
+	switch {
+	case strings.HasSuffix(lowerPath, ".zip"):
+		return readZipArchive(archivePath)
+	case strings.HasSuffix(lowerPath, ".tar"):
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return readTarArchive(file)
+	case strings.HasSuffix(lowerPath, ".tar.gz"), strings.HasSuffix(lowerPath, ".tgz"):
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		return readTarArchive(reader)
+	}
+
+	return nil, errors.New("unsupported archive type, expected .tar, .tar.gz, .tgz or .zip")
+}
+
+func readTarArchive(reader io.Reader) (*archiveFS, error) {
+	archive := newArchiveFS()
+	tarReader := tar.NewReader(reader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			archive.addDir(header.Name, header.ModTime)
+		case tar.TypeReg:
+			data, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+
+			// a snapshot only keeps the start of a file, if any of it, along
+			// with the size it really was
+			size := int64(len(data))
+			if recordedSize, found := header.PAXRecords[snapshotSizeRecord]; found {
+				if parsedSize, err := strconv.ParseInt(recordedSize, 10, 64); err == nil && parsedSize >= size {
+					size = parsedSize
+				}
+			}
+
+			archive.addFile(header.Name, data, size, header.ModTime)
+		}
+	}
+
+	return archive, nil
+}
+
+func readZipArchive(archivePath string) (*archiveFS, error) {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+
+	archive := newArchiveFS()
+
+	for _, zipFile := range zipReader.File {
+		if zipFile.FileInfo().IsDir() {
+			archive.addDir(zipFile.Name, zipFile.Modified)
+			continue
+		}
+
+		reader, err := zipFile.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		archive.addFile(zipFile.Name, data, int64(len(data)), zipFile.Modified)
+	}
+
+	return archive, nil
+}
+
+// archiveFS is a read only, in memory, fs.FS holding the files of an archive.
+// Directories that aren't listed in the archive are created for the files in
+// them. A file may be larger than the data kept for it, the rest reads as zeros
+type archiveFS struct {
+	files map[string]*archiveFile
+
+	// size is the total size of the files
+	size int64
+}
+
+func newArchiveFS() *archiveFS {
+	return &archiveFS{
+		files: map[string]*archiveFile{
+			".": {name: ".", mode: fs.ModeDir | 0755},
+		},
+	}
+}
+
+// cleanArchivePath converts the name of an archive entry (ex: ./DCIM/) to a
+// path that is valid for fs.FS (ex: DCIM)
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (a *archiveFS) addDir(name string, modTime time.Time) {
+	name = cleanArchivePath(name)
+	if name == "" {
+		return
+	}
+
+	if existing, found := a.files[name]; found {
+		existing.modTime = modTime
+		return
+	}
+
+	a.addDir(path.Dir(name), time.Time{})
+	a.files[name] = &archiveFile{name: path.Base(name), mode: fs.ModeDir | 0755, modTime: modTime}
+	a.addChild(name)
+}
+
+func (a *archiveFS) addFile(name string, data []byte, size int64, modTime time.Time) {
+	name = cleanArchivePath(name)
+	if name == "" {
+		return
+	}
+
+	a.addDir(path.Dir(name), time.Time{})
+	if existing, found := a.files[name]; found {
+		a.size -= existing.size
+	} else {
+		a.addChild(name)
+	}
+
+	a.files[name] = &archiveFile{name: path.Base(name), mode: 0644, size: size, modTime: modTime, data: data}
+	a.size += size
+}
+
+func (a *archiveFS) addChild(name string) {
+	parent := path.Dir(name)
+	if parent == "" {
+		parent = "."
+	}
+
+	a.files[parent].children = append(a.files[parent].children, name)
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	file, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.IsDir() {
+		entries, _ := a.ReadDir(name)
+		return &archiveDirHandle{file: file, entries: entries}, nil
+	}
+
+	return &archiveFileHandle{file: file}, nil
+}
+
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, err := a.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	children := slices.Clone(dir.children)
+	slices.Sort(children)
+
+	entries := make([]fs.DirEntry, len(children))
+	for idx, child := range children {
+		entries[idx] = a.files[child]
+	}
+
+	return entries, nil
+}
+
+func (a *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	return a.lookup("stat", name)
+}
+
+func (a *archiveFS) lookup(op string, name string) (*archiveFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	file, found := a.files[name]
+	if !found {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return file, nil
+}
+
+// archiveFile is both the fs.FileInfo and fs.DirEntry of a file in an archiveFS
+type archiveFile struct {
+	name     string
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	data     []byte
+	children []string
+}
+
+func (f *archiveFile) Name() string               { return f.name }
+func (f *archiveFile) Size() int64                { return f.size }
+func (f *archiveFile) Mode() fs.FileMode          { return f.mode }
+func (f *archiveFile) ModTime() time.Time         { return f.modTime }
+func (f *archiveFile) IsDir() bool                { return f.mode.IsDir() }
+func (f *archiveFile) Sys() any                   { return nil }
+func (f *archiveFile) Type() fs.FileMode          { return f.mode.Type() }
+func (f *archiveFile) Info() (fs.FileInfo, error) { return f, nil }
+
+type archiveFileHandle struct {
+	file   *archiveFile
+	offset int64
+}
+
+func (h *archiveFileHandle) Stat() (fs.FileInfo, error) { return h.file, nil }
+func (h *archiveFileHandle) Close() error               { return nil }
+
+func (h *archiveFileHandle) Read(b []byte) (int, error) {
+	count, err := h.ReadAt(b, h.offset)
+	h.offset += int64(count)
+
+	return count, err
+}
+
+// ReadAt reads the data kept for the file, followed by zeros up to its size
+func (h *archiveFileHandle) ReadAt(b []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "read", Path: h.file.name, Err: fs.ErrInvalid}
+	}
+
+	if offset >= h.file.size {
+		return 0, io.EOF
+	}
+
+	count := int(min(int64(len(b)), h.file.size-offset))
+	copied := 0
+	if offset < int64(len(h.file.data)) {
+		copied = copy(b[:count], h.file.data[offset:])
+	}
+	clear(b[copied:count])
+
+	if count < len(b) {
+		return count, io.EOF
+	}
+
+	return count, nil
+}
+
+func (h *archiveFileHandle) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		offset += h.file.size
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: h.file.name, Err: fs.ErrInvalid}
+	}
+
+	h.offset = offset
+	return offset, nil
+}
+
+type archiveDirHandle struct {
+	file    *archiveFile
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (h *archiveDirHandle) Stat() (fs.FileInfo, error) { return h.file, nil }
+func (h *archiveDirHandle) Close() error               { return nil }
+
+func (h *archiveDirHandle) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: h.file.name, Err: errors.New("is a directory")}
+}
+
+func (h *archiveDirHandle) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := h.entries[h.offset:]
+	if count <= 0 {
+		h.offset = len(h.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	count = min(count, len(remaining))
+	h.offset += count
+
+	return remaining[:count], nil
+}
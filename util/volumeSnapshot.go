@@ -0,0 +1,162 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+
+	"ccmm/model"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// snapshotSizeRecord is the PAX record holding the real size of a file in
+	// a snapshot, since the body of the file is left out or cut short
+	snapshotSizeRecord = "CCMM.size"
+
+	// snapshotSidecarBytes is how much of each sidecar file is kept
+	snapshotSidecarBytes = 8 * 1024
+)
+
+// snapshotSidecarRegex matches the control and sidecar files that processors
+// read to recognize a volume or the details of its media, which are kept in a
+// snapshot
+var snapshotSidecarRegex = regexp.MustCompile(`(?i)(\.CTG|\.hprj|(^|/)INDEX\.MIF|(^|/)SE_LOG\.BIN|\.XML)$`)
+
+// WriteVolumeSnapshot writes a gzipped tar archive of the volume that can be
+// opened with OpenVolume in place of the volume itself, so that the detection
+// of a card can be checked without the card. The archive holds the directory
+// tree with the size and modification time of every file, the start of each
+// sidecar file (ex: .CTG, .hprj, INDEX.MIF, SE_LOG.BIN and .XML) and a
+// VolumeDescriptorName file with the label and format of the volume. The
+// bodies of media files are left out, they read as zeros from the snapshot
+func WriteVolumeSnapshot(volume model.Volume, writer io.Writer) error {
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	descriptor, err := yaml.Marshal(volumeDescriptor{Label: volume.Label, FsType: volume.FsType, UUID: volume.UUID})
+	if err != nil {
+		return err
+	}
+
+	err = tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     VolumeDescriptorName,
+		Mode:     0644,
+		Size:     int64(len(descriptor)),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tarWriter.Write(descriptor); err != nil {
+		return err
+	}
+
+	err = fs.WalkDir(volume.FS, ".", func(relativePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// the descriptor written above replaces any that was already there
+		if relativePath == "." || relativePath == VolumeDescriptorName {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     relativePath + "/",
+				Mode:     0755,
+				ModTime:  info.ModTime(),
+				Format:   tar.FormatPAX,
+			})
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		var data []byte
+		if snapshotSidecarRegex.MatchString(relativePath) {
+			if data, err = readFileStart(volume.FS, relativePath, snapshotSidecarBytes); err != nil {
+				return err
+			}
+		}
+
+		err = tarWriter.WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       relativePath,
+			Mode:       0644,
+			Size:       int64(len(data)),
+			ModTime:    info.ModTime(),
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{snapshotSizeRecord: strconv.FormatInt(info.Size(), 10)},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tarWriter.Write(data)
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to snapshot '%s': %w", volume.Path, err)
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+//
+// private functions
+//
+
+// readFileStart reads up to maxBytes from the start of the file
+func readFileStart(fsys fs.FS, name string, maxBytes int64) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.LimitReader(file, maxBytes))
+}
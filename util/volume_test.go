@@ -0,0 +1,291 @@
+// =================================================================================
+//
+//		ccmm - https://www.foxhollow.cc/projects/ccmm/
+//
+//	 Connection Church Media Manager, aka ccmm, is a tool for managing all
+//   aspects of produced media- initial import from removable media,
+//   synchronization with clients and automatic data replication and backup
+//
+//		Copyright (c) 2024 Steve Cross <flip@foxhollow.cc>
+//
+//		Licensed under the Apache License, Version 2.0 (the "License");
+//		you may not use this file except in compliance with the License.
+//		You may obtain a copy of the License at
+//
+//		     http://www.apache.org/licenses/LICENSE-2.0
+//
+//		Unless required by applicable law or agreed to in writing, software
+//		distributed under the License is distributed on an "AS IS" BASIS,
+//		WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//		See the License for the specific language governing permissions and
+//		limitations under the License.
+//
+// =================================================================================
+
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// volumeFixture is the content of the volumes opened by the tests below
+var volumeFixture = map[string]string{
+	VolumeDescriptorName:            "label: EOS_DIGITAL\nfs_type: ExFAT\nuuid: 1234-ABCD\n",
+	"DCIM/100CANON/IMG_0001.CR3":    "raw image",
+	"DCIM/100CANON/MVI_0002.MP4":    "video",
+	"MISC/Canon/00000001.CTG":       "catalog",
+	"Untitled Folder/notes.txt":     "notes",
+	"Untitled Folder/empty/.hidden": "",
+}
+
+var volumeFixtureTime = time.Date(2024, 5, 26, 10, 30, 0, 0, time.UTC)
+
+func volumeFixtureFiles() []string {
+	files := make([]string, 0, len(volumeFixture))
+	for name := range volumeFixture {
+		files = append(files, name)
+	}
+
+	return files
+}
+
+func writeDirectoryFixture(t *testing.T, descriptor bool) string {
+	t.Helper()
+
+	volumePath := t.TempDir()
+	for name, content := range volumeFixture {
+		if name == VolumeDescriptorName && !descriptor {
+			continue
+		}
+
+		fullPath := filepath.Join(volumePath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return volumePath
+}
+
+func writeTarFixture(t *testing.T, writer io.Writer) {
+	t.Helper()
+
+	tarWriter := tar.NewWriter(writer)
+
+	// archives made with "tar -C volume ." name their entries ./...
+	if err := tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "./DCIM/", Mode: 0755, ModTime: volumeFixtureTime}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range volumeFixture {
+		err := tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./" + name, Mode: 0644, Size: int64(len(content)), ModTime: volumeFixtureTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeArchiveFixture(t *testing.T, fileName string) string {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), fileName)
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".tar":
+		writeTarFixture(t, file)
+	case ".gz", ".tgz":
+		gzipWriter := gzip.NewWriter(file)
+		writeTarFixture(t, gzipWriter)
+		if err := gzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case ".zip":
+		zipWriter := zip.NewWriter(file)
+		for name, content := range volumeFixture {
+			writer, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: volumeFixtureTime})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.Write([]byte(content))
+		}
+		if err := zipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return archivePath
+}
+
+func checkVolumeFixture(t *testing.T, volume fs.FS) {
+	t.Helper()
+
+	if err := fstest.TestFS(volume, volumeFixtureFiles()...); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range volumeFixture {
+		if data, err := fs.ReadFile(volume, name); err != nil || string(data) != content {
+			t.Errorf("'%s': expected '%s', got '%s' %v", name, content, data, err)
+		}
+	}
+}
+
+func TestOpenVolumeDirectory(t *testing.T) {
+	volumePath := writeDirectoryFixture(t, true)
+
+	volume, err := OpenVolume(volumePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if volume.Path != volumePath || volume.Archive {
+		t.Errorf("unexpected volume %+v", volume)
+	}
+	if volume.Label != "EOS_DIGITAL" || volume.FsType != ExFAT || volume.UUID != "1234-ABCD" {
+		t.Errorf("expected the details of the descriptor, got %+v", volume)
+	}
+
+	checkVolumeFixture(t, volume.FS)
+}
+
+func TestOpenVolumeMount(t *testing.T) {
+	// without a descriptor, the details are the ones probed from the mount
+	volumePath := writeDirectoryFixture(t, false)
+	probed := ProbeVolume(volumePath)
+
+	volume := OpenDirectoryVolume(volumePath)
+
+	if volume.Path != volumePath || volume.Archive {
+		t.Errorf("unexpected volume %+v", volume)
+	}
+	if volume.Label != probed.Label || volume.FsType != probed.FsType || volume.UUID != probed.UUID {
+		t.Errorf("expected the probed details %+v, got %+v", probed, volume)
+	}
+
+	if data, err := fs.ReadFile(volume.FS, "DCIM/100CANON/MVI_0002.MP4"); err != nil || string(data) != "video" {
+		t.Errorf("unexpected content '%s' %v", data, err)
+	}
+}
+
+func TestOpenVolumeInvalidDescriptor(t *testing.T) {
+	volumePath := writeDirectoryFixture(t, false)
+	if err := os.WriteFile(filepath.Join(volumePath, VolumeDescriptorName), []byte("label: [unterminated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	volume := OpenDirectoryVolume(volumePath)
+	if probed := ProbeVolume(volumePath); volume.Label != probed.Label {
+		t.Errorf("expected the invalid descriptor to be ignored, got %+v", volume)
+	}
+}
+
+func TestOpenVolumeArchive(t *testing.T) {
+	var fixtureSize int64
+	for _, content := range volumeFixture {
+		fixtureSize += int64(len(content))
+	}
+
+	for _, fileName := range []string{"card.tar", "card.tar.gz", "card.tgz", "CARD.ZIP"} {
+		t.Run(fileName, func(t *testing.T) {
+			archivePath := writeArchiveFixture(t, fileName)
+
+			volume, err := OpenVolume(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if volume.Path != archivePath || !volume.Archive || volume.Size != fixtureSize {
+				t.Errorf("unexpected volume %+v", volume)
+			}
+			if volume.Label != "EOS_DIGITAL" || volume.FsType != ExFAT || volume.UUID != "1234-ABCD" {
+				t.Errorf("expected the details of the descriptor, got %+v", volume)
+			}
+
+			checkVolumeFixture(t, volume.FS)
+
+			stat, err := fs.Stat(volume.FS, "DCIM/100CANON/IMG_0001.CR3")
+			if err != nil || !stat.ModTime().Equal(volumeFixtureTime) {
+				t.Errorf("expected the modification time from the archive, got %v %v", stat, err)
+			}
+		})
+	}
+}
+
+func TestOpenVolumeErrors(t *testing.T) {
+	unsupported := filepath.Join(t.TempDir(), "card.rar")
+	if err := os.WriteFile(unsupported, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := filepath.Join(t.TempDir(), "card.tar.gz")
+	if err := os.WriteFile(corrupt, []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, volumePath := range []string{filepath.Join(t.TempDir(), "missing"), unsupported, corrupt} {
+		if _, err := OpenVolume(volumePath); err == nil {
+			t.Errorf("'%s': expected an error", volumePath)
+		}
+	}
+}
+
+func TestVolumeSnapshotRoundTrip(t *testing.T) {
+	volume := OpenDirectoryVolume(writeDirectoryFixture(t, true))
+
+	var snapshot bytes.Buffer
+	if err := WriteVolumeSnapshot(volume, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.tar.gz")
+	if err := os.WriteFile(snapshotPath, snapshot.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := OpenVolume(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.Label != volume.Label || restored.FsType != volume.FsType || restored.UUID != volume.UUID {
+		t.Errorf("expected the details of %+v, got %+v", volume, restored)
+	}
+
+	if err := fstest.TestFS(restored.FS, volumeFixtureFiles()...); err != nil {
+		t.Fatal(err)
+	}
+
+	// sidecar files are kept, media files keep their size but read as zeros
+	if data, err := fs.ReadFile(restored.FS, "MISC/Canon/00000001.CTG"); err != nil || string(data) != "catalog" {
+		t.Errorf("expected the sidecar file to be kept, got '%s' %v", data, err)
+	}
+	if data, err := fs.ReadFile(restored.FS, "DCIM/100CANON/MVI_0002.MP4"); err != nil || !bytes.Equal(data, make([]byte, len("video"))) {
+		t.Errorf("expected the media file to read as zeros, got '%s' %v", data, err)
+	}
+}